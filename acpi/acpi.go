@@ -0,0 +1,145 @@
+// Package acpi reads ACPI tables the kernel exposes in
+// /sys/firmware/acpi/tables. Reading tables requires root. MADT and SRAT
+// are parsed for CPU and memory topology, other tables are returned raw.
+package acpi
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	tablesRoot = "/sys/firmware/acpi/tables"
+
+	headerSize = 36
+)
+
+// Header is the common header of system description tables
+type Header struct {
+	Signature       string
+	Length          uint32
+	Revision        uint8
+	Checksum        uint8
+	OEMID           string
+	OEMTableID      string
+	OEMRevision     uint32
+	CreatorID       string
+	CreatorRevision uint32
+}
+
+// Table is a table with its header decoded
+type Table struct {
+	// Name is the sysfs file name, like "SSDT2" for the second SSDT
+	Name string
+	Header
+	Data []byte
+}
+
+// List returns the headers of all tables indexed by their sysfs names
+func List() (map[string]Header, error) {
+	entries, err := ioutil.ReadDir(tablesRoot)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", tablesRoot)
+	}
+
+	headers := map[string]Header{}
+	for _, e := range entries {
+		// data/ and dynamic/ hold other blobs and runtime loaded tables
+		if e.IsDir() {
+			continue
+		}
+		t, err := Read(e.Name())
+		if err != nil {
+			return nil, err
+		}
+		headers[t.Name] = t.Header
+	}
+
+	return headers, nil
+}
+
+// Names returns sysfs names of tables in headers sorted
+func Names(headers map[string]Header) []string {
+	names := make([]string, 0, len(headers))
+	for n := range headers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Read reads the table by its sysfs name like "APIC" or "SRAT"
+func Read(name string) (*Table, error) {
+	p := path.Join(tablesRoot, name)
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", p)
+	}
+
+	h, err := parseHeader(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %v", p)
+	}
+
+	return &Table{Name: name, Header: h, Data: data}, nil
+}
+
+func parseHeader(data []byte) (Header, error) {
+	if len(data) < headerSize {
+		return Header{}, errors.Errorf("table of %d bytes is shorter than its header", len(data))
+	}
+
+	le := binary.LittleEndian
+	h := Header{
+		Signature:       string(data[0:4]),
+		Length:          le.Uint32(data[4:]),
+		Revision:        data[8],
+		Checksum:        data[9],
+		OEMID:           trim(data[10:16]),
+		OEMTableID:      trim(data[16:24]),
+		OEMRevision:     le.Uint32(data[24:]),
+		CreatorID:       trim(data[28:32]),
+		CreatorRevision: le.Uint32(data[32:]),
+	}
+	if int(h.Length) > len(data) {
+		return Header{}, errors.Errorf("table length %d exceeds %d bytes read", h.Length, len(data))
+	}
+
+	return h, nil
+}
+
+// Valid returns whether the table checksum is correct, all bytes summing
+// to zero
+func (t Table) Valid() bool {
+	var sum byte
+	for _, b := range t.Data[:t.Length] {
+		sum += b
+	}
+	return sum == 0
+}
+
+func trim(b []byte) string {
+	return strings.TrimRight(string(b), " \x00")
+}
+
+// entries walks the type and length prefixed structures following the
+// fixed part of MADT and SRAT
+func entries(data []byte, off int, fn func(typ byte, e []byte)) error {
+	for off < len(data) {
+		if off+2 > len(data) {
+			return errors.New("truncated table entry")
+		}
+		typ, length := data[off], int(data[off+1])
+		if length < 2 || off+length > len(data) {
+			return errors.Errorf("invalid entry length %d at offset %d", length, off)
+		}
+		fn(typ, data[off:off+length])
+		off += length
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package acpi
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// MADT entry types
+const (
+	madtLocalAPIC   = 0x0
+	madtLocalX2APIC = 0x9
+	madtGICC        = 0xb
+
+	madtEntriesOffset = headerSize + 8
+)
+
+// MADT CPU entry flags
+const (
+	CPUEnabled       = 1 << 0
+	CPUOnlineCapable = 1 << 1
+)
+
+// CPU is a processor entry of the MADT: a local APIC or x2APIC on x86 or a
+// GIC CPU interface on arm64
+type CPU struct {
+	// UID matches the _UID of the processor device in the namespace
+	UID uint32
+	// ID is the APIC or x2APIC id, or the MPIDR on arm64
+	ID    uint64
+	Flags uint32
+}
+
+// Enabled returns whether the processor is usable
+func (c CPU) Enabled() bool {
+	return c.Flags&CPUEnabled != 0
+}
+
+// MADT is the Multiple APIC Description Table, the "APIC" table
+type MADT struct {
+	Header
+	LocalInterruptController uint32
+	Flags                    uint32
+	CPUs                     []CPU
+}
+
+// ReadMADT reads and parses the MADT
+func ReadMADT() (*MADT, error) {
+	t, err := Read("APIC")
+	if err != nil {
+		return nil, err
+	}
+	return ParseMADT(t)
+}
+
+// ParseMADT parses CPU entries of the table, other entries are skipped
+func ParseMADT(t *Table) (*MADT, error) {
+	if t.Signature != "APIC" {
+		return nil, errors.Errorf("%s is not a MADT", t.Signature)
+	}
+	data := t.Data[:t.Length]
+	if len(data) < madtEntriesOffset {
+		return nil, errors.New("truncated MADT")
+	}
+
+	le := binary.LittleEndian
+	m := &MADT{
+		Header:                   t.Header,
+		LocalInterruptController: le.Uint32(data[headerSize:]),
+		Flags:                    le.Uint32(data[headerSize+4:]),
+	}
+
+	err := entries(data, madtEntriesOffset, func(typ byte, e []byte) {
+		switch {
+		case typ == madtLocalAPIC && len(e) >= 8:
+			m.CPUs = append(m.CPUs, CPU{UID: uint32(e[2]), ID: uint64(e[3]), Flags: le.Uint32(e[4:])})
+		case typ == madtLocalX2APIC && len(e) >= 16:
+			m.CPUs = append(m.CPUs, CPU{UID: le.Uint32(e[12:]), ID: uint64(le.Uint32(e[4:])), Flags: le.Uint32(e[8:])})
+		case typ == madtGICC && len(e) >= 76:
+			m.CPUs = append(m.CPUs, CPU{UID: le.Uint32(e[8:]), ID: le.Uint64(e[68:]), Flags: le.Uint32(e[12:])})
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse MADT")
+	}
+
+	return m, nil
+}
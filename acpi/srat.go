@@ -0,0 +1,106 @@
+package acpi
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// SRAT entry types
+const (
+	sratCPUAffinity    = 0x0
+	sratMemoryAffinity = 0x1
+	sratX2APICAffinity = 0x2
+	sratGICCAffinity   = 0x3
+
+	sratEntriesOffset = headerSize + 12
+)
+
+// SRAT affinity flags
+const (
+	AffinityEnabled    = 1 << 0
+	MemoryHotPluggable = 1 << 1
+	MemoryNonVolatile  = 1 << 2
+)
+
+// CPUAffinity assigns a processor to a NUMA proximity domain
+type CPUAffinity struct {
+	Domain uint32
+	// ID is the APIC or x2APIC id on x86 and the ACPI processor UID on
+	// arm64
+	ID    uint32
+	Flags uint32
+}
+
+// MemoryAffinity assigns a physical address range to a proximity domain
+type MemoryAffinity struct {
+	Domain uint32
+	Base   uint64
+	Length uint64
+	Flags  uint32
+}
+
+// Enabled returns whether the entry is in use
+func (a CPUAffinity) Enabled() bool {
+	return a.Flags&AffinityEnabled != 0
+}
+
+// Enabled returns whether the entry is in use
+func (a MemoryAffinity) Enabled() bool {
+	return a.Flags&AffinityEnabled != 0
+}
+
+// SRAT is the System Resource Affinity Table
+type SRAT struct {
+	Header
+	CPUs   []CPUAffinity
+	Memory []MemoryAffinity
+}
+
+// ReadSRAT reads and parses the SRAT, which only NUMA systems have
+func ReadSRAT() (*SRAT, error) {
+	t, err := Read("SRAT")
+	if err != nil {
+		return nil, err
+	}
+	return ParseSRAT(t)
+}
+
+// ParseSRAT parses processor and memory affinity entries of the table
+func ParseSRAT(t *Table) (*SRAT, error) {
+	if t.Signature != "SRAT" {
+		return nil, errors.Errorf("%s is not a SRAT", t.Signature)
+	}
+	data := t.Data[:t.Length]
+	if len(data) < sratEntriesOffset {
+		return nil, errors.New("truncated SRAT")
+	}
+
+	le := binary.LittleEndian
+	s := &SRAT{Header: t.Header}
+
+	err := entries(data, sratEntriesOffset, func(typ byte, e []byte) {
+		switch {
+		case typ == sratCPUAffinity && len(e) >= 16:
+			// The domain is split into the low byte and three high bytes
+			domain := uint32(e[2]) | uint32(e[9])<<8 | uint32(e[10])<<16 | uint32(e[11])<<24
+			s.CPUs = append(s.CPUs, CPUAffinity{Domain: domain, ID: uint32(e[3]), Flags: le.Uint32(e[4:])})
+		case typ == sratMemoryAffinity && len(e) >= 40:
+			s.Memory = append(s.Memory, MemoryAffinity{
+				Domain: le.Uint32(e[2:]),
+				Base:   le.Uint64(e[8:]),
+				Length: le.Uint64(e[16:]),
+				Flags:  le.Uint32(e[28:]),
+			})
+		case typ == sratX2APICAffinity && len(e) >= 24:
+			s.CPUs = append(s.CPUs, CPUAffinity{Domain: le.Uint32(e[4:]), ID: le.Uint32(e[8:]), Flags: le.Uint32(e[12:])})
+		case typ == sratGICCAffinity && len(e) >= 18:
+			s.CPUs = append(s.CPUs, CPUAffinity{Domain: le.Uint32(e[2:]), ID: le.Uint32(e[6:]), Flags: le.Uint32(e[10:])})
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse SRAT")
+	}
+
+	return s, nil
+}
@@ -0,0 +1,115 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Action describes a single mutating operation
+type Action struct {
+	// Op names the operation, e.g. "xattr.set"
+	Op string `json:"op"`
+	// Target is the device or path the operation changes
+	Target string            `json:"target"`
+	Args   map[string]string `json:"args,omitempty"`
+}
+
+func (a Action) String() string {
+	keys := make([]string, 0, len(a.Args))
+	for k := range a.Args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", a.Op, a.Target)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%s", k, a.Args[k])
+	}
+	return b.String()
+}
+
+// Plan collects actions that would have been executed in dry-run mode.
+// Plan is safe for concurrent use.
+type Plan struct {
+	mu      sync.Mutex
+	actions []Action
+}
+
+// Actions returns recorded actions in the order they were issued
+func (p *Plan) Actions() []Action {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	as := make([]Action, len(p.actions))
+	copy(as, p.actions)
+	return as
+}
+
+func (p *Plan) add(a Action) {
+	p.mu.Lock()
+	p.actions = append(p.actions, a)
+	p.mu.Unlock()
+}
+
+type planKey struct{}
+
+// WithDryRun returns a context that makes every mutating call of the
+// library record its action into the returned plan instead of executing it
+func WithDryRun(ctx context.Context) (context.Context, *Plan) {
+	p := &Plan{}
+	return context.WithValue(ctx, planKey{}, p), p
+}
+
+// IsDryRun returns whether the context is in dry-run mode
+func IsDryRun(ctx context.Context) bool {
+	_, ok := ctx.Value(planKey{}).(*Plan)
+	return ok
+}
+
+// Run executes fn performing the action, or records the action when the
+// context is in dry-run mode. Every mutating API of the library goes
+// through Run, so the call is also reported to the configured auditors.
+func Run(ctx context.Context, a Action, fn func() error) error {
+	r := Record{Time: time.Now(), Action: a}
+
+	if p, ok := ctx.Value(planKey{}).(*Plan); ok {
+		p.add(a)
+		r.DryRun = true
+		audit(ctx, r)
+		return nil
+	}
+
+	err := fn()
+	r.Duration = time.Since(r.Time)
+	if err != nil {
+		r.Error = err.Error()
+	}
+	audit(ctx, r)
+
+	return err
+}
+
+// Ensure converges state like a controller reconcile loop: inSync reports
+// whether the desired state is already in place and apply is run through
+// Run only when it isn't. Ensure returns whether a change was made, which
+// in dry-run mode means a change would have been made.
+func Ensure(ctx context.Context, a Action, inSync func() (bool, error), apply func() error) (bool, error) {
+	ok, err := inSync()
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return false, nil
+	}
+
+	if err := Run(ctx, a, apply); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,107 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record describes a mutating call for the audit trail
+type Record struct {
+	// Actor is the caller identity set with WithActor
+	Actor  string    `json:"actor,omitempty"`
+	UID    int       `json:"uid"`
+	PID    int       `json:"pid"`
+	Time   time.Time `json:"time"`
+	Action Action    `json:"action"`
+	DryRun bool      `json:"dry_run,omitempty"`
+
+	Duration time.Duration `json:"duration"`
+	// Error is the failure text, empty on success
+	Error string `json:"error,omitempty"`
+}
+
+// Auditor receives a record for every mutating call made by the library.
+// Implementations must be safe for concurrent use.
+type Auditor interface {
+	Audit(Record)
+}
+
+// AuditorFunc adapts a function to the Auditor interface
+type AuditorFunc func(Record)
+
+// Audit calls f(r)
+func (f AuditorFunc) Audit(r Record) {
+	f(r)
+}
+
+var (
+	auditorMu sync.RWMutex
+	auditor   Auditor
+)
+
+// SetAuditor installs a process-wide auditor. Passing nil disables
+// auditing.
+func SetAuditor(a Auditor) {
+	auditorMu.Lock()
+	auditor = a
+	auditorMu.Unlock()
+}
+
+type auditorKey struct{}
+type actorKey struct{}
+
+// WithAuditor returns a context whose mutating calls are reported to a in
+// addition to the process-wide auditor
+func WithAuditor(ctx context.Context, a Auditor) context.Context {
+	return context.WithValue(ctx, auditorKey{}, a)
+}
+
+// WithActor returns a context that attributes mutating calls to actor,
+// e.g. the name of the controller or the user of the API request
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func audit(ctx context.Context, r Record) {
+	auditorMu.RLock()
+	global := auditor
+	auditorMu.RUnlock()
+
+	local, _ := ctx.Value(auditorKey{}).(Auditor)
+	if global == nil && local == nil {
+		return
+	}
+
+	r.Actor, _ = ctx.Value(actorKey{}).(string)
+	r.UID = os.Getuid()
+	r.PID = os.Getpid()
+
+	if global != nil {
+		global.Audit(r)
+	}
+	if local != nil {
+		local.Audit(r)
+	}
+}
+
+type jsonAuditor struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONAuditor returns an Auditor writing records to w as JSON lines
+func NewJSONAuditor(w io.Writer) Auditor {
+	return &jsonAuditor{enc: json.NewEncoder(w)}
+}
+
+func (a *jsonAuditor) Audit(r Record) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Audit is fire and forget, a broken sink must not fail the operation
+	_ = a.enc.Encode(r)
+}
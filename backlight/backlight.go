@@ -0,0 +1,108 @@
+// Package backlight reads and sets display brightness through
+// /sys/class/backlight.
+package backlight
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"path"
+
+	"github.com/alexdzyoba/sys/sysfs"
+	"github.com/pkg/errors"
+)
+
+const backlightRoot = "/sys/class/backlight"
+
+// Device is a backlight like "intel_backlight" or "acpi_video0"
+type Device struct {
+	Name string
+}
+
+// List returns backlight devices of the system
+func List() ([]Device, error) {
+	entries, err := ioutil.ReadDir(backlightRoot)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", backlightRoot)
+	}
+
+	ds := make([]Device, 0, len(entries))
+	for _, e := range entries {
+		ds = append(ds, Device{Name: e.Name()})
+	}
+	return ds, nil
+}
+
+func (d Device) attr(name string) string {
+	return path.Join(backlightRoot, d.Name, name)
+}
+
+// Type is "raw", "platform" or "firmware". Raw devices should be preferred
+// by callers picking one of several backlights of the same panel.
+func (d Device) Type() (string, error) {
+	return sysfs.Read(d.attr("type"), sysfs.String)
+}
+
+// MaxBrightness returns the upper bound of brightness values
+func (d Device) MaxBrightness() (int, error) {
+	return sysfs.Read(d.attr("max_brightness"), sysfs.Int[int])
+}
+
+// Brightness returns the brightness the hardware reports. It can differ
+// from the requested value in the brightness attribute, e.g. while the
+// firmware dims the panel.
+func (d Device) Brightness() (int, error) {
+	return sysfs.Read(d.attr("actual_brightness"), sysfs.Int[int])
+}
+
+// RequestedBrightness returns the last brightness set by software
+func (d Device) RequestedBrightness() (int, error) {
+	return sysfs.Read(d.attr("brightness"), sysfs.Int[int])
+}
+
+// SetBrightness sets the absolute brightness from 0 to MaxBrightness
+func (d Device) SetBrightness(ctx context.Context, value int) error {
+	max, err := d.MaxBrightness()
+	if err != nil {
+		return err
+	}
+	if value < 0 || value > max {
+		return errors.Errorf("brightness %d of %s is out of range 0-%d", value, d.Name, max)
+	}
+
+	return sysfs.Write(ctx, d.attr("brightness"), value, sysfs.FormatInt[int])
+}
+
+// Percent returns the actual brightness as a percentage of the maximum
+func (d Device) Percent() (float64, error) {
+	max, err := d.MaxBrightness()
+	if err != nil {
+		return 0, err
+	}
+	if max == 0 {
+		return 0, errors.Errorf("%s reports zero max brightness", d.Name)
+	}
+
+	value, err := d.Brightness()
+	if err != nil {
+		return 0, err
+	}
+
+	return float64(value) * 100 / float64(max), nil
+}
+
+// SetPercent sets the brightness as a percentage of the maximum, rounded to
+// the closest step of the device
+func (d Device) SetPercent(ctx context.Context, percent float64) error {
+	if percent < 0 || percent > 100 {
+		return errors.Errorf("brightness %v%% is not a percentage", percent)
+	}
+
+	max, err := d.MaxBrightness()
+	if err != nil {
+		return err
+	}
+
+	value := int(math.Round(percent * float64(max) / 100))
+	return sysfs.Write(ctx, d.attr("brightness"), value, sysfs.FormatInt[int])
+}
@@ -0,0 +1,219 @@
+// Package balloon reports memory a hypervisor took from or added to the
+// guest through virtio-balloon and virtio-mem devices, which explains
+// memory missing from MemTotal compared to what the VM was configured
+// with. Targets the host requested are read from the device configuration,
+// which needs root and virtio over PCI.
+package balloon
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	virtioRoot = "/sys/bus/virtio/devices"
+	meminfo    = "/proc/meminfo"
+	vmstat     = "/proc/vmstat"
+)
+
+// virtio device ids
+const (
+	deviceBalloon = 5
+	deviceMem     = 24
+)
+
+// Feature bits of virtio-balloon
+const (
+	featureDeflateOnOOM = 2
+	featureReporting    = 5
+)
+
+// balloonPageSize is the unit of balloon sizes regardless of the guest
+// page size
+const balloonPageSize = 4096
+
+// Status is the memory the hypervisor manages in the guest
+type Status struct {
+	// Ballooned is memory balloon drivers hold in bytes, including ones of
+	// other hypervisors like VMware. MemTotal doesn't include it unless the
+	// balloon deflates on OOM.
+	Ballooned uint64
+	Balloons  []Balloon
+	Mems      []Mem
+}
+
+// Balloon is a virtio-balloon device
+type Balloon struct {
+	// Device is the virtio device name like "virtio0"
+	Device string
+	// Driver is empty when no driver is bound
+	Driver string
+	// DeflateOnOOM lets the guest take memory back from the balloon under
+	// memory pressure
+	DeflateOnOOM bool
+	// FreePageReporting hands free pages to the host without inflating
+	FreePageReporting bool
+	// Target is how much memory the host asks for and Actual how much the
+	// guest gave, in bytes. ConfigKnown is false when the device
+	// configuration can't be read and they are zero.
+	Target      uint64
+	Actual      uint64
+	ConfigKnown bool
+}
+
+// Pending returns how much memory the guest still has to give back in
+// bytes, negative when the host asked for memory to be returned to the guest
+func (b Balloon) Pending() int64 {
+	return int64(b.Target) - int64(b.Actual)
+}
+
+// Mem is a virtio-mem device adding memory to the guest in blocks
+type Mem struct {
+	Device string
+	Driver string
+	// Node is the NUMA node the memory is added to
+	Node int
+	// Addr and RegionSize locate the physical region memory is plugged in
+	Addr       uint64
+	RegionSize uint64
+	BlockSize  uint64
+	// Plugged is how much memory is added and Requested how much the host
+	// asks for, in bytes. ConfigKnown is false when the device
+	// configuration can't be read and the fields above are zero.
+	Plugged     uint64
+	Requested   uint64
+	ConfigKnown bool
+}
+
+// Pending returns how much memory the guest still has to plug in bytes,
+// negative when the host asked for memory to be unplugged
+func (m Mem) Pending() int64 {
+	return int64(m.Requested) - int64(m.Plugged)
+}
+
+// Read returns the balloon and virtio-mem devices of the guest. The
+// result is empty on bare metal.
+func Read() (*Status, error) {
+	s := &Status{}
+
+	var err error
+	if s.Ballooned, err = ballooned(); err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(virtioRoot)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "failed to read %v", virtioRoot)
+	}
+	for _, e := range entries {
+		dir := path.Join(virtioRoot, e.Name())
+		id, err := strconv.ParseUint(readTrimmed(path.Join(dir, "device")), 0, 16)
+		if err != nil {
+			continue
+		}
+
+		driver := ""
+		if link, err := os.Readlink(path.Join(dir, "driver")); err == nil {
+			driver = path.Base(link)
+		}
+
+		switch id {
+		case deviceBalloon:
+			features := readTrimmed(path.Join(dir, "features"))
+			b := Balloon{
+				Device:            e.Name(),
+				Driver:            driver,
+				DeflateOnOOM:      hasFeature(features, featureDeflateOnOOM),
+				FreePageReporting: hasFeature(features, featureReporting),
+			}
+			if cfg, err := readConfig(dir, 8); err == nil {
+				b.Target = uint64(cfg.uint32(0)) * balloonPageSize
+				b.Actual = uint64(cfg.uint32(4)) * balloonPageSize
+				b.ConfigKnown = true
+			}
+			s.Balloons = append(s.Balloons, b)
+		case deviceMem:
+			m := Mem{Device: e.Name(), Driver: driver, Node: -1}
+			if cfg, err := readConfig(dir, 56); err == nil {
+				m.BlockSize = cfg.uint64(0)
+				m.Node = int(cfg.uint32(8) & 0xffff)
+				m.Addr = cfg.uint64(16)
+				m.RegionSize = cfg.uint64(24)
+				m.Plugged = cfg.uint64(40)
+				m.Requested = cfg.uint64(48)
+				m.ConfigKnown = true
+			}
+			s.Mems = append(s.Mems, m)
+		}
+	}
+
+	return s, nil
+}
+
+// hasFeature checks the features attribute, a string of 0 and 1 per bit
+// starting at bit 0
+func hasFeature(features string, bit int) bool {
+	return bit < len(features) && features[bit] == '1'
+}
+
+// ballooned reads the Balloon line of meminfo, older kernels only have
+// nr_balloon_pages in vmstat or neither
+func ballooned() (uint64, error) {
+	if v, ok, err := field(meminfo, "Balloon:"); err != nil || ok {
+		return v << 10, err
+	}
+	v, _, err := field(vmstat, "nr_balloon_pages")
+	return v * uint64(os.Getpagesize()), err
+}
+
+// field returns the number following the key in a /proc statistics file
+func field(p, key string) (uint64, bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "failed to open %v", p)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || fields[0] != key {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, false, errors.Wrapf(err, "failed to parse %s of %v", key, p)
+		}
+		return v, true, nil
+	}
+	return 0, false, errors.Wrapf(s.Err(), "failed to read %v", p)
+}
+
+func readTrimmed(p string) string {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// pciDevice returns the sysfs directory of the PCI function the virtio
+// device is on, empty for other transports
+func pciDevice(dir string) string {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return ""
+	}
+	parent := path.Dir(resolved)
+	if subsystem, err := os.Readlink(path.Join(parent, "subsystem")); err != nil || path.Base(subsystem) != "pci" {
+		return ""
+	}
+	return parent
+}
@@ -0,0 +1,120 @@
+package balloon
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// PCI configuration space offsets
+const (
+	pciStatus         = 0x06
+	pciCapabilityList = 0x34
+
+	pciStatusCapList = 0x10
+	pciCapVendor     = 0x09
+)
+
+// virtioPCICapDeviceCfg is the cfg_type of struct virtio_pci_cap locating
+// the device specific configuration
+const virtioPCICapDeviceCfg = 4
+
+// config is a copy of the device specific configuration, virtio 1.0
+// devices use little endian
+type config []byte
+
+func (c config) uint32(off int) uint32 {
+	return binary.LittleEndian.Uint32(c[off:])
+}
+
+func (c config) uint64(off int) uint64 {
+	return binary.LittleEndian.Uint64(c[off:])
+}
+
+// readConfig copies size bytes of the device configuration of a virtio
+// PCI device. The capability list gives the BAR region holding it, which
+// is mapped through the resource file of the PCI function.
+func readConfig(dir string, size int) (config, error) {
+	pci := pciDevice(dir)
+	if pci == "" {
+		return nil, errors.Errorf("%s is not a virtio PCI device", path.Base(dir))
+	}
+
+	bar, off, err := deviceCfg(pci, size)
+	if err != nil {
+		return nil, err
+	}
+
+	resource := path.Join(pci, "resource"+strconv.Itoa(bar))
+	f, err := os.Open(resource)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", resource)
+	}
+	defer f.Close()
+
+	page := int64(os.Getpagesize())
+	base := off &^ (page - 1)
+	length := int((off - base + int64(size) + page - 1) &^ (page - 1))
+	m, err := syscall.Mmap(int(f.Fd()), base, length, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to map %v", resource)
+	}
+	defer syscall.Munmap(m)
+
+	// Registers are read in 32 bit loads, 64 bit fields may change between
+	// them so the copy is repeated until two agree
+	region := m[off-base:]
+	read := func() config {
+		c := make(config, size)
+		for i := 0; i+4 <= size; i += 4 {
+			v := *(*uint32)(unsafe.Pointer(&region[i]))
+			*(*uint32)(unsafe.Pointer(&c[i])) = v
+		}
+		return c
+	}
+	c := read()
+	for i := 0; i < 4; i++ {
+		next := read()
+		if string(next) == string(c) {
+			return c, nil
+		}
+		c = next
+	}
+	return nil, errors.Errorf("configuration of %s keeps changing", path.Base(dir))
+}
+
+// deviceCfg walks the capability list of the PCI function for the device
+// configuration and returns its BAR and offset
+func deviceCfg(pci string, size int) (int, int64, error) {
+	p := path.Join(pci, "config")
+	space, err := ioutil.ReadFile(p)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to read %v", p)
+	}
+	if len(space) < 0x40 || space[pciStatus]&pciStatusCapList == 0 {
+		return 0, 0, errors.Errorf("%v has no capabilities", p)
+	}
+
+	// Bounded in case of a loop in the list
+	pos := int(space[pciCapabilityList])
+	for i := 0; i < 48 && pos >= 0x40 && pos+16 <= len(space); i++ {
+		c := space[pos:]
+		if c[0] == pciCapVendor && c[3] == virtioPCICapDeviceCfg {
+			bar := int(c[4])
+			off := int64(binary.LittleEndian.Uint32(c[8:]))
+			length := int(binary.LittleEndian.Uint32(c[12:]))
+			if bar > 5 || length < size {
+				return 0, 0, errors.Errorf("%v has a bad virtio device configuration capability", p)
+			}
+			return bar, off, nil
+		}
+		pos = int(c[1] &^ 3)
+	}
+	return 0, 0, errors.Errorf("%v has no virtio device configuration", p)
+}
@@ -0,0 +1,63 @@
+package block
+
+import (
+	"fmt"
+
+	"github.com/alexdzyoba/sys/mounts"
+	"github.com/alexdzyoba/sys/sysfs"
+)
+
+// IOHints are the I/O sizes the device prefers from its queue attributes.
+// md arrays report the chunk size as minimum and the full stripe as
+// optimal, hardware RAID reports the same when the controller exposes it.
+type IOHints struct {
+	MinimumIOSize uint64
+	// OptimalIOSize is zero if the device has no preference
+	OptimalIOSize uint64
+}
+
+// IOHints reads queue/minimum_io_size and queue/optimal_io_size
+func (d Device) IOHints() (IOHints, error) {
+	var h IOHints
+	var err error
+	if h.MinimumIOSize, _, err = readQueue(d, nil, "minimum_io_size", sysfs.Int[uint64]); err != nil {
+		return h, err
+	}
+	if h.OptimalIOSize, _, err = readQueue(d, nil, "optimal_io_size", sysfs.Int[uint64]); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+// AlignmentError is returned by CheckAlignment when the filesystem stripe
+// geometry doesn't match the device
+type AlignmentError struct {
+	Device   string
+	Geometry mounts.Geometry
+	Hints    IOHints
+}
+
+func (e *AlignmentError) Error() string {
+	return fmt.Sprintf("%s filesystem on %s has stripe unit %d and width %d bytes, device prefers %d and %d",
+		e.Geometry.FSType, e.Device, e.Geometry.StripeUnit, e.Geometry.StripeWidth,
+		e.Hints.MinimumIOSize, e.Hints.OptimalIOSize)
+}
+
+// CheckAlignment validates the geometry from mounts.FilesystemGeometry
+// against the I/O hints of the device the filesystem is on. Devices
+// without an optimal I/O size accept any geometry, others need the stripe
+// unit to match the minimum and the stripe width the optimal I/O size.
+func (d Device) CheckAlignment(g mounts.Geometry) error {
+	h, err := d.IOHints()
+	if err != nil {
+		return err
+	}
+	if h.OptimalIOSize == 0 {
+		return nil
+	}
+
+	if g.StripeUnit != h.MinimumIOSize || g.StripeWidth != h.OptimalIOSize {
+		return &AlignmentError{Device: d.Name, Geometry: g, Hints: h}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package block
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Unavailable is a field of Details that couldn't be read
+type Unavailable struct {
+	Field string
+	Err   error
+	// Privileged is set when reading failed for lack of permissions,
+	// typically opening the device node as an unprivileged user
+	Privileged bool
+}
+
+func (u Unavailable) String() string {
+	if u.Privileged {
+		return u.Field + ": requires privileges: " + u.Err.Error()
+	}
+	return u.Field + ": " + u.Err.Error()
+}
+
+// Details is everything the package can tell about a device. Fields that
+// couldn't be read are left zero and listed in Unavailable, so callers
+// running without root still get all world-readable sysfs data.
+type Details struct {
+	Device
+	StableID     string
+	PhysicalPath string
+	Partitions   []Partition
+	Stats        *Stats
+	Flush        *FlushStats
+	// Empty requires reading the device node
+	Empty bool
+	// Error logs require SCSI commands on the device node, ATA statistics
+	// are only read for ATA drives
+	SCSIErrorLog       *SCSIErrorLog
+	ATAErrorStatistics *ATAErrorStatistics
+	NVMe               *NVMeInfo
+
+	Unavailable []Unavailable
+}
+
+// Available returns whether the field was read
+func (d Details) Available(field string) bool {
+	for _, u := range d.Unavailable {
+		if u.Field == field {
+			return false
+		}
+	}
+	return true
+}
+
+// Details collects all facts about the device never failing on a single
+// one. Only ErrDeviceGone is returned as the device vanishing makes the
+// rest meaningless.
+func (d Device) Details() (*Details, error) {
+	det := &Details{Device: d}
+
+	collect := func(field string, err error) error {
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, ErrDeviceGone) {
+			return err
+		}
+		det.Unavailable = append(det.Unavailable, Unavailable{
+			Field:      field,
+			Err:        err,
+			Privileged: errors.Is(err, os.ErrPermission),
+		})
+		return nil
+	}
+
+	type step struct {
+		field string
+		read  func() error
+	}
+
+	var err error
+	steps := []step{
+		{"StableID", func() error { det.StableID, err = d.StableID(); return err }},
+		{"PhysicalPath", func() error { det.PhysicalPath, err = d.PhysicalPath(); return err }},
+		{"Partitions", func() error { det.Partitions, err = d.Partitions(); return err }},
+		{"Stats", func() error { det.Stats, err = d.Stats(); return err }},
+		{"Flush", func() error { det.Flush, err = d.FlushStats(); return err }},
+		{"Empty", func() error { det.Empty, err = d.IsEmpty(); return err }},
+	}
+	if d.Type == TypeDisk {
+		steps = append(steps, step{"SCSIErrorLog", func() error { det.SCSIErrorLog, err = d.SCSIErrorLog(); return err }})
+		if d.IsATA() {
+			steps = append(steps, step{"ATAErrorStatistics", func() error { det.ATAErrorStatistics, err = d.ATAErrorStatistics(); return err }})
+		}
+	}
+	if d.Type == TypeNVMe {
+		steps = append(steps, step{"NVMe", func() error { det.NVMe, err = d.NVMe(); return err }})
+	}
+
+	for _, s := range steps {
+		if err := collect(s.field, s.read()); err != nil {
+			return nil, err
+		}
+	}
+
+	return det, nil
+}
@@ -4,16 +4,15 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
-	"strconv"
+	"path/filepath"
 	"strings"
+	"syscall"
 
+	"github.com/alexdzyoba/sys/sysfs"
 	"github.com/pkg/errors"
 )
 
-const (
-	sysfsBlockRoot  = "/sys/block"
-	sectorSizeBytes = 512
-)
+const sectorSizeBytes = 512
 
 type Type int
 
@@ -22,47 +21,299 @@ const (
 	TypeDisk
 	TypeRAID
 	TypeDeviceMapper
+	TypeOptical
+	// TypeNVMe is an NVMe namespace, which is a disk not going through
+	// the SCSI layer
+	TypeNVMe
+	// TypeZram is a compressed RAM device, see Device.Zram
+	TypeZram
+	// TypeRAMDisk is a brd RAM disk like ram0
+	TypeRAMDisk
 )
 
-// Device represents a blockdevice
+// SCSI peripheral device types reported in device/type
+const scsiTypeOptical = 5
+
+// ramdiskMajor is RAMDISK_MAJOR of brd devices, zram gets a dynamic major
+const ramdiskMajor = "1"
+
+// Device represents a blockdevice. It's a snapshot that never changes, see
+// Refresh. New fields must keep it free of slices, maps and pointers so
+// copies share nothing.
 type Device struct {
 	Name string
 	Size uint64
 	Type Type
+	// Rotational is read from queue/rotational, it's false for devices not
+	// reporting it, see RotationalKnown
+	Rotational bool
+
+	// LogicalBlockSize and PhysicalBlockSize are read from queue in bytes,
+	// they are zero for devices not reporting them
+	LogicalBlockSize  uint64
+	PhysicalBlockSize uint64
+	// Model, Vendor, Serial and WWN identify the hardware, they are empty
+	// when the driver doesn't report them
+	Model  string
+	Vendor string
+	Serial string
+	WWN    string
+	// Removable is the kernel's removable media flag, set for card readers
+	// and optical drives. Hotpluggable is set for devices attached through
+	// a hotplug bus like USB or FireWire, which includes USB sticks not
+	// flagged removable.
+	Removable    bool
+	Hotpluggable bool
+	// DMName and DMUUID are set for device-mapper devices, see DMTable
+	DMName string
+	DMUUID string
+
+	rotationalKnown bool
+	// dev is the major:minor number the device was read with, a new
+	// device under the same name gets another one
+	dev string
+	// sysfs is the tree the device was discovered from
+	sysfs Sysfs
+	// policy retries reads of attributes appearing after the device
+	policy RetryPolicy
+}
+
+// sysfsPath returns /sys/block/<name> in the tree of the device
+func (d Device) sysfsPath() string {
+	return d.sysfs.blockPath(d.Name)
 }
 
 // NewDevice creates a Device type.
 // The device properties are discovered from sysfs.
 func NewDevice(devicePath string) (*Device, error) {
-	name := path.Base(devicePath)
+	return DefaultSysfs.NewDevice(devicePath)
+}
+
+// NewDeviceWithRetry creates a Device type retrying reads of attributes
+// that are not there yet according to the policy
+func NewDeviceWithRetry(devicePath string, policy RetryPolicy) (*Device, error) {
+	return DefaultSysfs.NewDeviceWithRetry(devicePath, policy)
+}
+
+// NewDevice creates a Device type from the tree
+func (s Sysfs) NewDevice(devicePath string) (*Device, error) {
+	return s.NewDeviceWithRetry(devicePath, DefaultRetryPolicy)
+}
+
+// NewDeviceWithRetry creates a Device type from the tree retrying reads of
+// attributes that are not there yet according to the policy
+func (s Sysfs) NewDeviceWithRetry(devicePath string, policy RetryPolicy) (*Device, error) {
+	return s.newDevice(devicePath, policy, nil)
+}
 
-	sysfsPath := path.Join(sysfsBlockRoot, name)
+func (s Sysfs) newDevice(devicePath string, policy RetryPolicy, sink func(Warning)) (*Device, error) {
+	name := path.Base(resolveNode(devicePath))
+
+	sysfsPath := s.blockPath(name)
 	if _, err := os.Stat(sysfsPath); os.IsNotExist(err) {
 		return nil, errors.Errorf("device %s does not exist", sysfsPath)
 	}
 
 	// Discover device size from /sys/block/<name>/size
 	sizeFilePath := path.Join(sysfsPath, "size")
-	sizeContent, err := ioutil.ReadFile(sizeFilePath)
+	sizeContent, err := policy.readFile(sysfsPath, sizeFilePath)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to read %v for size", err)
+		return nil, s.deviceErr(name, errors.Wrapf(err, "failed to read %v for size", sizeFilePath))
 	}
 
-	sizeString := strings.Trim(string(sizeContent), "\n")
-	size, err := strconv.ParseUint(sizeString, 10, 64)
+	// Device size in sysfs is always shown in 512 bytes sectors
+	sizeString := strings.TrimSpace(string(sizeContent))
+	size, err := sysfs.Sectors(sizeString)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to parse device size")
+		err = &sysfs.ParseError{Path: sizeFilePath, Value: sizeString, Err: err}
+		if err := s.lenient(sink, name, "size", err); err != nil {
+			return nil, errors.Wrap(err, "failed to parse device size")
+		}
 	}
 
-	// Device size in sysfs is always shown in 512 bytes sectors
-	size = size * sectorSizeBytes
-
 	typ, err := discoverDeviceType(sysfsPath)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to parse device size")
+		return nil, errors.Wrap(err, "failed to discover device type")
+	}
+
+	d := &Device{Name: name, Size: size, Type: typ, sysfs: s, policy: policy}
+	if s.IoctlSize {
+		if size, err := d.SizeFromIoctl(); err == nil {
+			d.Size = size
+		}
 	}
 
-	return &Device{name, size, typ}, nil
+	if d.Rotational, d.rotationalKnown, err = readQueue(*d, sink, "rotational", sysfs.Bool); err != nil {
+		return nil, err
+	}
+	if d.LogicalBlockSize, _, err = readQueue(*d, sink, "logical_block_size", sysfs.Int[uint64]); err != nil {
+		return nil, err
+	}
+	if d.PhysicalBlockSize, _, err = readQueue(*d, sink, "physical_block_size", sysfs.Int[uint64]); err != nil {
+		return nil, err
+	}
+
+	// Every device has dev and removable, identity attributes depend on the
+	// driver
+	if d.dev, err = d.readAttr("dev"); err != nil {
+		return nil, err
+	}
+	removable, err := d.readAttr("removable")
+	if err != nil {
+		return nil, err
+	}
+	d.Removable = removable == "1"
+	for _, a := range []struct {
+		v     *string
+		attrs []string
+	}{
+		{&d.Model, []string{"device/model"}},
+		{&d.Vendor, []string{"device/vendor"}},
+		{&d.Serial, []string{"device/serial", "serial"}},
+		{&d.WWN, []string{"wwid", "device/wwid"}},
+	} {
+		if *a.v, err = d.firstAttr(a.attrs...); err != nil {
+			return nil, err
+		}
+	}
+	d.Hotpluggable = onHotplugBus(sysfsPath)
+	if typ == TypeDeviceMapper {
+		if d.DMName, err = d.readAttr("dm/name"); err != nil {
+			return nil, err
+		}
+		if d.DMUUID, err = d.readAttr("dm/uuid"); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// readAttr reads an attribute every device of its kind has, retrying while
+// the device is still being set up
+func (d Device) readAttr(attr string) (string, error) {
+	content, err := d.policy.readFile(d.sysfsPath(), path.Join(d.sysfsPath(), attr))
+	if err != nil {
+		return "", d.deviceErr(errors.Wrapf(err, "failed to read %s of %s", attr, d.Name))
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// readOptional reads an attribute the driver may not have. It returns an
+// empty string if the attribute is absent and ErrDeviceGone if the device
+// vanished while it was read.
+func (d Device) readOptional(attr string) (string, error) {
+	content, err := ioutil.ReadFile(path.Join(d.sysfsPath(), attr))
+	switch {
+	case err == nil:
+		return strings.TrimSpace(string(content)), nil
+	case os.IsNotExist(err):
+		if present, _ := exists(d.sysfsPath()); present {
+			return "", nil
+		}
+		return "", d.deviceErr(errors.Wrapf(err, "failed to read %s of %s", attr, d.Name))
+	default:
+		return "", errors.Wrapf(err, "failed to read %s of %s", attr, d.Name)
+	}
+}
+
+// firstAttr returns the first non-empty of the optional attributes.
+// Drivers don't agree on where identity is placed, e.g. virtio has serial
+// on the block device and SCSI on the parent device.
+func (d Device) firstAttr(attrs ...string) (string, error) {
+	for _, attr := range attrs {
+		v, err := d.readOptional(attr)
+		if v != "" || err != nil {
+			return v, err
+		}
+	}
+	return "", nil
+}
+
+// hotplugSubsystems are buses whose devices come and go at runtime
+var hotplugSubsystems = map[string]bool{"usb": true, "firewire": true, "ieee1394": true}
+
+// onHotplugBus returns whether any ancestor of the device in the sysfs
+// device tree belongs to a hotplug bus
+func onHotplugBus(sysfsPath string) bool {
+	p, err := filepath.EvalSymlinks(sysfsPath)
+	if err != nil {
+		return false
+	}
+
+	for ; p != "/" && p != "."; p = path.Dir(p) {
+		subsystem, err := os.Readlink(path.Join(p, "subsystem"))
+		if err == nil && hotplugSubsystems[path.Base(subsystem)] {
+			return true
+		}
+	}
+	return false
+}
+
+// readQueue reads the queue attribute of the device returning whether it's
+// present. Some virtual devices have no queue directory at all, attributes
+// of devices with one are retried while the device is being set up. An
+// attribute still missing afterwards is one the kernel doesn't have.
+func readQueue[T any](d Device, sink func(Warning), attr string, parse sysfs.Parser[T]) (T, bool, error) {
+	var zero T
+	queuePath := path.Join(d.sysfsPath(), "queue")
+	if present, _ := exists(queuePath); !present {
+		if present, _ := exists(d.sysfsPath()); !present {
+			return zero, false, d.deviceErr(errors.Wrapf(syscall.ENOENT, "failed to read %s", queuePath))
+		}
+		return zero, false, nil
+	}
+
+	p := path.Join(queuePath, attr)
+	content, err := d.policy.readFile(d.sysfsPath(), p)
+	if os.IsNotExist(err) {
+		if present, _ := exists(d.sysfsPath()); present {
+			return zero, false, nil
+		}
+	}
+	if err != nil {
+		return zero, false, d.deviceErr(errors.Wrapf(err, "failed to read %v", p))
+	}
+
+	value := strings.TrimSpace(string(content))
+	v, err := parse(value)
+	if err != nil {
+		if err := d.sysfs.lenient(sink, d.Name, "queue/"+attr, &sysfs.ParseError{Path: p, Value: value, Err: err}); err != nil {
+			return zero, false, err
+		}
+		return zero, false, nil
+	}
+	return v, true, nil
+}
+
+// RotationalKnown returns whether the kernel reported queue/rotational for
+// the device
+func (d Device) RotationalKnown() bool {
+	return d.rotationalKnown
+}
+
+// IsSSD returns whether the device is a disk or an NVMe namespace known to
+// be non-rotational
+func (d Device) IsSSD() bool {
+	return (d.Type == TypeDisk || d.Type == TypeNVMe) && d.rotationalKnown && !d.Rotational
+}
+
+// Refresh returns a new snapshot of the device reflecting its current
+// properties, e.g. after it was resized. The receiver is left as is.
+// ErrDeviceGone is returned if the device was removed or the name now
+// belongs to another device, told by its WWN, serial or device number.
+func (d Device) Refresh() (Device, error) {
+	fresh, err := d.sysfs.NewDeviceWithRetry(d.Name, d.policy)
+	if err != nil {
+		if present, _ := exists(d.sysfsPath()); !present {
+			return Device{}, d.deviceErr(errors.Wrapf(syscall.ENOENT, "failed to refresh %s", d.Name))
+		}
+		return Device{}, err
+	}
+	if fresh.WWN != d.WWN || fresh.Serial != d.Serial || fresh.dev != d.dev {
+		return Device{}, &goneError{name: d.Name, err: errors.New("replaced by another device")}
+	}
+	return *fresh, nil
 }
 
 func discoverDeviceType(sysfsPath string) (Type, error) {
@@ -86,6 +337,20 @@ func discoverDeviceType(sysfsPath string) (Type, error) {
 		return TypeDeviceMapper, nil
 	}
 
+	// RAM devices have no hardware device, zram is told by its memory
+	// statistics and brd by its major number
+	mmStatExists, err := exists(path.Join(sysfsPath, "mm_stat"))
+	if err != nil {
+		return TypeUnknown, errors.Errorf("failed to discover device type for %s", sysfsPath)
+	}
+
+	if mmStatExists {
+		return TypeZram, nil
+	}
+	if major, _, _ := strings.Cut(readTrimmed(path.Join(sysfsPath, "dev")), ":"); major == ramdiskMajor {
+		return TypeRAMDisk, nil
+	}
+
 	devicePath := path.Join(sysfsPath, "device")
 	devicePathExists, err := exists(devicePath)
 	if err != nil {
@@ -93,12 +358,28 @@ func discoverDeviceType(sysfsPath string) (Type, error) {
 	}
 
 	if devicePathExists {
+		if isNVMe(sysfsPath) {
+			return TypeNVMe, nil
+		}
+		if scsiType(sysfsPath) == scsiTypeOptical {
+			return TypeOptical, nil
+		}
 		return TypeDisk, nil
 	}
 
 	return TypeUnknown, nil
 }
 
+// scsiType returns the SCSI peripheral device type or -1 for non-SCSI
+// devices
+func scsiType(sysfsPath string) int {
+	typ, err := sysfs.Read(path.Join(sysfsPath, "device", "type"), sysfs.Int[int])
+	if err != nil {
+		return -1
+	}
+	return typ
+}
+
 // exists returns whether the given path exists
 func exists(path string) (bool, error) {
 	_, err := os.Stat(path)
@@ -114,19 +395,49 @@ func exists(path string) (bool, error) {
 }
 
 // ListDevices returns block devices found in the system.
-// Block devices are discovered by quering sysfs hierarchy.
+// Block devices are discovered by quering sysfs hierarchy. Devices removed
+// while listing are skipped.
 func ListDevices() ([]Device, error) {
-	root, err := os.Open(sysfsBlockRoot)
+	return DefaultSysfs.ListDevices()
+}
+
+// ListDevices returns block devices found in the tree
+func (s Sysfs) ListDevices() ([]Device, error) {
+	diskNames, err := s.deviceNames()
+	if err != nil {
+		return nil, err
+	}
+
+	ds := make([]Device, 0, len(diskNames))
+	for _, name := range diskNames {
+		d, err := s.NewDevice(name)
+		if err != nil {
+			present, _ := exists(s.blockPath(name))
+			if errors.Is(err, ErrDeviceGone) || !present {
+				continue
+			}
+			return nil, errors.Wrap(err, "failed to create disk")
+		}
+		ds = append(ds, *d)
+	}
+
+	return ds, nil
+}
+
+func (s Sysfs) deviceNames() ([]string, error) {
+	blockRoot := s.blockPath("")
+	root, err := os.Open(blockRoot)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to open %v", sysfsBlockRoot)
+		return nil, errors.Wrapf(err, "failed to open %v", blockRoot)
 	}
+	defer root.Close()
 
-	diskNames, err := root.Readdirnames(-1)
+	names, err := root.Readdirnames(-1)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to read directory %v", root.Name())
 	}
 
-	return NewDevicesFromPaths(diskNames)
+	return names, nil
 }
 
 // NewDevicesFromPaths creates Device types from a given slice of paths.
@@ -134,9 +445,14 @@ func ListDevices() ([]Device, error) {
 // Paths can be provided as base device names like ["sda", "sdb"] or with any
 // prefix like ["/dev/sda", "/dev/sdb"] - only base name will be used.
 func NewDevicesFromPaths(paths []string) ([]Device, error) {
+	return DefaultSysfs.NewDevicesFromPaths(paths)
+}
+
+// NewDevicesFromPaths creates Device types from the tree for the paths
+func (s Sysfs) NewDevicesFromPaths(paths []string) ([]Device, error) {
 	ds := make([]Device, 0, len(paths))
 	for _, p := range paths {
-		d, err := NewDevice(p)
+		d, err := s.NewDevice(p)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to create disk")
 		}
@@ -145,3 +461,15 @@ func NewDevicesFromPaths(paths []string) ([]Device, error) {
 
 	return ds, nil
 }
+
+// Modalias returns the modalias of the device the block device is attached
+// to, like "scsi:t-0x00" or "virtio:d00000002v00001AF4", which kmod matches
+// to kernel modules
+func (d Device) Modalias() (string, error) {
+	p := path.Join(d.sysfsPath(), "device", "modalias")
+	modalias, err := sysfs.Read(p, sysfs.String)
+	if err != nil {
+		return "", d.deviceErr(err)
+	}
+	return modalias, nil
+}
@@ -0,0 +1,285 @@
+package block
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	dmControl = "/dev/mapper/control"
+
+	// _IOWR(0xfd, DM_TABLE_STATUS_CMD, struct dm_ioctl)
+	dmTableStatus = 0xc138fd0c
+
+	dmIoctlSize      = 312
+	dmTargetSpecSize = 40
+	dmNameOffset     = 48
+	dmNameLength     = 128
+
+	dmStatusTableFlag = 1 << 4
+	dmBufferFullFlag  = 1 << 8
+
+	dmInitialBuffer = 16 << 10
+	dmMaxBuffer     = 16 << 20
+
+	// dmCryptUUIDPrefix starts dm UUIDs cryptsetup sets, followed by the
+	// format and the UUID of the header like "CRYPT-LUKS2-<uuid>-<name>"
+	dmCryptUUIDPrefix = "CRYPT-"
+)
+
+// DMTarget is a line of a device-mapper table
+type DMTarget struct {
+	// Start and Length are in 512 bytes sectors
+	Start  uint64
+	Length uint64
+	// Type is the target type like "linear", "crypt" or "thin"
+	Type string
+	// Params are the target arguments as printed by "dmsetup table"
+	Params string
+}
+
+// DMTable returns the targets the device-mapper device maps. It asks the
+// kernel through /dev/mapper/control and falls back to "dmsetup table"
+// when the control node isn't there.
+func (d Device) DMTable() ([]DMTarget, error) {
+	if d.Type != TypeDeviceMapper {
+		return nil, errors.Errorf("%s is not a device-mapper device", d.Name)
+	}
+	if d.DMName == "" {
+		return nil, errors.Errorf("device-mapper name of %s is unknown", d.Name)
+	}
+
+	return d.dmTable(dmStatusTableFlag, "table")
+}
+
+// DMStatus returns the status of every target of the device-mapper device.
+// Params then hold the target status as printed by "dmsetup status", e.g.
+// the usage of a thin pool that ParseThinPoolStatus decodes.
+func (d Device) DMStatus() ([]DMTarget, error) {
+	if d.Type != TypeDeviceMapper {
+		return nil, errors.Errorf("%s is not a device-mapper device", d.Name)
+	}
+	if d.DMName == "" {
+		return nil, errors.Errorf("device-mapper name of %s is unknown", d.Name)
+	}
+
+	return d.dmTable(0, "status")
+}
+
+// IsCrypt returns whether the device is a dm-crypt mapping set up by
+// cryptsetup, the decrypted view of another device. Its Slaves hold the
+// encrypted data.
+func (d Device) IsCrypt() bool {
+	return d.Type == TypeDeviceMapper && strings.HasPrefix(d.DMUUID, dmCryptUUIDPrefix)
+}
+
+func (d Device) dmTable(flags uint32, command string) ([]DMTarget, error) {
+	targets, err := dmTableIoctl(d.DMName, flags)
+	if os.IsNotExist(errors.Cause(err)) {
+		targets, err = dmTableExec(d.DMName, command)
+	}
+	if err != nil {
+		return nil, d.deviceErr(err)
+	}
+
+	return targets, nil
+}
+
+func dmTableIoctl(name string, flags uint32) ([]DMTarget, error) {
+	f, err := os.Open(dmControl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", dmControl)
+	}
+	defer f.Close()
+
+	// The kernel sets the buffer full flag when the table doesn't fit
+	for size := dmInitialBuffer; size <= dmMaxBuffer; size *= 4 {
+		buf := make([]byte, size)
+		le := binary.LittleEndian
+		le.PutUint32(buf[0:], 4) // interface version 4.0.0
+		le.PutUint32(buf[12:], uint32(size))
+		le.PutUint32(buf[16:], dmIoctlSize)
+		le.PutUint32(buf[28:], flags)
+		copy(buf[dmNameOffset:dmNameOffset+dmNameLength-1], name)
+
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), dmTableStatus, uintptr(unsafe.Pointer(&buf[0])))
+		if errno != 0 {
+			return nil, errors.Wrapf(errno, "failed to get table of %s", name)
+		}
+
+		if le.Uint32(buf[28:])&dmBufferFullFlag != 0 {
+			continue
+		}
+
+		return parseDMTargets(buf, le.Uint32(buf[16:]), le.Uint32(buf[20:]))
+	}
+
+	return nil, errors.Errorf("table of %s is larger than %d bytes", name, dmMaxBuffer)
+}
+
+// parseDMTargets decodes struct dm_target_spec entries each followed by
+// its parameters. Offsets of the next entries are relative to the first
+// one in DM_TABLE_STATUS replies.
+func parseDMTargets(buf []byte, start, count uint32) ([]DMTarget, error) {
+	le := binary.LittleEndian
+	targets := make([]DMTarget, 0, count)
+
+	off := int(start)
+	for i := uint32(0); i < count; i++ {
+		if off+dmTargetSpecSize > len(buf) {
+			return nil, errors.New("truncated device-mapper table")
+		}
+		spec := buf[off:]
+
+		params := spec[dmTargetSpecSize:]
+		if end := bytes.IndexByte(params, 0); end >= 0 {
+			params = params[:end]
+		}
+		targets = append(targets, DMTarget{
+			Start:  le.Uint64(spec[0:]),
+			Length: le.Uint64(spec[8:]),
+			Type:   cString(spec[24:40]),
+			Params: string(params),
+		})
+
+		off = int(start) + int(le.Uint32(spec[20:]))
+	}
+
+	return targets, nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func dmTableExec(name, command string) ([]DMTarget, error) {
+	out, err := exec.CommandContext(context.Background(), "dmsetup", command, name).Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to run dmsetup %s %s", command, name)
+	}
+
+	return parseDMSetupTable(out)
+}
+
+// parseDMSetupTable parses lines like "0 2097152 linear 8:16 2048", status
+// lines have the same layout
+func parseDMSetupTable(out []byte) ([]DMTarget, error) {
+	var targets []DMTarget
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), " ", 4)
+		if len(fields) < 3 {
+			continue
+		}
+
+		start, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse target start %q", fields[0])
+		}
+		length, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse target length %q", fields[1])
+		}
+
+		t := DMTarget{Start: start, Length: length, Type: fields[2]}
+		if len(fields) == 4 {
+			t.Params = fields[3]
+		}
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
+
+// ThinPoolStatus is the status of a thin-pool target
+type ThinPoolStatus struct {
+	TransactionID uint64
+	// Block counts of the metadata and data devices
+	MetadataUsed  uint64
+	MetadataTotal uint64
+	DataUsed      uint64
+	DataTotal     uint64
+	// Mode is "rw", "ro" or "out_of_data_space", or "Fail" when the pool
+	// has failed and nothing else is reported
+	Mode string
+	// NeedsCheck is set when the metadata must be repaired with
+	// thin_check
+	NeedsCheck bool
+}
+
+// DataPercent returns the used share of the data device
+func (s ThinPoolStatus) DataPercent() float64 {
+	if s.DataTotal == 0 {
+		return 0
+	}
+	return float64(s.DataUsed) * 100 / float64(s.DataTotal)
+}
+
+// MetadataPercent returns the used share of the metadata device
+func (s ThinPoolStatus) MetadataPercent() float64 {
+	if s.MetadataTotal == 0 {
+		return 0
+	}
+	return float64(s.MetadataUsed) * 100 / float64(s.MetadataTotal)
+}
+
+// ParseThinPoolStatus parses thin-pool status parameters like
+// "1 152/4096 1024/65536 - rw discard_passdown queue_if_no_space - 1024"
+func ParseThinPoolStatus(params string) (ThinPoolStatus, error) {
+	fields := strings.Fields(params)
+	if len(fields) == 1 && fields[0] == "Fail" {
+		return ThinPoolStatus{Mode: "Fail"}, nil
+	}
+	if len(fields) < 5 {
+		return ThinPoolStatus{}, errors.Errorf("invalid thin-pool status %q", params)
+	}
+
+	var s ThinPoolStatus
+	var err error
+	if s.TransactionID, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+		return ThinPoolStatus{}, errors.Wrapf(err, "invalid transaction id %q", fields[0])
+	}
+	if s.MetadataUsed, s.MetadataTotal, err = parseUsedTotal(fields[1]); err != nil {
+		return ThinPoolStatus{}, err
+	}
+	if s.DataUsed, s.DataTotal, err = parseUsedTotal(fields[2]); err != nil {
+		return ThinPoolStatus{}, err
+	}
+	s.Mode = fields[4]
+	for _, f := range fields[5:] {
+		if f == "needs_check" {
+			s.NeedsCheck = true
+		}
+	}
+
+	return s, nil
+}
+
+func parseUsedTotal(f string) (uint64, uint64, error) {
+	parts := strings.Split(f, "/")
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("invalid block usage %q", f)
+	}
+	used, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid block usage %q", f)
+	}
+	total, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid block usage %q", f)
+	}
+	return used, total, nil
+}
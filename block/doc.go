@@ -0,0 +1,9 @@
+// Package block discovers block devices from sysfs.
+//
+// Device values are immutable snapshots of a device at the time they were
+// created: fields are plain values and methods never modify the receiver,
+// so a Device can be copied and shared across goroutines without locking.
+// Use Refresh to get a new snapshot with current properties. Types holding
+// state across calls like NameMap are safe for concurrent use unless
+// documented otherwise.
+package block
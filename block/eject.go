@@ -0,0 +1,135 @@
+package block
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/alexdzyoba/sys/internal/sgio"
+	"github.com/pkg/errors"
+)
+
+const (
+	cdromEject = 0x5309
+
+	scsiAllowMediumRemoval = 0x1e
+	scsiStartStopUnit      = 0x1b
+)
+
+// Eject ejects the medium of an optical drive or a removable disk.
+// CDROMEJECT is used for optical drives and SCSI START STOP UNIT with the
+// load/eject bit for everything else, e.g. USB card readers.
+func (d Device) Eject(ctx context.Context) error {
+	node := path.Join("/dev", d.Name)
+	a := action.Action{Op: "eject", Target: node}
+
+	return action.Run(ctx, a, func() error {
+		f, err := os.OpenFile(node, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			return d.deviceErr(errors.Wrapf(err, "failed to open %s", node))
+		}
+		defer f.Close()
+
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), cdromEject, 0)
+		if errno == 0 {
+			return nil
+		}
+
+		// Unlock the medium first, then stop the unit and eject it
+		cdbs := [][]byte{
+			{scsiAllowMediumRemoval, 0, 0, 0, 0, 0},
+			{scsiStartStopUnit, 0, 0, 0, 0x02, 0},
+		}
+		for _, cdb := range cdbs {
+			if _, err := sgio.Exec(f, cdb, sgio.DirNone, nil, 0); err != nil {
+				return d.deviceErr(errors.Wrapf(err, "failed to eject %s", node))
+			}
+		}
+
+		return nil
+	})
+}
+
+// PowerOff detaches a USB storage device from the bus like
+// "udisksctl power-off": the device is removed from the kernel and its hub
+// port is disabled when the kernel supports it, so the drive spins down and
+// can be unplugged safely. Callers must unmount filesystems first.
+func (d Device) PowerOff(ctx context.Context) error {
+	usbPath, err := usbDevicePath(d)
+	if err != nil {
+		return err
+	}
+
+	a := action.Action{Op: "usb.power-off", Target: d.Name, Args: map[string]string{"usb": path.Base(usbPath)}}
+	return action.Run(ctx, a, func() error {
+		port := usbPortDisablePath(usbPath)
+
+		remove := path.Join(usbPath, "remove")
+		if err := ioutil.WriteFile(remove, []byte("1"), 0200); err != nil {
+			return errors.Wrapf(err, "failed to write %v", remove)
+		}
+
+		if port == "" {
+			return nil
+		}
+		if err := ioutil.WriteFile(port, []byte("1"), 0200); err != nil {
+			return errors.Wrapf(err, "failed to write %v", port)
+		}
+
+		return nil
+	})
+}
+
+// usbDevicePath returns the sysfs directory of the USB device the block
+// device is attached through
+func usbDevicePath(d Device) (string, error) {
+	name := d.Name
+	p, err := filepath.EvalSymlinks(path.Join(d.sysfsPath(), "device"))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve device of %s", name)
+	}
+
+	// Walk up to the first ancestor that is a USB device rather than an
+	// interface, recognized by the busnum attribute
+	for ; p != "/" && p != "."; p = path.Dir(p) {
+		if _, err := os.Stat(path.Join(p, "busnum")); err == nil {
+			return p, nil
+		}
+	}
+
+	return "", errors.Errorf("%s is not a USB device", name)
+}
+
+// usbPortDisablePath returns the disable attribute of the hub port the USB
+// device is plugged into, e.g. /sys/.../1-0:1.0/usb1-port2/disable for
+// device 1-2. It's empty if the kernel doesn't expose it.
+func usbPortDisablePath(usbPath string) string {
+	name := path.Base(usbPath)
+	i := strings.LastIndex(name, ".")
+	if j := strings.LastIndex(name, "-"); j > i {
+		i = j
+	}
+	if i < 0 {
+		return ""
+	}
+	hub, port := name[:i], name[i+1:]
+	hubPath := path.Dir(usbPath)
+
+	// Ports are attributes of the hub's interface directory which is named
+	// like "1-2:1.0", or "1-0:1.0" for root hubs
+	iface := hub + ":*"
+	if !strings.Contains(hub, "-") {
+		iface = hub + "-0:*"
+	}
+	matches, _ := filepath.Glob(path.Join(hubPath, iface, "*-port"+port, "disable"))
+	if len(matches) == 0 {
+		return ""
+	}
+
+	return matches[0]
+}
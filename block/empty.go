@@ -0,0 +1,31 @@
+//go:build !noprobe
+// +build !noprobe
+
+package block
+
+import (
+	"path"
+
+	"github.com/alexdzyoba/sys/probe"
+)
+
+// IsEmpty returns whether the device has no partitions and no partition
+// table, filesystem, RAID, LVM or LUKS signatures in its first and last MiB
+// or at any other known superblock offset. It's the safety check before
+// handing a disk to destructive operations.
+func (d Device) IsEmpty() (bool, error) {
+	ps, err := d.Partitions()
+	if err != nil {
+		return false, err
+	}
+	if len(ps) > 0 {
+		return false, nil
+	}
+
+	sigs, err := probe.Scan(path.Join("/dev", d.Name))
+	if err != nil {
+		return false, d.deviceErr(err)
+	}
+
+	return len(sigs) == 0, nil
+}
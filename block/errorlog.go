@@ -0,0 +1,283 @@
+package block
+
+import (
+	"encoding/binary"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/alexdzyoba/sys/internal/sgio"
+	"github.com/pkg/errors"
+)
+
+const (
+	scsiLogSense       = 0x4d
+	scsiATAPassThrough = 0x85
+
+	// Cumulative values page control
+	logSensePCCumulative = 0x40
+
+	logPageWriteErrors     = 0x02
+	logPageReadErrors      = 0x03
+	logPageVerifyErrors    = 0x05
+	logPageNonMediumErrors = 0x06
+
+	ataReadLogExt          = 0x2f
+	ataLogDeviceStatistics = 0x04
+
+	ataStatsPageGeneralErrors = 0x04
+	ataStatsPageTransport     = 0x06
+
+	logPageMaxLength = 0xfffc
+	ataLogPageLength = 512
+)
+
+// Counter is a device counter that may be unsupported by the device
+type Counter struct {
+	Value uint64
+	Valid bool
+}
+
+// ErrorCounters mirror parameters of SCSI read, write and verify error
+// counter log pages
+type ErrorCounters struct {
+	CorrectedWithoutDelay Counter
+	CorrectedWithDelay    Counter
+	// Retries are total rereads or rewrites
+	Retries           Counter
+	Corrected         Counter
+	CorrectionInvoked Counter
+	BytesProcessed    Counter
+	Uncorrected       Counter
+}
+
+// SCSIErrorLog holds command-level error counters of a SCSI device.
+// Pages the device doesn't implement are nil.
+type SCSIErrorLog struct {
+	Read   *ErrorCounters
+	Write  *ErrorCounters
+	Verify *ErrorCounters
+	// NonMedium counts recoverable errors other than medium errors
+	NonMedium Counter
+}
+
+// ATAErrorStatistics holds error counters from ATA Device Statistics log
+type ATAErrorStatistics struct {
+	ReportedUncorrectable Counter
+	// ResetsDuringCommand counts resets between command acceptance and
+	// completion
+	ResetsDuringCommand Counter
+	HardwareResets      Counter
+	InterfaceCRCErrors  Counter
+}
+
+// SCSIErrorLog reads error counter log pages with LOG SENSE
+func (d Device) SCSIErrorLog() (*SCSIErrorLog, error) {
+	f, err := d.openSG()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pages, err := logSense(f, 0)
+	if err != nil {
+		return nil, d.deviceErr(errors.Wrapf(err, "failed to list log pages of %s", d.Name))
+	}
+
+	supported := map[byte]bool{}
+	for _, p := range pages {
+		supported[byte(p.code)] = true
+	}
+
+	l := &SCSIErrorLog{}
+	for _, p := range []struct {
+		page byte
+		dst  **ErrorCounters
+	}{
+		{logPageReadErrors, &l.Read},
+		{logPageWriteErrors, &l.Write},
+		{logPageVerifyErrors, &l.Verify},
+	} {
+		if !supported[p.page] {
+			continue
+		}
+		params, err := logSense(f, p.page)
+		if err != nil {
+			return nil, d.deviceErr(errors.Wrapf(err, "failed to read log page %#x of %s", p.page, d.Name))
+		}
+		*p.dst = errorCounters(params)
+	}
+
+	if supported[logPageNonMediumErrors] {
+		params, err := logSense(f, logPageNonMediumErrors)
+		if err != nil {
+			return nil, d.deviceErr(errors.Wrapf(err, "failed to read log page %#x of %s", logPageNonMediumErrors, d.Name))
+		}
+		for _, p := range params {
+			if p.code == 0 {
+				l.NonMedium = Counter{p.value(), true}
+			}
+		}
+	}
+
+	return l, nil
+}
+
+func errorCounters(params []logParam) *ErrorCounters {
+	c := &ErrorCounters{}
+	fields := []*Counter{
+		&c.CorrectedWithoutDelay,
+		&c.CorrectedWithDelay,
+		&c.Retries,
+		&c.Corrected,
+		&c.CorrectionInvoked,
+		&c.BytesProcessed,
+		&c.Uncorrected,
+	}
+	for _, p := range params {
+		if int(p.code) < len(fields) {
+			*fields[p.code] = Counter{p.value(), true}
+		}
+	}
+	return c
+}
+
+// logParam is a log page parameter. For the supported pages page (0x00)
+// every supported page code is returned as a parameter without data.
+type logParam struct {
+	code uint16
+	data []byte
+}
+
+func (p logParam) value() uint64 {
+	var v uint64
+	for _, b := range p.data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+func logSense(f *os.File, page byte) ([]logParam, error) {
+	buf := make([]byte, logPageMaxLength)
+	cdb := []byte{scsiLogSense, 0, logSensePCCumulative | page, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(cdb[7:], uint16(len(buf)))
+
+	if _, err := sgio.Exec(f, cdb, sgio.DirFromDev, buf, 0); err != nil {
+		return nil, err
+	}
+
+	if len(buf) < 4 || buf[0]&0x3f != page {
+		return nil, errors.Errorf("unexpected log page %#x", buf[0]&0x3f)
+	}
+	end := 4 + int(binary.BigEndian.Uint16(buf[2:]))
+	if end > len(buf) {
+		end = len(buf)
+	}
+
+	var params []logParam
+	if page == 0 {
+		for _, code := range buf[4:end] {
+			params = append(params, logParam{code: uint16(code)})
+		}
+		return params, nil
+	}
+
+	for i := 4; i+4 <= end; {
+		n := int(buf[i+3])
+		if i+4+n > end {
+			break
+		}
+		params = append(params, logParam{
+			code: binary.BigEndian.Uint16(buf[i:]),
+			data: buf[i+4 : i+4+n],
+		})
+		i += 4 + n
+	}
+
+	return params, nil
+}
+
+// ATAErrorStatistics reads error counters from the Device Statistics log
+// with READ LOG EXT through SCSI-ATA translation
+func (d Device) ATAErrorStatistics() (*ATAErrorStatistics, error) {
+	f, err := d.openSG()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := &ATAErrorStatistics{}
+
+	errs, err := ataStatisticsPage(f, ataStatsPageGeneralErrors)
+	if err != nil {
+		return nil, d.deviceErr(errors.Wrapf(err, "failed to read general errors statistics of %s", d.Name))
+	}
+	s.ReportedUncorrectable = ataStatistic(errs, 8)
+	s.ResetsDuringCommand = ataStatistic(errs, 16)
+
+	transport, err := ataStatisticsPage(f, ataStatsPageTransport)
+	if err != nil {
+		return nil, d.deviceErr(errors.Wrapf(err, "failed to read transport statistics of %s", d.Name))
+	}
+	s.HardwareResets = ataStatistic(transport, 8)
+	s.InterfaceCRCErrors = ataStatistic(transport, 24)
+
+	return s, nil
+}
+
+func ataStatisticsPage(f *os.File, page byte) ([]byte, error) {
+	buf := make([]byte, ataLogPageLength)
+
+	// ATA PASS-THROUGH(16): PIO data-in with 48-bit command, transfer
+	// length in sector count, one sector read. LBA low holds the log
+	// address and LBA mid the page.
+	cdb := []byte{
+		scsiATAPassThrough,
+		4<<1 | 1,
+		0x0e,
+		0, 0,
+		0, 1,
+		0, ataLogDeviceStatistics,
+		0, page,
+		0, 0,
+		0,
+		ataReadLogExt,
+		0,
+	}
+
+	if _, err := sgio.Exec(f, cdb, sgio.DirFromDev, buf, 0); err != nil {
+		return nil, err
+	}
+
+	// Header is a qword with revision, page number and flags
+	if buf[2] != page {
+		return nil, errors.Errorf("unexpected statistics page %#x", buf[2])
+	}
+
+	return buf, nil
+}
+
+// ataStatistic decodes a statistic qword: bit 63 is supported, bit 62 is
+// valid and the low 48 bits are the value
+func ataStatistic(page []byte, off int) Counter {
+	q := binary.LittleEndian.Uint64(page[off:])
+	if q&(1<<63) == 0 || q&(1<<62) == 0 {
+		return Counter{}
+	}
+	return Counter{Value: q & (1<<48 - 1), Valid: true}
+}
+
+// IsATA returns whether the device is an ATA drive behind the kernel
+// SCSI-ATA translation layer
+func (d Device) IsATA() bool {
+	return readTrimmed(path.Join(d.sysfsPath(), "device", "vendor")) == "ATA"
+}
+
+func (d Device) openSG() (*os.File, error) {
+	node := path.Join("/dev", d.Name)
+	f, err := os.OpenFile(node, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, d.deviceErr(errors.Wrapf(err, "failed to open %s", node))
+	}
+	return f, nil
+}
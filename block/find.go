@@ -0,0 +1,213 @@
+package block
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const devDiskRoot = "/dev/disk"
+
+// FindByUUID returns the disk or partition with the filesystem or partition
+// UUID like fstab's UUID= and PARTUUID= identifiers. The /dev/disk
+// symlinks are used first, then udev properties and finally the devices
+// are probed for systems without udev. An error wrapping os.ErrNotExist is
+// returned if nothing matches.
+func FindByUUID(uuid string) (*DeviceInfo, error) {
+	return DefaultSysfs.FindByUUID(uuid)
+}
+
+// FindByUUID finds a device like FindByUUID scanning the tree
+func (s Sysfs) FindByUUID(uuid string) (*DeviceInfo, error) {
+	return s.find(uuidQuery(uuid))
+}
+
+func uuidQuery(uuid string) query {
+	uuid = strings.ToLower(uuid)
+	return query{
+		id:     "UUID=" + uuid,
+		links:  []string{path.Join("by-uuid", uuid), path.Join("by-partuuid", uuid)},
+		match:  MatchUUID(uuid),
+		probed: func(_, u, _ string) bool { return strings.ToLower(u) == uuid },
+	}
+}
+
+// FindByLabel returns the disk or partition with the filesystem label like
+// fstab's LABEL= identifier. Labels are looked up like FindByUUID.
+func FindByLabel(label string) (*DeviceInfo, error) {
+	return DefaultSysfs.FindByLabel(label)
+}
+
+// FindByLabel finds a device like FindByLabel scanning the tree
+func (s Sysfs) FindByLabel(label string) (*DeviceInfo, error) {
+	return s.find(labelQuery(label))
+}
+
+func labelQuery(label string) query {
+	return query{
+		id:    "LABEL=" + label,
+		links: []string{path.Join("by-label", encodeLink(label)), path.Join("by-partlabel", encodeLink(label))},
+		match: func(d DeviceInfo) bool {
+			// ID_FS_LABEL has unsafe characters replaced, the encoded
+			// values keep them escaped
+			for _, k := range []string{"ID_FS_LABEL_ENC", "ID_PART_ENTRY_NAME"} {
+				if v, ok := d.Properties[k]; ok && decodeLink(v) == label {
+					return true
+				}
+			}
+			return false
+		},
+		probed: func(_, _, l string) bool { return l == label },
+	}
+}
+
+// FindByWWN returns the disk with the World Wide Name like "0x5000c500a1b2c3d4"
+// or "naa.5000c500a1b2c3d4". Without udev the wwid attribute of disks is
+// compared.
+func FindByWWN(wwn string) (*DeviceInfo, error) {
+	return DefaultSysfs.FindByWWN(wwn)
+}
+
+// FindByWWN finds a disk like FindByWWN scanning the tree
+func (s Sysfs) FindByWWN(wwn string) (*DeviceInfo, error) {
+	want := normalizeWWN(wwn)
+	info, err := s.find(query{
+		id:    "WWN=" + wwn,
+		links: []string{path.Join("by-id", "wwn-0x"+want)},
+		match: func(d DeviceInfo) bool {
+			return d.DevType == "disk" &&
+				(normalizeWWN(d.Properties["ID_WWN"]) == want || normalizeWWN(d.Properties["ID_WWN_WITH_EXTENSION"]) == want)
+		},
+	})
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return info, err
+	}
+
+	ds, err := s.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range ds {
+		if d.WWN != "" && normalizeWWN(d.WWN) == want {
+			return s.deviceInfoByName(d.Name)
+		}
+	}
+
+	return nil, errors.Wrapf(os.ErrNotExist, "no device with WWN %s", wwn)
+}
+
+// query is how a device is looked up by an identifier like "UUID=..."
+type query struct {
+	id string
+	// links are relative to /dev/disk
+	links []string
+	// match checks udev properties
+	match Matcher
+	// probed checks signatures found on the device when it isn't nil
+	probed func(typ, uuid, label string) bool
+}
+
+// matches checks a single device, probing it if probe is set
+func (q query) matches(d DeviceInfo, probe bool) bool {
+	return q.match(d) || probe && q.probed != nil && probeMatches(d.Node, q.probed)
+}
+
+// find tries the /dev/disk links, then the matcher on udev properties and
+// finally probes signatures of every device if probed isn't nil
+func (s Sysfs) find(q query) (*DeviceInfo, error) {
+	if strings.HasSuffix(q.id, "=") {
+		return nil, errors.Errorf("empty identifier %s", q.id)
+	}
+
+	for _, l := range q.links {
+		target, err := filepath.EvalSymlinks(path.Join(devDiskRoot, l))
+		if err != nil {
+			continue
+		}
+		if info, err := s.deviceInfoByName(path.Base(target)); err == nil {
+			return info, nil
+		}
+	}
+
+	infos, err := s.ListDeviceInfos()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range infos {
+		if q.match(d) {
+			return &d, nil
+		}
+	}
+
+	if q.probed != nil {
+		for _, d := range infos {
+			if probeMatches(d.Node, q.probed) {
+				return &d, nil
+			}
+		}
+	}
+
+	return nil, errors.Wrapf(os.ErrNotExist, "no device with %s", q.id)
+}
+
+func (s Sysfs) deviceInfoByName(name string) (*DeviceInfo, error) {
+	infos, err := s.ListDeviceInfos()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range infos {
+		if d.Name == name {
+			return &d, nil
+		}
+	}
+	return nil, errors.Wrapf(os.ErrNotExist, "device %s not found", name)
+}
+
+// encodeLink escapes a label the way udev names its symlinks, e.g. a space
+// becomes \x20 and a slash \x2f
+func encodeLink(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			strings.IndexByte("#+-.:=@_", c) >= 0, c >= 0x80:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		}
+	}
+	return b.String()
+}
+
+// decodeLink reverses encodeLink and the escaping of udev properties like
+// ID_FS_LABEL_ENC
+func decodeLink(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if c, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// normalizeWWN strips the "0x" prefix of udev and the "naa.", "eui." or
+// "t10." type prefixes of sysfs wwid attributes
+func normalizeWWN(wwn string) string {
+	wwn = strings.ToLower(strings.TrimSpace(wwn))
+	for _, prefix := range []string{"0x", "naa.", "eui.", "t10."} {
+		wwn = strings.TrimPrefix(wwn, prefix)
+	}
+	return wwn
+}
@@ -0,0 +1,16 @@
+//go:build !noprobe
+// +build !noprobe
+
+package block
+
+import "github.com/alexdzyoba/sys/probe"
+
+// probeMatches reads signatures of the device for FindByUUID and
+// FindByLabel on systems without udev
+func probeMatches(node string, match func(typ, uuid, label string) bool) bool {
+	res, err := probe.Probe(node)
+	if err != nil || res == nil {
+		return false
+	}
+	return match(res.Type, res.UUID, res.Label)
+}
@@ -0,0 +1,72 @@
+package block
+
+import (
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Indexes of flush fields in /sys/block/<dev>/stat available since Linux 5.5
+const (
+	statFlushes   = 15
+	statFlushTime = 16
+)
+
+// FlushStats describe the write cache of a device and the flush requests
+// completed since boot
+type FlushStats struct {
+	// WriteBack is set when the device has a volatile write cache that
+	// needs flushes for durability
+	WriteBack bool
+	// FUA is set when the device supports Force Unit Access writes, which
+	// the kernel uses instead of post-flushes
+	FUA bool
+	// Flushes and FlushTime are zero on kernels not reporting them, see
+	// Supported
+	Flushes   uint64
+	FlushTime time.Duration
+	Supported bool
+}
+
+// FlushStats reads flush counters and write cache settings of the device
+func (d Device) FlushStats() (*FlushStats, error) {
+	sysfsPath := d.sysfsPath()
+
+	statPath := path.Join(sysfsPath, "stat")
+	content, err := ioutil.ReadFile(statPath)
+	if err != nil {
+		return nil, d.deviceErr(errors.Wrapf(err, "failed to read %v", statPath))
+	}
+
+	writeCache, err := d.readOptional("queue/write_cache")
+	if err != nil {
+		return nil, err
+	}
+	fua, err := d.readOptional("queue/fua")
+	if err != nil {
+		return nil, err
+	}
+	s := &FlushStats{WriteBack: writeCache == "write back", FUA: fua == "1"}
+
+	fields := strings.Fields(string(content))
+	if len(fields) <= statFlushTime {
+		return s, nil
+	}
+
+	s.Flushes, err = strconv.ParseUint(fields[statFlushes], 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse flushes in %v", statPath)
+	}
+	ms, err := strconv.ParseUint(fields[statFlushTime], 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse flush time in %v", statPath)
+	}
+	s.FlushTime = time.Duration(ms) * time.Millisecond
+	s.Supported = true
+
+	return s, nil
+}
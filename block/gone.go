@@ -0,0 +1,55 @@
+package block
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ErrDeviceGone is returned when a device disappears in the middle of an
+// operation, e.g. it was unplugged. Check for it with errors.Is.
+var ErrDeviceGone = errors.New("device is gone")
+
+type goneError struct {
+	name string
+	err  error
+}
+
+func (e *goneError) Error() string {
+	return fmt.Sprintf("%s is gone: %v", e.name, e.err)
+}
+
+func (e *goneError) Unwrap() error {
+	return e.err
+}
+
+func (e *goneError) Is(target error) bool {
+	return target == ErrDeviceGone
+}
+
+// deviceErr marks errors of the device as ErrDeviceGone when it vanished:
+// sysfs attributes and device nodes disappear with ENOENT and open handles
+// fail with ENODEV or ENXIO. The same errnos come from attributes or ioctls
+// a present device doesn't have, so the error is only marked when the
+// device is gone from sysfs too.
+func (s Sysfs) deviceErr(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, errno := range []syscall.Errno{syscall.ENOENT, syscall.ENODEV, syscall.ENXIO} {
+		if !errors.Is(err, errno) {
+			continue
+		}
+		if _, statErr := os.Stat(s.classPath(name)); os.IsNotExist(statErr) {
+			return &goneError{name: name, err: err}
+		}
+		break
+	}
+	return err
+}
+
+func (d Device) deviceErr(err error) error {
+	return d.sysfs.deviceErr(d.Name, err)
+}
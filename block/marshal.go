@@ -0,0 +1,123 @@
+package block
+
+import (
+	"encoding/json"
+
+	"github.com/alexdzyoba/sys/units"
+	"github.com/pkg/errors"
+)
+
+var typeNames = map[Type]string{
+	TypeUnknown:      "unknown",
+	TypeDisk:         "disk",
+	TypeRAID:         "raid",
+	TypeDeviceMapper: "dm",
+	TypeOptical:      "optical",
+	TypeNVMe:         "nvme",
+	TypeZram:         "zram",
+	TypeRAMDisk:      "ramdisk",
+}
+
+func (t Type) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the type by name
+func (t Type) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText decodes the type name
+func (t *Type) UnmarshalText(text []byte) error {
+	for typ, name := range typeNames {
+		if name == string(text) {
+			*t = typ
+			return nil
+		}
+	}
+	return errors.Errorf("unknown device type %q", text)
+}
+
+// String renders the device like "sda disk 931.5 GiB"
+func (d Device) String() string {
+	return d.Name + " " + d.Type.String() + " " + units.Size(d.Size)
+}
+
+// deviceJSON is the wire format of Device. Sizes are numbers in bytes,
+// formatting them depends on the units settings and is left to String.
+// Rotational is omitted when the kernel didn't report it.
+type deviceJSON struct {
+	Name              string `json:"name"`
+	Size              uint64 `json:"size"`
+	Type              Type   `json:"type"`
+	Rotational        *bool  `json:"rotational,omitempty"`
+	LogicalBlockSize  uint64 `json:"logical_block_size,omitempty"`
+	PhysicalBlockSize uint64 `json:"physical_block_size,omitempty"`
+	Model             string `json:"model,omitempty"`
+	Vendor            string `json:"vendor,omitempty"`
+	Serial            string `json:"serial,omitempty"`
+	WWN               string `json:"wwn,omitempty"`
+	Removable         bool   `json:"removable"`
+	Hotpluggable      bool   `json:"hotpluggable"`
+	DMName            string `json:"dm_name,omitempty"`
+	DMUUID            string `json:"dm_uuid,omitempty"`
+}
+
+// MarshalJSON encodes the device
+func (d Device) MarshalJSON() ([]byte, error) {
+	j := deviceJSON{
+		Name:              d.Name,
+		Size:              d.Size,
+		Type:              d.Type,
+		LogicalBlockSize:  d.LogicalBlockSize,
+		PhysicalBlockSize: d.PhysicalBlockSize,
+		Model:             d.Model,
+		Vendor:            d.Vendor,
+		Serial:            d.Serial,
+		WWN:               d.WWN,
+		Removable:         d.Removable,
+		Hotpluggable:      d.Hotpluggable,
+		DMName:            d.DMName,
+		DMUUID:            d.DMUUID,
+	}
+	if d.rotationalKnown {
+		rotational := d.Rotational
+		j.Rotational = &rotational
+	}
+
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes a device encoded by MarshalJSON. The device refers
+// to DefaultSysfs, so its methods read the current host.
+func (d *Device) UnmarshalJSON(data []byte) error {
+	var j deviceJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return errors.Wrap(err, "failed to decode device")
+	}
+
+	*d = Device{
+		Name:              j.Name,
+		Size:              j.Size,
+		Type:              j.Type,
+		LogicalBlockSize:  j.LogicalBlockSize,
+		PhysicalBlockSize: j.PhysicalBlockSize,
+		Model:             j.Model,
+		Vendor:            j.Vendor,
+		Serial:            j.Serial,
+		WWN:               j.WWN,
+		Removable:         j.Removable,
+		Hotpluggable:      j.Hotpluggable,
+		DMName:            j.DMName,
+		DMUUID:            j.DMUUID,
+		sysfs:             DefaultSysfs,
+	}
+	if j.Rotational != nil {
+		d.Rotational, d.rotationalKnown = *j.Rotational, true
+	}
+
+	return nil
+}
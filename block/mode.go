@@ -0,0 +1,77 @@
+package block
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/alexdzyoba/sys/sysfs"
+	"github.com/pkg/errors"
+)
+
+// ParseMode controls what happens when an attribute has an unexpected
+// format
+type ParseMode int
+
+const (
+	// Strict returns errors, meant for tests and CI
+	Strict ParseMode = iota
+	// Permissive uses zero values and reports a Warning instead, so agents
+	// don't fail on unusual vendor sysfs contents
+	Permissive
+)
+
+// Warning is a non-fatal problem found while reading a device
+type Warning struct {
+	Device string
+	// Attr is the attribute path relative to the device directory, empty
+	// for problems with the device as a whole
+	Attr string
+	Err  error
+}
+
+func (w Warning) String() string {
+	if w.Attr == "" {
+		return fmt.Sprintf("%s: %v", w.Device, w.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", w.Device, w.Attr, w.Err)
+}
+
+var (
+	warningMu      sync.RWMutex
+	warningHandler func(Warning)
+)
+
+// SetWarningHandler installs a process-wide handler of warnings. Passing
+// nil drops them.
+func SetWarningHandler(h func(Warning)) {
+	warningMu.Lock()
+	warningHandler = h
+	warningMu.Unlock()
+}
+
+// warn reports the warning to the process-wide handler and to the sink of
+// the current call if there is one
+func warn(sink func(Warning), w Warning) {
+	warningMu.RLock()
+	h := warningHandler
+	warningMu.RUnlock()
+
+	if h != nil {
+		h(w)
+	}
+	if sink != nil {
+		sink(w)
+	}
+}
+
+// lenient returns nil for parse errors in permissive mode after reporting
+// them as warnings. Other errors are returned as is.
+func (s Sysfs) lenient(sink func(Warning), dev, attr string, err error) error {
+	var pe *sysfs.ParseError
+	if err == nil || s.Mode != Permissive || !errors.As(err, &pe) {
+		return err
+	}
+
+	warn(sink, Warning{Device: dev, Attr: attr, Err: err})
+	return nil
+}
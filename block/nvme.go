@@ -0,0 +1,119 @@
+package block
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NVMeInfo describes an NVMe namespace and its controller
+type NVMeInfo struct {
+	NamespaceID int
+	// Controller is the kernel name of the controller like "nvme0". With
+	// native multipathing it's the first of the controllers.
+	Controller string
+	Model      string
+	Serial     string
+	Firmware   string
+	// Transport is "pcie", "tcp", "rdma", "fc" or "loop"
+	Transport    string
+	SubsystemNQN string
+	// SubsystemPath is the sysfs directory of the NVMe subsystem like
+	// /sys/devices/virtual/nvme-subsystem/nvme-subsys0, empty on kernels
+	// without subsystems
+	SubsystemPath string
+}
+
+// isNVMe returns whether the device hangs off an NVMe controller or, with
+// native multipathing, off an NVMe subsystem
+func isNVMe(sysfsPath string) bool {
+	subsystem, err := os.Readlink(path.Join(sysfsPath, "device", "subsystem"))
+	if err != nil {
+		return false
+	}
+	switch path.Base(subsystem) {
+	case "nvme", "nvme-subsystem":
+		return true
+	}
+	return false
+}
+
+// NVMe reads the namespace ID and controller details of an NVMe device
+func (d Device) NVMe() (*NVMeInfo, error) {
+	if d.Type != TypeNVMe {
+		return nil, errors.Errorf("%s is not an NVMe device", d.Name)
+	}
+
+	sysfsPath := d.sysfsPath()
+	devicePath, err := filepath.EvalSymlinks(path.Join(sysfsPath, "device"))
+	if err != nil {
+		return nil, d.deviceErr(errors.Wrapf(err, "failed to resolve controller of %s", d.Name))
+	}
+
+	// A multipath namespace points to the subsystem holding the
+	// controllers, otherwise to the controller itself
+	ctrlPath := devicePath
+	if strings.HasPrefix(path.Base(devicePath), "nvme-subsys") {
+		ctrls := controllers(devicePath)
+		if len(ctrls) == 0 {
+			return nil, errors.Errorf("no controllers in %s", devicePath)
+		}
+		ctrlPath = ctrls[0]
+	}
+
+	info := &NVMeInfo{
+		Controller:   path.Base(ctrlPath),
+		Model:        readTrimmed(path.Join(ctrlPath, "model")),
+		Serial:       readTrimmed(path.Join(ctrlPath, "serial")),
+		Firmware:     readTrimmed(path.Join(ctrlPath, "firmware_rev")),
+		Transport:    readTrimmed(path.Join(ctrlPath, "transport")),
+		SubsystemNQN: readTrimmed(path.Join(ctrlPath, "subsysnqn")),
+	}
+	info.SubsystemPath = d.sysfs.nvmeSubsystem(info.Controller)
+
+	// nsid appeared in 5.x, older kernels only have it in the name
+	// nvme<ctrl>n<ns>
+	if info.NamespaceID, err = strconv.Atoi(readTrimmed(path.Join(sysfsPath, "nsid"))); err != nil {
+		if i := strings.LastIndex(d.Name, "n"); i > 0 {
+			info.NamespaceID, _ = strconv.Atoi(d.Name[i+1:])
+		}
+	}
+
+	return info, nil
+}
+
+// controllerName matches controllers and not the namespaces like "nvme0n1"
+// next to them in a subsystem
+var controllerName = regexp.MustCompile(`^nvme\d+$`)
+
+// controllers returns the controllers of the subsystem directory in order
+func controllers(subsysPath string) []string {
+	matches, _ := filepath.Glob(path.Join(subsysPath, "nvme[0-9]*"))
+	var ctrls []string
+	for _, m := range matches {
+		if controllerName.MatchString(path.Base(m)) {
+			ctrls = append(ctrls, m)
+		}
+	}
+	return ctrls
+}
+
+// nvmeSubsystem returns the sysfs directory of the subsystem linking to the
+// controller. The controller itself lives under its PCI or fabrics device,
+// only multipath namespaces sit in the subsystem.
+func (s Sysfs) nvmeSubsystem(controller string) string {
+	links, _ := filepath.Glob(s.root("class", "nvme-subsystem", "*", controller))
+	if len(links) == 0 {
+		return ""
+	}
+	subsysPath, err := filepath.EvalSymlinks(path.Dir(links[0]))
+	if err != nil {
+		return ""
+	}
+	return subsysPath
+}
@@ -0,0 +1,112 @@
+package block
+
+import (
+	"context"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/alexdzyoba/sys/poll"
+	"github.com/pkg/errors"
+)
+
+// ErrLocked is returned by TryLock when another process holds the lock.
+// Check for it with errors.Is.
+var ErrLocked = errors.New("device is locked")
+
+// lockInterval is how often Lock retries a held lock
+const lockInterval = 50 * time.Millisecond
+
+// OpenFlags select how Open opens the device node
+type OpenFlags int
+
+const (
+	// OpenWrite opens the device for reading and writing instead of
+	// reading only
+	OpenWrite OpenFlags = 1 << iota
+	// OpenExclusive adds O_EXCL, which the kernel refuses while the device
+	// or any of its partitions is mounted or held by device mapper or md,
+	// and which makes other exclusive opens fail until the file is closed
+	OpenExclusive
+	// OpenDirect adds O_DIRECT bypassing the page cache. Buffers, offsets
+	// and lengths of reads and writes must then be aligned to the logical
+	// block size.
+	OpenDirect
+)
+
+// Open opens the device node
+func (d Device) Open(flags OpenFlags) (*os.File, error) {
+	node := path.Join("/dev", d.Name)
+
+	mode := os.O_RDONLY
+	if flags&OpenWrite != 0 {
+		mode = os.O_RDWR
+	}
+	if flags&OpenExclusive != 0 {
+		mode |= syscall.O_EXCL
+	}
+	if flags&OpenDirect != 0 {
+		mode |= syscall.O_DIRECT
+	}
+
+	f, err := os.OpenFile(node, mode, 0)
+	if err != nil {
+		if flags&OpenExclusive != 0 {
+			return nil, d.deviceErr(errors.Wrapf(err, "failed to open %s exclusively", node))
+		}
+		return nil, d.deviceErr(errors.Wrapf(err, "failed to open %s", node))
+	}
+	return f, nil
+}
+
+// Lock is an advisory lock of a device taken with flock. Agents taking it
+// before formatting or partitioning don't work on the same disk at once,
+// and systemd-udevd doesn't probe a whole disk while it's locked, so it
+// doesn't see half written tables.
+type Lock struct {
+	f *os.File
+}
+
+// Lock takes the exclusive lock of the device waiting until the holder
+// releases it or the context is done
+func (d Device) Lock(ctx context.Context) (*Lock, error) {
+	var l *Lock
+	err := poll.Until(ctx, lockInterval, func() (bool, error) {
+		var err error
+		l, err = d.TryLock()
+		if errors.Is(err, ErrLocked) {
+			return false, nil
+		}
+		return true, err
+	})
+	if err != nil && err == ctx.Err() {
+		return nil, errors.Wrapf(err, "failed to lock %s", d.Name)
+	}
+	return l, err
+}
+
+// TryLock takes the exclusive lock of the device, ErrLocked is returned if
+// it's held
+func (d Device) TryLock() (*Lock, error) {
+	// Locks belong to the open file, a read only one doesn't make udev
+	// emit a change event on close
+	f, err := d.Open(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errors.Wrapf(ErrLocked, "failed to lock %s", d.Name)
+		}
+		return nil, d.deviceErr(errors.Wrapf(err, "failed to lock %s", d.Name))
+	}
+	return &Lock{f: f}, nil
+}
+
+// Unlock releases the lock
+func (l *Lock) Unlock() error {
+	return errors.Wrap(l.f.Close(), "failed to unlock device")
+}
@@ -0,0 +1,65 @@
+package block
+
+import (
+	"math"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	cdromDriveStatus = 0x5326
+	// cdslCurrent asks for the loaded disc, on changers 0 would be the first
+	// slot
+	cdslCurrent = math.MaxInt32
+)
+
+// MediumStatus is the state of an optical drive
+type MediumStatus int
+
+// Values match CDS_* constants from linux/cdrom.h
+const (
+	MediumNoInfo MediumStatus = iota
+	MediumNoDisc
+	MediumTrayOpen
+	MediumNotReady
+	MediumPresent
+)
+
+func (s MediumStatus) String() string {
+	switch s {
+	case MediumNoDisc:
+		return "no disc"
+	case MediumTrayOpen:
+		return "tray open"
+	case MediumNotReady:
+		return "not ready"
+	case MediumPresent:
+		return "present"
+	}
+	return "no info"
+}
+
+// MediumStatus returns whether an optical drive has a disc loaded
+func (d Device) MediumStatus() (MediumStatus, error) {
+	if d.Type != TypeOptical {
+		return MediumNoInfo, errors.Errorf("%s is not an optical drive", d.Name)
+	}
+
+	node := path.Join("/dev", d.Name)
+	// O_NONBLOCK allows opening a drive without a disc
+	f, err := os.OpenFile(node, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return MediumNoInfo, d.deviceErr(errors.Wrapf(err, "failed to open %s", node))
+	}
+	defer f.Close()
+
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), cdromDriveStatus, cdslCurrent)
+	if errno != 0 {
+		return MediumNoInfo, d.deviceErr(errors.Wrapf(errno, "failed to get drive status of %s", node))
+	}
+
+	return MediumStatus(r), nil
+}
@@ -0,0 +1,99 @@
+package block
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ListOptions select devices returned by ListDevicesWithOptions. The zero
+// value selects everything like ListDevices.
+type ListOptions struct {
+	// ExcludeRemovable skips devices with removable media and hotpluggable
+	// ones like USB sticks, which often aren't flagged removable
+	ExcludeRemovable bool
+	ExcludeLoop      bool
+	// ExcludeRAMDisks skips brd and zram devices
+	ExcludeRAMDisks bool
+	// MinSize skips devices smaller than that many bytes
+	MinSize uint64
+	// Types keeps only devices of the types, all types if empty
+	Types []Type
+	// Name is a glob the device name must match like "sd*"
+	Name string
+}
+
+// ExcludeVirtual skips loop and RAM devices, the most common filtering
+var ExcludeVirtual = ListOptions{ExcludeLoop: true, ExcludeRAMDisks: true}
+
+// ListDevicesWithOptions returns block devices found in the system
+// matching the options
+func ListDevicesWithOptions(opts ListOptions) ([]Device, error) {
+	return DefaultSysfs.ListDevicesWithOptions(opts)
+}
+
+// ListDevicesWithOptions returns block devices found in the tree matching
+// the options
+func (s Sysfs) ListDevicesWithOptions(opts ListOptions) ([]Device, error) {
+	if opts.Name != "" {
+		if _, err := filepath.Match(opts.Name, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid name pattern %q", opts.Name)
+		}
+	}
+
+	ds, err := s.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []Device
+	for _, d := range ds {
+		ok, err := opts.match(d)
+		if err != nil {
+			if errors.Is(err, ErrDeviceGone) {
+				continue
+			}
+			return nil, err
+		}
+		if ok {
+			selected = append(selected, d)
+		}
+	}
+
+	return selected, nil
+}
+
+func (o ListOptions) match(d Device) (bool, error) {
+	if o.ExcludeLoop && strings.HasPrefix(d.Name, "loop") {
+		return false, nil
+	}
+	if o.ExcludeRAMDisks && (d.Type == TypeZram || d.Type == TypeRAMDisk) {
+		return false, nil
+	}
+	if d.Size < o.MinSize {
+		return false, nil
+	}
+	if o.Name != "" {
+		if ok, _ := filepath.Match(o.Name, d.Name); !ok {
+			return false, nil
+		}
+	}
+	if len(o.Types) > 0 && !hasType(o.Types, d.Type) {
+		return false, nil
+	}
+	if o.ExcludeRemovable && (d.Removable || d.Hotpluggable) {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func hasType(types []Type, t Type) bool {
+	for _, typ := range types {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
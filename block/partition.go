@@ -0,0 +1,146 @@
+package block
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alexdzyoba/sys/sysfs"
+	"github.com/pkg/errors"
+)
+
+// Partition is a partition of a whole disk
+type Partition struct {
+	Name   string
+	Number int
+	// Start is the first sector of the partition in 512 bytes units
+	Start uint64
+	Size  uint64
+	// Parent is the name of the whole disk
+	Parent string
+}
+
+// Partitions returns partitions of the device ordered by number. Partitions
+// are sysfs subdirectories of the disk named after it like sda1 or
+// nvme0n1p1.
+func (d Device) Partitions() ([]Partition, error) {
+	return d.partitions(nil)
+}
+
+func (d Device) partitions(sink func(Warning)) ([]Partition, error) {
+	sysfsPath := d.sysfsPath()
+
+	entries, err := ioutil.ReadDir(sysfsPath)
+	if err != nil {
+		return nil, d.deviceErr(errors.Wrapf(err, "failed to read %v", sysfsPath))
+	}
+
+	var ps []Partition
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), d.Name) {
+			continue
+		}
+
+		p, ok, err := d.sysfs.readPartition(sink, d.policy, path.Join(sysfsPath, e.Name()), d.Name)
+		if err != nil {
+			return nil, d.deviceErr(err)
+		}
+		if ok {
+			ps = append(ps, p)
+		}
+	}
+
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Number < ps[j].Number })
+
+	return ps, nil
+}
+
+// readPartition reads the partition at its sysfs directory. It returns
+// false if the directory is not a partition or was removed meanwhile.
+// Attributes of a partition that is being added are retried according to
+// the policy.
+func (s Sysfs) readPartition(sink func(Warning), policy RetryPolicy, partPath, parent string) (Partition, bool, error) {
+	name := path.Base(partPath)
+
+	number, err := sysfs.Read(path.Join(partPath, "partition"), sysfs.Int[int])
+	if err != nil {
+		return Partition{}, false, nil
+	}
+	start, err := readPartitionAttr(policy, partPath, "start", sysfs.Int[uint64])
+	if err := s.lenient(sink, parent, path.Join(name, "start"), err); err != nil {
+		return Partition{}, false, err
+	}
+	size, err := readPartitionAttr(policy, partPath, "size", sysfs.Sectors)
+	if err := s.lenient(sink, parent, path.Join(name, "size"), err); err != nil {
+		return Partition{}, false, err
+	}
+
+	return Partition{
+		Name:   name,
+		Number: number,
+		Start:  start,
+		Size:   size,
+		Parent: parent,
+	}, true, nil
+}
+
+func readPartitionAttr[T any](policy RetryPolicy, partPath, attr string, parse sysfs.Parser[T]) (T, error) {
+	var zero T
+	p := path.Join(partPath, attr)
+	content, err := policy.readFile(partPath, p)
+	if err != nil {
+		return zero, errors.Wrapf(err, "failed to read %v", p)
+	}
+	value := strings.TrimSpace(string(content))
+	v, err := parse(value)
+	if err != nil {
+		return zero, &sysfs.ParseError{Path: p, Value: value, Err: err}
+	}
+	return v, nil
+}
+
+// Path returns the device node of the partition, which probe.Probe reads
+// like the one of a whole disk
+func (p Partition) Path() string {
+	return path.Join("/dev", p.Name)
+}
+
+// LookupPartition returns the partition of a device node like /dev/sda1 or
+// a symlink to one like /dev/disk/by-uuid/<uuid> or
+// /dev/disk/by-label/<label>
+func LookupPartition(devicePath string) (*Partition, error) {
+	return DefaultSysfs.LookupPartition(devicePath)
+}
+
+// LookupPartition returns the partition of a device node from the tree
+func (s Sysfs) LookupPartition(devicePath string) (*Partition, error) {
+	name := path.Base(resolveNode(devicePath))
+
+	partPath, err := filepath.EvalSymlinks(s.classPath(name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve sysfs path of %s", devicePath)
+	}
+
+	// Partitions are subdirectories of their disk
+	p, ok, err := s.readPartition(nil, DefaultRetryPolicy, partPath, path.Base(path.Dir(partPath)))
+	if err != nil {
+		return nil, s.deviceErr(name, err)
+	}
+	if !ok {
+		return nil, errors.Errorf("%s is not a partition", devicePath)
+	}
+
+	return &p, nil
+}
+
+// resolveNode follows symlinks of device nodes like the ones in /dev/disk.
+// Paths that don't exist are returned as is, so names of fixture trees keep
+// working.
+func resolveNode(devicePath string) string {
+	if resolved, err := filepath.EvalSymlinks(devicePath); err == nil {
+		return resolved
+	}
+	return devicePath
+}
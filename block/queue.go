@@ -0,0 +1,141 @@
+package block
+
+import (
+	"context"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/alexdzyoba/sys/sysfs"
+	"github.com/pkg/errors"
+)
+
+// RQAffinity selects the CPU completing requests, see queue/rq_affinity
+type RQAffinity int
+
+const (
+	// RQAffinityOff completes requests on the CPU the device interrupts
+	RQAffinityOff RQAffinity = iota
+	// RQAffinityGroup completes requests on a CPU sharing the cache with
+	// the submitting one
+	RQAffinityGroup
+	// RQAffinityCPU completes requests on the submitting CPU
+	RQAffinityCPU
+)
+
+// Queue reads and sets tunables of the request queue of a device in
+// /sys/block/<name>/queue. Values are read live, unlike Device fields.
+type Queue struct {
+	name  string
+	sysfs Sysfs
+}
+
+// Queue returns the request queue of the device
+func (d Device) Queue() Queue {
+	return Queue{name: d.Name, sysfs: d.sysfs}
+}
+
+func (q Queue) attr(name string) string {
+	return path.Join(q.sysfs.blockPath(q.name), "queue", name)
+}
+
+func queueRead[T any](q Queue, attr string, parse sysfs.Parser[T]) (T, error) {
+	v, err := sysfs.Read(q.attr(attr), parse)
+	return v, q.sysfs.deviceErr(q.name, err)
+}
+
+func queueWrite[T any](ctx context.Context, q Queue, attr string, v T, format sysfs.Formatter[T]) error {
+	return q.sysfs.deviceErr(q.name, sysfs.Write(ctx, q.attr(attr), v, format))
+}
+
+// Scheduler returns the active I/O scheduler like "mq-deadline" or "none"
+func (q Queue) Scheduler() (string, error) {
+	return queueRead(q, "scheduler", sysfs.Selected)
+}
+
+// Schedulers returns the I/O schedulers available for the device.
+// Schedulers built as modules are only listed once loaded.
+func (q Queue) Schedulers() ([]string, error) {
+	return queueRead(q, "scheduler", func(s string) ([]string, error) {
+		var names []string
+		for _, f := range strings.Fields(s) {
+			names = append(names, strings.Trim(f, "[]"))
+		}
+		return names, nil
+	})
+}
+
+// SetScheduler switches the I/O scheduler. The kernel loads the module of
+// a scheduler not yet available if it can.
+func (q Queue) SetScheduler(ctx context.Context, name string) error {
+	if name == "" || strings.ContainsAny(name, " []\n") {
+		return errors.Errorf("invalid scheduler name %q", name)
+	}
+	return queueWrite(ctx, q, "scheduler", name, sysfs.FormatString[string])
+}
+
+// NrRequests returns how many requests the scheduler or each hardware
+// queue keeps in flight
+func (q Queue) NrRequests() (uint64, error) {
+	return queueRead(q, "nr_requests", sysfs.Int[uint64])
+}
+
+// SetNrRequests sets the request limit, the kernel rejects values beyond
+// the hardware queue depth when no scheduler is used
+func (q Queue) SetNrRequests(ctx context.Context, n uint64) error {
+	if n == 0 {
+		return errors.Errorf("nr_requests of %s must be positive", q.name)
+	}
+	return queueWrite(ctx, q, "nr_requests", n, sysfs.FormatInt[uint64])
+}
+
+// ReadAhead returns the read-ahead window in bytes
+func (q Queue) ReadAhead() (uint64, error) {
+	return queueRead(q, "read_ahead_kb", sysfs.KiB)
+}
+
+// SetReadAhead sets the read-ahead window in bytes rounded up to KiB, so
+// small windows don't turn into zero, which disables read-ahead
+func (q Queue) SetReadAhead(ctx context.Context, bytes uint64) error {
+	if bytes > math.MaxUint64-1023 {
+		return errors.Errorf("read-ahead %d of %s is too large", bytes, q.name)
+	}
+	return queueWrite(ctx, q, "read_ahead_kb", (bytes+1023)&^1023, sysfs.FormatKiB)
+}
+
+// MaxSectors returns the largest request size in bytes
+func (q Queue) MaxSectors() (uint64, error) {
+	return queueRead(q, "max_sectors_kb", sysfs.KiB)
+}
+
+// MaxHWSectors returns the largest request size in bytes the hardware
+// supports, the upper bound of SetMaxSectors
+func (q Queue) MaxHWSectors() (uint64, error) {
+	return queueRead(q, "max_hw_sectors_kb", sysfs.KiB)
+}
+
+// SetMaxSectors sets the largest request size in bytes. It must be at
+// least a page and at most MaxHWSectors.
+func (q Queue) SetMaxSectors(ctx context.Context, bytes uint64) error {
+	max, err := q.MaxHWSectors()
+	if err != nil {
+		return err
+	}
+	if bytes < 4096 || bytes > max {
+		return errors.Errorf("max sectors %d of %s is out of range 4096-%d", bytes, q.name, max)
+	}
+	return queueWrite(ctx, q, "max_sectors_kb", bytes, sysfs.FormatKiB)
+}
+
+// RQAffinity returns where requests are completed
+func (q Queue) RQAffinity() (RQAffinity, error) {
+	return queueRead(q, "rq_affinity", sysfs.Int[RQAffinity])
+}
+
+// SetRQAffinity sets where requests are completed
+func (q Queue) SetRQAffinity(ctx context.Context, a RQAffinity) error {
+	if a < RQAffinityOff || a > RQAffinityCPU {
+		return errors.Errorf("invalid rq_affinity %d", a)
+	}
+	return queueWrite(ctx, q, "rq_affinity", a, sysfs.FormatInt[RQAffinity])
+}
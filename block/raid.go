@@ -0,0 +1,319 @@
+package block
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const procMDStat = "/proc/mdstat"
+
+// RAIDInfo describes an md array from its md/ directory in sysfs
+type RAIDInfo struct {
+	// Level is like "raid1" or "raid6"
+	Level string
+	// State is md/array_state like "clean", "active" or "inactive"
+	State     string
+	RaidDisks int
+	// Degraded is the number of missing members, zero for levels without
+	// redundancy
+	Degraded int
+	// SyncAction is "idle" or the running operation like "resync",
+	// "recover", "check" or "reshape"
+	SyncAction string
+	// SyncCompleted and SyncTotal are in sectors, both zero when idle
+	SyncCompleted uint64
+	SyncTotal     uint64
+	Members       []RAIDMember
+}
+
+// RAIDMember is a component device of an md array
+type RAIDMember struct {
+	// Name is the kernel name of the member like "sda1"
+	Name string
+	// Slot is the role in the array, -1 for spares and failed devices
+	Slot int
+	// State lists flags like "in_sync", "faulty", "spare" or
+	// "write_mostly"
+	State []string
+}
+
+// Progress returns the completed fraction of the running sync operation
+// from 0 to 1, or 0 when idle
+func (r RAIDInfo) Progress() float64 {
+	if r.SyncTotal == 0 {
+		return 0
+	}
+	return float64(r.SyncCompleted) / float64(r.SyncTotal)
+}
+
+// RAID reads the state of an md array
+func (d Device) RAID() (*RAIDInfo, error) {
+	if d.Type != TypeRAID {
+		return nil, errors.Errorf("%s is not an md array", d.Name)
+	}
+
+	mdPath := path.Join(d.sysfsPath(), "md")
+	read := func(attr string) string {
+		return readTrimmed(path.Join(mdPath, attr))
+	}
+
+	r := &RAIDInfo{
+		Level:      read("level"),
+		State:      read("array_state"),
+		SyncAction: read("sync_action"),
+	}
+	if r.State == "" {
+		if present, _ := exists(mdPath); !present {
+			return nil, d.deviceErr(errors.Wrapf(os.ErrNotExist, "failed to read %v", mdPath))
+		}
+	}
+	r.RaidDisks, _ = strconv.Atoi(read("raid_disks"))
+	r.Degraded, _ = strconv.Atoi(read("degraded"))
+
+	// "none" when idle, "done / total" otherwise
+	if parts := strings.Split(read("sync_completed"), "/"); len(parts) == 2 {
+		r.SyncCompleted, _ = strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 64)
+		r.SyncTotal, _ = strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 64)
+	}
+
+	members, err := filepath.Glob(path.Join(mdPath, "dev-*"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list members of %s", d.Name)
+	}
+	for _, m := range members {
+		member := RAIDMember{Name: strings.TrimPrefix(path.Base(m), "dev-"), Slot: -1}
+		if slot, err := strconv.Atoi(readTrimmed(path.Join(m, "slot"))); err == nil {
+			member.Slot = slot
+		}
+		if state := readTrimmed(path.Join(m, "state")); state != "" {
+			member.State = strings.Split(state, ",")
+		}
+		r.Members = append(r.Members, member)
+	}
+	sort.Slice(r.Members, func(i, j int) bool {
+		a, b := r.Members[i], r.Members[j]
+		if a.Slot != b.Slot {
+			// Spares and failed devices go last
+			return uint(a.Slot) < uint(b.Slot)
+		}
+		return a.Name < b.Name
+	})
+
+	return r, nil
+}
+
+// MDStat is the system-wide view of md arrays from /proc/mdstat
+type MDStat struct {
+	// Personalities are the RAID levels the kernel supports like "raid1"
+	Personalities []string
+	Arrays        []MDArray
+}
+
+// MDArray is an array entry of /proc/mdstat
+type MDArray struct {
+	Name string
+	// State is "active" or "inactive"
+	State    string
+	ReadOnly bool
+	// Level is empty for inactive arrays
+	Level   string
+	Members []MDStatMember
+	// Blocks is the array size in 1 KiB units
+	Blocks uint64
+	// RaidDisks and ActiveDisks come from "[2/1]", Status from "[U_]"
+	RaidDisks   int
+	ActiveDisks int
+	Status      string
+	// Sync is nil without a running or pending sync operation
+	Sync *MDSync
+}
+
+// MDStatMember is a member of an array in /proc/mdstat like "sdb1[1](F)"
+type MDStatMember struct {
+	Name  string
+	Index int
+	// Flags are suffixes like "F" for faulty, "S" for spare, "W" for
+	// write-mostly and "R" for replacement
+	Flags []string
+}
+
+// MDSync is the progress of a resync, recovery, check or reshape
+type MDSync struct {
+	Action string
+	// Pending is set for delayed or pending operations without progress
+	Pending bool
+	// Percent is the completion from 0 to 100
+	Percent float64
+	// Done and Total are in 1 KiB blocks
+	Done   uint64
+	Total  uint64
+	Finish time.Duration
+	// Speed is in KiB per second
+	Speed uint64
+}
+
+// ReadMDStat parses /proc/mdstat
+func ReadMDStat() (*MDStat, error) {
+	f, err := os.Open(procMDStat)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", procMDStat)
+	}
+	defer f.Close()
+
+	s, err := parseMDStat(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %v", procMDStat)
+	}
+	return s, nil
+}
+
+func parseMDStat(r io.Reader) (*MDStat, error) {
+	s := &MDStat{}
+	var array *MDArray
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "Personalities :"):
+			for _, p := range strings.Fields(strings.TrimPrefix(line, "Personalities :")) {
+				s.Personalities = append(s.Personalities, strings.Trim(p, "[]"))
+			}
+		case strings.HasPrefix(line, "unused devices:"):
+			array = nil
+		case trimmed == "":
+			array = nil
+		case !strings.HasPrefix(line, " ") && strings.Contains(line, " : "):
+			a, err := parseMDArrayLine(line)
+			if err != nil {
+				return nil, err
+			}
+			s.Arrays = append(s.Arrays, *a)
+			array = &s.Arrays[len(s.Arrays)-1]
+		case array != nil:
+			if err := parseMDDetail(array, trimmed); err != nil {
+				return nil, errors.Wrapf(err, "failed to parse %s", array.Name)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// parseMDArrayLine parses "md0 : active (auto-read-only) raid1 sdb1[1] sda1[0]"
+func parseMDArrayLine(line string) (*MDArray, error) {
+	parts := strings.SplitN(line, " : ", 2)
+	fields := strings.Fields(parts[1])
+	if len(fields) == 0 {
+		return nil, errors.Errorf("invalid array line %q", line)
+	}
+
+	a := &MDArray{Name: strings.TrimSpace(parts[0]), State: fields[0]}
+	for _, f := range fields[1:] {
+		switch {
+		case f == "(read-only)" || f == "(auto-read-only)":
+			a.ReadOnly = true
+		case strings.Contains(f, "["):
+			m, err := parseMDStatMember(f)
+			if err != nil {
+				return nil, err
+			}
+			a.Members = append(a.Members, m)
+		default:
+			a.Level = f
+		}
+	}
+
+	return a, nil
+}
+
+// parseMDStatMember parses "sdb1[1](F)"
+func parseMDStatMember(f string) (MDStatMember, error) {
+	open := strings.Index(f, "[")
+	end := strings.Index(f, "]")
+	if end < open {
+		return MDStatMember{}, errors.Errorf("invalid member %q", f)
+	}
+
+	index, err := strconv.Atoi(f[open+1 : end])
+	if err != nil {
+		return MDStatMember{}, errors.Wrapf(err, "invalid member index %q", f)
+	}
+
+	m := MDStatMember{Name: f[:open], Index: index}
+	for _, flag := range strings.Split(f[end+1:], ")") {
+		if flag = strings.TrimPrefix(flag, "("); flag != "" {
+			m.Flags = append(m.Flags, flag)
+		}
+	}
+
+	return m, nil
+}
+
+// parseMDDetail parses lines following the array line like
+// "1046528 blocks super 1.2 [2/1] [U_]" and
+// "[=>....]  recovery =  8.5% (89536/1046528) finish=0.7min speed=22384K/sec"
+func parseMDDetail(a *MDArray, line string) error {
+	fields := strings.Fields(line)
+
+	switch {
+	case len(fields) > 1 && fields[1] == "blocks":
+		a.Blocks, _ = strconv.ParseUint(fields[0], 10, 64)
+		for _, f := range fields[2:] {
+			if !strings.HasPrefix(f, "[") {
+				continue
+			}
+			f = strings.Trim(f, "[]")
+			if counts := strings.Split(f, "/"); len(counts) == 2 {
+				a.RaidDisks, _ = strconv.Atoi(counts[0])
+				a.ActiveDisks, _ = strconv.Atoi(counts[1])
+			} else {
+				a.Status = f
+			}
+		}
+	case strings.Contains(line, "=PENDING") || strings.Contains(line, "=DELAYED"):
+		action := strings.SplitN(line, "=", 2)[0]
+		a.Sync = &MDSync{Action: strings.TrimSpace(action), Pending: true}
+	case strings.Contains(line, "% ("):
+		sync := &MDSync{}
+		// The progress bar is absent for tiny arrays
+		if strings.HasPrefix(fields[0], "[") {
+			fields = fields[1:]
+		}
+		if len(fields) < 4 || fields[1] != "=" {
+			return errors.Errorf("invalid sync line %q", line)
+		}
+		sync.Action = fields[0]
+		sync.Percent, _ = strconv.ParseFloat(strings.TrimSuffix(fields[2], "%"), 64)
+		if counts := strings.Split(strings.Trim(fields[3], "()"), "/"); len(counts) == 2 {
+			sync.Done, _ = strconv.ParseUint(counts[0], 10, 64)
+			sync.Total, _ = strconv.ParseUint(counts[1], 10, 64)
+		}
+		for _, f := range fields[4:] {
+			switch {
+			case strings.HasPrefix(f, "finish="):
+				min, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimPrefix(f, "finish="), "min"), 64)
+				sync.Finish = time.Duration(min * float64(time.Minute))
+			case strings.HasPrefix(f, "speed="):
+				sync.Speed, _ = strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(f, "speed="), "K/sec"), 10, 64)
+			}
+		}
+		a.Sync = sync
+	}
+
+	return nil
+}
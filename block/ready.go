@@ -0,0 +1,265 @@
+package block
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexdzyoba/sys/mounts"
+	"github.com/alexdzyoba/sys/poll"
+	"github.com/alexdzyoba/sys/sysfs"
+	"github.com/pkg/errors"
+)
+
+// DefaultReadyTimeout applies to items of a StorageSpec without their own
+const DefaultReadyTimeout = 90 * time.Second
+
+// defaultReadyInterval is how often mounts are checked
+const defaultReadyInterval = 500 * time.Millisecond
+
+// DeviceRequirement is a device that must appear with media in it
+type DeviceRequirement struct {
+	// Name describes the requirement in errors like "data disk"
+	Name    string
+	Match   Matcher
+	Timeout time.Duration
+}
+
+// FilesystemRequirement is a filesystem that must appear by UUID or label
+type FilesystemRequirement struct {
+	UUID    string
+	Label   string
+	Timeout time.Duration
+}
+
+// MountRequirement is a mount point that must be mounted and respond
+type MountRequirement struct {
+	MountPoint string
+	Timeout    time.Duration
+}
+
+// StorageSpec lists what must be in place before a service starts
+type StorageSpec struct {
+	Devices     []DeviceRequirement
+	Filesystems []FilesystemRequirement
+	Mounts      []MountRequirement
+	// Interval is how often mounts are checked, 500ms if zero. Devices and
+	// filesystems are waited for with uevents, filesystems are also
+	// rescanned starting at the interval and backing off.
+	Interval time.Duration
+}
+
+// NotReady is a requirement that wasn't met in time
+type NotReady struct {
+	Item string
+	Err  error
+}
+
+// NotReadyError is returned by WaitForStorageReady listing every
+// requirement that wasn't met
+type NotReadyError struct {
+	Items []NotReady
+}
+
+func (e *NotReadyError) Error() string {
+	parts := make([]string, 0, len(e.Items))
+	for _, i := range e.Items {
+		parts = append(parts, fmt.Sprintf("%s: %v", i.Item, i.Err))
+	}
+	return "storage not ready: " + strings.Join(parts, "; ")
+}
+
+// WaitForStorageReady blocks until every requirement of the spec is met.
+// Requirements are waited for concurrently, each within its own timeout,
+// DefaultReadyTimeout if zero, and the context bounds them all. It's meant
+// to gate service startup instead of sleep loops in unit files.
+func WaitForStorageReady(ctx context.Context, spec StorageSpec) error {
+	return DefaultSysfs.WaitForStorageReady(ctx, spec)
+}
+
+// WaitForStorageReady waits for the spec like WaitForStorageReady scanning
+// the tree
+func (s Sysfs) WaitForStorageReady(ctx context.Context, spec StorageSpec) error {
+	interval := spec.Interval
+	if interval <= 0 {
+		interval = defaultReadyInterval
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		nrs []NotReady
+	)
+	wait := func(item string, timeout time.Duration, fn func(context.Context) error) {
+		if timeout <= 0 {
+			timeout = DefaultReadyTimeout
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			if err := fn(ctx); err != nil {
+				mu.Lock()
+				nrs = append(nrs, NotReady{Item: item, Err: err})
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, r := range spec.Devices {
+		r := r
+		wait("device "+r.Name, r.Timeout, func(ctx context.Context) error {
+			return s.waitDeviceReady(ctx, r.Match, interval)
+		})
+	}
+	for _, r := range spec.Filesystems {
+		q := uuidQuery(r.UUID)
+		if r.UUID == "" {
+			q = labelQuery(r.Label)
+		}
+		wait("filesystem "+q.id, r.Timeout, func(ctx context.Context) error {
+			return s.waitFilesystem(ctx, q, interval)
+		})
+	}
+	for _, r := range spec.Mounts {
+		mp := path.Clean(r.MountPoint)
+		wait("mount "+mp, r.Timeout, func(ctx context.Context) error {
+			return retry(ctx, interval, func() error {
+				return mountReady(ctx, mp)
+			})
+		})
+	}
+
+	wg.Wait()
+
+	if len(nrs) > 0 {
+		sort.Slice(nrs, func(i, j int) bool { return nrs[i].Item < nrs[j].Item })
+		return &NotReadyError{Items: nrs}
+	}
+	return nil
+}
+
+// waitDeviceReady waits for the device and then for media in it, card
+// readers and optical drives show up with zero size while empty
+func (s Sysfs) waitDeviceReady(ctx context.Context, m Matcher, interval time.Duration) error {
+	d, err := s.WaitForDevice(ctx, m)
+	if err != nil {
+		return err
+	}
+
+	sizePath := path.Join(s.classPath(d.Name), "size")
+	return retry(ctx, interval, func() error {
+		size, err := sysfs.Read(sizePath, sysfs.Sectors)
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return errors.Errorf("%s has no media", d.Name)
+		}
+		return nil
+	})
+}
+
+// maxScanBackoff bounds how far apart full scans for a filesystem get in
+// intervals
+const maxScanBackoff = 16
+
+// waitFilesystem checks devices as uevents report them added or changed and
+// scans all of them only at the start, after lost events and with an
+// exponential backoff from the interval. The scans catch filesystems created
+// without an event, e.g. by mkfs on a host without udev, and back off since
+// probing reads every device.
+func (s Sysfs) waitFilesystem(ctx context.Context, q query, interval time.Duration) error {
+	// Subscribe before scanning so a filesystem appearing in between isn't
+	// missed. Without events, e.g. lacking permission, the scans still
+	// find it.
+	evs, _ := Watch(ctx, WatchOptions{})
+
+	_, last := s.find(q)
+	if last == nil {
+		return nil
+	}
+
+	delay := interval
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(last, "timed out")
+		case ev, ok := <-evs:
+			if !ok {
+				evs = nil
+				continue
+			}
+			switch ev.Action {
+			case EventAdd, EventChange:
+				if q.matches(ev.Device, true) {
+					return nil
+				}
+			case EventResync:
+				if _, last = s.find(q); last == nil {
+					return nil
+				}
+			}
+		case <-timer.C:
+			if _, last = s.find(q); last == nil {
+				return nil
+			}
+			if delay < maxScanBackoff*interval {
+				delay *= 2
+			}
+			timer.Reset(delay)
+		}
+	}
+}
+
+// mountReady checks the mount point is mounted and answers statfs(2)
+func mountReady(ctx context.Context, mp string) error {
+	ms, err := mounts.ListMounts()
+	if err != nil {
+		return err
+	}
+
+	var m *mounts.Mount
+	for i := range ms {
+		if ms[i].MountPoint == mp {
+			m = &ms[i]
+		}
+	}
+	if m == nil {
+		return errors.Errorf("%s is not mounted", mp)
+	}
+
+	// A hung network mount must not eat the whole timeout in one check
+	probeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if res := mounts.Probe(probeCtx, *m); res.Health != mounts.HealthOK {
+		return res.Err
+	}
+	return nil
+}
+
+// retry calls fn until it succeeds or the context is done, returning the
+// last failure then
+func retry(ctx context.Context, interval time.Duration, fn func() error) error {
+	var last error
+	err := poll.Until(ctx, interval, func() (bool, error) {
+		last = fn()
+		return last == nil, nil
+	})
+	if err != nil {
+		return errors.Wrap(last, "timed out")
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package block
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Holders returns devices built on top of this one, like md arrays and dm
+// targets. Holders of the disk partitions are included.
+func (d Device) Holders() ([]Device, error) {
+	dirs := []string{d.sysfsPath()}
+
+	ps, err := d.Partitions()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range ps {
+		dirs = append(dirs, path.Join(d.sysfsPath(), p.Name))
+	}
+
+	return d.sysfs.related(d.Name, dirs, "holders")
+}
+
+// Slaves returns devices this one is built on, like members of an md array.
+// Partitions are reported as their disks.
+func (d Device) Slaves() ([]Device, error) {
+	return d.sysfs.related(d.Name, []string{d.sysfsPath()}, "slaves")
+}
+
+// related resolves entries of holders or slaves directories to whole disk
+// devices
+func (s Sysfs) related(name string, dirs []string, rel string) ([]Device, error) {
+	seen := map[string]bool{}
+	var ds []Device
+	for _, dir := range dirs {
+		relPath := path.Join(dir, rel)
+		entries, err := ioutil.ReadDir(relPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, s.deviceErr(name, errors.Wrapf(err, "failed to read %v", relPath))
+		}
+
+		for _, e := range entries {
+			p, err := filepath.EvalSymlinks(path.Join(relPath, e.Name()))
+			if err != nil {
+				return nil, s.deviceErr(name, errors.Wrapf(err, "failed to resolve %s of %s", rel, name))
+			}
+
+			disk := path.Base(p)
+			if _, err := os.Stat(path.Join(p, "partition")); err == nil {
+				disk = path.Base(path.Dir(p))
+			}
+			if seen[disk] {
+				continue
+			}
+			seen[disk] = true
+
+			dev, err := s.NewDevice(disk)
+			if err != nil {
+				return nil, err
+			}
+			ds = append(ds, *dev)
+		}
+	}
+
+	sort.Slice(ds, func(i, j int) bool { return ds[i].Name < ds[j].Name })
+
+	return ds, nil
+}
+
+// TopLevelDevices returns devices nothing is built on, like dm targets
+// holding filesystems and disks used directly
+func TopLevelDevices() ([]Device, error) {
+	return DefaultSysfs.TopLevelDevices()
+}
+
+// LeafDevices returns devices not built on other devices, i.e. physical
+// disks and loop devices
+func LeafDevices() ([]Device, error) {
+	return DefaultSysfs.LeafDevices()
+}
+
+// TopLevelDevices returns devices of the tree without holders
+func (s Sysfs) TopLevelDevices() ([]Device, error) {
+	return s.filter(Device.Holders)
+}
+
+// LeafDevices returns devices of the tree without slaves
+func (s Sysfs) LeafDevices() ([]Device, error) {
+	return s.filter(Device.Slaves)
+}
+
+func (s Sysfs) filter(rel func(Device) ([]Device, error)) ([]Device, error) {
+	all, err := s.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	var ds []Device
+	for _, d := range all {
+		related, err := rel(d)
+		if errors.Is(err, ErrDeviceGone) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(related) == 0 {
+			ds = append(ds, d)
+		}
+	}
+
+	return ds, nil
+}
@@ -0,0 +1,54 @@
+package block
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// RetryPolicy controls how sysfs attribute reads are retried while a
+// device is being constructed. During hotplug the device directory shows up
+// before all of its attributes do, so reads may fail with ENOENT for a few
+// milliseconds. Attributes every device has and those of its partitions
+// are retried, optional ones of some drivers only are read once. Devices
+// keep the policy for reading their partitions.
+type RetryPolicy struct {
+	// Attempts is the total number of reads, values below 1 mean one
+	Attempts int
+	// Backoff is the delay before the second attempt, it doubles on each
+	// subsequent one up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is used by NewDevice, ListDevices and
+// NewDevicesFromPaths
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   5,
+	Backoff:    10 * time.Millisecond,
+	MaxBackoff: 100 * time.Millisecond,
+}
+
+// NoRetry fails on the first missing attribute
+var NoRetry = RetryPolicy{Attempts: 1}
+
+// readFile reads the attribute retrying while it doesn't exist. It gives up
+// early when the device directory itself is gone.
+func (p RetryPolicy) readFile(deviceDir, path string) ([]byte, error) {
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		content, err := ioutil.ReadFile(path)
+		if err == nil || !os.IsNotExist(err) || attempt >= p.Attempts {
+			return content, err
+		}
+		if _, err := os.Stat(deviceDir); err != nil {
+			return nil, err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
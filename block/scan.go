@@ -0,0 +1,79 @@
+package block
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// virtualPrefixes name devices without a backing hardware device whose
+// type is expectedly unknown
+var virtualPrefixes = []string{"loop", "ram", "zram", "nbd"}
+
+// Scan lists devices like ListDevices but never fails on a single device:
+// devices that can't be read are skipped and reported as warnings along
+// with other anomalies, like unknown device types and partitions not
+// fitting their disk. Warnings also go to the handler installed with
+// SetWarningHandler.
+func Scan() ([]Device, []Warning, error) {
+	return DefaultSysfs.Scan()
+}
+
+// Scan lists devices of the tree collecting warnings, see Scan
+func (s Sysfs) Scan() ([]Device, []Warning, error) {
+	var ws []Warning
+	sink := func(w Warning) { ws = append(ws, w) }
+
+	names, err := s.deviceNames()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ds []Device
+	for _, name := range names {
+		d, err := s.newDevice(name, DefaultRetryPolicy, sink)
+		if err != nil {
+			if present, _ := exists(s.blockPath(name)); present && !errors.Is(err, ErrDeviceGone) {
+				warn(sink, Warning{Device: name, Err: err})
+			}
+			continue
+		}
+
+		s.check(*d, sink)
+		ds = append(ds, *d)
+	}
+
+	return ds, ws, nil
+}
+
+// check reports anomalies of a constructed device
+func (s Sysfs) check(d Device, sink func(Warning)) {
+	if d.Type == TypeUnknown && !isVirtual(d.Name) {
+		warn(sink, Warning{Device: d.Name, Err: errors.New("unknown device type")})
+	}
+
+	ps, err := d.partitions(sink)
+	if err != nil {
+		warn(sink, Warning{Device: d.Name, Err: err})
+		return
+	}
+	for _, p := range ps {
+		if end := (p.Start*sectorSizeBytes + p.Size); end > d.Size {
+			warn(sink, Warning{
+				Device: d.Name,
+				Attr:   path.Join(p.Name, "size"),
+				Err:    errors.Errorf("partition ends at %d beyond disk size %d", end, d.Size),
+			})
+		}
+	}
+}
+
+func isVirtual(name string) bool {
+	for _, prefix := range virtualPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
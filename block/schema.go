@@ -0,0 +1,104 @@
+package block
+
+// Field describes a value the package reports so tables and documentation
+// can be generated from the package itself
+type Field struct {
+	// Name is the Go field name
+	Name string
+	// Type is the Go type like "uint64"
+	Type string
+	// Unit is empty for unitless values
+	Unit string
+	// Source is the sysfs attribute relative to /sys/block/<name>
+	Source string
+	// Kernel is the first kernel reporting the value, empty if there is no
+	// known requirement
+	Kernel      string
+	Description string
+}
+
+// Schema lists the fields of a reported type
+type Schema struct {
+	// Type is the Go type name like "Device"
+	Type   string
+	Fields []Field
+}
+
+// Schemas returns the fields of every type the package reports
+func Schemas() []Schema {
+	return []Schema{
+		{Type: "Device", Fields: []Field{
+			{Name: "Name", Type: "string", Source: ".", Description: "kernel name like sda"},
+			{Name: "Size", Type: "uint64", Unit: "bytes", Source: "size", Description: "capacity"},
+			{Name: "Type", Type: "Type", Source: "md, dm, mm_stat, dev, device/subsystem, device/type", Description: "kind of device"},
+			{Name: "Rotational", Type: "bool", Source: "queue/rotational", Kernel: "2.6.29", Description: "spinning media"},
+			{Name: "LogicalBlockSize", Type: "uint64", Unit: "bytes", Source: "queue/logical_block_size", Kernel: "2.6.31", Description: "smallest addressable unit"},
+			{Name: "PhysicalBlockSize", Type: "uint64", Unit: "bytes", Source: "queue/physical_block_size", Kernel: "2.6.31", Description: "smallest unit written without read-modify-write"},
+			{Name: "Model", Type: "string", Source: "device/model", Description: "hardware model"},
+			{Name: "Vendor", Type: "string", Source: "device/vendor", Description: "hardware vendor"},
+			{Name: "Serial", Type: "string", Source: "device/serial, serial", Description: "serial number, not reported by all drivers"},
+			{Name: "WWN", Type: "string", Source: "wwid, device/wwid", Description: "world wide name, not reported by all drivers"},
+			{Name: "Removable", Type: "bool", Source: "removable", Description: "removable media"},
+			{Name: "Hotpluggable", Type: "bool", Source: "device", Description: "attached through USB or FireWire"},
+			{Name: "DMName", Type: "string", Source: "dm/name", Description: "device-mapper name"},
+			{Name: "DMUUID", Type: "string", Source: "dm/uuid", Description: "device-mapper UUID"},
+		}},
+		{Type: "Partition", Fields: []Field{
+			{Name: "Name", Type: "string", Source: "<partition>", Description: "kernel name like sda1"},
+			{Name: "Number", Type: "int", Source: "<partition>/partition", Description: "index in the partition table"},
+			{Name: "Start", Type: "uint64", Unit: "sectors", Source: "<partition>/start", Description: "first sector in 512 bytes units"},
+			{Name: "Size", Type: "uint64", Unit: "bytes", Source: "<partition>/size", Description: "capacity"},
+			{Name: "Parent", Type: "string", Source: ".", Description: "name of the whole disk"},
+		}},
+		{Type: "FlushStats", Fields: []Field{
+			{Name: "WriteBack", Type: "bool", Source: "queue/write_cache", Kernel: "4.7", Description: "volatile write cache enabled"},
+			{Name: "FUA", Type: "bool", Source: "queue/fua", Description: "Force Unit Access supported"},
+			{Name: "Flushes", Type: "uint64", Source: "stat", Kernel: "5.5", Description: "completed flush requests"},
+			{Name: "FlushTime", Type: "time.Duration", Unit: "ns", Source: "stat", Kernel: "5.5", Description: "time spent on flush requests"},
+			{Name: "Supported", Type: "bool", Source: "stat", Description: "kernel reports flush counters"},
+		}},
+		{Type: "Stats", Fields: []Field{
+			{Name: "ReadIOs", Type: "uint64", Source: "stat", Description: "completed reads"},
+			{Name: "ReadMerges", Type: "uint64", Source: "stat", Description: "reads merged with adjacent ones"},
+			{Name: "ReadSectors", Type: "uint64", Unit: "sectors", Source: "stat", Description: "sectors read"},
+			{Name: "ReadTicks", Type: "time.Duration", Unit: "ns", Source: "stat", Description: "time spent reading"},
+			{Name: "WriteIOs", Type: "uint64", Source: "stat", Description: "completed writes"},
+			{Name: "WriteMerges", Type: "uint64", Source: "stat", Description: "writes merged with adjacent ones"},
+			{Name: "WriteSectors", Type: "uint64", Unit: "sectors", Source: "stat", Description: "sectors written"},
+			{Name: "WriteTicks", Type: "time.Duration", Unit: "ns", Source: "stat", Description: "time spent writing"},
+			{Name: "InFlight", Type: "uint64", Source: "stat", Description: "requests in progress"},
+			{Name: "IOTicks", Type: "time.Duration", Unit: "ns", Source: "stat", Description: "time with requests in progress"},
+			{Name: "TimeInQueue", Type: "time.Duration", Unit: "ns", Source: "stat", Description: "request time weighted by their count"},
+			{Name: "DiscardIOs", Type: "uint64", Source: "stat", Kernel: "4.18", Description: "completed discards"},
+			{Name: "DiscardMerges", Type: "uint64", Source: "stat", Kernel: "4.18", Description: "discards merged with adjacent ones"},
+			{Name: "DiscardSectors", Type: "uint64", Unit: "sectors", Source: "stat", Kernel: "4.18", Description: "sectors discarded"},
+			{Name: "DiscardTicks", Type: "time.Duration", Unit: "ns", Source: "stat", Kernel: "4.18", Description: "time spent discarding"},
+			{Name: "FlushIOs", Type: "uint64", Source: "stat", Kernel: "5.5", Description: "completed flush requests"},
+			{Name: "FlushTicks", Type: "time.Duration", Unit: "ns", Source: "stat", Kernel: "5.5", Description: "time spent on flush requests"},
+			{Name: "Fields", Type: "int", Source: "stat", Description: "number of values reported"},
+		}},
+		{Type: "ZramStats", Fields: []Field{
+			{Name: "Initialized", Type: "bool", Source: "initstate", Description: "whether the device is set up"},
+			{Name: "DiskSize", Type: "uint64", Unit: "bytes", Source: "disksize", Description: "uncompressed capacity"},
+			{Name: "Algorithm", Type: "string", Source: "comp_algorithm", Description: "compressor"},
+			{Name: "Algorithms", Type: "[]string", Source: "comp_algorithm", Description: "compressors offered"},
+			{Name: "OrigDataSize", Type: "uint64", Unit: "bytes", Source: "mm_stat", Description: "uncompressed data stored"},
+			{Name: "ComprDataSize", Type: "uint64", Unit: "bytes", Source: "mm_stat", Description: "compressed data stored"},
+			{Name: "MemUsedTotal", Type: "uint64", Unit: "bytes", Source: "mm_stat", Description: "memory used including overhead"},
+			{Name: "MemUsedMax", Type: "uint64", Unit: "bytes", Source: "mm_stat", Description: "peak memory used"},
+			{Name: "MemLimit", Type: "uint64", Unit: "bytes", Source: "mm_stat", Description: "memory cap, zero for none"},
+			{Name: "SamePages", Type: "uint64", Unit: "pages", Source: "mm_stat", Description: "pages filled with one value"},
+			{Name: "HugePages", Type: "uint64", Unit: "pages", Source: "mm_stat", Kernel: "5.4", Description: "incompressible pages"},
+		}},
+	}
+}
+
+// SchemaOf returns the fields of the named type
+func SchemaOf(typ string) (Schema, bool) {
+	for _, s := range Schemas() {
+		if s.Type == typ {
+			return s, true
+		}
+	}
+	return Schema{}, false
+}
@@ -0,0 +1,31 @@
+package block
+
+import (
+	"os"
+	"path"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// blkGetSize64 is BLKGETSIZE64 from linux/fs.h
+const blkGetSize64 = 0x80081272
+
+// SizeFromIoctl returns the size in bytes the kernel currently uses for
+// the device node. Right after a dm table reload or a loop capacity change
+// sysfs may still report the old size, the ioctl doesn't lag behind.
+func (d Device) SizeFromIoctl() (uint64, error) {
+	node := path.Join("/dev", d.Name)
+	f, err := os.Open(node)
+	if err != nil {
+		return 0, d.deviceErr(errors.Wrapf(err, "failed to open %s", node))
+	}
+	defer f.Close()
+
+	var size uint64
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blkGetSize64, uintptr(unsafe.Pointer(&size))); errno != 0 {
+		return 0, d.deviceErr(errors.Wrapf(errno, "failed to get size of %s", node))
+	}
+	return size, nil
+}
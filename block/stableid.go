@@ -0,0 +1,197 @@
+package block
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alexdzyoba/sys/internal/fsutil"
+	"github.com/alexdzyoba/sys/udev"
+	"github.com/pkg/errors"
+)
+
+// StableID returns an identifier of the device that survives reboots and
+// kernel renames (sdb becoming sdc). Hardware identity is preferred:
+// WWN, then serial number, then md/dm UUIDs, then filesystem UUID and
+// finally the physical path. The id is prefixed with its kind, e.g.
+// "wwn-0x5000c500a1b2c3d4" or "serial-WD-WCC4N1234567".
+func (d Device) StableID() (string, error) {
+	sysfsPath := d.sysfsPath()
+
+	props := map[string]string{}
+	if major, minor, err := devNumber(sysfsPath); err == nil {
+		if rec, err := udev.BlockDevice(major, minor); err == nil {
+			props = rec.Properties
+		}
+	}
+
+	candidates := []struct {
+		prefix string
+		value  string
+	}{
+		{"wwn-", props["ID_WWN_WITH_EXTENSION"]},
+		{"wwn-", props["ID_WWN"]},
+		{"wwid-", readTrimmed(path.Join(sysfsPath, "wwid"))},
+		{"wwid-", readTrimmed(path.Join(sysfsPath, "device", "wwid"))},
+		{"serial-", props["ID_SERIAL"]},
+		{"serial-", readTrimmed(path.Join(sysfsPath, "device", "serial"))},
+		{"md-uuid-", readTrimmed(path.Join(sysfsPath, "md", "uuid"))},
+		{"dm-uuid-", readTrimmed(path.Join(sysfsPath, "dm", "uuid"))},
+		{"uuid-", props["ID_FS_UUID"]},
+		{"path-", props["ID_PATH"]},
+	}
+
+	for _, c := range candidates {
+		if c.value != "" {
+			return c.prefix + strings.Replace(c.value, " ", "_", -1), nil
+		}
+	}
+
+	return "", errors.Errorf("no stable identifier found for %s", d.Name)
+}
+
+// DevNumber returns the major and minor numbers of the device
+func (d Device) DevNumber() (major, minor uint32, err error) {
+	major, minor, err = devNumber(d.sysfsPath())
+	if err != nil {
+		return 0, 0, d.deviceErr(err)
+	}
+	return major, minor, nil
+}
+
+// devNumber reads the major:minor pair from the dev attribute
+func devNumber(sysfsPath string) (uint32, uint32, error) {
+	content, err := ioutil.ReadFile(path.Join(sysfsPath, "dev"))
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to read device number of %s", sysfsPath)
+	}
+
+	parts := strings.SplitN(strings.TrimSpace(string(content)), ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("invalid device number %q", content)
+	}
+
+	major, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to parse major number")
+	}
+
+	minor, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to parse minor number")
+	}
+
+	return uint32(major), uint32(minor), nil
+}
+
+// readTrimmed returns the attribute value or empty string if it can't be
+// read
+func readTrimmed(p string) string {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// PhysicalPath returns where the device is attached, like udev's ID_PATH
+// ("pci-0000:00:1f.2-ata-3") or the resolved sysfs device path when udev
+// data isn't available. It changes when a disk is moved to another slot.
+func (d Device) PhysicalPath() (string, error) {
+	sysfsPath := d.sysfsPath()
+
+	if major, minor, err := devNumber(sysfsPath); err == nil {
+		if rec, err := udev.BlockDevice(major, minor); err == nil && rec.Properties["ID_PATH"] != "" {
+			return rec.Properties["ID_PATH"], nil
+		}
+	}
+
+	p, err := filepath.EvalSymlinks(path.Join(sysfsPath, "device"))
+	if err != nil {
+		return "", d.deviceErr(errors.Wrapf(err, "failed to resolve physical path of %s", d.Name))
+	}
+
+	return p, nil
+}
+
+// Rename is a kernel name change of a device detected by NameMap
+type Rename struct {
+	StableID string
+	OldName  string
+	NewName  string
+}
+
+// NameMap persists the mapping of stable ids to kernel names so consumers
+// can track the same disk across reboots. NameMap is safe for concurrent
+// use.
+type NameMap struct {
+	mu    sync.Mutex
+	path  string
+	names map[string]string
+}
+
+// LoadNameMap reads the mapping from the file, which doesn't have to exist
+func LoadNameMap(p string) (*NameMap, error) {
+	m := &NameMap{path: p, names: map[string]string{}}
+
+	content, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", p)
+	}
+
+	if err := json.Unmarshal(content, &m.names); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %v", p)
+	}
+
+	return m, nil
+}
+
+// Lookup returns the last known kernel name of the device
+func (m *NameMap) Lookup(stableID string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, ok := m.names[stableID]
+	return name, ok
+}
+
+// Update records current names of the devices and returns the renames
+// since the previous update. Devices without a stable id are skipped.
+func (m *NameMap) Update(ds []Device) []Rename {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var renames []Rename
+	for _, d := range ds {
+		id, err := d.StableID()
+		if err != nil {
+			continue
+		}
+		if old, ok := m.names[id]; ok && old != d.Name {
+			renames = append(renames, Rename{StableID: id, OldName: old, NewName: d.Name})
+		}
+		m.names[id] = d.Name
+	}
+
+	return renames
+}
+
+// Save writes the mapping back to its file atomically
+func (m *NameMap) Save() error {
+	m.mu.Lock()
+	content, err := json.MarshalIndent(m.names, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "failed to encode name map")
+	}
+
+	return fsutil.WriteFileAtomic(m.path, content)
+}
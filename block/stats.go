@@ -0,0 +1,161 @@
+package block
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const procDiskstats = "/proc/diskstats"
+
+// Stats are I/O counters of a device since boot as described in the
+// kernel's Documentation/admin-guide/iostats.rst. Sectors are 512 bytes
+// units. Discard counters appeared in Linux 4.18 and flush counters in 5.5,
+// they are zero on older kernels, see Fields.
+type Stats struct {
+	ReadIOs     uint64
+	ReadMerges  uint64
+	ReadSectors uint64
+	ReadTicks   time.Duration
+
+	WriteIOs     uint64
+	WriteMerges  uint64
+	WriteSectors uint64
+	WriteTicks   time.Duration
+
+	// InFlight is the number of requests in progress, not a counter
+	InFlight uint64
+	// IOTicks is the time the device had requests in progress
+	IOTicks time.Duration
+	// TimeInQueue is the time of all requests weighted by their count
+	TimeInQueue time.Duration
+
+	DiscardIOs     uint64
+	DiscardMerges  uint64
+	DiscardSectors uint64
+	DiscardTicks   time.Duration
+
+	FlushIOs   uint64
+	FlushTicks time.Duration
+
+	// Fields is the number of values the kernel reported
+	Fields int
+}
+
+// DiskStat is a line of /proc/diskstats
+type DiskStat struct {
+	Major uint32
+	Minor uint32
+	Name  string
+	Stats
+}
+
+// Stats reads I/O counters of the device from its stat attribute
+func (d Device) Stats() (*Stats, error) {
+	statPath := path.Join(d.sysfsPath(), "stat")
+	content, err := ioutil.ReadFile(statPath)
+	if err != nil {
+		return nil, d.deviceErr(errors.Wrapf(err, "failed to read %v", statPath))
+	}
+
+	s, err := parseStats(strings.Fields(string(content)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %v", statPath)
+	}
+
+	return s, nil
+}
+
+// DiskStats reads I/O counters of all devices and partitions at once from
+// /proc/diskstats
+func DiskStats() ([]DiskStat, error) {
+	f, err := os.Open(procDiskstats)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", procDiskstats)
+	}
+	defer f.Close()
+
+	var stats []DiskStat
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		major, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse major number in %v", procDiskstats)
+		}
+		minor, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse minor number in %v", procDiskstats)
+		}
+		s, err := parseStats(fields[3:])
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s in %v", fields[2], procDiskstats)
+		}
+
+		stats = append(stats, DiskStat{Major: uint32(major), Minor: uint32(minor), Name: fields[2], Stats: *s})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", procDiskstats)
+	}
+
+	return stats, nil
+}
+
+// parseStats parses the values of the stat attribute which are the same
+// as /proc/diskstats without the device number and name
+func parseStats(fields []string) (*Stats, error) {
+	if len(fields) < 11 {
+		return nil, errors.Errorf("expected at least 11 fields, got %d", len(fields))
+	}
+
+	values := make([]uint64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse field %d", i+1)
+		}
+		values[i] = v
+	}
+
+	// Missing trailing fields of older kernels are zero
+	get := func(i int) uint64 {
+		if i < len(values) {
+			return values[i]
+		}
+		return 0
+	}
+	ms := func(i int) time.Duration {
+		return time.Duration(get(i)) * time.Millisecond
+	}
+
+	return &Stats{
+		ReadIOs:        get(0),
+		ReadMerges:     get(1),
+		ReadSectors:    get(2),
+		ReadTicks:      ms(3),
+		WriteIOs:       get(4),
+		WriteMerges:    get(5),
+		WriteSectors:   get(6),
+		WriteTicks:     ms(7),
+		InFlight:       get(8),
+		IOTicks:        ms(9),
+		TimeInQueue:    ms(10),
+		DiscardIOs:     get(11),
+		DiscardMerges:  get(12),
+		DiscardSectors: get(13),
+		DiscardTicks:   ms(14),
+		FlushIOs:       get(statFlushes),
+		FlushTicks:     ms(statFlushTime),
+		Fields:         len(values),
+	}, nil
+}
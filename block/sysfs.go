@@ -0,0 +1,48 @@
+package block
+
+import "path"
+
+// Sysfs is a sysfs tree devices are discovered from. Pointing Root at a
+// fixture directory laid out like /sys lets device selection logic be
+// tested without root or real hardware. Devices remember the tree they come
+// from, so their methods read attributes from it as well. Operations on
+// device nodes still use /dev.
+type Sysfs struct {
+	// Root is where sysfs is mounted, "/sys" if empty
+	Root string
+	// Mode is Strict by default
+	Mode ParseMode
+	// IoctlSize makes devices take Size from SizeFromIoctl when their node
+	// can be opened, so online resize workflows don't see the stale sysfs
+	// size. Sysfs is used when the node isn't accessible.
+	IoctlSize bool
+}
+
+// DefaultSysfs is the tree used by package level functions
+var DefaultSysfs = Sysfs{Root: "/sys"}
+
+// root returns the path of the elements in the tree
+func (s Sysfs) root(elem ...string) string {
+	root := s.Root
+	if root == "" {
+		root = "/sys"
+	}
+	return path.Join(append([]string{root}, elem...)...)
+}
+
+// Path returns the path of the elements in the tree for attributes Device
+// doesn't cover, like "class/zram-control/hot_add"
+func (s Sysfs) Path(elem ...string) string {
+	return s.root(elem...)
+}
+
+// blockPath returns the path of the device under block/ of the tree
+func (s Sysfs) blockPath(name string) string {
+	return s.root("block", name)
+}
+
+// classPath returns the path of a disk or partition under class/block/ of
+// the tree
+func (s Sysfs) classPath(name string) string {
+	return s.root("class", "block", name)
+}
@@ -0,0 +1,40 @@
+package block
+
+import (
+	"os"
+	"path"
+
+	"github.com/alexdzyoba/sys/mounts"
+	"github.com/pkg/errors"
+)
+
+// Usage returns usage of the filesystem on the device. Only a filesystem
+// directly on the device counts, a disk with mounted partitions has no
+// usage of its own. An error wrapping os.ErrNotExist is returned if the
+// device is not mounted.
+func (d Device) Usage() (mounts.Usage, error) {
+	major, minor, err := d.DevNumber()
+	if err != nil {
+		return mounts.Usage{}, err
+	}
+
+	ms, err := mounts.ListMounts()
+	if err != nil {
+		return mounts.Usage{}, err
+	}
+
+	sources := map[string]bool{path.Join("/dev", d.Name): true}
+	if d.DMName != "" {
+		sources[path.Join("/dev/mapper", d.DMName)] = true
+	}
+
+	for _, m := range ms {
+		// btrfs reports an anonymous device number, its source still names
+		// the device
+		if (m.Major == major && m.Minor == minor) || sources[m.Source] {
+			return mounts.FilesystemUsage(m.MountPoint)
+		}
+	}
+
+	return mounts.Usage{}, d.deviceErr(errors.Wrapf(os.ErrNotExist, "%s is not mounted", d.Name))
+}
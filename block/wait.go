@@ -0,0 +1,222 @@
+package block
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/alexdzyoba/sys/udev"
+	"github.com/alexdzyoba/sys/uevent"
+	"github.com/pkg/errors"
+)
+
+const udevDataRoot = "/run/udev/data"
+
+// DeviceInfo is a block device, whole disk or partition, as announced by
+// the kernel and udev
+type DeviceInfo struct {
+	// Name is the kernel name like "sdb" or "sdb1"
+	Name string
+	// Node is the device node path like "/dev/sdb"
+	Node string
+	// DevType is "disk" or "partition"
+	DevType    string
+	Major      uint32
+	Minor      uint32
+	Links      []string
+	Properties map[string]string
+}
+
+// Matcher selects devices for WaitForDevice
+type Matcher func(DeviceInfo) bool
+
+// MatchName matches devices by kernel name, node path or any /dev symlink
+// like "/dev/disk/by-id/..."
+func MatchName(name string) Matcher {
+	return func(d DeviceInfo) bool {
+		if d.Name == path.Base(name) || d.Node == name {
+			return true
+		}
+		for _, l := range d.Links {
+			if l == name || "/dev/"+l == name {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchSerial matches disks by serial number or WWN
+func MatchSerial(serial string) Matcher {
+	return matchProperty(serial, "ID_SERIAL", "ID_SERIAL_SHORT", "ID_SCSI_SERIAL", "ID_WWN", "ID_WWN_WITH_EXTENSION")
+}
+
+// MatchUUID matches devices by filesystem or partition UUID
+func MatchUUID(uuid string) Matcher {
+	return matchProperty(strings.ToLower(uuid), "ID_FS_UUID", "ID_PART_ENTRY_UUID")
+}
+
+func matchProperty(value string, keys ...string) Matcher {
+	return func(d DeviceInfo) bool {
+		for _, k := range keys {
+			if v, ok := d.Properties[k]; ok && strings.ToLower(v) == strings.ToLower(value) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// WaitForDevice blocks until a device accepted by the matcher exists or the
+// context expires. Devices present at call time are found by a sysfs scan,
+// later ones through uevents. When udev is running its events are used so
+// that the device node and symlinks are in place by the time the device is
+// returned.
+func WaitForDevice(ctx context.Context, m Matcher) (*DeviceInfo, error) {
+	return DefaultSysfs.WaitForDevice(ctx, m)
+}
+
+// WaitForDevice waits for a device like WaitForDevice scanning the tree
+func (s Sysfs) WaitForDevice(ctx context.Context, m Matcher) (*DeviceInfo, error) {
+	src := uevent.SourceKernel
+	if _, err := os.Stat(udevDataRoot); err == nil {
+		src = uevent.SourceUdev
+	}
+
+	// Subscribe before scanning so a device appearing in between isn't missed
+	conn, err := uevent.Dial(src)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	infos, err := s.ListDeviceInfos()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range infos {
+		if m(d) {
+			return &d, nil
+		}
+	}
+
+	found := make(chan DeviceInfo, 1)
+	failed := make(chan error, 1)
+	go func() {
+		for {
+			ev, err := conn.Read()
+			if err != nil {
+				failed <- err
+				return
+			}
+			if ev.Subsystem != "block" || (ev.Action != "add" && ev.Action != "change") {
+				continue
+			}
+
+			d := deviceInfoFromProperties(ev.Properties)
+			if m(d) {
+				found <- d
+				return
+			}
+		}
+	}()
+
+	select {
+	case d := <-found:
+		return &d, nil
+	case err := <-failed:
+		return nil, err
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "device did not appear")
+	}
+}
+
+// ListDeviceInfos returns every block device including partitions with
+// its udev properties
+func ListDeviceInfos() ([]DeviceInfo, error) {
+	return DefaultSysfs.ListDeviceInfos()
+}
+
+// ListDeviceInfos returns every block device of the tree including
+// partitions
+func (s Sysfs) ListDeviceInfos() ([]DeviceInfo, error) {
+	classRoot := s.classPath("")
+	entries, err := ioutil.ReadDir(classRoot)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", classRoot)
+	}
+
+	infos := make([]DeviceInfo, 0, len(entries))
+	for _, e := range entries {
+		props, err := readUevent(path.Join(classRoot, e.Name(), "uevent"))
+		if errors.Is(s.deviceErr(e.Name(), err), ErrDeviceGone) {
+			// The device went away while scanning
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		d := deviceInfoFromProperties(props)
+		if rec, err := udev.BlockDevice(d.Major, d.Minor); err == nil {
+			for k, v := range rec.Properties {
+				d.Properties[k] = v
+			}
+			d.Links = rec.Links
+		}
+		infos = append(infos, d)
+	}
+
+	return infos, nil
+}
+
+func readUevent(p string) (map[string]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", p)
+	}
+	defer f.Close()
+
+	props := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) == 2 {
+			props[kv[0]] = kv[1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", p)
+	}
+
+	return props, nil
+}
+
+func deviceInfoFromProperties(props map[string]string) DeviceInfo {
+	d := DeviceInfo{
+		Name:       path.Base(props["DEVNAME"]),
+		DevType:    props["DEVTYPE"],
+		Properties: props,
+	}
+	if name := props["DEVNAME"]; name != "" {
+		d.Node = name
+		if !strings.HasPrefix(name, "/") {
+			d.Node = path.Join("/dev", name)
+		}
+	}
+
+	major, _ := strconv.ParseUint(props["MAJOR"], 10, 32)
+	minor, _ := strconv.ParseUint(props["MINOR"], 10, 32)
+	d.Major, d.Minor = uint32(major), uint32(minor)
+
+	// udev events carry symlinks as a space separated DEVLINKS property
+	for _, l := range strings.Fields(props["DEVLINKS"]) {
+		d.Links = append(d.Links, strings.TrimPrefix(l, "/dev/"))
+	}
+
+	return d
+}
@@ -0,0 +1,95 @@
+package block
+
+import (
+	"context"
+	"os"
+
+	"github.com/alexdzyoba/sys/uevent"
+)
+
+// EventAction is the kind of change of a block device
+type EventAction string
+
+const (
+	EventAdd    EventAction = "add"
+	EventRemove EventAction = "remove"
+	EventChange EventAction = "change"
+	// EventResync is sent after events were lost, consumers should list
+	// devices again
+	EventResync EventAction = "resync"
+)
+
+// Event is a change of a block device, whole disk or partition. Device is
+// empty for EventResync.
+type Event struct {
+	Action EventAction
+	Device DeviceInfo
+	Seqnum uint64
+	// Replayed marks the add events of devices that existed when watching
+	// started
+	Replayed bool
+}
+
+// WatchOptions configures Watch, see uevent.WatchOptions
+type WatchOptions struct {
+	// Buffer is the number of events queued for a slow consumer before
+	// events are lost. Defaults to 256.
+	Buffer int
+	// Replay sends an add event for every existing device before any live
+	// event
+	Replay bool
+	// Resync is called when events were lost with their number or -1 if
+	// unknown. Without it an EventResync is sent instead.
+	Resync func(lost int)
+}
+
+// Watch delivers add, remove and change events of block devices until the
+// context is done, then the channel is closed. Like WaitForDevice it uses
+// udev events when udev is running, so nodes and symlinks of added devices
+// are in place. Replayed devices carry only the kernel properties since
+// they're read from sysfs.
+func Watch(ctx context.Context, opts WatchOptions) (<-chan Event, error) {
+	src := uevent.SourceKernel
+	if _, err := os.Stat(udevDataRoot); err == nil {
+		src = uevent.SourceUdev
+	}
+
+	evs, err := uevent.Watch(ctx, src, uevent.WatchOptions{
+		Buffer:     opts.Buffer,
+		Subsystems: []string{"block"},
+		Replay:     opts.Replay,
+		Resync:     opts.Resync,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		for ev := range evs {
+			var e Event
+			switch ev.Action {
+			case uevent.ActionResync:
+				e = Event{Action: EventResync}
+			case string(EventAdd), string(EventRemove), string(EventChange):
+				e = Event{
+					Action:   EventAction(ev.Action),
+					Device:   deviceInfoFromProperties(ev.Properties),
+					Seqnum:   ev.Seqnum,
+					Replayed: ev.Synthetic,
+				}
+			default:
+				continue
+			}
+
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
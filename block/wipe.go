@@ -0,0 +1,162 @@
+package block
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/pkg/errors"
+)
+
+// ioctls from linux/fs.h
+const (
+	blkRRPart  = 0x125f
+	blkDiscard = 0x1277
+	blkZeroOut = 0x127f
+)
+
+// zeroChunk is how much is zeroed between progress reports and context
+// checks
+const zeroChunk = 64 << 20
+
+// ErasedSignature is a signature WipeSignatures zeroed
+type ErasedSignature struct {
+	Type   string
+	Offset int64
+	Length int
+}
+
+// Discard tells the device the byte range is unused with BLKDISCARD, which
+// SSDs and thin volumes turn into free space. Offset and length must be
+// multiples of the logical block size. Whether discarded blocks read back
+// as zeroes depends on the device.
+func (d Device) Discard(ctx context.Context, offset, length uint64) error {
+	node := path.Join("/dev", d.Name)
+	a := action.Action{
+		Op:     "block.discard",
+		Target: node,
+		Args:   map[string]string{"offset": strconv.FormatUint(offset, 10), "length": strconv.FormatUint(length, 10)},
+	}
+
+	if err := d.checkRange(offset, length); err != nil {
+		return err
+	}
+
+	return action.Run(ctx, a, func() error {
+		f, err := d.openExclusive()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := blockRange(f, blkDiscard, offset, length); err != nil {
+			return d.deviceErr(errors.Wrapf(err, "failed to discard %s", node))
+		}
+		return nil
+	})
+}
+
+// ZeroFill overwrites the whole device with zeroes. BLKZEROOUT lets the
+// device or the kernel do it without transferring zero buffers, plain
+// writes are the fallback. progress, if not nil, is called with the bytes
+// done after every chunk. Cancelling the context stops between chunks.
+func (d Device) ZeroFill(ctx context.Context, progress func(done, total uint64)) error {
+	node := path.Join("/dev", d.Name)
+	a := action.Action{Op: "block.zero-fill", Target: node, Args: map[string]string{"size": strconv.FormatUint(d.Size, 10)}}
+
+	return action.Run(ctx, a, func() error {
+		f, err := d.openExclusive()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		zeroOut := true
+		var buf []byte
+		for done := uint64(0); done < d.Size; {
+			if err := ctx.Err(); err != nil {
+				return d.deviceErr(errors.Wrapf(err, "zero fill of %s stopped at %d bytes", node, done))
+			}
+
+			n := uint64(zeroChunk)
+			if d.Size-done < n {
+				n = d.Size - done
+			}
+
+			if zeroOut {
+				err := blockRange(f, blkZeroOut, done, n)
+				if err == syscall.EOPNOTSUPP || err == syscall.ENOTTY || err == syscall.EINVAL {
+					zeroOut = false
+					buf = make([]byte, 1<<20)
+					continue
+				}
+				if err != nil {
+					return d.deviceErr(errors.Wrapf(err, "failed to zero %s at %d", node, done))
+				}
+			} else if err := writeZeroes(f, buf, int64(done), int64(n)); err != nil {
+				return d.deviceErr(errors.Wrapf(err, "failed to zero %s at %d", node, done))
+			}
+
+			done += n
+			if progress != nil {
+				progress(done, d.Size)
+			}
+		}
+
+		if err := f.Sync(); err != nil {
+			return d.deviceErr(errors.Wrapf(err, "failed to sync %s", node))
+		}
+		return nil
+	})
+}
+
+// openExclusive opens the device for writing, see OpenExclusive
+func (d Device) openExclusive() (*os.File, error) {
+	return d.Open(OpenWrite | OpenExclusive)
+}
+
+// checkRange validates a byte range against the size and the logical block
+// size
+func (d Device) checkRange(offset, length uint64) error {
+	bs := d.LogicalBlockSize
+	if bs == 0 {
+		bs = 512
+	}
+	if offset%bs != 0 || length%bs != 0 {
+		return errors.Errorf("range %d+%d of %s isn't aligned to %d bytes blocks", offset, length, d.Name, bs)
+	}
+	if offset+length > d.Size || offset+length < offset {
+		return errors.Errorf("range %d+%d is beyond the end of %s", offset, length, d.Name)
+	}
+	return nil
+}
+
+// blockRange issues an ioctl taking a uint64 start and length pair
+func blockRange(f *os.File, req uintptr, offset, length uint64) error {
+	r := [2]uint64{offset, length}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(&r))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func writeZeroes(w io.WriterAt, buf []byte, off, n int64) error {
+	for n > 0 {
+		chunk := buf
+		if int64(len(chunk)) > n {
+			chunk = chunk[:n]
+		}
+		written, err := w.WriteAt(chunk, off)
+		if err != nil {
+			return err
+		}
+		off += int64(written)
+		n -= int64(written)
+	}
+	return nil
+}
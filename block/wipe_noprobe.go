@@ -0,0 +1,16 @@
+//go:build noprobe
+// +build noprobe
+
+package block
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// WipeSignatures can't find signatures without the probe package, which is
+// left out of binaries built with the noprobe tag
+func (d Device) WipeSignatures(ctx context.Context) ([]ErasedSignature, error) {
+	return nil, errors.Errorf("signatures of %s can't be wiped, built with noprobe", d.Name)
+}
@@ -0,0 +1,77 @@
+//go:build !noprobe
+// +build !noprobe
+
+package block
+
+import (
+	"context"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/alexdzyoba/sys/probe"
+	"github.com/pkg/errors"
+)
+
+// WipeSignatures zeroes the magic bytes of every partition table,
+// filesystem, RAID, LVM and LUKS signature probe finds on the device like
+// wipefs --all does, so nothing recognizes the old content anymore. Data
+// behind the signatures stays. The kernel re-reads the partition table after
+// one was erased. WipeSignatures returns what was erased.
+func (d Device) WipeSignatures(ctx context.Context) ([]ErasedSignature, error) {
+	node := path.Join("/dev", d.Name)
+
+	// The device is claimed before it's scanned so nothing mounts or
+	// formats it between the scan and the writes. A dry run only reads.
+	flags := OpenWrite | OpenExclusive
+	if action.IsDryRun(ctx) {
+		flags = 0
+	}
+	f, err := d.Open(flags)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sigs, err := probe.ScanFile(f)
+	if err != nil {
+		return nil, d.deviceErr(err)
+	}
+	if len(sigs) == 0 {
+		return nil, nil
+	}
+
+	erased := make([]ErasedSignature, 0, len(sigs))
+	types := make([]string, 0, len(sigs))
+	partitionTable := false
+	for _, s := range sigs {
+		erased = append(erased, ErasedSignature{Type: s.Type, Offset: s.Offset, Length: len(s.Magic)})
+		types = append(types, s.Type)
+		partitionTable = partitionTable || s.Usage == probe.UsagePartitionTable
+	}
+
+	a := action.Action{Op: "block.wipe-signatures", Target: node, Args: map[string]string{"signatures": strings.Join(types, ",")}}
+	err = action.Run(ctx, a, func() error {
+		for _, e := range erased {
+			if _, err := f.WriteAt(make([]byte, e.Length), e.Offset); err != nil {
+				return d.deviceErr(errors.Wrapf(err, "failed to erase %s signature at %d", e.Type, e.Offset))
+			}
+		}
+		if err := f.Sync(); err != nil {
+			return d.deviceErr(errors.Wrapf(err, "failed to sync %s", node))
+		}
+
+		if partitionTable {
+			if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blkRRPart, 0); errno != 0 {
+				return d.deviceErr(errors.Wrapf(errno, "failed to re-read partition table of %s", node))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return erased, nil
+}
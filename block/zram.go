@@ -0,0 +1,122 @@
+package block
+
+import (
+	"path"
+	"strings"
+
+	"github.com/alexdzyoba/sys/sysfs"
+	"github.com/pkg/errors"
+)
+
+// ZramStats are the setup and memory usage of a zram device. Sizes are in
+// bytes and page counts in pages, see the kernel's Documentation/admin-guide/blockdev/zram.rst.
+type ZramStats struct {
+	// Initialized is set once the device got its size and until it's
+	// reset
+	Initialized bool
+	// DiskSize is the uncompressed capacity, zero until the device is set
+	// up
+	DiskSize uint64
+	// Algorithm is the compressor like "lzo-rle" or "zstd" and Algorithms
+	// the ones the kernel offers
+	Algorithm  string
+	Algorithms []string
+	// OrigDataSize is the uncompressed data stored and ComprDataSize what
+	// it compressed to
+	OrigDataSize  uint64
+	ComprDataSize uint64
+	// MemUsedTotal is the memory taken including allocator overhead and
+	// MemUsedMax its peak
+	MemUsedTotal uint64
+	MemUsedMax   uint64
+	// MemLimit caps MemUsedTotal, zero means no limit
+	MemLimit uint64
+	// SamePages counts pages filled with one value, stored without memory
+	SamePages uint64
+	// HugePages counts incompressible pages stored as is
+	HugePages uint64
+}
+
+// Ratio returns how many times smaller the data is in memory counting the
+// allocator overhead, zero for an empty device
+func (s ZramStats) Ratio() float64 {
+	if s.MemUsedTotal == 0 {
+		return 0
+	}
+	return float64(s.OrigDataSize) / float64(s.MemUsedTotal)
+}
+
+// Zram reads the setup and the memory statistics of a zram device
+func (d Device) Zram() (*ZramStats, error) {
+	if d.Type != TypeZram {
+		return nil, errors.Errorf("%s is not a zram device", d.Name)
+	}
+
+	s := &ZramStats{}
+	var err error
+	if s.Initialized, err = readZram(d, "initstate", sysfs.Bool); err != nil {
+		return nil, err
+	}
+	if s.DiskSize, err = readZram(d, "disksize", sysfs.Int[uint64]); err != nil {
+		return nil, err
+	}
+	algorithms, err := readZram(d, "comp_algorithm", zramAlgorithms)
+	if err != nil {
+		return nil, err
+	}
+	s.Algorithm, s.Algorithms = algorithms.selected, algorithms.all
+
+	stat, err := readZram(d, "mm_stat", zramMMStat)
+	if err != nil {
+		return nil, err
+	}
+	values := []*uint64{&s.OrigDataSize, &s.ComprDataSize, &s.MemUsedTotal, &s.MemLimit, &s.MemUsedMax, &s.SamePages, nil, &s.HugePages}
+	for i, v := range values {
+		if v != nil && i < len(stat) {
+			*v = stat[i]
+		}
+	}
+
+	return s, nil
+}
+
+func readZram[T any](d Device, attr string, parse sysfs.Parser[T]) (T, error) {
+	v, err := sysfs.Read(path.Join(d.sysfsPath(), attr), parse)
+	return v, d.deviceErr(err)
+}
+
+type compressors struct {
+	selected string
+	all      []string
+}
+
+// zramAlgorithms parses comp_algorithm like "lzo [lzo-rle] zstd"
+func zramAlgorithms(s string) (compressors, error) {
+	var c compressors
+	var err error
+	if c.selected, err = sysfs.Selected(s); err != nil {
+		return compressors{}, err
+	}
+	for _, a := range strings.Fields(s) {
+		c.all = append(c.all, strings.Trim(a, "[]"))
+	}
+	return c, nil
+}
+
+// zramMMStat parses the values of mm_stat, huge_pages at index 7 appeared
+// in Linux 5.4
+func zramMMStat(s string) ([]uint64, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 7 {
+		return nil, errors.Errorf("expected at least 7 fields, got %d", len(fields))
+	}
+	values := make([]uint64, len(fields))
+	for i, f := range fields {
+		v, err := sysfs.Int[uint64](f)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
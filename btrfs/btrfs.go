@@ -0,0 +1,184 @@
+// Package btrfs lists subvolumes and reports qgroup usage of mounted btrfs
+// filesystems with the tree search ioctl, without btrfs-progs. Searching
+// trees requires CAP_SYS_ADMIN.
+package btrfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// ioctls from linux/btrfs.h
+const (
+	iocTreeSearch = 0xd0009411
+	iocInoLookup  = 0xd0009412
+)
+
+// Tree and item ids from linux/btrfs_tree.h
+const (
+	rootTreeID  = 1
+	fsTreeID    = 5
+	quotaTreeID = 8
+
+	firstFreeObjectID = 256
+	lastFreeObjectID  = ^uint64(0) - 255
+
+	typeRootItem       = 132
+	typeRootBackref    = 144
+	typeQgroupStatus   = 240
+	typeQgroupInfo     = 242
+	typeQgroupLimit    = 244
+	typeQgroupRelation = 246
+)
+
+const (
+	searchArgsSize   = 4096
+	searchKeySize    = 104
+	searchHeaderSize = 32
+)
+
+// searchKey mirrors struct btrfs_ioctl_search_key
+type searchKey struct {
+	treeID      uint64
+	minObjectID uint64
+	maxObjectID uint64
+	minOffset   uint64
+	maxOffset   uint64
+	minTransID  uint64
+	maxTransID  uint64
+	minType     uint32
+	maxType     uint32
+	nrItems     uint32
+	_           uint32
+	_           [4]uint64
+}
+
+// searchArgs mirrors struct btrfs_ioctl_search_args
+type searchArgs struct {
+	key searchKey
+	buf [searchArgsSize - searchKeySize]byte
+}
+
+// item is a search result
+type item struct {
+	objectID uint64
+	offset   uint64
+	typ      uint32
+	data     []byte
+}
+
+// search returns items of the tree with objectids and types in the ranges.
+// The kernel compares whole (objectid, type, offset) tuples, so items of
+// other types between them are dropped here.
+func search(f *os.File, tree, minObjectID, maxObjectID uint64, minType, maxType uint32) ([]item, error) {
+	args := &searchArgs{key: searchKey{
+		treeID:      tree,
+		minObjectID: minObjectID,
+		maxObjectID: maxObjectID,
+		maxOffset:   ^uint64(0),
+		maxTransID:  ^uint64(0),
+		minType:     minType,
+		maxType:     maxType,
+	}}
+
+	var items []item
+	for {
+		args.key.nrItems = 4096
+		if err := ioctl(f, iocTreeSearch, unsafe.Pointer(args)); err != nil {
+			return nil, err
+		}
+		if args.key.nrItems == 0 {
+			return items, nil
+		}
+
+		found := parseItems(args.buf[:], int(args.key.nrItems))
+		if len(found) == 0 {
+			return items, nil
+		}
+		for _, it := range found {
+			if it.typ >= minType && it.typ <= maxType {
+				items = append(items, it)
+			}
+		}
+		last := found[len(found)-1]
+
+		// Continue after the last key, the range is compared as whole
+		// (objectid, type, offset) tuples
+		k := &args.key
+		k.minObjectID, k.minType, k.minOffset = last.objectID, last.typ, last.offset
+		switch {
+		case k.minOffset < ^uint64(0):
+			k.minOffset++
+		case k.minType < 255:
+			k.minType, k.minOffset = k.minType+1, 0
+		case k.minObjectID < maxObjectID:
+			k.minObjectID, k.minType, k.minOffset = k.minObjectID+1, 0, 0
+		default:
+			return items, nil
+		}
+	}
+}
+
+// parseItems decodes n items of the search buffer
+func parseItems(buf []byte, n int) []item {
+	var items []item
+	for off := 0; n > 0 && off+searchHeaderSize <= len(buf); n-- {
+		h := buf[off:]
+		it := item{
+			objectID: binary.LittleEndian.Uint64(h[8:]),
+			offset:   binary.LittleEndian.Uint64(h[16:]),
+			typ:      binary.LittleEndian.Uint32(h[24:]),
+		}
+		size := int(binary.LittleEndian.Uint32(h[28:]))
+		off += searchHeaderSize
+		if off+size > len(buf) {
+			break
+		}
+		it.data = append([]byte(nil), buf[off:off+size]...)
+		off += size
+
+		items = append(items, it)
+	}
+	return items
+}
+
+// openMount opens the mount point checking it's btrfs
+func openMount(mountPoint string) (*os.File, error) {
+	f, err := os.Open(mountPoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", mountPoint)
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Fstatfs(int(f.Fd()), &st); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "failed to statfs %v", mountPoint)
+	}
+	const btrfsMagic = 0x9123683e
+	if uint32(st.Type) != btrfsMagic {
+		f.Close()
+		return nil, errors.Errorf("%s is not on btrfs", mountPoint)
+	}
+
+	return f, nil
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func formatUUID(b []byte) string {
+	var zero [16]byte
+	if len(b) != 16 || string(b) == string(zero[:]) {
+		return ""
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,173 @@
+package btrfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ErrQuotaDisabled is returned for filesystems without quota enabled
+var ErrQuotaDisabled = errors.New("btrfs quota is not enabled")
+
+// Flags of the qgroup status and limit items
+const (
+	statusFlagOn           = 1 << 0
+	statusFlagRescan       = 1 << 1
+	statusFlagInconsistent = 1 << 2
+
+	limitMaxReferenced = 1 << 0
+	limitMaxExclusive  = 1 << 1
+)
+
+// QgroupID is a level and an id, level 0 qgroups belong to the subvolume
+// of the same id
+type QgroupID uint64
+
+// NewQgroupID returns the id of the qgroup like "1/100"
+func NewQgroupID(level uint16, id uint64) QgroupID {
+	return QgroupID(uint64(level)<<48 | id&(1<<48-1))
+}
+
+// Level returns the level, 0 for subvolume groups
+func (q QgroupID) Level() uint16 {
+	return uint16(q >> 48)
+}
+
+// ID returns the id within the level
+func (q QgroupID) ID() uint64 {
+	return uint64(q) & (1<<48 - 1)
+}
+
+// String formats the id like btrfs qgroup show does
+func (q QgroupID) String() string {
+	return fmt.Sprintf("%d/%d", q.Level(), q.ID())
+}
+
+// MarshalText encodes the id as "level/id"
+func (q QgroupID) MarshalText() ([]byte, error) {
+	return []byte(q.String()), nil
+}
+
+// Qgroup is the usage and limits of a quota group. Referenced counts all
+// data the subvolumes of the group can reach, Exclusive only data no other
+// group shares.
+type Qgroup struct {
+	ID         QgroupID
+	Referenced uint64
+	Exclusive  uint64
+	// MaxReferenced and MaxExclusive are zero when not limited
+	MaxReferenced uint64
+	MaxExclusive  uint64
+	// Parents are higher level groups this one is assigned to
+	Parents []QgroupID
+}
+
+// Quota is the qgroup accounting of a filesystem
+type Quota struct {
+	// Inconsistent numbers need a rescan, which may be running
+	Inconsistent bool
+	Rescanning   bool
+	Qgroups      []Qgroup
+}
+
+// Qgroup returns the group by id or nil
+func (q Quota) Qgroup(id QgroupID) *Qgroup {
+	for i := range q.Qgroups {
+		if q.Qgroups[i].ID == id {
+			return &q.Qgroups[i]
+		}
+	}
+	return nil
+}
+
+// Subvolume returns the level 0 group of the subvolume or nil
+func (q Quota) Subvolume(id uint64) *Qgroup {
+	return q.Qgroup(NewQgroupID(0, id))
+}
+
+// Quotas reads qgroups of the btrfs filesystem mounted at the path ordered
+// by id. ErrQuotaDisabled is returned if quota isn't enabled.
+func Quotas(mountPoint string) (*Quota, error) {
+	f, err := openMount(mountPoint)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	items, err := search(f, quotaTreeID, 0, ^uint64(0), typeQgroupStatus, typeQgroupRelation)
+	if err == syscall.ENOENT {
+		return nil, ErrQuotaDisabled
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to search qgroups of %v", mountPoint)
+	}
+
+	return parseQuota(items)
+}
+
+func parseQuota(items []item) (*Quota, error) {
+	q := &Quota{}
+	groups := map[QgroupID]*Qgroup{}
+	group := func(id QgroupID) *Qgroup {
+		g, ok := groups[id]
+		if !ok {
+			g = &Qgroup{ID: id}
+			groups[id] = g
+		}
+		return g
+	}
+
+	enabled := false
+	for _, it := range items {
+		switch it.typ {
+		case typeQgroupStatus:
+			if len(it.data) < 24 {
+				continue
+			}
+			flags := binary.LittleEndian.Uint64(it.data[16:])
+			enabled = flags&statusFlagOn != 0
+			q.Rescanning = flags&statusFlagRescan != 0
+			q.Inconsistent = flags&statusFlagInconsistent != 0
+		case typeQgroupInfo:
+			if len(it.data) < 40 {
+				continue
+			}
+			g := group(QgroupID(it.offset))
+			g.Referenced = binary.LittleEndian.Uint64(it.data[8:])
+			g.Exclusive = binary.LittleEndian.Uint64(it.data[24:])
+		case typeQgroupLimit:
+			if len(it.data) < 24 {
+				continue
+			}
+			g := group(QgroupID(it.offset))
+			flags := binary.LittleEndian.Uint64(it.data[0:])
+			if flags&limitMaxReferenced != 0 {
+				g.MaxReferenced = binary.LittleEndian.Uint64(it.data[8:])
+			}
+			if flags&limitMaxExclusive != 0 {
+				g.MaxExclusive = binary.LittleEndian.Uint64(it.data[16:])
+			}
+		case typeQgroupRelation:
+			// Relations are stored both ways, the parent has the higher level
+			child, parent := QgroupID(it.objectID), QgroupID(it.offset)
+			if parent.Level() > child.Level() {
+				g := group(child)
+				g.Parents = append(g.Parents, parent)
+			}
+		}
+	}
+	if !enabled {
+		return nil, ErrQuotaDisabled
+	}
+
+	for _, g := range groups {
+		sort.Slice(g.Parents, func(i, j int) bool { return g.Parents[i] < g.Parents[j] })
+		q.Qgroups = append(q.Qgroups, *g)
+	}
+	sort.Slice(q.Qgroups, func(i, j int) bool { return q.Qgroups[i].ID < q.Qgroups[j].ID })
+
+	return q, nil
+}
@@ -0,0 +1,167 @@
+package btrfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path"
+	"sort"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// subvolReadOnly is BTRFS_ROOT_SUBVOL_RDONLY of the root item flags
+const subvolReadOnly = 1 << 0
+
+// Subvolume is a subvolume or snapshot of a filesystem
+type Subvolume struct {
+	ID uint64
+	// ParentID is the subvolume containing this one, 5 for the top level
+	ParentID uint64
+	// Path is relative to the top level subvolume like btrfs subvolume
+	// list prints it
+	Path string
+	UUID string
+	// ParentUUID is the subvolume a snapshot was taken from
+	ParentUUID string
+	// ReceivedUUID is set for subvolumes created by btrfs receive
+	ReceivedUUID string
+	Generation   uint64
+	ReadOnly     bool
+	Created      time.Time
+}
+
+// IsSnapshot returns whether the subvolume was created as a snapshot
+func (s Subvolume) IsSnapshot() bool {
+	return s.ParentUUID != ""
+}
+
+// backref is a ROOT_BACKREF item: where a subvolume is linked in its parent
+type backref struct {
+	parent uint64
+	dirID  uint64
+	name   string
+}
+
+// Subvolumes lists subvolumes of the btrfs filesystem mounted at the path,
+// ordered by id. The top level subvolume 5 isn't included.
+func Subvolumes(mountPoint string) ([]Subvolume, error) {
+	f, err := openMount(mountPoint)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	items, err := search(f, rootTreeID, firstFreeObjectID, lastFreeObjectID, typeRootItem, typeRootBackref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to search subvolumes of %v", mountPoint)
+	}
+
+	subvols := map[uint64]*Subvolume{}
+	refs := map[uint64]backref{}
+	for _, it := range items {
+		switch it.typ {
+		case typeRootItem:
+			s := parseRootItem(it.data)
+			s.ID = it.objectID
+			subvols[it.objectID] = &s
+		case typeRootBackref:
+			if len(it.data) < 18 {
+				continue
+			}
+			nameLen := int(binary.LittleEndian.Uint16(it.data[16:]))
+			if 18+nameLen > len(it.data) {
+				continue
+			}
+			refs[it.objectID] = backref{
+				parent: it.offset,
+				dirID:  binary.LittleEndian.Uint64(it.data[0:]),
+				name:   string(it.data[18 : 18+nameLen]),
+			}
+		}
+	}
+
+	paths := map[uint64]string{fsTreeID: ""}
+	var resolve func(id uint64, depth int) (string, error)
+	resolve = func(id uint64, depth int) (string, error) {
+		if p, ok := paths[id]; ok {
+			return p, nil
+		}
+		ref, ok := refs[id]
+		if !ok || depth > len(refs) {
+			return "", errors.Errorf("subvolume %d is not linked", id)
+		}
+		parent, err := resolve(ref.parent, depth+1)
+		if err != nil {
+			return "", err
+		}
+		dir, err := inoLookup(f, ref.parent, ref.dirID)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to look up directory of subvolume %d", id)
+		}
+		p := path.Join(parent, dir, ref.name)
+		paths[id] = p
+		return p, nil
+	}
+
+	var ss []Subvolume
+	for id, s := range subvols {
+		ref, ok := refs[id]
+		if !ok {
+			// Deleted subvolumes keep their root item until cleaned up
+			continue
+		}
+		s.ParentID = ref.parent
+		if s.Path, err = resolve(id, 0); err != nil {
+			return nil, err
+		}
+		ss = append(ss, *s)
+	}
+
+	sort.Slice(ss, func(i, j int) bool { return ss[i].ID < ss[j].ID })
+	return ss, nil
+}
+
+// parseRootItem decodes struct btrfs_root_item. UUIDs and times were added
+// later and are only valid when generation_v2 matches generation.
+func parseRootItem(b []byte) Subvolume {
+	var s Subvolume
+	if len(b) < 216 {
+		return s
+	}
+	s.Generation = binary.LittleEndian.Uint64(b[160:])
+	s.ReadOnly = binary.LittleEndian.Uint64(b[208:])&subvolReadOnly != 0
+
+	if len(b) < 351 || binary.LittleEndian.Uint64(b[239:]) != s.Generation {
+		return s
+	}
+	s.UUID = formatUUID(b[247:263])
+	s.ParentUUID = formatUUID(b[263:279])
+	s.ReceivedUUID = formatUUID(b[279:295])
+	if sec := binary.LittleEndian.Uint64(b[339:]); sec != 0 {
+		s.Created = time.Unix(int64(sec), int64(binary.LittleEndian.Uint32(b[347:])))
+	}
+	return s
+}
+
+// inoLookupArgs mirrors struct btrfs_ioctl_ino_lookup_args
+type inoLookupArgs struct {
+	treeID   uint64
+	objectID uint64
+	name     [4080]byte
+}
+
+// inoLookup returns the path of the directory inside the subvolume tree
+func inoLookup(f *os.File, tree, dirID uint64) (string, error) {
+	args := &inoLookupArgs{treeID: tree, objectID: dirID}
+	if err := ioctl(f, iocInoLookup, unsafe.Pointer(args)); err != nil {
+		return "", err
+	}
+	name := args.name[:]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	return string(name), nil
+}
@@ -0,0 +1,37 @@
+package bulkio
+
+import (
+	"context"
+	"os"
+)
+
+// Request is a single positioned read. N and Err are filled in when the
+// batch completes.
+type Request struct {
+	File   *os.File
+	Offset int64
+	Buf    []byte
+
+	N   int
+	Err error
+}
+
+// Reader executes batches of reads
+type Reader interface {
+	// ReadBatch performs all reads of the batch. Errors of individual reads
+	// are reported in their requests, the returned error is only set when
+	// the batch as a whole failed. Short reads report io.EOF like
+	// io.ReaderAt does.
+	ReadBatch(ctx context.Context, reqs []*Request) error
+	Close() error
+}
+
+// New returns an io_uring backed Reader when the kernel supports it and a
+// pread based one otherwise
+func New() Reader {
+	r, err := NewURing(defaultEntries)
+	if err != nil {
+		return NewPread(defaultConcurrency)
+	}
+	return r
+}
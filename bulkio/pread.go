@@ -0,0 +1,48 @@
+package bulkio
+
+import (
+	"context"
+	"sync"
+)
+
+const defaultConcurrency = 16
+
+type preadReader struct {
+	concurrency int
+}
+
+// NewPread returns a Reader issuing plain pread(2) calls from up to
+// concurrency goroutines
+func NewPread(concurrency int) Reader {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &preadReader{concurrency}
+}
+
+func (r *preadReader) ReadBatch(ctx context.Context, reqs []*Request) error {
+	sem := make(chan struct{}, r.concurrency)
+	var wg sync.WaitGroup
+
+	for _, req := range reqs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		}
+		wg.Add(1)
+		go func(req *Request) {
+			defer wg.Done()
+			req.N, req.Err = req.File.ReadAt(req.Buf, req.Offset)
+			<-sem
+		}(req)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func (r *preadReader) Close() error {
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !mips && !mipsle && !mips64 && !mips64le
+// +build !mips,!mipsle,!mips64,!mips64le
+
+package bulkio
+
+// syscall doesn't define the io_uring syscalls, the numbers come from the
+// generic syscall table of Linux 5.1 that all architectures but mips share
+const (
+	sysIOUringSetup = 425
+	sysIOUringEnter = 426
+)
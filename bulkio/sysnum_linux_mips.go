@@ -0,0 +1,10 @@
+//go:build mips || mipsle
+// +build mips mipsle
+
+package bulkio
+
+// mips o32 offsets the generic syscall numbers
+const (
+	sysIOUringSetup = 4425
+	sysIOUringEnter = 4426
+)
@@ -0,0 +1,10 @@
+//go:build mips64 || mips64le
+// +build mips64 mips64le
+
+package bulkio
+
+// mips n64 offsets the generic syscall numbers
+const (
+	sysIOUringSetup = 5425
+	sysIOUringEnter = 5426
+)
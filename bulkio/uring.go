@@ -0,0 +1,359 @@
+package bulkio
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultEntries = 128
+
+	ioringOffSQRing = 0
+	ioringOffCQRing = 0x8000000
+	ioringOffSQEs   = 0x10000000
+
+	ioringFeatSingleMmap = 1 << 0
+	ioringFeatExtArg     = 1 << 8
+	ioringEnterGetEvents = 1 << 0
+	ioringEnterExtArg    = 1 << 3
+
+	ioringOpReadv       = 1
+	ioringOpAsyncCancel = 14
+
+	// cancelTag marks the user data of cancel requests, the completions
+	// of reads carry the request index
+	cancelTag = 1 << 63
+	// waitSlice bounds how long a wait for completions blocks before the
+	// context is checked again
+	waitSlice = 100 * time.Millisecond
+
+	sqeSize = 64
+	cqeSize = 16
+)
+
+// sqringOffsets mirrors struct io_sqring_offsets
+type sqringOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// cqringOffsets mirrors struct io_cqring_offsets
+type cqringOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// uringParams mirrors struct io_uring_params
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  sqringOffsets
+	cqOff                                                                  cqringOffsets
+}
+
+// sqe mirrors struct io_uring_sqe for the fields used by reads
+type sqe struct {
+	opcode   uint8
+	flags    uint8
+	ioprio   uint16
+	fd       int32
+	off      uint64
+	addr     uint64
+	len      uint32
+	rwFlags  uint32
+	userData uint64
+	_        [3]uint64
+}
+
+// getEventsArg mirrors struct io_uring_getevents_arg
+type getEventsArg struct {
+	sigmask   uint64
+	sigmaskSz uint32
+	pad       uint32
+	ts        uint64
+}
+
+// kernelTimespec mirrors struct __kernel_timespec, which is 64 bit on all
+// architectures unlike syscall.Timespec
+type kernelTimespec struct {
+	sec, nsec int64
+}
+
+// cqe mirrors struct io_uring_cqe
+type cqe struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+type uringReader struct {
+	mu sync.Mutex
+
+	fd     int
+	params uringParams
+	sqRing []byte
+	cqRing []byte
+	sqes   []byte
+}
+
+// NewURing returns a Reader submitting reads through an io_uring instance
+// with the given number of submission entries. It requires Linux 5.1+.
+func NewURing(entries uint32) (Reader, error) {
+	r := &uringReader{}
+
+	fd, _, errno := syscall.Syscall(sysIOUringSetup, uintptr(entries), uintptr(unsafe.Pointer(&r.params)), 0)
+	if errno != 0 {
+		return nil, errors.Wrap(errno, "failed to set up io_uring")
+	}
+	r.fd = int(fd)
+
+	if err := r.mmap(); err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *uringReader) mmap() error {
+	p := &r.params
+
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	cqSize := int(p.cqOff.cqes + p.cqEntries*cqeSize)
+	if p.features&ioringFeatSingleMmap != 0 && cqSize > sqSize {
+		sqSize = cqSize
+	}
+
+	var err error
+	r.sqRing, err = syscall.Mmap(r.fd, ioringOffSQRing, sqSize,
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return errors.Wrap(err, "failed to map io_uring submission ring")
+	}
+
+	if p.features&ioringFeatSingleMmap != 0 {
+		r.cqRing = r.sqRing
+	} else {
+		r.cqRing, err = syscall.Mmap(r.fd, ioringOffCQRing, cqSize,
+			syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+		if err != nil {
+			return errors.Wrap(err, "failed to map io_uring completion ring")
+		}
+	}
+
+	r.sqes, err = syscall.Mmap(r.fd, ioringOffSQEs, int(p.sqEntries*sqeSize),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return errors.Wrap(err, "failed to map io_uring submission entries")
+	}
+
+	return nil
+}
+
+func ringUint32(ring []byte, off uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(&ring[off]))
+}
+
+func (r *uringReader) ReadBatch(ctx context.Context, reqs []*Request) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	iovecs := make([]syscall.Iovec, len(reqs))
+	for start := 0; start < len(reqs); start += int(r.params.sqEntries) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		end := start + int(r.params.sqEntries)
+		if end > len(reqs) {
+			end = len(reqs)
+		}
+
+		if err := r.submit(ctx, reqs, iovecs, start, end); err != nil {
+			return err
+		}
+	}
+	runtime.KeepAlive(iovecs)
+	runtime.KeepAlive(reqs)
+
+	return nil
+}
+
+// submit queues reqs[start:end], waits for all of them and fills results.
+// When the context is done the outstanding reads are cancelled, and still
+// waited for since the kernel writes to their buffers until they complete.
+func (r *uringReader) submit(ctx context.Context, reqs []*Request, iovecs []syscall.Iovec, start, end int) error {
+	p := &r.params
+
+	tail := atomic.LoadUint32(ringUint32(r.sqRing, p.sqOff.tail))
+	for i := start; i < end; i++ {
+		req := reqs[i]
+		iov := &iovecs[i]
+		if len(req.Buf) > 0 {
+			iov.Base = &req.Buf[0]
+		}
+		iov.SetLen(len(req.Buf))
+
+		r.queue(tail, sqe{
+			opcode:   ioringOpReadv,
+			fd:       int32(req.File.Fd()),
+			off:      uint64(req.Offset),
+			addr:     uint64(uintptr(unsafe.Pointer(iov))),
+			len:      1,
+			userData: uint64(i),
+		})
+		tail++
+	}
+	atomic.StoreUint32(ringUint32(r.sqRing, p.sqOff.tail), tail)
+
+	n := end - start
+	if err := r.enter(n); err != nil {
+		return err
+	}
+
+	done := make([]bool, n)
+	cancelled := false
+	cqMask := *ringUint32(r.cqRing, p.cqOff.ringMask)
+	for completed := 0; completed < n; {
+		head := atomic.LoadUint32(ringUint32(r.cqRing, p.cqOff.head))
+		ctail := atomic.LoadUint32(ringUint32(r.cqRing, p.cqOff.tail))
+		if head == ctail {
+			if !cancelled && ctx.Err() != nil {
+				if err := r.cancel(start, done); err != nil {
+					return err
+				}
+				cancelled = true
+			}
+			if err := r.wait(ctx, cancelled); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for ; head != ctail; head++ {
+			c := (*cqe)(unsafe.Pointer(&r.cqRing[p.cqOff.cqes+(head&cqMask)*cqeSize]))
+			if c.userData&cancelTag != 0 {
+				continue
+			}
+			req := reqs[c.userData]
+			if c.res < 0 {
+				req.N, req.Err = 0, syscall.Errno(-c.res)
+			} else {
+				req.N, req.Err = int(c.res), nil
+				if req.N < len(req.Buf) {
+					req.Err = io.EOF
+				}
+			}
+			done[int(c.userData)-start] = true
+			completed++
+		}
+		atomic.StoreUint32(ringUint32(r.cqRing, p.cqOff.head), head)
+	}
+
+	if cancelled {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// queue writes the entry at the ring position without publishing it
+func (r *uringReader) queue(pos uint32, e sqe) {
+	p := &r.params
+	idx := pos & *ringUint32(r.sqRing, p.sqOff.ringMask)
+	*(*sqe)(unsafe.Pointer(&r.sqes[idx*sqeSize])) = e
+	*ringUint32(r.sqRing, p.sqOff.array+idx*4) = idx
+}
+
+// enter submits n queued entries
+func (r *uringReader) enter(n int) error {
+	for submitted := 0; submitted < n; {
+		ret, _, errno := syscall.Syscall6(sysIOUringEnter, uintptr(r.fd),
+			uintptr(n-submitted), 0, 0, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return errors.Wrap(errno, "failed to submit io_uring requests")
+		}
+		submitted += int(ret)
+	}
+	return nil
+}
+
+// cancel asks the kernel to cancel the reads not done yet. Kernels before
+// 5.5 reject it and the reads run to completion.
+func (r *uringReader) cancel(start int, done []bool) error {
+	p := &r.params
+
+	tail := atomic.LoadUint32(ringUint32(r.sqRing, p.sqOff.tail))
+	n := 0
+	for i, d := range done {
+		if d {
+			continue
+		}
+		r.queue(tail, sqe{
+			opcode:   ioringOpAsyncCancel,
+			fd:       -1,
+			addr:     uint64(start + i),
+			userData: uint64(start+i) | cancelTag,
+		})
+		tail++
+		n++
+	}
+	atomic.StoreUint32(ringUint32(r.sqRing, p.sqOff.tail), tail)
+
+	return r.enter(n)
+}
+
+// wait blocks until a completion arrives. Unless the reads were cancelled
+// already it gives up after waitSlice, or the context deadline if sooner,
+// so the caller notices the context is done. Kernels before 5.11 can't
+// time out the wait and block until a completion.
+func (r *uringReader) wait(ctx context.Context, cancelled bool) error {
+	flags := uintptr(ioringEnterGetEvents)
+	var arg *getEventsArg
+	var argSize uintptr
+	if !cancelled && ctx.Done() != nil && r.params.features&ioringFeatExtArg != 0 {
+		timeout := waitSlice
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+			timeout = time.Until(deadline)
+		}
+		if timeout < 0 {
+			timeout = 0
+		}
+		ts := &kernelTimespec{sec: int64(timeout / time.Second), nsec: int64(timeout % time.Second)}
+		arg = &getEventsArg{ts: uint64(uintptr(unsafe.Pointer(ts)))}
+		argSize = unsafe.Sizeof(*arg)
+		flags |= ioringEnterExtArg
+		defer runtime.KeepAlive(ts)
+	}
+
+	_, _, errno := syscall.Syscall6(sysIOUringEnter, uintptr(r.fd), 0, 1, flags,
+		uintptr(unsafe.Pointer(arg)), argSize)
+	if errno != 0 && errno != syscall.EINTR && errno != syscall.ETIME {
+		return errors.Wrap(errno, "failed to wait for io_uring completions")
+	}
+	return nil
+}
+
+func (r *uringReader) Close() error {
+	if r.sqes != nil {
+		syscall.Munmap(r.sqes)
+	}
+	if r.cqRing != nil && r.params.features&ioringFeatSingleMmap == 0 {
+		syscall.Munmap(r.cqRing)
+	}
+	if r.sqRing != nil {
+		syscall.Munmap(r.sqRing)
+	}
+
+	return syscall.Close(r.fd)
+}
@@ -0,0 +1,187 @@
+package csi
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/alexdzyoba/sys/block"
+	"github.com/alexdzyoba/sys/mkfs"
+	"github.com/alexdzyoba/sys/mounts"
+	"github.com/pkg/errors"
+)
+
+const byIDRoot = "/dev/disk/by-id"
+
+// FindDevice returns the device node of the disk whose serial or WWN
+// matches volumeID. Cloud providers expose volume ids as disk serials,
+// udev turns them into /dev/disk/by-id names like
+// "virtio-<serial>", "nvme-Amazon_Elastic_Block_Store_<serial>" or
+// "wwn-0x<wwn>". Partition links are ignored.
+func FindDevice(volumeID string) (string, error) {
+	entries, err := ioutil.ReadDir(byIDRoot)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read %v", byIDRoot)
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if strings.Contains(name, "-part") {
+			continue
+		}
+		if !byIDMatches(name, volumeID) {
+			continue
+		}
+
+		dev, err := filepath.EvalSymlinks(path.Join(byIDRoot, name))
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to resolve %s", name)
+		}
+		return dev, nil
+	}
+
+	return "", errors.Errorf("no device found for volume %s", volumeID)
+}
+
+// byIDMatches returns whether the by-id link name is of the volume. The
+// name without its transport prefix is the serial or WWN, possibly after
+// the model like "Amazon_Elastic_Block_Store_", and has to equal the id
+// so that volumes whose ids end the same aren't mixed up.
+func byIDMatches(name, volumeID string) bool {
+	i := strings.Index(name, "-")
+	if i < 0 {
+		return false
+	}
+	serial := name[i+1:]
+	candidates := []string{serial, strings.TrimPrefix(serial, "0x")}
+	if j := strings.LastIndex(serial, "_"); j >= 0 {
+		candidates = append(candidates, serial[j+1:])
+	}
+
+	id := normalize(strings.TrimPrefix(volumeID, "0x"))
+	for _, c := range candidates {
+		if normalize(c) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize drops characters udev escapes differently between transports
+func normalize(s string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(s))
+}
+
+// WaitForDevice waits until the device of the volume appears or the context
+// expires
+func WaitForDevice(ctx context.Context, volumeID string) (string, error) {
+	d, err := block.WaitForDevice(ctx, func(d block.DeviceInfo) bool {
+		if d.DevType != "disk" {
+			return false
+		}
+		for _, l := range d.Links {
+			if strings.HasPrefix(l, "disk/by-id/") && byIDMatches(path.Base(l), volumeID) {
+				return true
+			}
+		}
+		return block.MatchSerial(volumeID)(d)
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "device for volume %s did not appear", volumeID)
+	}
+
+	return d.Node, nil
+}
+
+// StageRequest describes a volume to stage on the node, mirroring
+// NodeStageVolume of the CSI spec
+type StageRequest struct {
+	VolumeID     string
+	StagingPath  string
+	FSType       string
+	MountOptions []string
+	// Format options are used only when the volume has no filesystem yet
+	Format mkfs.Options
+}
+
+// NodeStage waits for the volume device, formats it if it's blank and
+// mounts it on the staging path. It's idempotent: staging an already staged
+// volume is a no-op.
+func NodeStage(ctx context.Context, req StageRequest) error {
+	dev, err := WaitForDevice(ctx, req.VolumeID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := mkfs.EnsureFilesystem(ctx, dev, req.FSType, req.Format); err != nil {
+		return err
+	}
+
+	if err := mkdir(ctx, req.StagingPath); err != nil {
+		return err
+	}
+
+	_, err = mounts.EnsureMounted(ctx, dev, req.StagingPath, req.FSType, req.MountOptions)
+	return err
+}
+
+// NodeUnstage unmounts the staging path and removes it
+func NodeUnstage(ctx context.Context, stagingPath string) error {
+	return cleanup(ctx, stagingPath)
+}
+
+// NodePublish bind mounts the staged volume to the target path
+func NodePublish(ctx context.Context, stagingPath, targetPath string, readOnly bool) error {
+	if err := mkdir(ctx, targetPath); err != nil {
+		return err
+	}
+
+	options := []string{"bind"}
+	if readOnly {
+		options = append(options, "ro")
+	}
+
+	changed, err := mounts.EnsureMounted(ctx, stagingPath, targetPath, "", options)
+	if err != nil {
+		return err
+	}
+
+	// The kernel ignores MS_RDONLY when creating a bind mount, it takes a
+	// remount of the bind mount alone with MS_REMOUNT|MS_BIND|MS_RDONLY
+	if changed && readOnly {
+		return mounts.MountAt(ctx, "", targetPath, "", []string{"remount", "bind", "ro"})
+	}
+
+	return nil
+}
+
+// NodeUnpublish unmounts the target path and removes it
+func NodeUnpublish(ctx context.Context, targetPath string) error {
+	return cleanup(ctx, targetPath)
+}
+
+func cleanup(ctx context.Context, p string) error {
+	if _, err := mounts.EnsureUnmounted(ctx, p); err != nil {
+		return err
+	}
+
+	return action.Run(ctx, action.Action{Op: "rmdir", Target: p}, func() error {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to remove %s", p)
+		}
+		return nil
+	})
+}
+
+func mkdir(ctx context.Context, p string) error {
+	return action.Run(ctx, action.Action{Op: "mkdir", Target: p}, func() error {
+		if err := os.MkdirAll(p, 0750); err != nil {
+			return errors.Wrapf(err, "failed to create %s", p)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,326 @@
+package du
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/alexdzyoba/sys/ioprio"
+	"github.com/pkg/errors"
+)
+
+// Usage is the space consumed by a directory tree
+type Usage struct {
+	// Apparent is the sum of file sizes
+	Apparent uint64
+	// Disk is the space actually allocated, which differs from Apparent
+	// for sparse and small files
+	Disk  uint64
+	Files uint64
+	Dirs  uint64
+}
+
+func (u *Usage) add(o Usage) {
+	u.Apparent += o.Apparent
+	u.Disk += o.Disk
+	u.Files += o.Files
+	u.Dirs += o.Dirs
+}
+
+// Progress is reported periodically while scanning
+type Progress struct {
+	Files  uint64
+	Dirs   uint64
+	Bytes  uint64
+	Errors uint64
+}
+
+// Options configures a scan
+type Options struct {
+	// Workers is the number of directories read in parallel.
+	// Defaults to the number of CPUs.
+	Workers int
+	// CrossMounts allows descending into other filesystems mounted below
+	// the root. By default the scan stays on the root's device like du -x.
+	CrossMounts bool
+	// Progress is called every ProgressInterval (default 1s) during the scan
+	Progress         func(Progress)
+	ProgressInterval time.Duration
+	// IOPriority, when set, is applied to every thread reading directories
+	// so the scan doesn't compete with production workloads
+	IOPriority *ioprio.Priority
+}
+
+// Result holds totals of a scan
+type Result struct {
+	Root  string
+	Total Usage
+	// Dirs maps every scanned directory to the cumulative usage of its
+	// subtree
+	Dirs map[string]Usage
+	// Errors are non-fatal errors of entries that couldn't be read
+	Errors []error
+}
+
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+type scanner struct {
+	ctx  context.Context
+	opts Options
+	dev  uint64
+	sem  chan struct{}
+	wg   sync.WaitGroup
+
+	mu     sync.Mutex
+	seen   map[inode]bool
+	own    map[string]Usage
+	errors []error
+
+	files, dirs, bytes, errCount uint64
+}
+
+// Scan walks the directory tree under root and returns per-directory totals.
+// Hard-linked files are counted once.
+func Scan(ctx context.Context, root string, opts Options) (*Result, error) {
+	root = filepath.Clean(root)
+
+	var st syscall.Stat_t
+	if err := syscall.Lstat(root, &st); err != nil {
+		return nil, errors.Wrapf(err, "failed to stat %s", root)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		return nil, errors.Errorf("%s is not a directory", root)
+	}
+
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.ProgressInterval <= 0 {
+		opts.ProgressInterval = time.Second
+	}
+
+	s := &scanner{
+		ctx:  ctx,
+		opts: opts,
+		dev:  uint64(st.Dev),
+		sem:  make(chan struct{}, opts.Workers),
+		seen: map[inode]bool{},
+		own:  map[string]Usage{},
+	}
+
+	stop := make(chan struct{})
+	if opts.Progress != nil {
+		go s.report(stop)
+	}
+
+	s.own[root] = usageOf(&st, true)
+	// The scan gets its own goroutine, a thread locked with the priority
+	// must not be the caller's
+	s.wg.Add(1)
+	go s.run(root)
+	s.wg.Wait()
+	close(stop)
+
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "scan interrupted")
+	}
+
+	res := &Result{
+		Root:   root,
+		Dirs:   rollup(s.own),
+		Errors: s.errors,
+	}
+	res.Total = res.Dirs[root]
+
+	if opts.Progress != nil {
+		opts.Progress(s.progress())
+	}
+
+	return res, nil
+}
+
+func (s *scanner) report(stop chan struct{}) {
+	t := time.NewTicker(s.opts.ProgressInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			s.opts.Progress(s.progress())
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (s *scanner) progress() Progress {
+	return Progress{
+		Files:  atomic.LoadUint64(&s.files),
+		Dirs:   atomic.LoadUint64(&s.dirs),
+		Bytes:  atomic.LoadUint64(&s.bytes),
+		Errors: atomic.LoadUint64(&s.errCount),
+	}
+}
+
+func (s *scanner) fail(err error) {
+	atomic.AddUint64(&s.errCount, 1)
+	s.mu.Lock()
+	s.errors = append(s.errors, err)
+	s.mu.Unlock()
+}
+
+// run scans dir in the calling goroutine with the configured I/O priority
+func (s *scanner) run(dir string) {
+	if s.opts.IOPriority == nil {
+		s.scanDir(dir)
+		return
+	}
+
+	ran := false
+	err := ioprio.Do(*s.opts.IOPriority, func() error {
+		ran = true
+		s.scanDir(dir)
+		return nil
+	})
+	if err != nil {
+		s.fail(err)
+		if !ran {
+			// Priority couldn't be applied, scan anyway
+			s.scanDir(dir)
+		}
+	}
+}
+
+func (s *scanner) scanDir(dir string) {
+	defer s.wg.Done()
+
+	if s.ctx.Err() != nil {
+		return
+	}
+
+	atomic.AddUint64(&s.dirs, 1)
+
+	f, err := os.Open(dir)
+	if err != nil {
+		s.fail(errors.Wrapf(err, "failed to open %s", dir))
+		return
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		s.fail(errors.Wrapf(err, "failed to read directory %s", dir))
+	}
+
+	var own Usage
+	for _, name := range names {
+		p := filepath.Join(dir, name)
+
+		var st syscall.Stat_t
+		if err := syscall.Lstat(p, &st); err != nil {
+			if err != syscall.ENOENT {
+				s.fail(errors.Wrapf(err, "failed to stat %s", p))
+			}
+			continue
+		}
+
+		if st.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+			if !s.opts.CrossMounts && uint64(st.Dev) != s.dev {
+				continue
+			}
+
+			s.mu.Lock()
+			s.own[p] = usageOf(&st, true)
+			s.mu.Unlock()
+
+			s.wg.Add(1)
+			select {
+			case s.sem <- struct{}{}:
+				go func() {
+					s.run(p)
+					<-s.sem
+				}()
+			default:
+				s.scanDir(p)
+			}
+			continue
+		}
+
+		if st.Nlink > 1 {
+			key := inode{uint64(st.Dev), uint64(st.Ino)}
+			s.mu.Lock()
+			dup := s.seen[key]
+			s.seen[key] = true
+			s.mu.Unlock()
+			if dup {
+				continue
+			}
+		}
+
+		u := usageOf(&st, false)
+		atomic.AddUint64(&s.files, 1)
+		atomic.AddUint64(&s.bytes, u.Apparent)
+		own.add(u)
+	}
+
+	s.mu.Lock()
+	u := s.own[dir]
+	u.add(own)
+	s.own[dir] = u
+	s.mu.Unlock()
+}
+
+func usageOf(st *syscall.Stat_t, dir bool) Usage {
+	u := Usage{
+		Apparent: uint64(st.Size),
+		// st_blocks is always in 512 bytes units
+		Disk: uint64(st.Blocks) * 512,
+	}
+	if dir {
+		u.Dirs = 1
+	} else {
+		u.Files = 1
+	}
+	return u
+}
+
+// rollup turns usage of directories own entries into cumulative subtree
+// usage by adding every directory to its parent, deepest first
+func rollup(own map[string]Usage) map[string]Usage {
+	paths := make([]string, 0, len(own))
+	for p := range own {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return strings.Count(paths[i], "/") > strings.Count(paths[j], "/")
+	})
+
+	total := make(map[string]Usage, len(own))
+	for p, u := range own {
+		total[p] = u
+	}
+
+	for _, p := range paths {
+		parent := filepath.Dir(p)
+		if parent == p {
+			continue
+		}
+		pu, ok := total[parent]
+		if !ok {
+			continue
+		}
+		pu.add(total[p])
+		total[parent] = pu
+	}
+
+	return total
+}
@@ -0,0 +1,93 @@
+package fiemap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const sysfsDevBlockRoot = "/sys/dev/block"
+
+// Placement tells where a file is stored
+type Placement struct {
+	// Device is the block device the filesystem is mounted from, e.g. dm-0
+	Device string
+	// Disks are the bottom-most block devices found by following the
+	// slaves of Device through md, dm and LVM layers
+	Disks []string
+}
+
+// Disks returns the block devices a file lives on.
+// For striped and mirrored layers every member is reported since the exact
+// member depends on the offset within the stripe.
+func Disks(p string) (*Placement, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(p, &st); err != nil {
+		return nil, errors.Wrapf(err, "failed to stat %s", p)
+	}
+
+	major, minor := devMajor(uint64(st.Dev)), devMinor(uint64(st.Dev))
+	link := path.Join(sysfsDevBlockRoot, fmt.Sprintf("%d:%d", major, minor))
+	sysfsPath, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return nil, errors.Wrapf(err, "no block device %d:%d backs %s", major, minor, p)
+	}
+
+	pl := &Placement{Device: path.Base(sysfsPath)}
+
+	seen := map[string]bool{}
+	if err := leaves(sysfsPath, seen); err != nil {
+		return nil, err
+	}
+	for d := range seen {
+		pl.Disks = append(pl.Disks, d)
+	}
+	sort.Strings(pl.Disks)
+
+	return pl, nil
+}
+
+// leaves collects bottom-most devices under the sysfs device path.
+// Partitions are reported as their parent disks.
+func leaves(sysfsPath string, seen map[string]bool) error {
+	slaves, err := ioutil.ReadDir(path.Join(sysfsPath, "slaves"))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to read slaves of %s", sysfsPath)
+	}
+
+	if len(slaves) == 0 {
+		name := path.Base(sysfsPath)
+		if _, err := os.Stat(path.Join(sysfsPath, "partition")); err == nil {
+			name = path.Base(path.Dir(sysfsPath))
+		}
+		seen[name] = true
+		return nil
+	}
+
+	for _, s := range slaves {
+		p, err := filepath.EvalSymlinks(path.Join(sysfsPath, "slaves", s.Name()))
+		if err != nil {
+			return errors.Wrapf(err, "failed to resolve slave %s", s.Name())
+		}
+		if err := leaves(p, seen); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// devMajor and devMinor decode dev_t like glibc's gnu_dev_major/minor
+func devMajor(dev uint64) uint32 {
+	return uint32((dev>>8)&0xfff) | uint32((dev>>32)&^0xfff)
+}
+
+func devMinor(dev uint64) uint32 {
+	return uint32(dev&0xff) | uint32((dev>>12)&^0xff)
+}
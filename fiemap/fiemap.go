@@ -0,0 +1,182 @@
+package fiemap
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	fsIocFiemap = 0xc020660b
+	fibmap      = 1
+	figetbsz    = 2
+
+	fiemapFlagSync = 0x1
+
+	// extentBatch is the number of extents requested per ioctl
+	extentBatch = 256
+)
+
+// Flag describes an extent as reported by the filesystem
+type Flag uint32
+
+const (
+	FlagLast          Flag = 0x1
+	FlagUnknown       Flag = 0x2
+	FlagDelalloc      Flag = 0x4
+	FlagEncoded       Flag = 0x8
+	FlagDataEncrypted Flag = 0x80
+	FlagNotAligned    Flag = 0x100
+	FlagDataInline    Flag = 0x200
+	FlagDataTail      Flag = 0x400
+	FlagUnwritten     Flag = 0x800
+	FlagMerged        Flag = 0x1000
+	FlagShared        Flag = 0x2000
+)
+
+// Extent maps a range of a file to the underlying device
+type Extent struct {
+	// Logical is the offset in the file
+	Logical uint64
+	// Physical is the offset on the device the filesystem is on
+	Physical uint64
+	Length   uint64
+	Flags    Flag
+}
+
+// fiemapHeader mirrors struct fiemap from linux/fiemap.h
+type fiemapHeader struct {
+	start         uint64
+	length        uint64
+	flags         uint32
+	mappedExtents uint32
+	extentCount   uint32
+	reserved      uint32
+}
+
+// fiemapExtent mirrors struct fiemap_extent from linux/fiemap.h
+type fiemapExtent struct {
+	logical    uint64
+	physical   uint64
+	length     uint64
+	reserved64 [2]uint64
+	flags      uint32
+	reserved   [3]uint32
+}
+
+type fiemapRequest struct {
+	fiemapHeader
+	extents [extentBatch]fiemapExtent
+}
+
+// Extents returns the physical layout of the file.
+// FIEMAP is used when the filesystem supports it, otherwise the layout is
+// built block by block with FIBMAP which requires CAP_SYS_RAWIO.
+func Extents(path string) ([]Extent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", path)
+	}
+	defer f.Close()
+
+	es, err := fiemap(f)
+	if err == syscall.ENOTTY || err == syscall.EOPNOTSUPP {
+		es, err = fibmapExtents(f)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to map extents of %s", path)
+	}
+
+	return es, nil
+}
+
+func fiemap(f *os.File) ([]Extent, error) {
+	var es []Extent
+
+	var start uint64
+	for {
+		req := fiemapRequest{
+			fiemapHeader: fiemapHeader{
+				start:       start,
+				length:      ^uint64(0) - start,
+				flags:       fiemapFlagSync,
+				extentCount: extentBatch,
+			},
+		}
+		if err := ioctl(f.Fd(), fsIocFiemap, unsafe.Pointer(&req)); err != nil {
+			return nil, err
+		}
+		if req.mappedExtents == 0 {
+			return es, nil
+		}
+
+		for _, fe := range req.extents[:req.mappedExtents] {
+			es = append(es, Extent{
+				Logical:  fe.logical,
+				Physical: fe.physical,
+				Length:   fe.length,
+				Flags:    Flag(fe.flags),
+			})
+			if Flag(fe.flags)&FlagLast != 0 {
+				return es, nil
+			}
+		}
+
+		last := req.extents[req.mappedExtents-1]
+		start = last.logical + last.length
+	}
+}
+
+func fibmapExtents(f *os.File) ([]Extent, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var bsz int32
+	if err := ioctl(f.Fd(), figetbsz, unsafe.Pointer(&bsz)); err != nil {
+		return nil, err
+	}
+	if bsz <= 0 {
+		return nil, errors.Errorf("invalid block size %d", bsz)
+	}
+	blockSize := uint64(bsz)
+
+	var es []Extent
+	blocks := (uint64(fi.Size()) + blockSize - 1) / blockSize
+	for i := uint64(0); i < blocks; i++ {
+		b := int32(i)
+		if err := ioctl(f.Fd(), fibmap, unsafe.Pointer(&b)); err != nil {
+			return nil, err
+		}
+		// Zero means a hole
+		if b == 0 {
+			continue
+		}
+
+		phys := uint64(b) * blockSize
+		if n := len(es); n > 0 {
+			prev := &es[n-1]
+			if prev.Logical+prev.Length == i*blockSize && prev.Physical+prev.Length == phys {
+				prev.Length += blockSize
+				continue
+			}
+		}
+		es = append(es, Extent{Logical: i * blockSize, Physical: phys, Length: blockSize})
+	}
+	if n := len(es); n > 0 {
+		es[n-1].Flags |= FlagLast
+	}
+
+	return es, nil
+}
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
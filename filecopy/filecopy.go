@@ -0,0 +1,299 @@
+package filecopy
+
+import (
+	"context"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/alexdzyoba/sys/ioprio"
+	"github.com/alexdzyoba/sys/xattr"
+	"github.com/pkg/errors"
+)
+
+const (
+	ficlone = 0x40049409
+
+	seekData = 3
+	seekHole = 4
+
+	// chunkSize bounds a single copy call so cancellation is checked often
+	chunkSize = 8 << 20
+)
+
+// Method is the mechanism used to copy file data
+type Method int
+
+const (
+	MethodReflink Method = iota
+	MethodCopyFileRange
+	MethodReadWrite
+)
+
+func (m Method) String() string {
+	switch m {
+	case MethodReflink:
+		return "reflink"
+	case MethodCopyFileRange:
+		return "copy_file_range"
+	case MethodReadWrite:
+		return "read/write"
+	}
+	return "unknown"
+}
+
+// Preserve is a set of file metadata to carry over to the copy
+type Preserve int
+
+const (
+	PreserveMode Preserve = 1 << iota
+	PreserveOwnership
+	PreserveXattrs
+	PreserveTimestamps
+
+	PreserveAll = PreserveMode | PreserveOwnership | PreserveXattrs | PreserveTimestamps
+)
+
+// Options configures a copy
+type Options struct {
+	// Preserve lists metadata to copy. The zero value copies data only.
+	Preserve Preserve
+	// NoReflink disables sharing extents with the source file via FICLONE
+	NoReflink bool
+	// NoCopyFileRange disables in-kernel copying via copy_file_range(2)
+	NoCopyFileRange bool
+	// IOPriority, when set, is applied while copying data
+	IOPriority *ioprio.Priority
+}
+
+// CopyFile copies the regular file src to dst, replacing dst if it exists.
+// A reflink is preferred, then copy_file_range(2) and finally plain
+// read/write. Holes of sparse files are preserved. CopyFile returns the method
+// used for the data.
+func CopyFile(ctx context.Context, src, dst string, opts Options) (Method, error) {
+	a := action.Action{
+		Op:     "filecopy.copy",
+		Target: dst,
+		Args:   map[string]string{"source": src},
+	}
+
+	var method Method
+	err := action.Run(ctx, a, func() error {
+		var err error
+		method, err = copyFile(ctx, src, dst, opts)
+		return err
+	})
+
+	return method, err
+}
+
+func copyFile(ctx context.Context, src, dst string, opts Options) (Method, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to open %s", src)
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to stat %s", src)
+	}
+	if !fi.Mode().IsRegular() {
+		return 0, errors.Errorf("%s is not a regular file", src)
+	}
+
+	// dst is truncated only after checking it's not src under another
+	// name, that would lose the data
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to create %s", dst)
+	}
+	defer out.Close()
+
+	ofi, err := out.Stat()
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to stat %s", dst)
+	}
+	if os.SameFile(fi, ofi) {
+		return 0, errors.Errorf("%s and %s are the same file", src, dst)
+	}
+	if err := out.Truncate(0); err != nil {
+		return 0, errors.Wrapf(err, "failed to truncate %s", dst)
+	}
+
+	var method Method
+	doCopy := func() error {
+		method, err = copyData(ctx, in, out, fi.Size(), opts)
+		return err
+	}
+	if opts.IOPriority != nil {
+		err = ioprio.Do(*opts.IOPriority, doCopy)
+	} else {
+		err = doCopy()
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to copy %s to %s", src, dst)
+	}
+
+	if err := preserve(ctx, out, src, dst, fi.Sys().(*syscall.Stat_t), opts.Preserve); err != nil {
+		return 0, err
+	}
+
+	if err := out.Close(); err != nil {
+		return 0, errors.Wrapf(err, "failed to close %s", dst)
+	}
+
+	return method, nil
+}
+
+func copyData(ctx context.Context, in, out *os.File, size int64, opts Options) (Method, error) {
+	if !opts.NoReflink {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd())
+		if errno == 0 {
+			return MethodReflink, nil
+		}
+	}
+
+	method := MethodCopyFileRange
+	if opts.NoCopyFileRange || !haveCopyFileRange {
+		method = MethodReadWrite
+	}
+
+	segments, err := dataSegments(in, size)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, s := range segments {
+		for off := s.start; off < s.end; {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+
+			n := s.end - off
+			if n > chunkSize {
+				n = chunkSize
+			}
+
+			var copied int64
+			if method == MethodCopyFileRange {
+				copied, err = copyFileRange(in, out, off, n)
+				if err == syscall.EXDEV || err == syscall.ENOSYS ||
+					err == syscall.EINVAL || err == syscall.EOPNOTSUPP {
+					method = MethodReadWrite
+					continue
+				}
+			} else {
+				copied, err = readWrite(in, out, off, n)
+			}
+			if err != nil {
+				return 0, err
+			}
+			if copied == 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			off += copied
+		}
+	}
+
+	// Extend the file over a trailing hole
+	if err := out.Truncate(size); err != nil {
+		return 0, err
+	}
+
+	return method, nil
+}
+
+type segment struct {
+	start int64
+	end   int64
+}
+
+// dataSegments returns ranges of the file holding data using
+// SEEK_DATA/SEEK_HOLE. Filesystems without hole reporting get one segment.
+func dataSegments(f *os.File, size int64) ([]segment, error) {
+	var segs []segment
+
+	fd := int(f.Fd())
+	for off := int64(0); off < size; {
+		start, err := syscall.Seek(fd, off, seekData)
+		if err == syscall.ENXIO {
+			break
+		}
+		if err == syscall.EINVAL {
+			return []segment{{0, size}}, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to seek data")
+		}
+
+		end, err := syscall.Seek(fd, start, seekHole)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to seek hole")
+		}
+		if end > size {
+			end = size
+		}
+
+		segs = append(segs, segment{start, end})
+		off = end
+	}
+
+	return segs, nil
+}
+
+func copyFileRange(in, out *os.File, off, n int64) (int64, error) {
+	offIn, offOut := off, off
+	r, _, errno := syscall.Syscall6(uintptr(sysCopyFileRange),
+		in.Fd(), uintptr(unsafe.Pointer(&offIn)),
+		out.Fd(), uintptr(unsafe.Pointer(&offOut)),
+		uintptr(n), 0)
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return int64(r), nil
+}
+
+func readWrite(in, out *os.File, off, n int64) (int64, error) {
+	buf := make([]byte, n)
+	r, err := in.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	w, err := out.WriteAt(buf[:r], off)
+	return int64(w), err
+}
+
+func preserve(ctx context.Context, out *os.File, src, dst string, st *syscall.Stat_t, p Preserve) error {
+	// Ownership goes first since chown clears setuid and setgid bits
+	if p&PreserveOwnership != 0 {
+		if err := out.Chown(int(st.Uid), int(st.Gid)); err != nil {
+			return errors.Wrapf(err, "failed to chown %s", dst)
+		}
+	}
+
+	if p&PreserveMode != 0 {
+		if err := out.Chmod(os.FileMode(st.Mode & 07777)); err != nil {
+			return errors.Wrapf(err, "failed to chmod %s", dst)
+		}
+	}
+
+	if p&PreserveXattrs != 0 {
+		if err := xattr.CopyAll(ctx, src, dst); err != nil {
+			return err
+		}
+	}
+
+	if p&PreserveTimestamps != 0 {
+		ts := []syscall.Timespec{st.Atim, st.Mtim}
+		if err := syscall.UtimesNano(dst, ts); err != nil {
+			return errors.Wrapf(err, "failed to set timestamps of %s", dst)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,6 @@
+package filecopy
+
+const (
+	sysCopyFileRange  = 326
+	haveCopyFileRange = true
+)
@@ -0,0 +1,11 @@
+//go:build linux && !amd64 && !arm64
+// +build linux,!amd64,!arm64
+
+package filecopy
+
+// copy_file_range is not wired up for this architecture, the copier falls
+// back to read/write
+const (
+	sysCopyFileRange  = 0
+	haveCopyFileRange = false
+)
@@ -0,0 +1,6 @@
+// Package flushtrace counts preflush and FUA requests each process submits
+// to each device with an eBPF program on the block_bio_queue tracepoint,
+// finding who drives durability traffic behind flush counters of
+// block.FlushStats. It needs CAP_BPF or root and is only built with the
+// "bpf" build tag.
+package flushtrace
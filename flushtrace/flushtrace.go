@@ -0,0 +1,193 @@
+//go:build bpf
+// +build bpf
+
+package flushtrace
+
+import (
+	"bytes"
+	"sort"
+	"syscall"
+	"unsafe"
+
+	"github.com/alexdzyoba/sys/internal/bpf"
+	"github.com/pkg/errors"
+)
+
+const maxEntries = 10240
+
+// Flushes are durability requests a process submitted to a device
+type Flushes struct {
+	PID int
+	// Command is the name of the thread that submitted the first request
+	Command      string
+	Major, Minor uint32
+	// Device is the kernel device name, empty if the device is gone
+	Device string
+	// Preflushes are requests flushing the write cache before their data,
+	// fsync and journal commits produce them
+	Preflushes uint64
+	// FUA are writes bypassing the write cache
+	FUA uint64
+}
+
+type key struct {
+	pid uint32
+	dev uint32
+}
+
+type value struct {
+	preflushes uint64
+	fua        uint64
+	comm       [16]byte
+}
+
+// Collector counts flushes until closed
+type Collector struct {
+	objs    bpf.Collector
+	flushes int
+}
+
+// NewCollector loads the program and starts collecting
+func NewCollector() (_ *Collector, err error) {
+	c := &Collector{}
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	c.flushes, err = c.objs.CreateMap(uint32(unsafe.Sizeof(key{})), uint32(unsafe.Sizeof(value{})), maxEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	const tp = "block_bio_queue"
+	dev, err := bpf.FieldOffset("block", tp, "dev")
+	if err != nil {
+		return nil, err
+	}
+	rwbs, err := bpf.FieldOffset("block", tp, "rwbs")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.objs.Attach(c.program(dev, rwbs), "block", tp); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// program counts bios by their rwbs flags. The kernel formats them as an
+// optional F for preflush, the operation (R, W, D, E or N) and an optional
+// F for FUA.
+func (c *Collector) program(dev, rwbs int16) *bpf.Asm {
+	a := bpf.NewAsm()
+	a.Emit(bpf.OpMovReg, bpf.R6, bpf.R1, 0, 0)
+
+	// r8 = preflush, r9 = fua
+	a.Emit(bpf.OpMovImm, bpf.R8, 0, 0, 0)
+	a.Emit(bpf.OpMovImm, bpf.R9, 0, 0, 0)
+	a.Emit(bpf.OpLdxB, bpf.R7, bpf.R6, rwbs, 0)
+	a.Jump(bpf.OpJneImm, bpf.R7, 'F', "op")
+	a.Emit(bpf.OpMovImm, bpf.R8, 0, 0, 1)
+	a.Emit(bpf.OpLdxB, bpf.R7, bpf.R6, rwbs+2, 0)
+	a.Jump(bpf.OpJa, 0, 0, "fua")
+	a.Label("op")
+	a.Emit(bpf.OpLdxB, bpf.R7, bpf.R6, rwbs+1, 0)
+	a.Label("fua")
+	a.Emit(bpf.OpJneImm, bpf.R7, 0, 1, 'F')
+	a.Emit(bpf.OpMovImm, bpf.R9, 0, 0, 1)
+
+	// Nothing to count for regular bios
+	a.Jump(bpf.OpJneImm, bpf.R8, 0, "key")
+	a.Jump(bpf.OpJeqImm, bpf.R9, 0, "out")
+
+	// Key at r10-8: tgid, dev
+	a.Label("key")
+	a.Call(bpf.FnGetCurrentPidTgid)
+	a.Emit(bpf.OpRshImm, bpf.R0, 0, 0, 32)
+	a.Emit(bpf.OpStxW, bpf.R10, bpf.R0, -8, 0)
+	a.Emit(bpf.OpLdxW, bpf.R7, bpf.R6, dev, 0)
+	a.Emit(bpf.OpStxW, bpf.R10, bpf.R7, -4, 0)
+
+	a.LoadMap(bpf.R1, c.flushes)
+	a.StackPtr(bpf.R2, -8)
+	a.Call(bpf.FnMapLookupElem)
+	a.Jump(bpf.OpJneImm, bpf.R0, 0, "add")
+
+	// First flush of the process to the device, value at r10-40
+	a.Emit(bpf.OpStxDW, bpf.R10, bpf.R8, -40, 0)
+	a.Emit(bpf.OpStxDW, bpf.R10, bpf.R9, -32, 0)
+	a.StackPtr(bpf.R1, -24)
+	a.Emit(bpf.OpMovImm, bpf.R2, 0, 0, 16)
+	a.Call(bpf.FnGetCurrentComm)
+	a.LoadMap(bpf.R1, c.flushes)
+	a.StackPtr(bpf.R2, -8)
+	a.StackPtr(bpf.R3, -40)
+	a.Emit(bpf.OpMovImm, bpf.R4, 0, 0, bpf.NoExist)
+	a.Call(bpf.FnMapUpdateElem)
+	a.Jump(bpf.OpJeqImm, bpf.R0, 0, "out")
+
+	// Another CPU created the entry meanwhile
+	a.LoadMap(bpf.R1, c.flushes)
+	a.StackPtr(bpf.R2, -8)
+	a.Call(bpf.FnMapLookupElem)
+	a.Jump(bpf.OpJeqImm, bpf.R0, 0, "out")
+
+	a.Label("add")
+	a.Emit(bpf.OpXaddDW, bpf.R0, bpf.R8, 0, 0)
+	a.Emit(bpf.OpXaddDW, bpf.R0, bpf.R9, 8, 0)
+
+	a.Label("out")
+	a.Return()
+	return a
+}
+
+// Flushes returns flushes submitted since the collector started, most
+// frequent first
+func (c *Collector) Flushes() ([]Flushes, error) {
+	var fs []Flushes
+
+	var k, next key
+	var cur unsafe.Pointer
+	for {
+		ok, err := bpf.NextKey(c.flushes, cur, unsafe.Pointer(&next))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to iterate flushes map")
+		}
+		if !ok {
+			break
+		}
+		k = next
+		cur = unsafe.Pointer(&k)
+
+		var v value
+		if err := bpf.Lookup(c.flushes, unsafe.Pointer(&k), unsafe.Pointer(&v)); err != nil {
+			if err == syscall.ENOENT {
+				continue
+			}
+			return nil, errors.Wrap(err, "failed to read flushes map")
+		}
+
+		f := Flushes{
+			PID:        int(k.pid),
+			Command:    string(bytes.TrimRight(v.comm[:], "\x00")),
+			Preflushes: v.preflushes,
+			FUA:        v.fua,
+		}
+		f.Major, f.Minor, f.Device = bpf.DeviceName(k.dev)
+		fs = append(fs, f)
+	}
+
+	sort.Slice(fs, func(i, j int) bool {
+		return fs[i].Preflushes+fs[i].FUA > fs[j].Preflushes+fs[j].FUA
+	})
+
+	return fs, nil
+}
+
+// Close detaches the program and releases the map
+func (c *Collector) Close() error {
+	return c.objs.Close()
+}
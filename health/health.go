@@ -0,0 +1,543 @@
+// Package health combines the checks of other packages into one host
+// report: SMART warnings and error counters of disks, degraded md arrays,
+// thin pool usage, filesystem errors, sensor alarms and pressure stall
+// information.
+package health
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alexdzyoba/sys/block"
+	"github.com/alexdzyoba/sys/hwmon"
+	"github.com/alexdzyoba/sys/internal/sgio"
+	"github.com/alexdzyoba/sys/nvmemi"
+	"github.com/alexdzyoba/sys/smart"
+	"github.com/pkg/errors"
+)
+
+const (
+	sysfsExt4Root = "/sys/fs/ext4"
+	pressureRoot  = "/proc/pressure"
+
+	senseIllegalRequest = 0x05
+)
+
+// Severity of a finding, higher is worse
+type Severity int
+
+const (
+	SeverityOK Severity = iota
+	SeverityInfo
+	SeverityWarning
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityOK:
+		return "ok"
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityCritical:
+		return "critical"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the severity by name
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Finding is a problem reported by a check
+type Finding struct {
+	// Check is one of "smart", "md", "thinpool", "filesystem", "sensor"
+	// and "psi"
+	Check string `json:"check"`
+	// Subject is what the finding is about like "md0" or "io"
+	Subject  string   `json:"subject"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+// Skipped is a check that couldn't run, e.g. for lack of privileges
+type Skipped struct {
+	Check string `json:"check"`
+	Err   string `json:"error"`
+}
+
+// HostHealth is the combined report
+type HostHealth struct {
+	Time time.Time `json:"time"`
+	// Status is the worst severity of the findings
+	Status   Severity  `json:"status"`
+	Findings []Finding `json:"findings,omitempty"`
+	Skipped  []Skipped `json:"skipped,omitempty"`
+}
+
+// Options configure the checks, thresholds are in percent. Zero thresholds
+// take the values of DefaultOptions.
+type Options struct {
+	ThinPoolWarning  float64
+	ThinPoolCritical float64
+	// PSI thresholds apply to the "some" share over the last 60 seconds
+	PSIWarning  float64
+	PSICritical float64
+	// NVMeMIBuses are the i2c adapters probed for NVMe-MI drives, e.g.
+	// from nvmemi.MuxBuses. None are probed by default.
+	NVMeMIBuses []int
+}
+
+// DefaultOptions are the thresholds used for zero fields of Options
+var DefaultOptions = Options{
+	ThinPoolWarning:  80,
+	ThinPoolCritical: 95,
+	PSIWarning:       20,
+	PSICritical:      50,
+}
+
+func (o Options) withDefaults() Options {
+	if o.ThinPoolWarning == 0 {
+		o.ThinPoolWarning = DefaultOptions.ThinPoolWarning
+	}
+	if o.ThinPoolCritical == 0 {
+		o.ThinPoolCritical = DefaultOptions.ThinPoolCritical
+	}
+	if o.PSIWarning == 0 {
+		o.PSIWarning = DefaultOptions.PSIWarning
+	}
+	if o.PSICritical == 0 {
+		o.PSICritical = DefaultOptions.PSICritical
+	}
+	return o
+}
+
+// Check runs all checks. A check failing to run is listed in Skipped
+// instead of failing the report, subsystems missing on the host like md or
+// hwmon just have no findings.
+func Check(ctx context.Context, opts Options) *HostHealth {
+	opts = opts.withDefaults()
+	h := &HostHealth{Time: time.Now()}
+
+	checks := []struct {
+		name string
+		run  func(Options) ([]Finding, error)
+	}{
+		{"smart", checkSMART},
+		{"md", checkMD},
+		{"thinpool", checkThinPools},
+		{"filesystem", checkFilesystems},
+		{"sensor", checkSensors},
+		{"psi", checkPSI},
+	}
+	for _, c := range checks {
+		if ctx.Err() != nil {
+			h.Skipped = append(h.Skipped, Skipped{Check: c.name, Err: ctx.Err().Error()})
+			continue
+		}
+
+		fs, err := c.run(opts)
+		if err != nil && !os.IsNotExist(errors.Cause(err)) {
+			h.Skipped = append(h.Skipped, Skipped{Check: c.name, Err: err.Error()})
+		}
+		for _, f := range fs {
+			f.Check = c.name
+			if f.Severity > h.Status {
+				h.Status = f.Severity
+			}
+			h.Findings = append(h.Findings, f)
+		}
+	}
+
+	return h
+}
+
+// checkSMART reports SMART failures, wear and error counters of the disks.
+// NVMe drives reachable over NVMe-MI are asked through their management
+// endpoint, the others through their device nodes, SCSI and SATA disks
+// report their error counter logs too. Disks not speaking SMART like
+// virtio ones are left out, it's an error when a disk can't be queried.
+func checkSMART(opts Options) ([]Finding, error) {
+	fs, serials, err := checkNVMeMI(opts.NVMeMIBuses)
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return fs, err
+	}
+
+	ds, err := block.ListDevices()
+	if err != nil {
+		return fs, err
+	}
+	for _, d := range ds {
+		if d.Type != block.TypeDisk && d.Type != block.TypeNVMe || d.Serial != "" && serials[d.Serial] {
+			continue
+		}
+
+		r, err := smart.Read(path.Join("/dev", d.Name))
+		switch {
+		case unsupported(err):
+			continue
+		case err != nil:
+			return fs, err
+		}
+		fs = append(fs, smartFindings(d.Name, r)...)
+
+		if d.Type == block.TypeDisk {
+			counters, err := errorCounterFindings(d)
+			if err != nil {
+				return fs, err
+			}
+			fs = append(fs, counters...)
+		}
+	}
+
+	return fs, nil
+}
+
+// checkNVMeMI reports critical warnings and wear of NVMe drives reachable
+// over NVMe-MI on the buses and returns their serials
+func checkNVMeMI(buses []int) ([]Finding, map[string]bool, error) {
+	drives, err := nvmemi.ListDrives(buses)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	warnings := []struct {
+		bit  uint8
+		text string
+	}{
+		{nvmemi.WarnSpare, "spare capacity below threshold"},
+		{nvmemi.WarnTemperature, "temperature out of range"},
+		{nvmemi.WarnReliability, "reliability degraded"},
+		{nvmemi.WarnReadOnly, "media is read-only"},
+		{nvmemi.WarnBackup, "volatile memory backup failed"},
+	}
+
+	var fs []Finding
+	serials := map[string]bool{}
+	for _, d := range drives {
+		h, err := d.Health()
+		if err != nil {
+			return fs, serials, err
+		}
+		subject := d.Identity.Serial
+		serials[subject] = true
+
+		if !h.Functional {
+			fs = append(fs, Finding{Subject: subject, Severity: SeverityCritical, Message: "drive is not functional"})
+		}
+		for _, w := range warnings {
+			if h.Warnings&w.bit != 0 {
+				fs = append(fs, Finding{Subject: subject, Severity: SeverityCritical, Message: w.text})
+			}
+		}
+		if h.PercentUsed >= 100 {
+			fs = append(fs, Finding{Subject: subject, Severity: SeverityWarning, Message: fmt.Sprintf("%d%% of rated life used", h.PercentUsed)})
+		}
+	}
+
+	return fs, serials, nil
+}
+
+func smartFindings(name string, r *smart.Report) []Finding {
+	var fs []Finding
+	if !r.Passed {
+		fs = append(fs, Finding{Subject: name, Severity: SeverityCritical, Message: "SMART health check failed"})
+	}
+	if r.WearValid && r.PercentUsed >= 100 {
+		fs = append(fs, Finding{Subject: name, Severity: SeverityWarning, Message: fmt.Sprintf("%d%% of rated life used", r.PercentUsed)})
+	}
+	if r.ReallocatedSectors > 0 {
+		fs = append(fs, Finding{Subject: name, Severity: SeverityWarning, Message: fmt.Sprintf("%d sectors reallocated", r.ReallocatedSectors)})
+	}
+	if r.MediaErrors > 0 {
+		fs = append(fs, Finding{Subject: name, Severity: SeverityWarning, Message: fmt.Sprintf("%d media errors", r.MediaErrors)})
+	}
+	return fs
+}
+
+// errorCounterFindings reports uncorrected errors of the SCSI error
+// counter log or, for SATA disks, the ATA device statistics
+func errorCounterFindings(d block.Device) ([]Finding, error) {
+	var fs []Finding
+	uncorrected := func(what string, c block.Counter) {
+		if c.Valid && c.Value > 0 {
+			fs = append(fs, Finding{Subject: d.Name, Severity: SeverityWarning, Message: fmt.Sprintf("%d uncorrected %s errors", c.Value, what)})
+		}
+	}
+
+	log, err := d.SCSIErrorLog()
+	switch {
+	case err == nil:
+		for _, p := range []struct {
+			what     string
+			counters *block.ErrorCounters
+		}{{"read", log.Read}, {"write", log.Write}, {"verify", log.Verify}} {
+			if p.counters != nil {
+				uncorrected(p.what, p.counters.Uncorrected)
+			}
+		}
+	case !unsupported(err):
+		return nil, err
+	}
+
+	stats, err := d.ATAErrorStatistics()
+	switch {
+	case err == nil:
+		uncorrected("reported", stats.ReportedUncorrectable)
+		if stats.InterfaceCRCErrors.Valid && stats.InterfaceCRCErrors.Value > 0 {
+			fs = append(fs, Finding{Subject: d.Name, Severity: SeverityInfo, Message: fmt.Sprintf("%d interface CRC errors", stats.InterfaceCRCErrors.Value)})
+		}
+	case !unsupported(err):
+		return fs, err
+	}
+
+	return fs, nil
+}
+
+// unsupported returns whether the driver or the device rejected the
+// commands, unlike failing to open the device
+func unsupported(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EINVAL, syscall.ENOTTY, syscall.EOPNOTSUPP} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var se *sgio.Error
+	if errors.As(err, &se) {
+		key, _, _, ok := se.SenseKey()
+		return ok && key == senseIllegalRequest
+	}
+	return false
+}
+
+// checkMD reports inactive, degraded and rebuilding md arrays
+func checkMD(Options) ([]Finding, error) {
+	stat, err := block.ReadMDStat()
+	if err != nil {
+		return nil, err
+	}
+
+	var fs []Finding
+	for _, a := range stat.Arrays {
+		switch {
+		case a.State == "inactive":
+			fs = append(fs, Finding{Subject: a.Name, Severity: SeverityCritical, Message: "array is inactive"})
+		case a.ActiveDisks < a.RaidDisks:
+			fs = append(fs, Finding{
+				Subject:  a.Name,
+				Severity: SeverityCritical,
+				Message:  fmt.Sprintf("array is degraded with %d of %d disks", a.ActiveDisks, a.RaidDisks),
+			})
+		}
+
+		if a.Sync != nil && (a.Sync.Action == "recovery" || a.Sync.Action == "resync") {
+			fs = append(fs, Finding{
+				Subject:  a.Name,
+				Severity: SeverityInfo,
+				Message:  fmt.Sprintf("%s at %.1f%%", a.Sync.Action, a.Sync.Percent),
+			})
+		}
+	}
+
+	return fs, nil
+}
+
+// checkThinPools reports usage of data and metadata of thin pools
+func checkThinPools(opts Options) ([]Finding, error) {
+	ds, err := block.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	var fs []Finding
+	for _, d := range ds {
+		if d.Type != block.TypeDeviceMapper {
+			continue
+		}
+
+		// Pools are found by their target type, LVM names them with a
+		// "-tpool" suffix but dmsetup users name them freely
+		targets, err := d.DMStatus()
+		if err != nil {
+			if errors.Is(err, block.ErrDeviceGone) {
+				continue
+			}
+			return fs, err
+		}
+		for _, t := range targets {
+			if t.Type != "thin-pool" {
+				continue
+			}
+			s, err := block.ParseThinPoolStatus(t.Params)
+			if err != nil {
+				return fs, err
+			}
+			fs = append(fs, thinPoolFindings(d.DMName, s, opts)...)
+		}
+	}
+
+	return fs, nil
+}
+
+func thinPoolFindings(name string, s block.ThinPoolStatus, opts Options) []Finding {
+	var fs []Finding
+
+	switch s.Mode {
+	case "Fail":
+		return []Finding{{Subject: name, Severity: SeverityCritical, Message: "pool has failed"}}
+	case "out_of_data_space":
+		fs = append(fs, Finding{Subject: name, Severity: SeverityCritical, Message: "pool is out of data space"})
+	case "ro":
+		fs = append(fs, Finding{Subject: name, Severity: SeverityCritical, Message: "pool is read-only"})
+	}
+	if s.NeedsCheck {
+		fs = append(fs, Finding{Subject: name, Severity: SeverityCritical, Message: "metadata needs thin_check"})
+	}
+
+	usage := []struct {
+		what    string
+		percent float64
+	}{
+		{"data", s.DataPercent()},
+		{"metadata", s.MetadataPercent()},
+	}
+	for _, u := range usage {
+		severity := SeverityOK
+		switch {
+		case u.percent >= opts.ThinPoolCritical:
+			severity = SeverityCritical
+		case u.percent >= opts.ThinPoolWarning:
+			severity = SeverityWarning
+		}
+		if severity != SeverityOK {
+			fs = append(fs, Finding{Subject: name, Severity: severity, Message: fmt.Sprintf("%s is %.1f%% used", u.what, u.percent)})
+		}
+	}
+
+	return fs
+}
+
+// checkFilesystems reports ext4 filesystems that recorded errors. Other
+// filesystems don't expose error counters in sysfs.
+func checkFilesystems(Options) ([]Finding, error) {
+	entries, err := ioutil.ReadDir(sysfsExt4Root)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", sysfsExt4Root)
+	}
+
+	var fs []Finding
+	for _, e := range entries {
+		dir := path.Join(sysfsExt4Root, e.Name())
+		count, err := strconv.Atoi(readAttr(path.Join(dir, "errors_count")))
+		if err != nil || count == 0 {
+			continue
+		}
+
+		msg := fmt.Sprintf("%d errors recorded", count)
+		if fn := readAttr(path.Join(dir, "last_error_func")); fn != "" {
+			msg += ", last in " + fn
+		}
+		fs = append(fs, Finding{Subject: e.Name(), Severity: SeverityWarning, Message: msg})
+	}
+
+	return fs, nil
+}
+
+// checkSensors reports alarms raised by hwmon chips
+func checkSensors(Options) ([]Finding, error) {
+	alarms, err := hwmon.ActiveAlarms()
+	if err != nil {
+		return nil, err
+	}
+
+	var fs []Finding
+	for _, a := range alarms {
+		severity := SeverityWarning
+		if strings.HasSuffix(a, "_crit_alarm") || strings.HasSuffix(a, "_emergency_alarm") {
+			severity = SeverityCritical
+		}
+		fs = append(fs, Finding{Subject: a, Severity: severity, Message: "alarm raised"})
+	}
+
+	return fs, nil
+}
+
+// checkPSI reports resources processes stall on
+func checkPSI(opts Options) ([]Finding, error) {
+	var fs []Finding
+	for _, resource := range []string{"cpu", "memory", "io"} {
+		p := path.Join(pressureRoot, resource)
+		some, err := readPressure(p)
+		if err != nil {
+			return fs, err
+		}
+
+		severity := SeverityOK
+		switch {
+		case some >= opts.PSICritical:
+			severity = SeverityCritical
+		case some >= opts.PSIWarning:
+			severity = SeverityWarning
+		}
+		if severity != SeverityOK {
+			fs = append(fs, Finding{
+				Subject:  resource,
+				Severity: severity,
+				Message:  fmt.Sprintf("tasks stalled %.1f%% of the last minute", some),
+			})
+		}
+	}
+
+	return fs, nil
+}
+
+// readPressure returns avg60 of the "some" line of a PSI file like
+// "some avg10=0.00 avg60=1.25 avg300=0.80 total=40246758"
+func readPressure(p string) (float64, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to open %v", p)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "some" {
+			continue
+		}
+		for _, kv := range fields[1:] {
+			if v := strings.TrimPrefix(kv, "avg60="); v != kv {
+				avg, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return 0, errors.Wrapf(err, "failed to parse %v", p)
+				}
+				return avg, nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, errors.Wrapf(err, "failed to read %v", p)
+	}
+
+	return 0, errors.Errorf("no stall average in %v", p)
+}
+
+func readAttr(p string) string {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
@@ -0,0 +1,237 @@
+// Package healthlog keeps a local history of disk health samples so trends
+// like growing reallocated sectors can be spotted without an external time
+// series database. Every disk has its own JSON file named after its stable
+// id holding at most a fixed number of the latest samples.
+package healthlog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexdzyoba/sys/internal/fsutil"
+	"github.com/pkg/errors"
+)
+
+// DefaultCapacity keeps about a month of hourly samples
+const DefaultCapacity = 24 * 31
+
+const fileSuffix = ".json"
+
+// Sample is a set of health readings taken at once. Values are keyed by
+// attribute like "temperature" or "reallocated_sectors", any name the caller
+// uses consistently works.
+type Sample struct {
+	Time   time.Time          `json:"time"`
+	Values map[string]float64 `json:"values"`
+}
+
+// Point is a single reading of an attribute
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Log is a directory of per-disk sample files. Log is safe for concurrent
+// use within a process.
+type Log struct {
+	mu  sync.Mutex
+	dir string
+	// Capacity is the number of samples kept per disk, older ones are
+	// dropped
+	Capacity int
+}
+
+// Open returns the log stored in the directory creating it if needed
+func Open(dir string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create %v", dir)
+	}
+
+	return &Log{dir: dir, Capacity: DefaultCapacity}, nil
+}
+
+// Record appends the sample to the history of the disk
+func (l *Log) Record(stableID string, s Sample) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	samples, err := l.load(stableID)
+	if err != nil {
+		return err
+	}
+
+	samples = append(samples, s)
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Time.Before(samples[j].Time)
+	})
+	if l.Capacity > 0 && len(samples) > l.Capacity {
+		samples = samples[len(samples)-l.Capacity:]
+	}
+
+	content, err := json.Marshal(samples)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode history of %s", stableID)
+	}
+
+	return fsutil.WriteFileAtomic(l.file(stableID), content)
+}
+
+// History returns the samples of the disk ordered by time, it's empty for
+// disks never recorded
+func (l *Log) History(stableID string) ([]Sample, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.load(stableID)
+}
+
+// Range returns the samples of the disk taken in [from, to)
+func (l *Log) Range(stableID string, from, to time.Time) ([]Sample, error) {
+	samples, err := l.History(stableID)
+	if err != nil {
+		return nil, err
+	}
+
+	var in []Sample
+	for _, s := range samples {
+		if !s.Time.Before(from) && s.Time.Before(to) {
+			in = append(in, s)
+		}
+	}
+
+	return in, nil
+}
+
+// Series returns the readings of a single attribute of the disk skipping
+// samples without it
+func (l *Log) Series(stableID, attr string) ([]Point, error) {
+	samples, err := l.History(stableID)
+	if err != nil {
+		return nil, err
+	}
+
+	return Series(samples, attr), nil
+}
+
+// Disks returns stable ids of all disks with history
+func (l *Log) Disks() ([]string, error) {
+	files, err := ioutil.ReadDir(l.dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", l.dir)
+	}
+
+	var ids []string
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		id, err := url.PathUnescape(strings.TrimSuffix(name, fileSuffix))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// Forget removes the history of the disk
+func (l *Log) Forget(stableID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p := l.file(stableID)
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove %v", p)
+	}
+
+	return nil
+}
+
+func (l *Log) load(stableID string) ([]Sample, error) {
+	p := l.file(stableID)
+	content, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", p)
+	}
+
+	var samples []Sample
+	if err := json.Unmarshal(content, &samples); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %v", p)
+	}
+
+	return samples, nil
+}
+
+// file returns the history file of the disk. Stable ids are escaped as
+// serial numbers may contain anything.
+func (l *Log) file(stableID string) string {
+	return path.Join(l.dir, url.PathEscape(stableID)+fileSuffix)
+}
+
+// Series extracts the readings of an attribute from the samples
+func Series(samples []Sample, attr string) []Point {
+	var ps []Point
+	for _, s := range samples {
+		if v, ok := s.Values[attr]; ok {
+			ps = append(ps, Point{Time: s.Time, Value: v})
+		}
+	}
+	return ps
+}
+
+// Rate returns the least squares slope of the readings per second. It's
+// not ok with fewer than two points or when they were all taken at once.
+func Rate(ps []Point) (perSecond float64, ok bool) {
+	if len(ps) < 2 {
+		return 0, false
+	}
+
+	// Seconds are taken relative to the first point to keep precision
+	var sx, sy, sxx, sxy float64
+	for _, p := range ps {
+		x := p.Time.Sub(ps[0].Time).Seconds()
+		sx += x
+		sy += p.Value
+		sxx += x * x
+		sxy += x * p.Value
+	}
+
+	n := float64(len(ps))
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return 0, false
+	}
+
+	return (n*sxy - sx*sy) / den, true
+}
+
+// Predict returns when the attribute reaches the limit if it keeps changing
+// at the current rate. It's not ok when the readings don't change or the
+// trend leads away from the limit, which includes readings already past it.
+func Predict(ps []Point, limit float64) (time.Time, bool) {
+	rate, ok := Rate(ps)
+	if !ok || rate == 0 {
+		return time.Time{}, false
+	}
+
+	last := ps[len(ps)-1]
+	left := (limit - last.Value) / rate
+	if left < 0 {
+		return time.Time{}, false
+	}
+
+	return last.Time.Add(time.Duration(left * float64(time.Second))), true
+}
@@ -0,0 +1,294 @@
+// Package hwdb reads the systemd hardware database compiled by
+// "systemd-hwdb update" to resolve modalias strings into udev properties
+// like ID_VENDOR_FROM_DATABASE and ID_MODEL_FROM_DATABASE without running
+// udevadm.
+package hwdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"syscall"
+
+	"github.com/alexdzyoba/sys/internal/fnmatch"
+	"github.com/pkg/errors"
+)
+
+// Paths are the locations of hwdb.bin in order of precedence
+var Paths = []string{"/etc/systemd/hwdb/hwdb.bin", "/etc/udev/hwdb.bin", "/usr/lib/systemd/hwdb/hwdb.bin", "/usr/lib/udev/hwdb.bin", "/lib/udev/hwdb.bin"}
+
+const (
+	signature = "KSLPHHRH"
+
+	headerSize = 80
+	// Value entries of version 2 files also carry the source file, line
+	// and priority used to resolve conflicting assignments
+	valueEntryV2Size = 32
+)
+
+// DB is an opened hardware database. DB is safe for concurrent lookups.
+type DB struct {
+	data []byte
+
+	nodeSize       uint64
+	childEntrySize uint64
+	valueEntrySize uint64
+	root           uint64
+}
+
+// Open maps the database file into memory
+func Open(p string) (*DB, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", p)
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to stat %v", p)
+	}
+	if st.Size() < headerSize {
+		return nil, errors.Errorf("%v is too small for a hwdb", p)
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(st.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to map %v", p)
+	}
+
+	db, err := newDB(data)
+	if err != nil {
+		syscall.Munmap(data)
+		return nil, errors.Wrapf(err, "failed to read %v", p)
+	}
+
+	return db, nil
+}
+
+// OpenDefault opens the first database found in Paths
+func OpenDefault() (*DB, error) {
+	for _, p := range Paths {
+		if _, err := os.Stat(p); err == nil {
+			return Open(p)
+		}
+	}
+	return nil, errors.Wrap(os.ErrNotExist, "hwdb.bin not found")
+}
+
+func newDB(data []byte) (*DB, error) {
+	if !bytes.Equal(data[:len(signature)], []byte(signature)) {
+		return nil, errors.New("invalid hwdb signature")
+	}
+
+	le := binary.LittleEndian
+	if fileSize := le.Uint64(data[16:]); fileSize != uint64(len(data)) {
+		return nil, errors.Errorf("hwdb size %d doesn't match the header %d", len(data), fileSize)
+	}
+
+	db := &DB{
+		data:           data,
+		nodeSize:       le.Uint64(data[32:]),
+		childEntrySize: le.Uint64(data[40:]),
+		valueEntrySize: le.Uint64(data[48:]),
+		root:           le.Uint64(data[56:]),
+	}
+	if db.nodeSize < 24 || db.childEntrySize < 16 || db.valueEntrySize < 16 {
+		return nil, errors.New("unsupported hwdb entry sizes")
+	}
+
+	return db, nil
+}
+
+// Close unmaps the database
+func (db *DB) Close() error {
+	return syscall.Munmap(db.data)
+}
+
+// Lookup returns properties of all entries matching the modalias, like
+// "pci:v00008086d00002922sv00001AF4sd00001100bc01sc06i01"
+func (db *DB) Lookup(modalias string) (props map[string]string, err error) {
+	// Offsets come from the file, a corrupt one must not crash the caller
+	defer func() {
+		if r := recover(); r != nil {
+			props, err = nil, errors.Errorf("corrupt hwdb: %v", r)
+		}
+	}()
+
+	s := &search{db: db, props: map[string]string{}, origins: map[string]origin{}}
+	s.trie(modalias)
+
+	return s.props, nil
+}
+
+type node struct {
+	prefix   uint64
+	children int
+	values   uint64
+	off      uint64
+}
+
+func (db *DB) node(off uint64) node {
+	le := binary.LittleEndian
+	b := db.data[off:]
+	return node{
+		prefix:   le.Uint64(b[0:]),
+		children: int(b[8]),
+		values:   le.Uint64(b[16:]),
+		off:      off,
+	}
+}
+
+// prefix returns the characters shared by all keys below the node
+func (db *DB) prefix(n node) string {
+	if n.prefix == 0 {
+		return ""
+	}
+	return db.str(n.prefix)
+}
+
+func (db *DB) str(off uint64) string {
+	b := db.data[off:]
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// child returns the entry of the node for the character, children are
+// sorted
+func (db *DB) child(n node, c byte) (node, bool) {
+	lo, hi := 0, n.children
+	for lo < hi {
+		mid := (lo + hi) / 2
+		e := db.data[n.off+db.nodeSize+uint64(mid)*db.childEntrySize:]
+		switch {
+		case e[0] == c:
+			return db.node(binary.LittleEndian.Uint64(e[8:])), true
+		case e[0] < c:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return node{}, false
+}
+
+func (db *DB) childAt(n node, i int) (byte, node) {
+	e := db.data[n.off+db.nodeSize+uint64(i)*db.childEntrySize:]
+	return e[0], db.node(binary.LittleEndian.Uint64(e[8:]))
+}
+
+type origin struct {
+	priority uint16
+	file     uint64
+	line     uint32
+}
+
+// lower returns whether the assignment loses to the previous one. Files
+// without priorities are added in the order of priority, so their offsets
+// order them.
+func (o origin) lower(prev origin) bool {
+	if o.priority == 0 {
+		return o.file < prev.file || (o.file == prev.file && o.line < prev.line)
+	}
+	return o.priority < prev.priority || (o.priority == prev.priority && o.line < prev.line)
+}
+
+type search struct {
+	db      *DB
+	props   map[string]string
+	origins map[string]origin
+	// pattern holds the part of a key from its first wildcard on
+	pattern []byte
+}
+
+// trie walks literal characters of the modalias and hands over to glob
+// matching at wildcards, like sd-hwdb's trie_search_f
+func (s *search) trie(key string) {
+	db := s.db
+	n := db.node(db.root)
+	i := 0
+
+	for {
+		if prefix := db.prefix(n); prefix != "" {
+			for p := 0; p < len(prefix); p++ {
+				c := prefix[p]
+				if c == '*' || c == '?' || c == '[' {
+					s.fnmatch(n, p, key[i+p:])
+					return
+				}
+				if i+p >= len(key) || c != key[i+p] {
+					return
+				}
+			}
+			i += len(prefix)
+		}
+
+		for _, w := range []byte("*?[") {
+			if child, ok := db.child(n, w); ok {
+				s.pattern = append(s.pattern, w)
+				s.fnmatch(child, 0, key[i:])
+				s.pattern = s.pattern[:len(s.pattern)-1]
+			}
+		}
+
+		if i == len(key) {
+			s.addValues(n)
+			return
+		}
+
+		child, ok := db.child(n, key[i])
+		if !ok {
+			return
+		}
+		n = child
+		i++
+	}
+}
+
+// fnmatch collects every key below the node and matches it as a glob
+func (s *search) fnmatch(n node, p int, rest string) {
+	prefix := s.db.prefix(n)[p:]
+	s.pattern = append(s.pattern, prefix...)
+
+	for i := 0; i < n.children; i++ {
+		c, child := s.db.childAt(n, i)
+		s.pattern = append(s.pattern, c)
+		s.fnmatch(child, 0, rest)
+		s.pattern = s.pattern[:len(s.pattern)-1]
+	}
+
+	if n.values > 0 && fnmatch.Match(string(s.pattern), rest) {
+		s.addValues(n)
+	}
+
+	s.pattern = s.pattern[:len(s.pattern)-len(prefix)]
+}
+
+func (s *search) addValues(n node) {
+	db := s.db
+	le := binary.LittleEndian
+	base := n.off + db.nodeSize + uint64(n.children)*db.childEntrySize
+
+	for i := uint64(0); i < n.values; i++ {
+		e := db.data[base+i*db.valueEntrySize:]
+		key := db.str(le.Uint64(e[0:]))
+		// Keys start with a space, other prefixes are reserved
+		if len(key) < 2 || key[0] != ' ' {
+			continue
+		}
+		key = key[1:]
+
+		var o origin
+		if db.valueEntrySize >= valueEntryV2Size {
+			o = origin{file: le.Uint64(e[16:]), line: le.Uint32(e[24:]), priority: le.Uint16(e[28:])}
+			if prev, ok := s.origins[key]; ok && o.lower(prev) {
+				continue
+			}
+		}
+
+		s.props[key] = db.str(le.Uint64(e[8:]))
+		s.origins[key] = o
+	}
+}
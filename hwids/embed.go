@@ -0,0 +1,22 @@
+//go:build !nohwids
+
+package hwids
+
+import "embed"
+
+//go:generate curl -sSfL -o data/pci.ids.gz https://pci-ids.ucw.cz/v2.2/pci.ids.gz
+//go:generate curl -sSfL -o data/usb.ids.gz http://www.linux-usb.org/usb.ids.gz
+
+// data holds the gzip compressed databases refreshed by go generate
+//
+//go:embed data
+var data embed.FS
+
+// embedded returns the compressed database or nil if it isn't there
+func embedded(name string) []byte {
+	b, err := data.ReadFile("data/" + name)
+	if err != nil {
+		return nil
+	}
+	return b
+}
@@ -0,0 +1,251 @@
+// Package hwids resolves PCI and USB vendor and device ids to names using
+// the pci.ids and usb.ids databases.
+//
+// The databases are maintained at https://pci-ids.ucw.cz and
+// http://www.linux-usb.org/usb-ids.html. Gzip compressed copies are
+// embedded, refreshed with go generate, and the nohwids build tag leaves
+// them out. The ones installed on the host by the hwdata package in
+// /usr/share/hwdata or by pciutils and usbutils in /usr/share/misc take
+// precedence since hosts usually keep them fresher. A file downloaded
+// elsewhere is used with LoadPCI and LoadUSB. Without any database ids
+// resolve to empty names.
+package hwids
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Locations of the databases installed by hwdata on various distributions
+var (
+	systemPCI = []string{"/usr/share/hwdata/pci.ids", "/usr/share/misc/pci.ids"}
+	systemUSB = []string{"/usr/share/hwdata/usb.ids", "/usr/share/misc/usb.ids", "/var/lib/usbutils/usb.ids"}
+)
+
+// Vendor is a vendor entry of a database
+type Vendor struct {
+	ID      uint16
+	Name    string
+	Devices map[uint16]string
+}
+
+// Database maps vendor and device ids to names
+type Database struct {
+	Vendors map[uint16]*Vendor
+}
+
+// Name is a resolved vendor and device pair, fields are empty when the id
+// is unknown
+type Name struct {
+	Vendor string
+	Device string
+}
+
+func (n Name) String() string {
+	return strings.TrimSpace(n.Vendor + " " + n.Device)
+}
+
+// Vendor returns the name of the vendor
+func (db *Database) Vendor(vendor uint16) (string, bool) {
+	v, ok := db.Vendors[vendor]
+	if !ok {
+		return "", false
+	}
+	return v.Name, true
+}
+
+// Device returns the name of the device of the vendor
+func (db *Database) Device(vendor, device uint16) (string, bool) {
+	v, ok := db.Vendors[vendor]
+	if !ok {
+		return "", false
+	}
+	name, ok := v.Devices[device]
+	return name, ok
+}
+
+// Lookup resolves both ids at once
+func (db *Database) Lookup(vendor, device uint16) Name {
+	var n Name
+	n.Vendor, _ = db.Vendor(vendor)
+	n.Device, _ = db.Device(vendor, device)
+	return n
+}
+
+// Parse reads a database in the pci.ids or usb.ids format, gzip
+// compressed or not. Only vendors and their devices are kept, subsystems,
+// interfaces and the class lists following vendors are skipped.
+func Parse(r io.Reader) (*Database, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decompress database")
+		}
+		defer zr.Close()
+		br = bufio.NewReader(zr)
+	}
+
+	db := &Database{Vendors: map[uint16]*Vendor{}}
+	var vendor *Vendor
+
+	scanner := bufio.NewScanner(br)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "\t\t"):
+			// Subsystems or interfaces
+		case line[0] == '\t':
+			if vendor == nil {
+				continue
+			}
+			id, name, ok := entry(line[1:])
+			if ok {
+				vendor.Devices[id] = name
+			}
+		default:
+			id, name, ok := entry(line)
+			if !ok {
+				// Vendors are followed by sections like "C 01  Mass
+				// storage controller" which aren't vendors
+				vendor = nil
+				continue
+			}
+			vendor = &Vendor{ID: id, Name: name, Devices: map[uint16]string{}}
+			db.Vendors[id] = vendor
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read database")
+	}
+
+	return db, nil
+}
+
+// entry parses "1af4  Red Hat, Inc."
+func entry(line string) (uint16, string, bool) {
+	parts := strings.SplitN(line, "  ", 2)
+	if len(parts) != 2 || len(parts[0]) != 4 {
+		return 0, "", false
+	}
+	id, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return 0, "", false
+	}
+	return uint16(id), strings.TrimSpace(parts[1]), true
+}
+
+// ParseFile reads a database file
+func ParseFile(p string) (*Database, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", p)
+	}
+	defer f.Close()
+
+	db, err := Parse(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %v", p)
+	}
+	return db, nil
+}
+
+// source is a database loaded on first use
+type source struct {
+	once   sync.Once
+	mu     sync.RWMutex
+	db     *Database
+	system []string
+	// embedded is the name of the embedded copy
+	embedded string
+}
+
+var (
+	pci = &source{system: systemPCI, embedded: "pci.ids.gz"}
+	usb = &source{system: systemUSB, embedded: "usb.ids.gz"}
+)
+
+func (s *source) get() *Database {
+	s.once.Do(func() {
+		db := s.load()
+		s.mu.Lock()
+		if s.db == nil {
+			s.db = db
+		}
+		s.mu.Unlock()
+	})
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db
+}
+
+// load reads the first database of the host, compressed ones included, and
+// falls back to the embedded one
+func (s *source) load() *Database {
+	for _, p := range s.system {
+		for _, name := range []string{p, p + ".gz"} {
+			if db, err := ParseFile(name); err == nil {
+				return db
+			}
+		}
+	}
+
+	if b := embedded(s.embedded); b != nil {
+		if db, err := Parse(bytes.NewReader(b)); err == nil {
+			return db
+		}
+	}
+
+	return &Database{Vendors: map[uint16]*Vendor{}}
+}
+
+func (s *source) set(db *Database) {
+	s.once.Do(func() {})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.db = db
+}
+
+// PCI returns the PCI database in use
+func PCI() *Database {
+	return pci.get()
+}
+
+// USB returns the USB database in use
+func USB() *Database {
+	return usb.get()
+}
+
+// LoadPCI replaces the PCI database with the file, e.g. a newer pci.ids
+func LoadPCI(p string) error {
+	db, err := ParseFile(p)
+	if err != nil {
+		return err
+	}
+	pci.set(db)
+	return nil
+}
+
+// LoadUSB replaces the USB database with the file, e.g. a newer usb.ids
+func LoadUSB(p string) error {
+	db, err := ParseFile(p)
+	if err != nil {
+		return err
+	}
+	usb.set(db)
+	return nil
+}
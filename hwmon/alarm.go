@@ -0,0 +1,163 @@
+package hwmon
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alexdzyoba/sys/poll"
+	"github.com/alexdzyoba/sys/sysfs"
+)
+
+// Input is a temperature source, a Sensor or a Zone
+type Input interface {
+	Read() (float64, error)
+	String() string
+}
+
+// Threshold raises an alarm when the input reaches High degrees Celsius
+// and clears it once the input drops below High minus Hysteresis, so a
+// value hovering around the threshold doesn't flap
+type Threshold struct {
+	Input      Input
+	High       float64
+	Hysteresis float64
+}
+
+// AlarmState is whether an alarm is active
+type AlarmState string
+
+const (
+	AlarmRaised  AlarmState = "raised"
+	AlarmCleared AlarmState = "cleared"
+)
+
+// AlarmEvent is a change of a threshold or of a chip alarm file
+type AlarmEvent struct {
+	Time  time.Time
+	State AlarmState
+	// Source is the input like "hwmon1/temp2" or the chip alarm attribute
+	// like "hwmon1/temp2_crit_alarm"
+	Source string
+	// Value and Threshold are in Celsius, zero for chip alarms
+	Value     float64
+	Threshold float64
+}
+
+// WatchOptions configure alarm watching
+type WatchOptions struct {
+	Thresholds []Threshold
+	// ChipAlarms also watches *_alarm attributes the chips raise on their
+	// own limits, like temp1_crit_alarm or fan2_alarm
+	ChipAlarms bool
+	// Interval defaults to DefaultInterval
+	Interval time.Duration
+}
+
+// WatchAlarms polls the inputs and delivers alarm changes until the
+// context is done, then the channel is closed. Inputs failing to read keep
+// their previous state.
+func WatchAlarms(ctx context.Context, opts WatchOptions) (<-chan AlarmEvent, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+
+	var alarms []string
+	if opts.ChipAlarms {
+		var err error
+		if alarms, err = chipAlarms(); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make(chan AlarmEvent)
+	go func() {
+		defer close(out)
+
+		raised := make([]bool, len(opts.Thresholds))
+		chipRaised := map[string]bool{}
+
+		poll.Every(ctx, opts.Interval, func() bool {
+			var evs []AlarmEvent
+			now := time.Now()
+
+			for i, t := range opts.Thresholds {
+				v, err := t.Input.Read()
+				if err != nil {
+					continue
+				}
+
+				var state AlarmState
+				switch {
+				case !raised[i] && v >= t.High:
+					state = AlarmRaised
+				case raised[i] && v < t.High-t.Hysteresis:
+					state = AlarmCleared
+				default:
+					continue
+				}
+				raised[i] = state == AlarmRaised
+				evs = append(evs, AlarmEvent{Time: now, State: state, Source: t.Input.String(), Value: v, Threshold: t.High})
+			}
+
+			for _, a := range alarms {
+				on, err := sysfs.Read(a, sysfs.Bool)
+				if err != nil || on == chipRaised[a] {
+					continue
+				}
+				chipRaised[a] = on
+
+				state := AlarmCleared
+				if on {
+					state = AlarmRaised
+				}
+				evs = append(evs, AlarmEvent{Time: now, State: state, Source: strings.TrimPrefix(a, hwmonRoot+"/")})
+			}
+
+			for _, e := range evs {
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			return true
+		})
+	}()
+
+	return out, nil
+}
+
+// chipAlarms returns paths of alarm attributes of all chips
+func chipAlarms() ([]string, error) {
+	chips, err := Chips()
+	if err != nil {
+		return nil, err
+	}
+
+	var alarms []string
+	for _, c := range chips {
+		matches, _ := filepath.Glob(path.Join(hwmonRoot, c.Name, "*_alarm"))
+		alarms = append(alarms, matches...)
+	}
+	return alarms, nil
+}
+
+// ActiveAlarms returns chip alarm attributes currently raised like
+// "hwmon1/temp2_crit_alarm"
+func ActiveAlarms() ([]string, error) {
+	alarms, err := chipAlarms()
+	if err != nil {
+		return nil, err
+	}
+
+	var active []string
+	for _, a := range alarms {
+		if on, err := sysfs.Read(a, sysfs.Bool); err == nil && on {
+			active = append(active, strings.TrimPrefix(a, hwmonRoot+"/"))
+		}
+	}
+	return active, nil
+}
@@ -0,0 +1,138 @@
+// Package hwmon reads hardware monitoring chips from /sys/class/hwmon and
+// thermal zones, watches temperature alarms and controls PWM fans with
+// temperature interlocks.
+package hwmon
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alexdzyoba/sys/sysfs"
+	"github.com/pkg/errors"
+)
+
+const hwmonRoot = "/sys/class/hwmon"
+
+// Chip is a monitoring chip like "hwmon0"
+type Chip struct {
+	Name string
+	// Driver is the name attribute like "coretemp" or "nct6775"
+	Driver string
+}
+
+// Chips returns monitoring chips of the system
+func Chips() ([]Chip, error) {
+	entries, err := ioutil.ReadDir(hwmonRoot)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", hwmonRoot)
+	}
+
+	cs := make([]Chip, 0, len(entries))
+	for _, e := range entries {
+		driver, _ := sysfs.Read(path.Join(hwmonRoot, e.Name(), "name"), sysfs.String)
+		cs = append(cs, Chip{Name: e.Name(), Driver: driver})
+	}
+	return cs, nil
+}
+
+// Sensor is a temperature input tempN of a chip
+type Sensor struct {
+	Chip  string
+	Index int
+	Label string
+}
+
+// Sensors returns temperature inputs of the chip
+func (c Chip) Sensors() ([]Sensor, error) {
+	indexes, err := c.inputs("temp")
+	if err != nil {
+		return nil, err
+	}
+
+	ss := make([]Sensor, 0, len(indexes))
+	for _, i := range indexes {
+		s := Sensor{Chip: c.Name, Index: i}
+		s.Label, _ = sysfs.Read(s.attr("label"), sysfs.String)
+		ss = append(ss, s)
+	}
+	return ss, nil
+}
+
+func (s Sensor) attr(name string) string {
+	return path.Join(hwmonRoot, s.Chip, "temp"+strconv.Itoa(s.Index)+"_"+name)
+}
+
+func (s Sensor) String() string {
+	return s.Chip + "/temp" + strconv.Itoa(s.Index)
+}
+
+// Read returns the temperature in Celsius
+func (s Sensor) Read() (float64, error) {
+	return sysfs.Read(s.attr("input"), millidegrees)
+}
+
+// Critical returns the critical temperature the chip reports in Celsius
+func (s Sensor) Critical() (float64, error) {
+	return sysfs.Read(s.attr("crit"), millidegrees)
+}
+
+// Fan is a tachometer input fanN of a chip
+type Fan struct {
+	Chip  string
+	Index int
+	Label string
+}
+
+// Fans returns tachometer inputs of the chip
+func (c Chip) Fans() ([]Fan, error) {
+	indexes, err := c.inputs("fan")
+	if err != nil {
+		return nil, err
+	}
+
+	fs := make([]Fan, 0, len(indexes))
+	for _, i := range indexes {
+		f := Fan{Chip: c.Name, Index: i}
+		f.Label, _ = sysfs.Read(path.Join(hwmonRoot, c.Name, "fan"+strconv.Itoa(i)+"_label"), sysfs.String)
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// RPM returns the fan speed
+func (f Fan) RPM() (int, error) {
+	return sysfs.Read(path.Join(hwmonRoot, f.Chip, "fan"+strconv.Itoa(f.Index)+"_input"), sysfs.Int[int])
+}
+
+// inputs returns sorted N of <kind>N_input attributes
+func (c Chip) inputs(kind string) ([]int, error) {
+	matches, err := filepath.Glob(path.Join(hwmonRoot, c.Name, kind+"*_input"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list %s inputs of %s", kind, c.Name)
+	}
+	return indexes(matches, kind, "_input"), nil
+}
+
+func indexes(matches []string, prefix, suffix string) []int {
+	var is []int
+	for _, m := range matches {
+		s := strings.TrimSuffix(strings.TrimPrefix(path.Base(m), prefix), suffix)
+		if i, err := strconv.Atoi(s); err == nil {
+			is = append(is, i)
+		}
+	}
+	sort.Ints(is)
+	return is
+}
+
+func millidegrees(s string) (float64, error) {
+	v, err := sysfs.Int[int64](s)
+	if err != nil {
+		return 0, err
+	}
+	return float64(v) / 1000, nil
+}
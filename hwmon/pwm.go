@@ -0,0 +1,355 @@
+package hwmon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/alexdzyoba/sys/poll"
+	"github.com/alexdzyoba/sys/sysfs"
+	"github.com/pkg/errors"
+)
+
+// DefaultInterval is how often interlocks are checked unless set
+const DefaultInterval = 2 * time.Second
+
+// Mode is the pwmN_enable value
+type Mode int
+
+const (
+	// ModeFull runs the fan at full speed
+	ModeFull Mode = 0
+	// ModeManual takes the duty cycle from pwmN
+	ModeManual Mode = 1
+	// ModeAuto lets the chip control the fan. Some drivers define values
+	// above 2 for different automatic algorithms.
+	ModeAuto Mode = 2
+)
+
+// PWM is a fan output pwmN of a chip
+type PWM struct {
+	Chip  string
+	Index int
+}
+
+// PWMs returns fan outputs of the chip
+func (c Chip) PWMs() ([]PWM, error) {
+	matches, err := filepath.Glob(path.Join(hwmonRoot, c.Name, "pwm*_enable"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list pwm outputs of %s", c.Name)
+	}
+
+	var ps []PWM
+	for _, i := range indexes(matches, "pwm", "_enable") {
+		ps = append(ps, PWM{Chip: c.Name, Index: i})
+	}
+	return ps, nil
+}
+
+func (p PWM) attr(suffix string) string {
+	return path.Join(hwmonRoot, p.Chip, "pwm"+strconv.Itoa(p.Index)+suffix)
+}
+
+// Mode returns how the fan is controlled
+func (p PWM) Mode() (Mode, error) {
+	return sysfs.Read(p.attr("_enable"), sysfs.Int[Mode])
+}
+
+// Value returns the duty cycle from 0 to 255
+func (p PWM) Value() (uint8, error) {
+	return sysfs.Read(p.attr(""), sysfs.Int[uint8])
+}
+
+// Interlock reverts the fan to automatic control once the sensor reaches
+// Max degrees Celsius
+type Interlock struct {
+	Sensor Sensor
+	Max    float64
+}
+
+// ManualOptions configure manual fan control
+type ManualOptions struct {
+	// Interlocks are required, manual control without them is refused
+	Interlocks []Interlock
+	// Interval defaults to DefaultInterval
+	Interval time.Duration
+	// RestoreOnSignal reverts every fan of the process on SIGINT, SIGTERM
+	// or SIGHUP, then raises the signal again for the process to end as it
+	// would have, or for its own handlers. SIGKILL can't be caught and
+	// leaves fans as they are, so supervisors should stop the process with
+	// a catchable signal.
+	RestoreOnSignal bool
+}
+
+// TripError is returned when an interlock reverted the fan to automatic
+// control
+type TripError struct {
+	Sensor      Sensor
+	Temperature float64
+	Max         float64
+	// Err is set when the sensor couldn't be read, which also trips
+	Err error
+}
+
+func (e *TripError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("interlock tripped: %v", e.Err)
+	}
+	return fmt.Sprintf("interlock tripped: %s/temp%d at %.1f reached %.1f",
+		e.Sensor.Chip, e.Sensor.Index, e.Temperature, e.Max)
+}
+
+// Manual is manual control of a fan output. The fan goes back to
+// automatic control when an interlock trips, the context is canceled or
+// Close is called. Without ManualOptions.RestoreOnSignal signals are left to
+// the caller, which should call Close, or RestoreAll, on SIGINT and SIGTERM
+// before exiting.
+type Manual struct {
+	ctx     context.Context
+	pwm     PWM
+	restore Mode
+
+	mu     sync.Mutex
+	closed bool
+	err    error
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// Manual switches the fan to manual control. Interlocks are checked before
+// taking over and then every interval.
+func (p PWM) Manual(ctx context.Context, opts ManualOptions) (*Manual, error) {
+	if len(opts.Interlocks) == 0 {
+		return nil, errors.Errorf("manual control of %s/pwm%d requires interlocks", p.Chip, p.Index)
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if err := check(opts.Interlocks); err != nil {
+		return nil, err
+	}
+
+	mode, err := p.Mode()
+	if err != nil {
+		return nil, err
+	}
+	// Going back to a manual duty cycle would leave the fan unattended
+	restore := mode
+	if restore == ModeManual {
+		restore = ModeAuto
+	}
+
+	if err := sysfs.Write(ctx, p.attr("_enable"), ModeManual, sysfs.FormatInt[Mode]); err != nil {
+		return nil, err
+	}
+
+	m := &Manual{
+		ctx:     ctx,
+		pwm:     p,
+		restore: restore,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	activeMu.Lock()
+	active[m] = true
+	activeMu.Unlock()
+	if opts.RestoreOnSignal {
+		watchSignals()
+	}
+	go m.watch(opts)
+
+	return m, nil
+}
+
+var (
+	activeMu sync.Mutex
+	// active are the fans under manual control for RestoreAll
+	active = map[*Manual]bool{}
+)
+
+var restoreSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
+
+var (
+	signalMu sync.Mutex
+	// signalUsers counts manual controls with RestoreOnSignal, the handler
+	// is installed while there are any
+	signalUsers int
+	signalStop  chan struct{}
+)
+
+func watchSignals() {
+	signalMu.Lock()
+	defer signalMu.Unlock()
+
+	signalUsers++
+	if signalUsers > 1 {
+		return
+	}
+
+	ch := make(chan os.Signal, 1)
+	stop := make(chan struct{})
+	signalStop = stop
+	signal.Notify(ch, restoreSignals...)
+	go func() {
+		select {
+		case sig := <-ch:
+			// Without the handler the raised signal gets its default
+			// action, unless the process handles it as well
+			signal.Stop(ch)
+			RestoreAll()
+			syscall.Kill(os.Getpid(), sig.(syscall.Signal))
+		case <-stop:
+			signal.Stop(ch)
+		}
+	}()
+}
+
+func unwatchSignals() {
+	signalMu.Lock()
+	defer signalMu.Unlock()
+
+	signalUsers--
+	if signalUsers == 0 {
+		close(signalStop)
+	}
+}
+
+// RestoreAll closes every manual control of the process, for signal
+// handlers that don't keep track of them. It returns the first error.
+func RestoreAll() error {
+	activeMu.Lock()
+	ms := make([]*Manual, 0, len(active))
+	for m := range active {
+		ms = append(ms, m)
+	}
+	activeMu.Unlock()
+
+	var first error
+	for _, m := range ms {
+		if err := m.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Set sets the duty cycle from 0 to 255
+func (m *Manual) Set(value uint8) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		if m.err != nil {
+			return m.err
+		}
+		return errors.New("manual fan control is closed")
+	}
+
+	return sysfs.Write(m.ctx, m.pwm.attr(""), value, sysfs.FormatInt[uint8])
+}
+
+// Done is closed once the fan is back to automatic control
+func (m *Manual) Done() <-chan struct{} {
+	return m.done
+}
+
+// Err returns the *TripError that ended manual control or the failure to
+// revert it, if any
+func (m *Manual) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Close reverts the fan to the mode it had before taking over, automatic
+// if it was manual, and returns the error that ended manual control if any
+func (m *Manual) Close() error {
+	m.stopOnce.Do(func() { close(m.stop) })
+	<-m.done
+
+	return m.Err()
+}
+
+func (m *Manual) watch(opts ManualOptions) {
+	defer close(m.done)
+	defer func() {
+		activeMu.Lock()
+		delete(active, m)
+		activeMu.Unlock()
+		if opts.RestoreOnSignal {
+			unwatchSignals()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-m.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var tripped error
+	poll.Every(ctx, opts.Interval, func() bool {
+		tripped = check(opts.Interlocks)
+		return tripped == nil
+	})
+	m.revert(tripped)
+}
+
+// revert restores automatic control, falling back to full speed when the
+// driver rejects it. The canceled context of the caller must not prevent
+// the write, so a fresh one keeping dry-run mode is used.
+func (m *Manual) revert(cause error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	m.err = cause
+
+	ctx := withoutCancel(m.ctx)
+	enable := m.pwm.attr("_enable")
+	if err := sysfs.Write(ctx, enable, m.restore, sysfs.FormatInt[Mode]); err != nil {
+		if ferr := sysfs.Write(ctx, enable, ModeFull, sysfs.FormatInt[Mode]); ferr != nil && m.err == nil {
+			m.err = errors.Wrapf(ferr, "failed to revert %s/pwm%d", m.pwm.Chip, m.pwm.Index)
+		}
+	}
+}
+
+func check(interlocks []Interlock) error {
+	for _, i := range interlocks {
+		t, err := i.Sensor.Read()
+		if err != nil {
+			return &TripError{Sensor: i.Sensor, Max: i.Max, Err: err}
+		}
+		if t >= i.Max {
+			return &TripError{Sensor: i.Sensor, Temperature: t, Max: i.Max}
+		}
+	}
+	return nil
+}
+
+// detached keeps values of the parent context but is never canceled
+type detached struct {
+	context.Context
+	parent context.Context
+}
+
+func withoutCancel(ctx context.Context) context.Context {
+	return detached{Context: context.Background(), parent: ctx}
+}
+
+func (d detached) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
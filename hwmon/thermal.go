@@ -0,0 +1,47 @@
+package hwmon
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/alexdzyoba/sys/sysfs"
+	"github.com/pkg/errors"
+)
+
+const thermalRoot = "/sys/class/thermal"
+
+// Zone is a thermal zone of /sys/class/thermal like "thermal_zone0"
+type Zone struct {
+	Name string
+	// Type names the zone like "x86_pkg_temp" or "acpitz"
+	Type string
+}
+
+// Zones returns thermal zones of the system
+func Zones() ([]Zone, error) {
+	entries, err := ioutil.ReadDir(thermalRoot)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", thermalRoot)
+	}
+
+	var zs []Zone
+	for _, e := range entries {
+		// Cooling devices share the directory
+		if !strings.HasPrefix(e.Name(), "thermal_zone") {
+			continue
+		}
+		typ, _ := sysfs.Read(path.Join(thermalRoot, e.Name(), "type"), sysfs.String)
+		zs = append(zs, Zone{Name: e.Name(), Type: typ})
+	}
+	return zs, nil
+}
+
+// Read returns the temperature in Celsius
+func (z Zone) Read() (float64, error) {
+	return sysfs.Read(path.Join(thermalRoot, z.Name, "temp"), millidegrees)
+}
+
+func (z Zone) String() string {
+	return z.Name
+}
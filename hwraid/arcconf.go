@@ -0,0 +1,192 @@
+package hwraid
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ArcConf lists drives behind Microsemi Adaptec SmartRAID controllers with
+// arcconf
+type ArcConf struct {
+	// Path to arcconf binary
+	Path string
+	// Run overrides how the tool is executed
+	Run Runner
+}
+
+// Drives implements Controller
+func (a *ArcConf) Drives(ctx context.Context) ([]Drive, error) {
+	out, err := a.run(ctx, "list", "nologs")
+	if err != nil {
+		return nil, err
+	}
+
+	var n int
+	for _, f := range fields(out) {
+		if f.key == "Controllers found" {
+			n, err = strconv.Atoi(f.value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse controllers count %q", f.value)
+			}
+		}
+	}
+
+	var drives []Drive
+	for c := 1; c <= n; c++ {
+		ds, err := a.controllerDrives(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		drives = append(drives, ds...)
+	}
+
+	return drives, nil
+}
+
+func (a *ArcConf) controllerDrives(ctx context.Context, controller int) ([]Drive, error) {
+	id := strconv.Itoa(controller)
+
+	ld, err := a.run(ctx, "getconfig", id, "ld", "nologs")
+	if err != nil {
+		return nil, err
+	}
+
+	// Map member serials and locations to OS names of logical devices
+	members := map[string]string{}
+	var disk string
+	for _, f := range fields(ld) {
+		switch {
+		case f.key == "Disk Name":
+			// "/dev/sda (Disk0) (Bus: 1, Target: 0, Lun: 0)"
+			disk = path.Base(strings.Fields(f.value + " ")[0])
+		case strings.Contains(f.key, "Segment") && disk != "":
+			// Present (953869MB, SATA, HDD, Enclosure:0, Slot:0) SERIAL
+			// or "Connector:1, Device:2" for direct attached drives
+			i := strings.Index(f.value, "(")
+			j := strings.Index(f.value, ")")
+			if i < 0 || j < i {
+				continue
+			}
+			if serial := strings.TrimSpace(f.value[j+1:]); serial != "" {
+				members[serial] = disk
+			}
+			enc, slot := -1, -1
+			for _, part := range strings.Split(f.value[i+1:j], ",") {
+				part = strings.TrimSpace(part)
+				fmt.Sscanf(part, "Enclosure:%d", &enc)
+				fmt.Sscanf(part, "Slot:%d", &slot)
+				fmt.Sscanf(part, "Device:%d", &slot)
+			}
+			if slot >= 0 {
+				members[fmt.Sprintf("%d:%d", enc, slot)] = disk
+			}
+		}
+	}
+
+	pd, err := a.run(ctx, "getconfig", id, "pd", "nologs")
+	if err != nil {
+		return nil, err
+	}
+
+	var drives []Drive
+	var cur *Drive
+	for _, f := range fields(pd) {
+		if strings.HasPrefix(f.key, "Device #") {
+			drives = append(drives, Drive{Controller: controller, Enclosure: -1})
+			cur = &drives[len(drives)-1]
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		// Enclosure services and other devices are listed along drives
+		if strings.HasPrefix(f.key, "Device is a") && f.key != "Device is a Hard drive" {
+			drives = drives[:len(drives)-1]
+			cur = nil
+			continue
+		}
+
+		switch f.key {
+		case "State":
+			cur.State = f.value
+		case "Model":
+			cur.Model = f.value
+		case "Serial number":
+			cur.Serial = f.value
+		case "Reported Location":
+			// "Enclosure 0, Slot 1(Connector 0:CN0)" or
+			// "Connector 0, Device 1" for direct attached drives
+			if _, err := fmt.Sscanf(f.value, "Enclosure %d, Slot %d", &cur.Enclosure, &cur.Slot); err != nil {
+				fmt.Sscanf(f.value, "Connector %d, Device %d", new(int), &cur.Slot)
+			}
+		case "Total Size":
+			size, err := parseSize(f.value)
+			if err != nil {
+				return nil, err
+			}
+			cur.Size = size
+		case "Transfer Speed":
+			cur.Interface = strings.Fields(f.value + " ")[0]
+		case "SSD":
+			cur.Media = "HDD"
+			if f.value == "Yes" {
+				cur.Media = "SSD"
+			}
+		}
+	}
+
+	for i := range drives {
+		d := &drives[i]
+		if disk, ok := members[d.Serial]; ok && d.Serial != "" {
+			d.VirtualDisk = disk
+		} else {
+			d.VirtualDisk = members[fmt.Sprintf("%d:%d", d.Enclosure, d.Slot)]
+		}
+	}
+	sortDrives(drives)
+
+	return drives, nil
+}
+
+type field struct {
+	key, value string
+}
+
+// fields parses "key : value" lines of arcconf output. Lines without a
+// separator like "Device #0" are returned as keys with empty values.
+func fields(out []byte) []field {
+	var fs []field
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "---") {
+			continue
+		}
+
+		i := strings.Index(line, ": ")
+		if i < 0 {
+			fs = append(fs, field{key: strings.TrimSuffix(line, ":")})
+			continue
+		}
+		fs = append(fs, field{
+			key:   strings.TrimSpace(line[:i]),
+			value: strings.TrimSpace(line[i+2:]),
+		})
+	}
+	return fs
+}
+
+func (a *ArcConf) run(ctx context.Context, args ...string) ([]byte, error) {
+	run := a.Run
+	if run == nil {
+		run = execRunner
+	}
+	return run(ctx, a.Path, args...)
+}
@@ -0,0 +1,149 @@
+// Package hwraid enumerates physical drives hidden behind hardware RAID
+// controllers by running vendor management tools
+package hwraid
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Drive is a physical drive attached to a RAID controller
+type Drive struct {
+	Controller int
+	Enclosure  int
+	Slot       int
+	Model      string
+	Serial     string
+	Size       uint64
+	// Interface is SATA, SAS or NVMe
+	Interface string
+	// Media is HDD or SSD
+	Media string
+	// State is as reported by the controller like "Onln", "UGood" or
+	// "Optimal"
+	State string
+	// VirtualDisk is the OS block device name of the virtual disk the drive
+	// is a member of like "sda". It's empty for unconfigured drives and
+	// spares.
+	VirtualDisk string
+}
+
+// Controller lists drives behind a family of RAID controllers sorted by
+// controller, enclosure and slot
+type Controller interface {
+	Drives(ctx context.Context) ([]Drive, error)
+}
+
+// Runner runs a management tool and returns its standard output
+type Runner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+func execRunner(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return out, errors.Wrapf(err, "%s failed: %s", name, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+// Available returns controllers whose management tools are installed
+func Available() []Controller {
+	var cs []Controller
+	for _, name := range []string{"storcli64", "storcli", "perccli64", "perccli"} {
+		if p, err := exec.LookPath(name); err == nil {
+			cs = append(cs, &StorCLI{Path: p})
+			break
+		}
+	}
+	if p, err := exec.LookPath("arcconf"); err == nil {
+		cs = append(cs, &ArcConf{Path: p})
+	}
+	return cs
+}
+
+// Drives lists drives behind all controllers with installed tools. A host
+// without any tool has no hidden drives as far as we can tell.
+func Drives(ctx context.Context) ([]Drive, error) {
+	var drives []Drive
+	for _, c := range Available() {
+		ds, err := c.Drives(ctx)
+		if err != nil {
+			return nil, err
+		}
+		drives = append(drives, ds...)
+	}
+	sortDrives(drives)
+	return drives, nil
+}
+
+// sortDrives orders drives by their location, tools report them in no
+// particular order
+func sortDrives(drives []Drive) {
+	sort.Slice(drives, func(i, j int) bool {
+		a, b := drives[i], drives[j]
+		if a.Controller != b.Controller {
+			return a.Controller < b.Controller
+		}
+		if a.Enclosure != b.Enclosure {
+			return a.Enclosure < b.Enclosure
+		}
+		return a.Slot < b.Slot
+	})
+}
+
+// parseSize parses sizes like "1.818 TB" or "953869MB". Tools use binary
+// multiples despite the decimal unit names.
+func parseSize(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+
+	v, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse size %q", s)
+	}
+
+	var mult float64
+	switch strings.ToUpper(strings.TrimSpace(s[i:])) {
+	case "", "B":
+		mult = 1
+	case "KB", "KIB":
+		mult = 1 << 10
+	case "MB", "MIB":
+		mult = 1 << 20
+	case "GB", "GIB":
+		mult = 1 << 30
+	case "TB", "TIB":
+		mult = 1 << 40
+	case "PB", "PIB":
+		mult = 1 << 50
+	default:
+		return 0, errors.Errorf("unknown unit in size %q", s)
+	}
+
+	return uint64(v * mult), nil
+}
+
+// ByVirtualDisk groups drives by the OS block device of their virtual disk
+// so they can be presented alongside block.ListDevices. Unconfigured
+// drives are grouped under the empty name.
+func ByVirtualDisk(drives []Drive) map[string][]Drive {
+	m := map[string][]Drive{}
+	for _, d := range drives {
+		m[d.VirtualDisk] = append(m[d.VirtualDisk], d)
+	}
+	return m
+}
@@ -0,0 +1,147 @@
+package hwraid
+
+import (
+	"context"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// fixtures runs tools by reading their captured output from testdata
+func fixtures(t *testing.T, tool string, files map[string]string) Runner {
+	return func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		if name != tool {
+			t.Errorf("ran %s, want %s", name, tool)
+		}
+		f, ok := files[strings.Join(args, " ")]
+		if !ok {
+			return nil, errors.Errorf("unexpected arguments %q", args)
+		}
+		return os.ReadFile(path.Join("testdata", f))
+	}
+}
+
+// size is how tools compute sizes from their decimal output
+func size(v float64, unit uint) uint64 {
+	return uint64(v * float64(uint64(1)<<unit))
+}
+
+func TestStorCLIDrives(t *testing.T) {
+	s := &StorCLI{
+		Path: "storcli64",
+		Run: fixtures(t, "storcli64", map[string]string{
+			"/call/eall/sall show all J": "storcli-pds.json",
+			"/call/vall show all J":      "storcli-vds.json",
+		}),
+	}
+
+	want := []Drive{
+		{
+			Controller: 0, Enclosure: -1, Slot: 4, Model: "KPM5XRUG3T84", Serial: "X0B0A00BTB8F",
+			Size: size(3.492, 40), Interface: "SAS", Media: "SSD", State: "Onln", VirtualDisk: "sdb",
+		},
+		{
+			Controller: 0, Enclosure: 252, Slot: 0, Model: "ST2000NM0055-1V4104", Serial: "ZC20ABCD",
+			Size: size(1.818, 40), Interface: "SATA", Media: "HDD", State: "Onln", VirtualDisk: "sda",
+		},
+		{
+			Controller: 0, Enclosure: 252, Slot: 1, Model: "ST2000NM0055-1V4104", Serial: "ZC20ABCE",
+			Size: size(1.818, 40), Interface: "SATA", Media: "HDD", State: "Onln", VirtualDisk: "sda",
+		},
+		{
+			Controller: 0, Enclosure: 252, Slot: 2, Model: "SAMSUNG MZ7LH960HAJR-00005", Serial: "S45NNE0M123456",
+			Size: size(893.75, 30), Interface: "SATA", Media: "SSD", State: "UGood",
+		},
+		{
+			Controller: 1, Enclosure: 8, Slot: 0, Model: "ST1200MM0099",
+			Size: size(1.09, 40), Interface: "SAS", Media: "HDD", State: "UGood",
+		},
+	}
+
+	got, err := s.Drives(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Drives() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestStorCLIFailure(t *testing.T) {
+	s := &StorCLI{
+		Path: "storcli64",
+		Run: func(ctx context.Context, name string, args ...string) ([]byte, error) {
+			return []byte(`{"Controllers": [{"Command Status": {"Controller": 0, "Status": "Failure",
+				"Description": "Un-supported command"}}]}`), nil
+		},
+	}
+	if _, err := s.Drives(context.Background()); err == nil || !strings.Contains(err.Error(), "Un-supported command") {
+		t.Errorf("Drives() error = %v", err)
+	}
+}
+
+func TestArcConfDrives(t *testing.T) {
+	a := &ArcConf{
+		Path: "arcconf",
+		Run: fixtures(t, "arcconf", map[string]string{
+			"list nologs":           "arcconf-list.txt",
+			"getconfig 1 ld nologs": "arcconf-ld.txt",
+			"getconfig 1 pd nologs": "arcconf-pd.txt",
+		}),
+	}
+
+	want := []Drive{
+		{
+			Controller: 1, Enclosure: -1, Slot: 2, Model: "SAMSUNG MZ7KM480", Serial: "S2HSNX0H500999",
+			Size: 457862 << 20, Interface: "SATA", Media: "SSD", State: "Online", VirtualDisk: "sdb",
+		},
+		{
+			// The segment of this member has no serial, its location is used
+			Controller: 1, Enclosure: 0, Slot: 0, Model: "WDC WD1003FBYX-0", Serial: "WD-WCAW36391838",
+			Size: 953869 << 20, Interface: "SATA", Media: "HDD", State: "Online", VirtualDisk: "sda",
+		},
+		{
+			Controller: 1, Enclosure: 0, Slot: 1, Model: "WDC WD1003FBYX-0", Serial: "WD-WCAW36391999",
+			Size: 953869 << 20, Interface: "SATA", Media: "HDD", State: "Online", VirtualDisk: "sda",
+		},
+		{
+			Controller: 1, Enclosure: 0, Slot: 3, Model: "SAMSUNG MZ7KM480", Serial: "S2HSNX0H500123",
+			Size: 457862 << 20, Interface: "SATA", Media: "SSD", State: "Ready",
+		},
+	}
+
+	got, err := a.Drives(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Drives() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+		ok   bool
+	}{
+		{"953869MB", 953869 << 20, true},
+		{"953869 MB", 953869 << 20, true},
+		{"512", 512, true},
+		{"2 TiB", 2 << 40, true},
+		{"1.5 GB", 3 << 29, true},
+		{"12 parsecs", 0, false},
+		{"MB", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package hwraid
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// StorCLI lists drives behind Broadcom MegaRAID controllers with storcli.
+// Dell PERC controllers are handled the same way with perccli.
+type StorCLI struct {
+	// Path to storcli or perccli binary
+	Path string
+	// Run overrides how the tool is executed
+	Run Runner
+}
+
+type storcliOutput struct {
+	Controllers []struct {
+		CommandStatus struct {
+			Controller  int    `json:"Controller"`
+			Status      string `json:"Status"`
+			Description string `json:"Description"`
+		} `json:"Command Status"`
+		ResponseData map[string]json.RawMessage `json:"Response Data"`
+	} `json:"Controllers"`
+}
+
+type storcliDrive struct {
+	EIDSlt string      `json:"EID:Slt"`
+	State  string      `json:"State"`
+	DG     interface{} `json:"DG"`
+	Size   string      `json:"Size"`
+	Intf   string      `json:"Intf"`
+	Med    string      `json:"Med"`
+	Model  string      `json:"Model"`
+}
+
+type storcliDriveAttrs struct {
+	SN string `json:"SN"`
+}
+
+// Drives implements Controller
+func (s *StorCLI) Drives(ctx context.Context) ([]Drive, error) {
+	pds, err := s.run(ctx, "/call/eall/sall", "show", "all", "J")
+	if err != nil {
+		return nil, err
+	}
+	vds, err := s.run(ctx, "/call/vall", "show", "all", "J")
+	if err != nil {
+		return nil, err
+	}
+
+	// Map drive slots to OS names of virtual disks
+	members := map[string]string{}
+	for _, c := range vds.Controllers {
+		for key, raw := range c.ResponseData {
+			// "PDs for VD 0" lists members of "VD0 Properties"
+			if !strings.HasPrefix(key, "PDs for VD ") {
+				continue
+			}
+			vd := strings.TrimPrefix(key, "PDs for VD ")
+
+			var props struct {
+				OSDriveName string `json:"OS Drive Name"`
+			}
+			if p, ok := c.ResponseData["VD"+vd+" Properties"]; ok {
+				if err := json.Unmarshal(p, &props); err != nil {
+					return nil, errors.Wrapf(err, "failed to parse properties of VD %s", vd)
+				}
+			}
+
+			var drives []storcliDrive
+			if err := json.Unmarshal(raw, &drives); err != nil {
+				return nil, errors.Wrapf(err, "failed to parse members of VD %s", vd)
+			}
+			for _, d := range drives {
+				members[fmt.Sprintf("%d/%s", c.CommandStatus.Controller, d.EIDSlt)] = path.Base(props.OSDriveName)
+			}
+		}
+	}
+
+	var drives []Drive
+	for _, c := range pds.Controllers {
+		for key, raw := range c.ResponseData {
+			// Each drive is reported as "Drive /c0/e252/s0" with the
+			// details in "Drive /c0/e252/s0 - Detailed Information"
+			if !strings.HasPrefix(key, "Drive /") || strings.Contains(key, " - ") {
+				continue
+			}
+
+			var ds []storcliDrive
+			if err := json.Unmarshal(raw, &ds); err != nil {
+				return nil, errors.Wrapf(err, "failed to parse %s", key)
+			}
+
+			var attrs storcliDriveAttrs
+			if details, ok := c.ResponseData[key+" - Detailed Information"]; ok {
+				var info map[string]json.RawMessage
+				if err := json.Unmarshal(details, &info); err != nil {
+					return nil, errors.Wrapf(err, "failed to parse details of %s", key)
+				}
+				if a, ok := info[key+" Device attributes"]; ok {
+					if err := json.Unmarshal(a, &attrs); err != nil {
+						return nil, errors.Wrapf(err, "failed to parse attributes of %s", key)
+					}
+				}
+			}
+
+			for _, d := range ds {
+				drive, err := d.drive(c.CommandStatus.Controller)
+				if err != nil {
+					return nil, err
+				}
+				drive.Serial = strings.TrimSpace(attrs.SN)
+				drive.VirtualDisk = members[fmt.Sprintf("%d/%s", drive.Controller, d.EIDSlt)]
+				drives = append(drives, drive)
+			}
+		}
+	}
+	sortDrives(drives)
+
+	return drives, nil
+}
+
+func (d storcliDrive) drive(controller int) (Drive, error) {
+	drive := Drive{
+		Controller: controller,
+		Model:      strings.TrimSpace(d.Model),
+		Interface:  d.Intf,
+		Media:      d.Med,
+		State:      d.State,
+	}
+
+	// Enclosure is empty for drives attached directly like " :0"
+	eid := -1
+	if _, err := fmt.Sscanf(strings.Replace(d.EIDSlt, " ", "-1", 1), "%d:%d", &eid, &drive.Slot); err != nil {
+		return drive, errors.Wrapf(err, "failed to parse slot %q", d.EIDSlt)
+	}
+	drive.Enclosure = eid
+
+	size, err := parseSize(d.Size)
+	if err != nil {
+		return drive, err
+	}
+	drive.Size = size
+
+	return drive, nil
+}
+
+func (s *StorCLI) run(ctx context.Context, args ...string) (*storcliOutput, error) {
+	run := s.Run
+	if run == nil {
+		run = execRunner
+	}
+
+	out, err := run(ctx, s.Path, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var o storcliOutput
+	if err := json.Unmarshal(out, &o); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s output", s.Path)
+	}
+	for _, c := range o.Controllers {
+		// Controllers without virtual disks can't be queried for them
+		noVDs := strings.Contains(strings.ToLower(c.CommandStatus.Description), "no vd")
+		if c.CommandStatus.Status != "Success" && !noVDs {
+			return nil, errors.Errorf("%s failed on controller %d: %s",
+				s.Path, c.CommandStatus.Controller, c.CommandStatus.Description)
+		}
+	}
+
+	return &o, nil
+}
@@ -0,0 +1,146 @@
+//go:build bpf
+// +build bpf
+
+package bpf
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// insn mirrors struct bpf_insn
+type insn struct {
+	code uint8
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+// Registers
+const (
+	R0 uint8 = iota
+	R1
+	R2
+	R3
+	R4
+	R5
+	R6
+	R7
+	R8
+	R9
+	R10
+)
+
+// Opcodes used by the programs
+const (
+	OpLdDW   = 0x18 // lddw dst, imm64
+	OpLdxB   = 0x71 // dst = *(u8 *)(src + off)
+	OpLdxW   = 0x61 // dst = *(u32 *)(src + off)
+	OpLdxDW  = 0x79 // dst = *(u64 *)(src + off)
+	OpStW    = 0x62 // *(u32 *)(dst + off) = imm
+	OpStDW   = 0x7a // *(u64 *)(dst + off) = imm
+	OpStxW   = 0x63 // *(u32 *)(dst + off) = src
+	OpStxDW  = 0x7b // *(u64 *)(dst + off) = src
+	OpXaddDW = 0xdb // lock *(u64 *)(dst + off) += src
+	OpAddImm = 0x07
+	OpSubReg = 0x1f
+	OpMulImm = 0x27
+	OpDivImm = 0x37
+	OpLshImm = 0x67
+	OpRshImm = 0x77
+	OpMovImm = 0xb7
+	OpMovReg = 0xbf
+	OpJa     = 0x05
+	OpJeqImm = 0x15
+	OpJneImm = 0x55
+	OpCall   = 0x85
+	OpExit   = 0x95
+
+	pseudoMapFd = 1
+)
+
+// Helper functions
+const (
+	FnMapLookupElem     = 1
+	FnMapUpdateElem     = 2
+	FnMapDeleteElem     = 3
+	FnKtimeGetNs        = 5
+	FnGetCurrentPidTgid = 14
+	FnGetCurrentComm    = 16
+)
+
+// Map update flags
+const (
+	Any     = 0
+	NoExist = 1
+)
+
+// Asm assembles a program resolving jumps to labels
+type Asm struct {
+	insns  []insn
+	labels map[string]int
+	jumps  map[int]string
+}
+
+// NewAsm creates an empty program
+func NewAsm() *Asm {
+	return &Asm{labels: map[string]int{}, jumps: map[int]string{}}
+}
+
+// Emit appends an instruction
+func (a *Asm) Emit(code, dst, src uint8, off int16, imm int32) {
+	a.insns = append(a.insns, insn{code: code, regs: dst | src<<4, off: off, imm: imm})
+}
+
+// Label marks the position of the next instruction
+func (a *Asm) Label(name string) {
+	a.labels[name] = len(a.insns)
+}
+
+// Jump emits a jump instruction with the offset to the label filled later
+func (a *Asm) Jump(code, dst uint8, imm int32, label string) {
+	a.jumps[len(a.insns)] = label
+	a.Emit(code, dst, 0, 0, imm)
+}
+
+// LoadMap loads the map file descriptor into the register, it takes two
+// instruction slots
+func (a *Asm) LoadMap(dst uint8, fd int) {
+	a.Emit(OpLdDW, dst, pseudoMapFd, 0, int32(fd))
+	a.Emit(0, 0, 0, 0, 0)
+}
+
+// Call calls a helper function
+func (a *Asm) Call(fn int32) {
+	a.Emit(OpCall, 0, 0, 0, fn)
+}
+
+// StackPtr sets the register to point at the stack offset
+func (a *Asm) StackPtr(dst uint8, off int32) {
+	a.Emit(OpMovReg, dst, R10, 0, 0)
+	a.Emit(OpAddImm, dst, 0, 0, off)
+}
+
+// Return exits with zero
+func (a *Asm) Return() {
+	a.Emit(OpMovImm, R0, 0, 0, 0)
+	a.Emit(OpExit, 0, 0, 0, 0)
+}
+
+func (a *Asm) assemble() ([]byte, error) {
+	for i, label := range a.jumps {
+		target, ok := a.labels[label]
+		if !ok {
+			return nil, errors.Errorf("undefined label %s", label)
+		}
+		a.insns[i].off = int16(target - i - 1)
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, a.insns); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
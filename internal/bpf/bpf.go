@@ -0,0 +1,335 @@
+//go:build bpf
+// +build bpf
+
+package bpf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	bpfMapCreate     = 0
+	bpfMapLookupElem = 1
+	bpfMapGetNextKey = 4
+	bpfProgLoad      = 5
+
+	bpfMapTypeHash        = 1
+	bpfMapTypeLRUHash     = 9
+	bpfProgTypeTracepoint = 5
+
+	perfTypeTracepoint = 2
+	perfFlagCloexec    = 1 << 3
+
+	perfEventIocEnable = 0x2400
+	perfEventIocSetBPF = 0x40042408
+
+	logSize = 1 << 16
+)
+
+var tracefsRoots = []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"}
+
+// mapCreateAttr mirrors the BPF_MAP_CREATE part of union bpf_attr
+type mapCreateAttr struct {
+	mapType    uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	mapFlags   uint32
+}
+
+// mapElemAttr mirrors the BPF_MAP_*_ELEM part of union bpf_attr
+type mapElemAttr struct {
+	mapFd uint32
+	_     uint32
+	key   uint64
+	value uint64
+	flags uint64
+}
+
+// progLoadAttr mirrors the BPF_PROG_LOAD part of union bpf_attr
+type progLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+}
+
+// perfEventAttr mirrors struct perf_event_attr up to PERF_ATTR_SIZE_VER0
+type perfEventAttr struct {
+	typ          uint32
+	size         uint32
+	config       uint64
+	samplePeriod uint64
+	sampleType   uint64
+	readFormat   uint64
+	flags        uint64
+	wakeupEvents uint32
+	bpType       uint32
+	config1      uint64
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	if !haveBPF {
+		return -1, errors.New("bpf is not supported on this architecture")
+	}
+	r, _, errno := syscall.Syscall(sysBPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(r), nil
+}
+
+// CreateMap creates a hash map. Updates adding keys fail once it holds
+// maxEntries.
+func CreateMap(keySize, valueSize, maxEntries uint32) (int, error) {
+	return createMap(bpfMapTypeHash, keySize, valueSize, maxEntries)
+}
+
+// CreateLRUMap creates a hash map evicting the least recently used keys
+// once it holds maxEntries, for state that may never be deleted
+func CreateLRUMap(keySize, valueSize, maxEntries uint32) (int, error) {
+	return createMap(bpfMapTypeLRUHash, keySize, valueSize, maxEntries)
+}
+
+func createMap(typ, keySize, valueSize, maxEntries uint32) (int, error) {
+	attr := mapCreateAttr{
+		mapType:    typ,
+		keySize:    keySize,
+		valueSize:  valueSize,
+		maxEntries: maxEntries,
+	}
+	fd, err := bpf(bpfMapCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return -1, errors.Wrap(err, "failed to create bpf map")
+	}
+	return fd, nil
+}
+
+// Lookup copies the value of the key to value
+func Lookup(fd int, key, value unsafe.Pointer) error {
+	attr := mapElemAttr{mapFd: uint32(fd), key: uint64(uintptr(key)), value: uint64(uintptr(value))}
+	_, err := bpf(bpfMapLookupElem, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
+
+// NextKey stores the key following key in next and returns false when
+// there are no more keys. A nil key starts the iteration.
+func NextKey(fd int, key, next unsafe.Pointer) (bool, error) {
+	attr := mapElemAttr{mapFd: uint32(fd), key: uint64(uintptr(key)), value: uint64(uintptr(next))}
+	_, err := bpf(bpfMapGetNextKey, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err == syscall.ENOENT {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Load loads a tracepoint program and returns its file descriptor
+func Load(a *Asm) (int, error) {
+	code, err := a.assemble()
+	if err != nil {
+		return -1, err
+	}
+
+	license := []byte("GPL\x00")
+	log := make([]byte, logSize)
+	attr := progLoadAttr{
+		progType: bpfProgTypeTracepoint,
+		insnCnt:  uint32(len(a.insns)),
+		insns:    uint64(uintptr(unsafe.Pointer(&code[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(log)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&log[0]))),
+	}
+
+	fd, err := bpf(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		msg := strings.TrimSpace(strings.TrimRight(string(log), "\x00"))
+		return -1, errors.Wrapf(err, "failed to load bpf program: %s", msg)
+	}
+	return fd, nil
+}
+
+// Attach attaches the program to the tracepoint and returns the perf event
+// file descriptor keeping it attached
+func Attach(prog int, category, name string) (int, error) {
+	dir, err := tracepointDir(category, name)
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := readInt(path.Join(dir, "id"))
+	if err != nil {
+		return -1, err
+	}
+
+	attr := perfEventAttr{
+		typ:          perfTypeTracepoint,
+		config:       uint64(id),
+		samplePeriod: 1,
+		wakeupEvents: 1,
+	}
+	attr.size = uint32(unsafe.Sizeof(attr))
+
+	// Programs on tracepoints run for all CPUs whichever one the event is
+	// opened on, so any process on CPU 0 will do
+	r, _, errno := syscall.Syscall6(syscall.SYS_PERF_EVENT_OPEN,
+		uintptr(unsafe.Pointer(&attr)), ^uintptr(0), 0, ^uintptr(0), perfFlagCloexec, 0)
+	if errno != 0 {
+		return -1, errors.Wrapf(errno, "failed to open %s:%s perf event", category, name)
+	}
+	fd := int(r)
+
+	for _, req := range []uintptr{perfEventIocSetBPF, perfEventIocEnable} {
+		arg := uintptr(0)
+		if req == perfEventIocSetBPF {
+			arg = uintptr(prog)
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, arg); errno != 0 {
+			syscall.Close(fd)
+			return -1, errors.Wrapf(errno, "failed to attach to %s:%s", category, name)
+		}
+	}
+
+	return fd, nil
+}
+
+func tracepointDir(category, name string) (string, error) {
+	for _, root := range tracefsRoots {
+		dir := path.Join(root, "events", category, name)
+		if _, err := os.Stat(dir); err == nil {
+			return dir, nil
+		}
+	}
+	return "", errors.Errorf("tracepoint %s:%s not found, is tracefs mounted?", category, name)
+}
+
+// FieldOffset returns the offset of the field in the tracepoint record
+// from its format file, so programs adapt to the running kernel layout
+func FieldOffset(category, name, field string) (int16, error) {
+	dir, err := tracepointDir(category, name)
+	if err != nil {
+		return 0, err
+	}
+
+	p := path.Join(dir, "format")
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to read %v", p)
+	}
+
+	// field:dev_t dev;	offset:8;	size:4;	signed:0;
+	for _, line := range strings.Split(string(content), "\n") {
+		parts := strings.Split(strings.TrimSpace(line), ";")
+		if len(parts) < 2 || !strings.HasPrefix(parts[0], "field:") {
+			continue
+		}
+		// Arrays are declared like "char rwbs[8]"
+		decl := strings.Fields(parts[0])
+		name := decl[len(decl)-1]
+		if i := strings.Index(name, "["); i >= 0 {
+			name = name[:i]
+		}
+		if name != field {
+			continue
+		}
+
+		var off int16
+		if _, err := fmt.Sscanf(strings.TrimSpace(parts[1]), "offset:%d", &off); err != nil {
+			return 0, errors.Wrapf(err, "failed to parse offset of %s in %v", field, p)
+		}
+		return off, nil
+	}
+
+	return 0, errors.Errorf("field %s not found in %v", field, p)
+}
+
+func readInt(p string) (int, error) {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to read %v", p)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %v", p)
+	}
+	return n, nil
+}
+
+// Collector holds maps, programs and attachments until closed
+type Collector struct {
+	maps   []int
+	progs  []int
+	events []int
+}
+
+// CreateMap creates a hash map owned by the collector
+func (c *Collector) CreateMap(keySize, valueSize, maxEntries uint32) (int, error) {
+	return c.own(CreateMap(keySize, valueSize, maxEntries))
+}
+
+// CreateLRUMap creates an LRU hash map owned by the collector
+func (c *Collector) CreateLRUMap(keySize, valueSize, maxEntries uint32) (int, error) {
+	return c.own(CreateLRUMap(keySize, valueSize, maxEntries))
+}
+
+func (c *Collector) own(fd int, err error) (int, error) {
+	if err != nil {
+		return -1, err
+	}
+	c.maps = append(c.maps, fd)
+	return fd, nil
+}
+
+// Attach loads the program and attaches it to the tracepoint
+func (c *Collector) Attach(a *Asm, category, name string) error {
+	prog, err := Load(a)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load %s:%s program", category, name)
+	}
+	c.progs = append(c.progs, prog)
+
+	ev, err := Attach(prog, category, name)
+	if err != nil {
+		return err
+	}
+	c.events = append(c.events, ev)
+	return nil
+}
+
+// Close detaches the programs and releases the maps
+func (c *Collector) Close() error {
+	for _, fds := range [][]int{c.events, c.progs, c.maps} {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+	}
+	c.events, c.progs, c.maps = nil, nil, nil
+	return nil
+}
+
+// DeviceName returns the kernel name of a block device given its kernel
+// dev_t as seen by tracepoints, empty if the device is gone
+func DeviceName(dev uint32) (major, minor uint32, name string) {
+	// Kernel dev_t has 12 bits of major and 20 bits of minor
+	major, minor = dev>>20, dev&0xfffff
+	link, err := os.Readlink(fmt.Sprintf("/sys/dev/block/%d:%d", major, minor))
+	if err != nil {
+		return major, minor, ""
+	}
+	return major, minor, path.Base(link)
+}
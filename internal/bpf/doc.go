@@ -0,0 +1,3 @@
+// Package bpf assembles and loads eBPF programs on tracepoints and reads
+// their hash maps. It's only built with the "bpf" build tag.
+package bpf
@@ -0,0 +1,9 @@
+//go:build bpf
+// +build bpf
+
+package bpf
+
+const (
+	sysBPF  = 321
+	haveBPF = true
+)
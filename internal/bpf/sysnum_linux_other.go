@@ -0,0 +1,10 @@
+//go:build linux && bpf && !amd64 && !arm64
+// +build linux,bpf,!amd64,!arm64
+
+package bpf
+
+// bpf syscall is not wired up for this architecture, loading programs fails
+const (
+	sysBPF  = 0
+	haveBPF = false
+)
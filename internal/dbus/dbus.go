@@ -0,0 +1,265 @@
+// Package dbus is a minimal D-Bus client calling methods of system services
+// over unix sockets. Values are mapped to Go like this: 'y' byte, 'b' bool,
+// 'n' int16, 'q' uint16, 'i' int32, 'u' uint32, 'x' int64, 't' uint64, 'd'
+// float64, 's' string, 'o' ObjectPath, 'g' Signature, 'v' Variant. Arrays,
+// structs and dict entries are []interface{}, encoding also takes []string
+// for arrays of strings. Signals and unix fds aren't supported.
+package dbus
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	dialTimeout = 10 * time.Second
+	// maxMessage is the limit of the specification
+	maxMessage = 128 << 20
+)
+
+// Error is an error reply of a method call
+type Error struct {
+	Name    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return e.Name + ": " + e.Message
+}
+
+// Conn is an authenticated connection. Calls are serialized, Conn is safe
+// for concurrent use.
+type Conn struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	serial uint32
+	// err breaks the connection after a message was partially transferred
+	err error
+}
+
+// Dial connects to the unix socket authenticating with the uid of the
+// process. bus says the socket belongs to a message bus, which needs a
+// Hello call, rather than to a service accepting direct connections.
+func Dial(socket string, bus bool) (*Conn, error) {
+	nc, err := net.DialTimeout("unix", socket, dialTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to %v", socket)
+	}
+	c := &Conn{conn: nc, r: bufio.NewReader(nc)}
+
+	nc.SetDeadline(time.Now().Add(dialTimeout))
+	if err := c.auth(); err != nil {
+		nc.Close()
+		return nil, errors.Wrapf(err, "failed to authenticate to %v", socket)
+	}
+	nc.SetDeadline(time.Time{})
+
+	if bus {
+		ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+		defer cancel()
+		if _, err := c.Call(ctx, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello", ""); err != nil {
+			nc.Close()
+			return nil, errors.Wrapf(err, "failed to register on %v", socket)
+		}
+	}
+
+	return c, nil
+}
+
+// auth runs the EXTERNAL mechanism, the server checks the uid against the
+// socket credentials
+func (c *Conn) auth() error {
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := fmt.Fprintf(c.conn, "\x00AUTH EXTERNAL %s\r\n", uid); err != nil {
+		return err
+	}
+
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return errors.Errorf("server rejected authentication: %s", strings.TrimSpace(line))
+	}
+
+	_, err = io.WriteString(c.conn, "BEGIN\r\n")
+	return err
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Call invokes the method and returns the reply body. dest is the bus
+// name of the service, it's ignored by services connected directly.
+// Error replies are returned as *Error.
+func (c *Conn) Call(ctx context.Context, dest string, path ObjectPath, iface, member, sig string, args ...interface{}) ([]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	c.serial++
+	fields := map[byte]Variant{
+		fieldPath:   {Sig: "o", Value: path},
+		fieldMember: {Sig: "s", Value: member},
+	}
+	if iface != "" {
+		fields[fieldInterface] = Variant{Sig: "s", Value: iface}
+	}
+	if dest != "" {
+		fields[fieldDestination] = Variant{Sig: "s", Value: dest}
+	}
+	msg, err := encodeMessage(typeMethodCall, c.serial, fields, sig, args)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode %s.%s call", iface, member)
+	}
+
+	deadline, _ := ctx.Deadline()
+	c.conn.SetDeadline(deadline)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			// Unblocks the read or write in progress
+			c.conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	// The deadline is only touched while the call holds the connection
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	reply, intact, err := c.roundTrip(msg, c.serial)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if !intact {
+			c.err = errors.Wrap(err, "D-Bus connection is broken")
+		}
+		return nil, errors.Wrapf(err, "failed to call %s.%s", iface, member)
+	}
+
+	if reply.typ == typeError {
+		e := &Error{}
+		e.Name, _ = reply.fields[fieldErrorName].(string)
+		if len(reply.body) > 0 {
+			e.Message, _ = reply.body[0].(string)
+		}
+		return nil, e
+	}
+	return reply.body, nil
+}
+
+// roundTrip sends the message and reads until its reply, skipping signals
+// and other messages. On errors intact tells whether the stream is still at
+// a message boundary: a call timing out before it was sent or while waiting
+// for the reply leaves the connection usable, its late reply is skipped
+// by the next call.
+func (c *Conn) roundTrip(msg []byte, serial uint32) (*message, bool, error) {
+	if n, err := c.conn.Write(msg); err != nil {
+		return nil, n == 0 && isTimeout(err), err
+	}
+
+	for {
+		// Waiting for a message consumes nothing
+		if _, err := c.r.Peek(1); err != nil {
+			return nil, isTimeout(err), err
+		}
+		m, err := c.read()
+		if err != nil {
+			return nil, false, err
+		}
+		if m.typ != typeMethodReturn && m.typ != typeError {
+			continue
+		}
+		if rs, _ := m.fields[fieldReplySerial].(uint32); rs == serial {
+			return m, true, nil
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// read reads and decodes one message
+func (c *Conn) read() (*message, error) {
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(c.r, head); err != nil {
+		return nil, err
+	}
+
+	var order binary.ByteOrder
+	switch head[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, errors.Errorf("message has bad endianness %q", head[0])
+	}
+	bodyLen, fieldsLen := order.Uint32(head[4:]), order.Uint32(head[12:])
+	if uint64(bodyLen)+uint64(fieldsLen) > maxMessage {
+		return nil, errors.Errorf("message of %d bytes is too long", uint64(bodyLen)+uint64(fieldsLen))
+	}
+
+	bodyAt := (16 + int(fieldsLen) + 7) &^ 7
+	buf := make([]byte, bodyAt+int(bodyLen))
+	copy(buf, head)
+	if _, err := io.ReadFull(c.r, buf[16:]); err != nil {
+		return nil, err
+	}
+
+	m := &message{typ: head[1], serial: order.Uint32(head[8:]), fields: map[byte]interface{}{}}
+
+	d := &decoder{buf: buf[:16+fieldsLen], pos: 12, order: order}
+	hf, err := d.decode("a(yv)", 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode message header")
+	}
+	for _, f := range hf.([]interface{}) {
+		f := f.([]interface{})
+		m.fields[f[0].(byte)] = f[1].(Variant).Value
+	}
+
+	sig, _ := m.fields[fieldSignature].(Signature)
+	types, err := split(string(sig))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode message body")
+	}
+	d = &decoder{buf: buf[bodyAt:], order: order}
+	for _, t := range types {
+		v, err := d.decode(t, 0)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode message body")
+		}
+		m.body = append(m.body, v)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,472 @@
+package dbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// ObjectPath is a value of type 'o'
+type ObjectPath string
+
+// Signature is a value of type 'g'
+type Signature string
+
+// Variant is a value of type 'v' carrying its own signature
+type Variant struct {
+	Sig   Signature
+	Value interface{}
+}
+
+// Message types
+const (
+	typeMethodCall   = 1
+	typeMethodReturn = 2
+	typeError        = 3
+)
+
+// Header field codes
+const (
+	fieldPath        = 1
+	fieldInterface   = 2
+	fieldMember      = 3
+	fieldErrorName   = 4
+	fieldReplySerial = 5
+	fieldDestination = 6
+	fieldSignature   = 8
+)
+
+// message is a decoded message
+type message struct {
+	typ    byte
+	serial uint32
+	fields map[byte]interface{}
+	body   []interface{}
+}
+
+// nextType returns the end of the single complete type starting at i
+func nextType(sig string, i int) (int, error) {
+	if i >= len(sig) {
+		return 0, fmt.Errorf("signature %q is truncated", sig)
+	}
+	switch sig[i] {
+	case 'y', 'b', 'n', 'q', 'i', 'u', 'x', 't', 'd', 's', 'o', 'g', 'v':
+		return i + 1, nil
+	case 'a':
+		return nextType(sig, i+1)
+	case '(', '{':
+		end := byte(')')
+		if sig[i] == '{' {
+			end = '}'
+		}
+		j := i + 1
+		for j < len(sig) && sig[j] != end {
+			var err error
+			if j, err = nextType(sig, j); err != nil {
+				return 0, err
+			}
+		}
+		if j >= len(sig) || j == i+1 {
+			return 0, fmt.Errorf("signature %q has a bad container", sig)
+		}
+		return j + 1, nil
+	default:
+		return 0, fmt.Errorf("signature %q has unsupported type %q", sig, sig[i])
+	}
+}
+
+// split returns the complete types of the signature
+func split(sig string) ([]string, error) {
+	var types []string
+	for i := 0; i < len(sig); {
+		j, err := nextType(sig, i)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, sig[i:j])
+		i = j
+	}
+	return types, nil
+}
+
+func alignment(t byte) int {
+	switch t {
+	case 'n', 'q':
+		return 2
+	case 'b', 'i', 'u', 's', 'o', 'a':
+		return 4
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	default:
+		return 1
+	}
+}
+
+// encoder marshals little endian messages
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) put(n int, v uint64) {
+	e.align(n)
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	e.buf = append(e.buf, b[:n]...)
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.put(4, uint64(v))
+}
+
+// encode appends the value of the single complete type
+func (e *encoder) encode(sig string, v interface{}) error {
+	bad := func() error { return fmt.Errorf("can't encode %T as %q", v, sig) }
+
+	switch sig[0] {
+	case 'y':
+		b, ok := v.(byte)
+		if !ok {
+			return bad()
+		}
+		e.buf = append(e.buf, b)
+	case 'b':
+		b, ok := v.(bool)
+		if !ok {
+			return bad()
+		}
+		var u uint32
+		if b {
+			u = 1
+		}
+		e.uint32(u)
+	case 'n', 'q':
+		var u uint16
+		switch x := v.(type) {
+		case int16:
+			u = uint16(x)
+		case uint16:
+			u = x
+		default:
+			return bad()
+		}
+		e.put(2, uint64(u))
+	case 'i', 'u':
+		var u uint32
+		switch x := v.(type) {
+		case int32:
+			u = uint32(x)
+		case uint32:
+			u = x
+		default:
+			return bad()
+		}
+		e.uint32(u)
+	case 'x', 't', 'd':
+		var u uint64
+		switch x := v.(type) {
+		case int64:
+			u = uint64(x)
+		case uint64:
+			u = x
+		case float64:
+			u = math.Float64bits(x)
+		default:
+			return bad()
+		}
+		e.put(8, u)
+	case 's', 'o':
+		var s string
+		switch x := v.(type) {
+		case string:
+			s = x
+		case ObjectPath:
+			s = string(x)
+		default:
+			return bad()
+		}
+		e.uint32(uint32(len(s)))
+		e.buf = append(append(e.buf, s...), 0)
+	case 'g':
+		var s string
+		switch x := v.(type) {
+		case string:
+			s = x
+		case Signature:
+			s = string(x)
+		default:
+			return bad()
+		}
+		e.buf = append(append(append(e.buf, byte(len(s))), s...), 0)
+	case 'v':
+		x, ok := v.(Variant)
+		if !ok {
+			return bad()
+		}
+		if end, err := nextType(string(x.Sig), 0); err != nil || end != len(x.Sig) {
+			return fmt.Errorf("variant signature %q isn't a single type", x.Sig)
+		}
+		e.buf = append(append(append(e.buf, byte(len(x.Sig))), x.Sig...), 0)
+		return e.encode(string(x.Sig), x.Value)
+	case 'a':
+		var elems []interface{}
+		switch x := v.(type) {
+		case []interface{}:
+			elems = x
+		case []string:
+			for _, s := range x {
+				elems = append(elems, s)
+			}
+		default:
+			return bad()
+		}
+		e.uint32(0)
+		lenAt := len(e.buf) - 4
+		e.align(alignment(sig[1]))
+		start := len(e.buf)
+		for _, elem := range elems {
+			if err := e.encode(sig[1:], elem); err != nil {
+				return err
+			}
+		}
+		binary.LittleEndian.PutUint32(e.buf[lenAt:], uint32(len(e.buf)-start))
+	case '(', '{':
+		fields, ok := v.([]interface{})
+		if !ok {
+			return bad()
+		}
+		types, err := split(sig[1 : len(sig)-1])
+		if err != nil {
+			return err
+		}
+		if len(types) != len(fields) {
+			return fmt.Errorf("%q needs %d fields, got %d", sig, len(types), len(fields))
+		}
+		e.align(8)
+		for i, t := range types {
+			if err := e.encode(t, fields[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		return bad()
+	}
+	return nil
+}
+
+// decoder unmarshals values, offsets are relative to the message start
+// alignment is computed from
+type decoder struct {
+	buf   []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+func (d *decoder) align(n int) error {
+	for d.pos%n != 0 {
+		d.pos++
+	}
+	if d.pos > len(d.buf) {
+		return fmt.Errorf("message is truncated")
+	}
+	return nil
+}
+
+func (d *decoder) take(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.buf) {
+		return nil, fmt.Errorf("message is truncated")
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	if err := d.align(4); err != nil {
+		return 0, err
+	}
+	b, err := d.take(4)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint32(b), nil
+}
+
+func (d *decoder) string() (string, error) {
+	n, err := d.uint32()
+	if err != nil {
+		return "", err
+	}
+	b, err := d.take(int(n) + 1)
+	if err != nil {
+		return "", err
+	}
+	return string(b[:n]), nil
+}
+
+func (d *decoder) signature() (string, error) {
+	n, err := d.take(1)
+	if err != nil {
+		return "", err
+	}
+	b, err := d.take(int(n[0]) + 1)
+	if err != nil {
+		return "", err
+	}
+	return string(b[:n[0]]), nil
+}
+
+// decode reads the value of the single complete type, depth limits
+// nesting of hostile messages
+func (d *decoder) decode(sig string, depth int) (interface{}, error) {
+	if depth > 64 {
+		return nil, fmt.Errorf("message is nested too deeply")
+	}
+
+	switch sig[0] {
+	case 'y':
+		b, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case 'b':
+		u, err := d.uint32()
+		return u != 0, err
+	case 'n', 'q':
+		if err := d.align(2); err != nil {
+			return nil, err
+		}
+		b, err := d.take(2)
+		if err != nil {
+			return nil, err
+		}
+		if sig[0] == 'n' {
+			return int16(d.order.Uint16(b)), nil
+		}
+		return d.order.Uint16(b), nil
+	case 'i':
+		u, err := d.uint32()
+		return int32(u), err
+	case 'u':
+		return d.uint32()
+	case 'x', 't', 'd':
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		u := d.order.Uint64(b)
+		switch sig[0] {
+		case 'x':
+			return int64(u), nil
+		case 'd':
+			return math.Float64frombits(u), nil
+		}
+		return u, nil
+	case 's':
+		return d.string()
+	case 'o':
+		s, err := d.string()
+		return ObjectPath(s), err
+	case 'g':
+		s, err := d.signature()
+		return Signature(s), err
+	case 'v':
+		s, err := d.signature()
+		if err != nil {
+			return nil, err
+		}
+		if end, err := nextType(s, 0); err != nil || end != len(s) {
+			return nil, fmt.Errorf("variant signature %q isn't a single type", s)
+		}
+		v, err := d.decode(s, depth+1)
+		return Variant{Sig: Signature(s), Value: v}, err
+	case 'a':
+		n, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		if err := d.align(alignment(sig[1])); err != nil {
+			return nil, err
+		}
+		end := d.pos + int(n)
+		if end > len(d.buf) {
+			return nil, fmt.Errorf("message is truncated")
+		}
+		elems := []interface{}{}
+		for d.pos < end {
+			v, err := d.decode(sig[1:], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, v)
+		}
+		if d.pos != end {
+			return nil, fmt.Errorf("array overruns its length")
+		}
+		return elems, nil
+	case '(', '{':
+		types, err := split(sig[1 : len(sig)-1])
+		if err != nil {
+			return nil, err
+		}
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+		fields := make([]interface{}, 0, len(types))
+		for _, t := range types {
+			v, err := d.decode(t, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, v)
+		}
+		return fields, nil
+	}
+	return nil, fmt.Errorf("unsupported type %q", sig)
+}
+
+// encodeMessage marshals a message with the header fields and body
+func encodeMessage(typ byte, serial uint32, fields map[byte]Variant, sig string, args []interface{}) ([]byte, error) {
+	types, err := split(sig)
+	if err != nil {
+		return nil, err
+	}
+	if len(types) != len(args) {
+		return nil, fmt.Errorf("signature %q needs %d arguments, got %d", sig, len(types), len(args))
+	}
+	body := &encoder{}
+	for i, t := range types {
+		if err := body.encode(t, args[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	if sig != "" {
+		fields[fieldSignature] = Variant{Sig: "g", Value: Signature(sig)}
+	}
+	var hf []interface{}
+	for code := byte(1); code <= fieldSignature; code++ {
+		if v, ok := fields[code]; ok {
+			hf = append(hf, []interface{}{code, v})
+		}
+	}
+
+	h := &encoder{buf: []byte{'l', typ, 0, 1}}
+	h.uint32(uint32(len(body.buf)))
+	h.uint32(serial)
+	if err := h.encode("a(yv)", hf); err != nil {
+		return nil, err
+	}
+	h.align(8)
+
+	return append(h.buf, body.buf...), nil
+}
@@ -0,0 +1,79 @@
+// Package fnmatch matches shell globs the way fnmatch(3) does, which
+// hwdb and modules.alias patterns are written for
+package fnmatch
+
+// Match is fnmatch(3) without flags: '*' and '?' match any characters
+// including '/', brackets take ranges and '!' or '^' negation
+func Match(pattern, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if Match(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if name == "" {
+				return false
+			}
+			pattern, name = pattern[1:], name[1:]
+		case '[':
+			if name == "" {
+				return false
+			}
+			ok, rest, valid := matchClass(pattern[1:], name[0])
+			if !valid {
+				// A lone bracket is literal
+				if name[0] != '[' {
+					return false
+				}
+				pattern, name = pattern[1:], name[1:]
+				continue
+			}
+			if !ok {
+				return false
+			}
+			pattern, name = rest, name[1:]
+		default:
+			if name == "" || pattern[0] != name[0] {
+				return false
+			}
+			pattern, name = pattern[1:], name[1:]
+		}
+	}
+	return name == ""
+}
+
+// matchClass matches c against a bracket expression without its opening
+// bracket and returns the pattern following it
+func matchClass(class string, c byte) (matched bool, rest string, valid bool) {
+	negate := false
+	if class != "" && (class[0] == '!' || class[0] == '^') {
+		negate, class = true, class[1:]
+	}
+
+	for i := 0; i < len(class); i++ {
+		// A closing bracket first in the class is literal
+		if class[i] == ']' && i > 0 {
+			return matched != negate, class[i+1:], true
+		}
+		lo, hi := class[i], class[i]
+		if i+2 < len(class) && class[i+1] == '-' && class[i+2] != ']' {
+			hi = class[i+2]
+			i += 2
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+	}
+
+	return false, "", false
+}
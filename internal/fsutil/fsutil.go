@@ -0,0 +1,38 @@
+// Package fsutil holds file helpers shared by the packages persisting state
+package fsutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// WriteFileAtomic replaces the file through a rename so readers never see
+// a partially written one
+func WriteFileAtomic(p string, content []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p))
+	if err != nil {
+		return errors.Wrapf(err, "failed to create temporary file for %v", p)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "failed to write %v", tmp.Name())
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "failed to sync %v", tmp.Name())
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "failed to close %v", tmp.Name())
+	}
+
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return errors.Wrapf(err, "failed to rename %v to %v", tmp.Name(), p)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+// Package sgio issues SCSI commands through the SG_IO ioctl
+package sgio
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	sgIO = 0x2285
+
+	interfaceID = 'S'
+
+	senseBufferLength = 32
+	defaultTimeout    = 20 * time.Second
+)
+
+// Direction of the data transfer
+type Direction int32
+
+const (
+	DirNone    Direction = -1
+	DirToDev   Direction = -2
+	DirFromDev Direction = -3
+)
+
+// sgIOHdr mirrors struct sg_io_hdr from scsi/sg.h
+type sgIOHdr struct {
+	interfaceID    int32
+	dxferDirection int32
+	cmdLen         uint8
+	mxSbLen        uint8
+	iovecCount     uint16
+	dxferLen       uint32
+	dxferp         *byte
+	cmdp           *byte
+	sbp            *byte
+	timeout        uint32
+	flags          uint32
+	packID         int32
+	usrPtr         uintptr
+	status         uint8
+	maskedStatus   uint8
+	msgStatus      uint8
+	sbLenWr        uint8
+	hostStatus     uint16
+	driverStatus   uint16
+	resid          int32
+	duration       uint32
+	info           uint32
+}
+
+// Error is a failed SCSI command with its sense data
+type Error struct {
+	Status       uint8
+	HostStatus   uint16
+	DriverStatus uint16
+	Sense        []byte
+}
+
+func (e *Error) Error() string {
+	if k, asc, ascq, ok := e.SenseKey(); ok {
+		return fmt.Sprintf("scsi command failed: status %#x, sense key %#x, asc %#x, ascq %#x", e.Status, k, asc, ascq)
+	}
+	return fmt.Sprintf("scsi command failed: status %#x, host %#x, driver %#x", e.Status, e.HostStatus, e.DriverStatus)
+}
+
+// SenseKey decodes fixed or descriptor format sense data
+func (e *Error) SenseKey() (key, asc, ascq uint8, ok bool) {
+	if len(e.Sense) < 4 {
+		return 0, 0, 0, false
+	}
+	switch e.Sense[0] & 0x7f {
+	case 0x70, 0x71:
+		if len(e.Sense) < 14 {
+			return 0, 0, 0, false
+		}
+		return e.Sense[2] & 0xf, e.Sense[12], e.Sense[13], true
+	case 0x72, 0x73:
+		return e.Sense[1] & 0xf, e.Sense[2], e.Sense[3], true
+	}
+	return 0, 0, 0, false
+}
+
+// Exec sends the command descriptor block to the device and transfers data
+// to or from buf. It returns the sense data, which ATA pass-through commands
+// use to return registers even on success, and an *Error if the command
+// failed.
+func Exec(f *os.File, cdb []byte, dir Direction, buf []byte, timeout time.Duration) ([]byte, error) {
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	sense := make([]byte, senseBufferLength)
+	hdr := sgIOHdr{
+		interfaceID:    interfaceID,
+		dxferDirection: int32(dir),
+		cmdLen:         uint8(len(cdb)),
+		mxSbLen:        uint8(len(sense)),
+		dxferLen:       uint32(len(buf)),
+		cmdp:           &cdb[0],
+		sbp:            &sense[0],
+		timeout:        uint32(timeout / time.Millisecond),
+	}
+	if len(buf) > 0 {
+		hdr.dxferp = &buf[0]
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), sgIO, uintptr(unsafe.Pointer(&hdr)))
+	if errno != 0 {
+		return nil, errno
+	}
+
+	sense = sense[:hdr.sbLenWr]
+	if hdr.status != 0 || hdr.hostStatus != 0 || hdr.driverStatus&0xf != 0 {
+		return sense, &Error{
+			Status:       hdr.status,
+			HostStatus:   hdr.hostStatus,
+			DriverStatus: hdr.driverStatus,
+			Sense:        sense,
+		}
+	}
+
+	return sense, nil
+}
@@ -0,0 +1,275 @@
+//go:build bpf
+// +build bpf
+
+package iolatency
+
+import (
+	"sort"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/alexdzyoba/sys/internal/bpf"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Buckets is the number of log2 histogram slots
+	Buckets = 64
+
+	maxInflight = 10240
+	maxSlots    = 4096
+)
+
+// Histogram is a log2 histogram of request latencies of a device. Slot i
+// counts requests that took [2^i, 2^(i+1)) microseconds, slot 0 also
+// counts sub-microsecond ones.
+type Histogram struct {
+	Major, Minor uint32
+	// Name is the kernel device name, empty if the device is gone
+	Name  string
+	Slots [Buckets]uint64
+}
+
+// Count returns the number of completed requests
+func (h *Histogram) Count() uint64 {
+	var n uint64
+	for _, c := range h.Slots {
+		n += c
+	}
+	return n
+}
+
+// Percentile returns the upper bound of the slot holding the q-th quantile,
+// e.g. Percentile(0.999) for p99.9 tail latency
+func (h *Histogram) Percentile(q float64) time.Duration {
+	total := h.Count()
+	if total == 0 {
+		return 0
+	}
+
+	want := uint64(q * float64(total))
+	var seen uint64
+	for i, c := range h.Slots {
+		seen += c
+		if seen > want || seen == total {
+			return time.Duration(uint64(1)<<uint(i+1)) * time.Microsecond
+		}
+	}
+	return 0
+}
+
+// startKey identifies an in-flight request. Requests are matched by the
+// device and the starting sector as tracepoints don't expose the request
+// pointer.
+type startKey struct {
+	dev    uint32
+	_      uint32
+	sector uint64
+}
+
+type histKey struct {
+	dev  uint32
+	slot uint32
+}
+
+// Collector keeps programs attached and accumulates histograms until closed
+type Collector struct {
+	objs              bpf.Collector
+	startMap, histMap int
+}
+
+// NewCollector loads the programs and starts collecting
+func NewCollector() (_ *Collector, err error) {
+	c := &Collector{}
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	// Requests completing unseen, e.g. issued before the programs were
+	// attached or merged after issue, are never deleted. An LRU map evicts
+	// them instead of filling up and dropping new requests.
+	c.startMap, err = c.objs.CreateLRUMap(uint32(unsafe.Sizeof(startKey{})), 8, maxInflight)
+	if err != nil {
+		return nil, err
+	}
+	c.histMap, err = c.objs.CreateMap(uint32(unsafe.Sizeof(histKey{})), 8, maxSlots)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tp := range []struct {
+		name  string
+		build func(dev, sector int16) *bpf.Asm
+	}{
+		{"block_rq_issue", c.issueProgram},
+		{"block_rq_complete", c.completeProgram},
+	} {
+		dev, err := bpf.FieldOffset("block", tp.name, "dev")
+		if err != nil {
+			return nil, err
+		}
+		sector, err := bpf.FieldOffset("block", tp.name, "sector")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := c.objs.Attach(tp.build(dev, sector), "block", tp.name); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// loadStartKey stores the request key at r10-16 from the context in r6
+func loadStartKey(a *bpf.Asm, dev, sector int16) {
+	a.Emit(bpf.OpLdxW, bpf.R7, bpf.R6, dev, 0)
+	a.Emit(bpf.OpStxW, bpf.R10, bpf.R7, -16, 0)
+	a.Emit(bpf.OpStW, bpf.R10, 0, -12, 0)
+	a.Emit(bpf.OpLdxDW, bpf.R7, bpf.R6, sector, 0)
+	a.Emit(bpf.OpStxDW, bpf.R10, bpf.R7, -8, 0)
+}
+
+// issueProgram records the issue time of a request
+func (c *Collector) issueProgram(dev, sector int16) *bpf.Asm {
+	a := bpf.NewAsm()
+	a.Emit(bpf.OpMovReg, bpf.R6, bpf.R1, 0, 0)
+	loadStartKey(a, dev, sector)
+
+	a.Call(bpf.FnKtimeGetNs)
+	a.Emit(bpf.OpStxDW, bpf.R10, bpf.R0, -24, 0)
+
+	a.LoadMap(bpf.R1, c.startMap)
+	a.StackPtr(bpf.R2, -16)
+	a.StackPtr(bpf.R3, -24)
+	a.Emit(bpf.OpMovImm, bpf.R4, 0, 0, bpf.Any)
+	a.Call(bpf.FnMapUpdateElem)
+
+	a.Return()
+	return a
+}
+
+// completeProgram adds the latency of a completed request to its log2
+// histogram slot
+func (c *Collector) completeProgram(dev, sector int16) *bpf.Asm {
+	a := bpf.NewAsm()
+	a.Emit(bpf.OpMovReg, bpf.R6, bpf.R1, 0, 0)
+	loadStartKey(a, dev, sector)
+
+	a.LoadMap(bpf.R1, c.startMap)
+	a.StackPtr(bpf.R2, -16)
+	a.Call(bpf.FnMapLookupElem)
+	a.Jump(bpf.OpJeqImm, bpf.R0, 0, "out")
+	a.Emit(bpf.OpLdxDW, bpf.R8, bpf.R0, 0, 0)
+
+	a.LoadMap(bpf.R1, c.startMap)
+	a.StackPtr(bpf.R2, -16)
+	a.Call(bpf.FnMapDeleteElem)
+
+	// Latency in microseconds
+	a.Call(bpf.FnKtimeGetNs)
+	a.Emit(bpf.OpSubReg, bpf.R0, bpf.R8, 0, 0)
+	a.Emit(bpf.OpDivImm, bpf.R0, 0, 0, 1000)
+
+	// r9 = log2(r0), unrolled as loops need a recent verifier
+	a.Emit(bpf.OpMovImm, bpf.R9, 0, 0, 0)
+	for _, shift := range []int32{32, 16, 8, 4, 2, 1} {
+		a.Emit(bpf.OpMovReg, bpf.R2, bpf.R0, 0, 0)
+		a.Emit(bpf.OpRshImm, bpf.R2, 0, 0, shift)
+		a.Emit(bpf.OpJeqImm, bpf.R2, 0, 2, 0)
+		a.Emit(bpf.OpMovReg, bpf.R0, bpf.R2, 0, 0)
+		a.Emit(bpf.OpAddImm, bpf.R9, 0, 0, shift)
+	}
+
+	// Histogram key at r10-32
+	a.Emit(bpf.OpLdxW, bpf.R7, bpf.R10, -16, 0)
+	a.Emit(bpf.OpStxW, bpf.R10, bpf.R7, -32, 0)
+	a.Emit(bpf.OpStxW, bpf.R10, bpf.R9, -28, 0)
+
+	a.LoadMap(bpf.R1, c.histMap)
+	a.StackPtr(bpf.R2, -32)
+	a.Call(bpf.FnMapLookupElem)
+	a.Jump(bpf.OpJneImm, bpf.R0, 0, "add")
+
+	// First request in the slot
+	a.Emit(bpf.OpStDW, bpf.R10, 0, -40, 1)
+	a.LoadMap(bpf.R1, c.histMap)
+	a.StackPtr(bpf.R2, -32)
+	a.StackPtr(bpf.R3, -40)
+	a.Emit(bpf.OpMovImm, bpf.R4, 0, 0, bpf.NoExist)
+	a.Call(bpf.FnMapUpdateElem)
+	a.Jump(bpf.OpJeqImm, bpf.R0, 0, "out")
+
+	// Another CPU created the slot meanwhile
+	a.LoadMap(bpf.R1, c.histMap)
+	a.StackPtr(bpf.R2, -32)
+	a.Call(bpf.FnMapLookupElem)
+	a.Jump(bpf.OpJeqImm, bpf.R0, 0, "out")
+
+	a.Label("add")
+	a.Emit(bpf.OpMovImm, bpf.R1, 0, 0, 1)
+	a.Emit(bpf.OpXaddDW, bpf.R0, bpf.R1, 0, 0)
+
+	a.Label("out")
+	a.Return()
+	return a
+}
+
+// Histograms returns histograms accumulated since the collector started
+// sorted by device number
+func (c *Collector) Histograms() ([]Histogram, error) {
+	byDev := map[uint32]*Histogram{}
+
+	var key, next histKey
+	var cur unsafe.Pointer
+	for {
+		ok, err := bpf.NextKey(c.histMap, cur, unsafe.Pointer(&next))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to iterate histogram map")
+		}
+		if !ok {
+			break
+		}
+		key = next
+		cur = unsafe.Pointer(&key)
+
+		var v uint64
+		if err := bpf.Lookup(c.histMap, unsafe.Pointer(&key), unsafe.Pointer(&v)); err != nil {
+			if err == syscall.ENOENT {
+				continue
+			}
+			return nil, errors.Wrap(err, "failed to read histogram map")
+		}
+
+		h, ok := byDev[key.dev]
+		if !ok {
+			h = &Histogram{}
+			h.Major, h.Minor, h.Name = bpf.DeviceName(key.dev)
+			byDev[key.dev] = h
+		}
+		if key.slot < Buckets {
+			h.Slots[key.slot] = v
+		}
+	}
+
+	hs := make([]Histogram, 0, len(byDev))
+	for _, h := range byDev {
+		hs = append(hs, *h)
+	}
+	sort.Slice(hs, func(i, j int) bool {
+		if hs[i].Major != hs[j].Major {
+			return hs[i].Major < hs[j].Major
+		}
+		return hs[i].Minor < hs[j].Minor
+	})
+
+	return hs, nil
+}
+
+// Close detaches the programs and releases the maps
+func (c *Collector) Close() error {
+	return c.objs.Close()
+}
@@ -0,0 +1,5 @@
+// Package iolatency collects per-device block I/O latency histograms with
+// eBPF programs attached to block_rq_issue and block_rq_complete
+// tracepoints. It needs CAP_BPF or root and is only built with the "bpf"
+// build tag so regular builds don't carry the loader.
+package iolatency
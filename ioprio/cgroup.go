@@ -0,0 +1,77 @@
+package ioprio
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/pkg/errors"
+)
+
+const (
+	procSelfCgroup = "/proc/self/cgroup"
+	cgroup2Root    = "/sys/fs/cgroup"
+
+	minIOWeight = 1
+	maxIOWeight = 10000
+)
+
+// CurrentCgroup returns the cgroup v2 path of the current process relative
+// to the cgroup root, e.g. "/system.slice/agent.service"
+func CurrentCgroup() (string, error) {
+	f, err := os.Open(procSelfCgroup)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to open %v", procSelfCgroup)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// The unified hierarchy is reported as "0::/path"
+		if strings.HasPrefix(scanner.Text(), "0::") {
+			return strings.TrimPrefix(scanner.Text(), "0::"), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", errors.Wrapf(err, "failed to read %v", procSelfCgroup)
+	}
+
+	return "", errors.New("cgroup v2 hierarchy is not mounted")
+}
+
+// SetCgroupIOWeight sets the default io.weight of the cgroup. Weights
+// range from 1 to 10000 with 100 being the default; the cgroup competes for
+// disk time with its siblings proportionally to the weight.
+func SetCgroupIOWeight(ctx context.Context, cgroup string, weight int) error {
+	return writeIOWeight(ctx, cgroup, fmt.Sprintf("default %d", weight), weight)
+}
+
+// SetCgroupDeviceIOWeight sets io.weight of the cgroup for a single device
+func SetCgroupDeviceIOWeight(ctx context.Context, cgroup string, major, minor uint32, weight int) error {
+	return writeIOWeight(ctx, cgroup, fmt.Sprintf("%d:%d %d", major, minor, weight), weight)
+}
+
+func writeIOWeight(ctx context.Context, cgroup, value string, weight int) error {
+	if weight < minIOWeight || weight > maxIOWeight {
+		return errors.Errorf("invalid io.weight %d", weight)
+	}
+
+	p := path.Join(cgroup2Root, cgroup, "io.weight")
+	a := action.Action{
+		Op:     "cgroup.write",
+		Target: p,
+		Args:   map[string]string{"value": value},
+	}
+
+	return action.Run(ctx, a, func() error {
+		if err := ioutil.WriteFile(p, []byte(value), 0644); err != nil {
+			return errors.Wrapf(err, "failed to write %v", p)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,147 @@
+package ioprio
+
+import (
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	whoProcess = 1
+
+	classShift = 13
+	levelMask  = 1<<classShift - 1
+)
+
+// Class is the I/O scheduling class
+type Class int
+
+const (
+	ClassNone Class = iota
+	ClassRealtime
+	ClassBestEffort
+	ClassIdle
+)
+
+func (c Class) String() string {
+	switch c {
+	case ClassNone:
+		return "none"
+	case ClassRealtime:
+		return "realtime"
+	case ClassBestEffort:
+		return "best-effort"
+	case ClassIdle:
+		return "idle"
+	}
+	return "unknown"
+}
+
+// Priority is an I/O class with a level from 0 (highest) to 7 (lowest).
+// The level is ignored for ClassIdle.
+type Priority struct {
+	Class Class
+	Level int
+}
+
+var (
+	// Background is the priority for maintenance work that should only
+	// use the disk when nobody else does
+	Background = Priority{Class: ClassIdle}
+	// Low is the lowest best-effort priority
+	Low = Priority{Class: ClassBestEffort, Level: 7}
+)
+
+func (p Priority) value() int {
+	return int(p.Class)<<classShift | p.Level&levelMask
+}
+
+func fromValue(v int) Priority {
+	return Priority{Class: Class(v >> classShift), Level: v & levelMask}
+}
+
+// Get returns the I/O priority of the thread or process. Zero tid means the
+// calling thread.
+func Get(tid int) (Priority, error) {
+	r, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_GET, whoProcess, uintptr(tid), 0)
+	if errno != 0 {
+		return Priority{}, errors.Wrapf(errno, "failed to get I/O priority of %d", tid)
+	}
+
+	return fromValue(int(r)), nil
+}
+
+// Set sets the I/O priority of the thread or process like ionice(1). Zero
+// tid means the calling thread.
+func Set(tid int, p Priority) error {
+	if p.Level < 0 || p.Level > 7 {
+		return errors.Errorf("invalid I/O priority level %d", p.Level)
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, whoProcess, uintptr(tid), uintptr(p.value()))
+	if errno != 0 {
+		return errors.Wrapf(errno, "failed to set I/O priority of %d", tid)
+	}
+
+	return nil
+}
+
+// SetProcess sets the I/O priority of every thread of the current process.
+// Threads created later inherit the priority of the thread they are cloned
+// from.
+func SetProcess(p Priority) error {
+	tasks, err := ioutil.ReadDir("/proc/self/task")
+	if err != nil {
+		return errors.Wrap(err, "failed to list threads")
+	}
+
+	for _, t := range tasks {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+		err = Set(tid, p)
+		if errors.Cause(err) == syscall.ESRCH {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Do runs fn with the calling goroutine locked to its OS thread and the
+// thread's I/O priority set to p, restoring the previous priority afterwards.
+// I/O issued by other goroutines started from fn is not affected. If the
+// priority can't be restored the error is returned, unless fn failed, and
+// the goroutine stays locked to the thread so the runtime discards the
+// thread when the goroutine exits instead of reusing it with p.
+func Do(p Priority, fn func() error) (err error) {
+	runtime.LockOSThread()
+
+	prev, err := Get(0)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	if err := Set(0, p); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer func() {
+		if restoreErr := Set(0, prev); restoreErr != nil {
+			if err == nil {
+				err = errors.Wrap(restoreErr, "failed to restore I/O priority")
+			}
+			return
+		}
+		runtime.UnlockOSThread()
+	}()
+
+	return fn()
+}
@@ -0,0 +1,15 @@
+// Package iotop attributes block I/O bytes and operations to processes per
+// device with an eBPF program on the block_bio_queue tracepoint, answering
+// which process is hammering a disk. It needs CAP_BPF or root and is only
+// built with the "bpf" build tag.
+//
+// I/O is attributed to the thread group submitting the bio, which isn't
+// always the one causing it. Buffered writes reach the disk through
+// writeback and show up as kworker threads. The process is only charged
+// for reads missing the page cache, O_DIRECT I/O and the dirty pages its
+// own fsync or sync writes. Stacked devices resubmit bios from their own
+// threads, e.g. dm-crypt's kcryptd, so their lower devices see those
+// threads too, while the top device sees the process. Per cgroup writeback
+// accounting in io.stat is the way to charge buffered writes to their
+// owners.
+package iotop
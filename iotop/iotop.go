@@ -0,0 +1,217 @@
+//go:build bpf
+// +build bpf
+
+package iotop
+
+import (
+	"bytes"
+	"sort"
+	"syscall"
+	"unsafe"
+
+	"github.com/alexdzyoba/sys/internal/bpf"
+	"github.com/pkg/errors"
+)
+
+const maxEntries = 10240
+
+// Op is the direction of attributed I/O
+type Op uint32
+
+const (
+	OpRead Op = iota
+	OpWrite
+	// OpOther covers discards and requests without data
+	OpOther
+)
+
+func (o Op) String() string {
+	switch o {
+	case OpRead:
+		return "read"
+	case OpWrite:
+		return "write"
+	}
+	return "other"
+}
+
+// Usage is the I/O a process submitted to a device. Kernel threads like
+// kworker doing writeback on behalf of other processes appear with their
+// own PID, see the package documentation.
+type Usage struct {
+	PID int
+	// Command is the name of the thread that submitted the first request
+	Command      string
+	Major, Minor uint32
+	// Device is the kernel device name, empty if the device is gone
+	Device string
+	Op     Op
+	Bytes  uint64
+	IOs    uint64
+}
+
+// key mirrors the map key built by the program
+type key struct {
+	pid uint32
+	dev uint32
+	op  uint32
+	_   uint32
+}
+
+// value mirrors the map value updated by the program
+type value struct {
+	bytes uint64
+	ios   uint64
+	comm  [16]byte
+}
+
+// Collector attributes submitted block I/O to processes until closed
+type Collector struct {
+	objs  bpf.Collector
+	usage int
+}
+
+// NewCollector loads the program and starts collecting
+func NewCollector() (_ *Collector, err error) {
+	c := &Collector{}
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	c.usage, err = c.objs.CreateMap(uint32(unsafe.Sizeof(key{})), uint32(unsafe.Sizeof(value{})), maxEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bios are queued in the context of the submitting process unlike
+	// requests which may be issued from kernel workers
+	const tp = "block_bio_queue"
+	offsets := map[string]int16{}
+	for _, field := range []string{"dev", "nr_sector", "rwbs"} {
+		off, err := bpf.FieldOffset("block", tp, field)
+		if err != nil {
+			return nil, err
+		}
+		offsets[field] = off
+	}
+
+	if err := c.objs.Attach(c.program(offsets), "block", tp); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Collector) program(off map[string]int16) *bpf.Asm {
+	a := bpf.NewAsm()
+	a.Emit(bpf.OpMovReg, bpf.R6, bpf.R1, 0, 0)
+
+	// Key at r10-16: tgid, dev, op, padding
+	a.Call(bpf.FnGetCurrentPidTgid)
+	a.Emit(bpf.OpRshImm, bpf.R0, 0, 0, 32)
+	a.Emit(bpf.OpStxW, bpf.R10, bpf.R0, -16, 0)
+	a.Emit(bpf.OpLdxW, bpf.R7, bpf.R6, off["dev"], 0)
+	a.Emit(bpf.OpStxW, bpf.R10, bpf.R7, -12, 0)
+	a.Emit(bpf.OpStW, bpf.R10, 0, -4, 0)
+
+	// rwbs starts with F for preflush followed by R, W, D or N
+	a.Emit(bpf.OpLdxB, bpf.R7, bpf.R6, off["rwbs"], 0)
+	a.Emit(bpf.OpJneImm, bpf.R7, 0, 1, 'F')
+	a.Emit(bpf.OpLdxB, bpf.R7, bpf.R6, off["rwbs"]+1, 0)
+	a.Emit(bpf.OpMovImm, bpf.R8, 0, 0, int32(OpOther))
+	a.Emit(bpf.OpJneImm, bpf.R7, 0, 1, 'R')
+	a.Emit(bpf.OpMovImm, bpf.R8, 0, 0, int32(OpRead))
+	a.Emit(bpf.OpJneImm, bpf.R7, 0, 1, 'W')
+	a.Emit(bpf.OpMovImm, bpf.R8, 0, 0, int32(OpWrite))
+	a.Emit(bpf.OpStxW, bpf.R10, bpf.R8, -8, 0)
+
+	// Bytes from 512 byte sectors
+	a.Emit(bpf.OpLdxW, bpf.R8, bpf.R6, off["nr_sector"], 0)
+	a.Emit(bpf.OpLshImm, bpf.R8, 0, 0, 9)
+
+	a.LoadMap(bpf.R1, c.usage)
+	a.StackPtr(bpf.R2, -16)
+	a.Call(bpf.FnMapLookupElem)
+	a.Jump(bpf.OpJneImm, bpf.R0, 0, "add")
+
+	// First bio of the process to the device, value at r10-48
+	a.Emit(bpf.OpStxDW, bpf.R10, bpf.R8, -48, 0)
+	a.Emit(bpf.OpStDW, bpf.R10, 0, -40, 1)
+	a.StackPtr(bpf.R1, -32)
+	a.Emit(bpf.OpMovImm, bpf.R2, 0, 0, 16)
+	a.Call(bpf.FnGetCurrentComm)
+	a.LoadMap(bpf.R1, c.usage)
+	a.StackPtr(bpf.R2, -16)
+	a.StackPtr(bpf.R3, -48)
+	a.Emit(bpf.OpMovImm, bpf.R4, 0, 0, bpf.NoExist)
+	a.Call(bpf.FnMapUpdateElem)
+	a.Jump(bpf.OpJeqImm, bpf.R0, 0, "out")
+
+	// Another CPU created the entry meanwhile
+	a.LoadMap(bpf.R1, c.usage)
+	a.StackPtr(bpf.R2, -16)
+	a.Call(bpf.FnMapLookupElem)
+	a.Jump(bpf.OpJeqImm, bpf.R0, 0, "out")
+
+	a.Label("add")
+	a.Emit(bpf.OpXaddDW, bpf.R0, bpf.R8, 0, 0)
+	a.Emit(bpf.OpMovImm, bpf.R1, 0, 0, 1)
+	a.Emit(bpf.OpXaddDW, bpf.R0, bpf.R1, 8, 0)
+
+	a.Label("out")
+	a.Return()
+	return a
+}
+
+// Usage returns I/O submitted since the collector started, heaviest first
+func (c *Collector) Usage() ([]Usage, error) {
+	var us []Usage
+
+	var k, next key
+	var cur unsafe.Pointer
+	for {
+		ok, err := bpf.NextKey(c.usage, cur, unsafe.Pointer(&next))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to iterate usage map")
+		}
+		if !ok {
+			break
+		}
+		k = next
+		cur = unsafe.Pointer(&k)
+
+		var v value
+		if err := bpf.Lookup(c.usage, unsafe.Pointer(&k), unsafe.Pointer(&v)); err != nil {
+			if err == syscall.ENOENT {
+				continue
+			}
+			return nil, errors.Wrap(err, "failed to read usage map")
+		}
+
+		u := Usage{
+			PID:     int(k.pid),
+			Command: string(bytes.TrimRight(v.comm[:], "\x00")),
+			Op:      Op(k.op),
+			Bytes:   v.bytes,
+			IOs:     v.ios,
+		}
+		u.Major, u.Minor, u.Device = bpf.DeviceName(k.dev)
+		us = append(us, u)
+	}
+
+	sort.Slice(us, func(i, j int) bool {
+		if us[i].Bytes != us[j].Bytes {
+			return us[i].Bytes > us[j].Bytes
+		}
+		return us[i].IOs > us[j].IOs
+	})
+
+	return us, nil
+}
+
+// Close detaches the program and releases the map
+func (c *Collector) Close() error {
+	return c.objs.Close()
+}
@@ -0,0 +1,165 @@
+// Package kmod predicts which kernel modules drive a device by matching its
+// modalias against the aliases modules declare in modules.alias. It helps
+// debugging devices left without a driver.
+package kmod
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/alexdzyoba/sys/internal/fnmatch"
+	"github.com/pkg/errors"
+)
+
+const (
+	modulesRoot = "/lib/modules"
+	sysfsBus    = "/sys/bus"
+)
+
+// Alias is a line of modules.alias
+type Alias struct {
+	Pattern string
+	Module  string
+}
+
+// Aliases of the modules of a kernel
+type Aliases struct {
+	aliases []Alias
+}
+
+// Load reads modules.alias of the running kernel
+func Load() (*Aliases, error) {
+	release, err := kernelRelease()
+	if err != nil {
+		return nil, err
+	}
+	return LoadFile(path.Join(modulesRoot, release, "modules.alias"))
+}
+
+// LoadFile reads a modules.alias file
+func LoadFile(p string) (*Aliases, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", p)
+	}
+	defer f.Close()
+
+	a := &Aliases{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// alias pci:v00008086d00002922sv*sd*bc*sc*i* ahci
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || fields[0] != "alias" {
+			continue
+		}
+		a.aliases = append(a.aliases, Alias{Pattern: fields[1], Module: fields[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", p)
+	}
+
+	return a, nil
+}
+
+// Match returns modules whose aliases match the modalias in the file order,
+// which is the order modprobe tries them
+func (a *Aliases) Match(modalias string) []string {
+	var modules []string
+	seen := map[string]bool{}
+	for _, al := range a.aliases {
+		if seen[al.Module] || !fnmatch.Match(al.Pattern, modalias) {
+			continue
+		}
+		seen[al.Module] = true
+		modules = append(modules, al.Module)
+	}
+	return modules
+}
+
+// Device is a device found on a bus
+type Device struct {
+	// Path is the sysfs device directory
+	Path     string
+	Bus      string
+	Modalias string
+	// Driver is the bound driver, empty for unbound devices
+	Driver string
+	// Modules are the candidates to drive the device
+	Modules []string
+}
+
+// Unbound lists devices with a modalias but no driver along with the
+// modules that would claim them
+func (a *Aliases) Unbound() ([]Device, error) {
+	buses, err := ioutil.ReadDir(sysfsBus)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", sysfsBus)
+	}
+
+	var ds []Device
+	for _, bus := range buses {
+		dir := path.Join(sysfsBus, bus.Name(), "devices")
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, e := range entries {
+			d, err := a.Describe(path.Join(dir, e.Name()))
+			if err != nil || d.Modalias == "" || d.Driver != "" {
+				continue
+			}
+			ds = append(ds, *d)
+		}
+	}
+
+	return ds, nil
+}
+
+// Describe reads the modalias and driver of the sysfs device directory
+// and matches the modalias
+func (a *Aliases) Describe(devicePath string) (*Device, error) {
+	real, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve %v", devicePath)
+	}
+
+	d := &Device{Path: real}
+	if bus, err := os.Readlink(path.Join(real, "subsystem")); err == nil {
+		d.Bus = path.Base(bus)
+	}
+	if driver, err := os.Readlink(path.Join(real, "driver")); err == nil {
+		d.Driver = path.Base(driver)
+	}
+
+	content, err := ioutil.ReadFile(path.Join(real, "modalias"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "failed to read modalias of %v", real)
+	}
+	d.Modalias = strings.TrimSpace(string(content))
+	if d.Modalias != "" {
+		d.Modules = a.Match(d.Modalias)
+	}
+
+	return d, nil
+}
+
+func kernelRelease() (string, error) {
+	var u syscall.Utsname
+	if err := syscall.Uname(&u); err != nil {
+		return "", errors.Wrap(err, "failed to get kernel release")
+	}
+	var release []byte
+	for _, c := range u.Release {
+		if c == 0 {
+			break
+		}
+		release = append(release, byte(c))
+	}
+	return string(release), nil
+}
@@ -0,0 +1,431 @@
+// Package loop lists, attaches and detaches loop devices through
+// /dev/loop-control and the loop ioctls, like losetup does.
+package loop
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/pkg/errors"
+)
+
+const (
+	sysfsBlockRoot = "/sys/block"
+	controlNode    = "/dev/loop-control"
+)
+
+// ioctls from linux/loop.h
+const (
+	loopSetFd       = 0x4C00
+	loopClrFd       = 0x4C01
+	loopSetStatus64 = 0x4C04
+	loopConfigure   = 0x4C0A
+
+	loopCtlAdd     = 0x4C80
+	loopCtlRemove  = 0x4C81
+	loopCtlGetFree = 0x4C82
+)
+
+// Flags of loop_info64
+const (
+	flagReadOnly  = 1
+	flagAutoClear = 4
+	flagPartScan  = 8
+	flagDirectIO  = 16
+)
+
+// attachRetries bounds retries when another process grabs the free device
+// between LOOP_CTL_GET_FREE and attaching to it
+const attachRetries = 8
+
+// Device is a loop device
+type Device struct {
+	// Name is the kernel name like "loop0"
+	Name string
+	Node string
+	// BackingFile is empty when nothing is attached
+	BackingFile string
+	Offset      uint64
+	// SizeLimit is zero when the whole backing file is used
+	SizeLimit uint64
+	AutoClear bool
+	PartScan  bool
+	DirectIO  bool
+	ReadOnly  bool
+}
+
+// Attached returns whether a backing file is attached to the device
+func (d Device) Attached() bool {
+	return d.BackingFile != ""
+}
+
+// List returns all loop devices including unattached ones, sorted by number
+func List() ([]Device, error) {
+	matches, err := filepath.Glob(path.Join(sysfsBlockRoot, "loop*"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list loop devices")
+	}
+
+	var ds []Device
+	for _, m := range matches {
+		d, err := Get(path.Base(m))
+		if err != nil {
+			return nil, err
+		}
+		ds = append(ds, d)
+	}
+	sort.Slice(ds, func(i, j int) bool {
+		return number(ds[i].Name) < number(ds[j].Name)
+	})
+
+	return ds, nil
+}
+
+// Get returns the loop device by its kernel name
+func Get(name string) (Device, error) {
+	sysfsPath := path.Join(sysfsBlockRoot, name)
+	if _, err := os.Stat(sysfsPath); err != nil {
+		return Device{}, errors.Wrapf(err, "failed to stat %v", sysfsPath)
+	}
+
+	d := Device{
+		Name:     name,
+		Node:     path.Join("/dev", name),
+		ReadOnly: readAttr(path.Join(sysfsPath, "ro")) == "1",
+	}
+
+	// loop/ only exists while a backing file is attached
+	loopPath := path.Join(sysfsPath, "loop")
+	if _, err := os.Stat(loopPath); err != nil {
+		return d, nil
+	}
+
+	d.BackingFile = readAttr(path.Join(loopPath, "backing_file"))
+	d.Offset, _ = strconv.ParseUint(readAttr(path.Join(loopPath, "offset")), 10, 64)
+	d.SizeLimit, _ = strconv.ParseUint(readAttr(path.Join(loopPath, "sizelimit")), 10, 64)
+	d.AutoClear = readAttr(path.Join(loopPath, "autoclear")) == "1"
+	d.PartScan = readAttr(path.Join(loopPath, "partscan")) == "1"
+	d.DirectIO = readAttr(path.Join(loopPath, "dio")) == "1"
+
+	return d, nil
+}
+
+// Find returns attached devices backed by the file
+func Find(file string) ([]Device, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve %v", file)
+	}
+
+	ds, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Device
+	for _, d := range ds {
+		if d.BackingFile == abs {
+			found = append(found, d)
+		}
+	}
+	return found, nil
+}
+
+// Options configure attaching a backing file
+type Options struct {
+	Offset    uint64
+	SizeLimit uint64
+	// BlockSize is the logical block size, zero keeps the default of 512
+	BlockSize uint32
+	ReadOnly  bool
+	// AutoClear detaches the device when its last user closes it, it
+	// requires AttachFile
+	AutoClear bool
+	// PartScan makes the kernel scan the partition table of the file
+	PartScan bool
+	DirectIO bool
+}
+
+// Create adds a new unattached loop device, number -1 picks the first
+// unused number
+func Create(ctx context.Context, number int) (Device, error) {
+	a := action.Action{Op: "loop.create", Target: controlNode, Args: map[string]string{"number": strconv.Itoa(number)}}
+
+	var name string
+	err := action.Run(ctx, a, func() error {
+		n, err := control(loopCtlAdd, number)
+		if err != nil {
+			return errors.Wrap(err, "failed to add loop device")
+		}
+		name = "loop" + strconv.Itoa(n)
+		return nil
+	})
+	if err != nil || name == "" {
+		return Device{}, err
+	}
+
+	return Get(name)
+}
+
+// Remove deletes an unattached loop device
+func Remove(ctx context.Context, name string) error {
+	n := number(name)
+	if n < 0 {
+		return errors.Errorf("%s is not a loop device", name)
+	}
+
+	a := action.Action{Op: "loop.remove", Target: path.Join("/dev", name)}
+	return action.Run(ctx, a, func() error {
+		if _, err := control(loopCtlRemove, n); err != nil {
+			return errors.Wrapf(err, "failed to remove %s", name)
+		}
+		return nil
+	})
+}
+
+// Attach attaches the file to the first free loop device, allocating one if
+// needed, and returns the device. In dry-run mode the returned device is
+// empty. AutoClear is rejected since the device would be detached as soon as
+// Attach closes it, use AttachFile instead.
+func Attach(ctx context.Context, file string, opts Options) (Device, error) {
+	if opts.AutoClear {
+		return Device{}, errors.New("autoclear requires keeping the device open, use AttachFile")
+	}
+
+	d, dev, err := AttachFile(ctx, file, opts)
+	if dev != nil {
+		dev.Close()
+	}
+	return d, err
+}
+
+// AttachFile is Attach returning the loop device opened. With AutoClear the
+// kernel detaches the device once it and every other user close it. In
+// dry-run mode the returned device is empty and the file is nil.
+func AttachFile(ctx context.Context, file string, opts Options) (Device, *os.File, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return Device{}, nil, errors.Wrapf(err, "failed to resolve %v", file)
+	}
+
+	a := action.Action{Op: "loop.attach", Target: abs, Args: opts.args()}
+
+	var dev *os.File
+	err = action.Run(ctx, a, func() error {
+		flag := os.O_RDWR
+		if opts.ReadOnly {
+			flag = os.O_RDONLY
+		}
+		f, err := os.OpenFile(abs, flag, 0)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open %v", abs)
+		}
+		defer f.Close()
+
+		for i := 0; i < attachRetries; i++ {
+			n, err := control(loopCtlGetFree, 0)
+			if err != nil {
+				return errors.Wrap(err, "failed to get a free loop device")
+			}
+			name := "loop" + strconv.Itoa(n)
+
+			dev, err = attach(name, f, abs, opts)
+			if err == syscall.EBUSY {
+				continue
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to attach %v to %s", abs, name)
+			}
+			return nil
+		}
+
+		return errors.Errorf("failed to attach %v: loop devices are busy", abs)
+	})
+	if err != nil || dev == nil {
+		return Device{}, nil, err
+	}
+
+	d, err := Get(path.Base(dev.Name()))
+	if err != nil {
+		dev.Close()
+		return Device{}, nil, err
+	}
+	return d, dev, nil
+}
+
+// Detach detaches the backing file from the device. With the device still
+// open elsewhere, the kernel detaches it on the last close.
+func Detach(ctx context.Context, name string) error {
+	node := path.Join("/dev", name)
+	a := action.Action{Op: "loop.detach", Target: node}
+
+	return action.Run(ctx, a, func() error {
+		f, err := os.OpenFile(node, os.O_RDONLY, 0)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open %v", node)
+		}
+		defer f.Close()
+
+		if err := ioctl(f.Fd(), loopClrFd, 0); err != nil {
+			return errors.Wrapf(err, "failed to detach %s", name)
+		}
+		return nil
+	})
+}
+
+// loopInfo64 is struct loop_info64
+type loopInfo64 struct {
+	device         uint64
+	inode          uint64
+	rdevice        uint64
+	offset         uint64
+	sizeLimit      uint64
+	number         uint32
+	encryptType    uint32
+	encryptKeySize uint32
+	flags          uint32
+	fileName       [64]byte
+	cryptName      [64]byte
+	encryptKey     [32]byte
+	init           [2]uint64
+}
+
+// loopConfig is struct loop_config
+type loopConfig struct {
+	fd        uint32
+	blockSize uint32
+	info      loopInfo64
+	reserved  [8]uint64
+}
+
+// attach returns the loop device opened on success
+func attach(name string, f *os.File, file string, opts Options) (*os.File, error) {
+	node := path.Join("/dev", name)
+	flag := os.O_RDWR
+	if opts.ReadOnly {
+		flag = os.O_RDONLY
+	}
+	dev, err := os.OpenFile(node, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := configure(dev, f, file, opts); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	return dev, nil
+}
+
+func configure(dev, f *os.File, file string, opts Options) error {
+
+	info := loopInfo64{offset: opts.Offset, sizeLimit: opts.SizeLimit}
+	if opts.ReadOnly {
+		info.flags |= flagReadOnly
+	}
+	if opts.AutoClear {
+		info.flags |= flagAutoClear
+	}
+	if opts.PartScan {
+		info.flags |= flagPartScan
+	}
+	if opts.DirectIO {
+		info.flags |= flagDirectIO
+	}
+	copy(info.fileName[:len(info.fileName)-1], file)
+
+	// LOOP_CONFIGURE sets everything at once since Linux 5.8, older
+	// kernels need LOOP_SET_FD followed by LOOP_SET_STATUS64
+	config := loopConfig{fd: uint32(f.Fd()), blockSize: opts.BlockSize, info: info}
+	err := ioctl(dev.Fd(), loopConfigure, uintptr(unsafe.Pointer(&config)))
+	if err != syscall.EINVAL && err != syscall.ENOTTY {
+		return err
+	}
+	if opts.BlockSize != 0 {
+		return errors.New("block size requires LOOP_CONFIGURE of Linux 5.8")
+	}
+
+	if err := ioctl(dev.Fd(), loopSetFd, f.Fd()); err != nil {
+		return err
+	}
+	if err := ioctl(dev.Fd(), loopSetStatus64, uintptr(unsafe.Pointer(&info))); err != nil {
+		ioctl(dev.Fd(), loopClrFd, 0)
+		return err
+	}
+
+	return nil
+}
+
+// control issues a /dev/loop-control ioctl returning the device number
+func control(req uintptr, arg int) (int, error) {
+	f, err := os.OpenFile(controlNode, os.O_RDWR, 0)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to open %v", controlNode)
+	}
+	defer f.Close()
+
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (o Options) args() map[string]string {
+	args := map[string]string{}
+	if o.Offset != 0 {
+		args["offset"] = strconv.FormatUint(o.Offset, 10)
+	}
+	if o.SizeLimit != 0 {
+		args["sizelimit"] = strconv.FormatUint(o.SizeLimit, 10)
+	}
+	if o.BlockSize != 0 {
+		args["block-size"] = strconv.FormatUint(uint64(o.BlockSize), 10)
+	}
+	if o.ReadOnly {
+		args["read-only"] = "true"
+	}
+	if o.AutoClear {
+		args["autoclear"] = "true"
+	}
+	if o.PartScan {
+		args["partscan"] = "true"
+	}
+	if o.DirectIO {
+		args["direct-io"] = "true"
+	}
+	return args
+}
+
+// number returns N of "loopN", or -1 for other names like "loop-control"
+func number(name string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(name, "loop"))
+	if err != nil || !strings.HasPrefix(name, "loop") {
+		return -1
+	}
+	return n
+}
+
+func readAttr(p string) string {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
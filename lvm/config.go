@@ -0,0 +1,225 @@
+package lvm
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// section is a block of the LVM metadata text format:
+//
+//	name {
+//		key = "string"
+//		key = 42
+//		key = ["list", 0]
+//		child { ... }
+//	}
+//
+// Values are string, int64 or []interface{} of those.
+type section struct {
+	name     string
+	values   map[string]interface{}
+	children []*section
+}
+
+func (s *section) child(name string) *section {
+	for _, c := range s.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (s *section) str(key string) string {
+	v, _ := s.values[key].(string)
+	return v
+}
+
+func (s *section) int(key string) int64 {
+	v, _ := s.values[key].(int64)
+	return v
+}
+
+func (s *section) uint(key string) uint64 {
+	if v := s.int(key); v > 0 {
+		return uint64(v)
+	}
+	return 0
+}
+
+func (s *section) list(key string) []interface{} {
+	v, _ := s.values[key].([]interface{})
+	return v
+}
+
+// flag returns whether a status or flags list has the value
+func (s *section) flag(key, value string) bool {
+	for _, v := range s.list(key) {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseConfig parses metadata text into a root section holding the volume
+// group section and the top level values
+func parseConfig(text string) (*section, error) {
+	p := &parser{text: text}
+	root := &section{values: map[string]interface{}{}}
+	if err := p.body(root, 0); err != nil {
+		return nil, err
+	}
+	if p.peek() != 0 {
+		return nil, p.errorf("unexpected %q", p.peek())
+	}
+	return root, nil
+}
+
+type parser struct {
+	text string
+	pos  int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	line := 1 + strings.Count(p.text[:p.pos], "\n")
+	return errors.Errorf("metadata line %d: %s", line, errors.Errorf(format, args...))
+}
+
+// peek skips whitespace and comments and returns the next byte, 0 at the
+// end
+func (p *parser) peek() byte {
+	for p.pos < len(p.text) {
+		switch c := p.text[p.pos]; {
+		case c == '#':
+			for p.pos < len(p.text) && p.text[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == 0:
+			p.pos++
+		default:
+			return c
+		}
+	}
+	return 0
+}
+
+// body parses assignments and child sections until the closing brace
+func (p *parser) body(s *section, depth int) error {
+	if depth > 32 {
+		return p.errorf("sections are nested too deeply")
+	}
+
+	for {
+		c := p.peek()
+		if c == 0 || c == '}' {
+			if (c == '}') != (depth > 0) {
+				return p.errorf("unbalanced braces")
+			}
+			if c == '}' {
+				p.pos++
+			}
+			return nil
+		}
+
+		name := p.word()
+		if name == "" {
+			return p.errorf("unexpected %q", c)
+		}
+		switch p.peek() {
+		case '{':
+			p.pos++
+			child := &section{name: name, values: map[string]interface{}{}}
+			if err := p.body(child, depth+1); err != nil {
+				return err
+			}
+			s.children = append(s.children, child)
+		case '=':
+			p.pos++
+			v, err := p.value(true)
+			if err != nil {
+				return err
+			}
+			s.values[name] = v
+		default:
+			return p.errorf("expected { or = after %s", name)
+		}
+	}
+}
+
+// word reads a section name or key
+func (p *parser) word() string {
+	start := p.pos
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("_.+-", c) >= 0) {
+			break
+		}
+		p.pos++
+	}
+	return p.text[start:p.pos]
+}
+
+// value reads a string, a number or, at the top level, a list
+func (p *parser) value(top bool) (interface{}, error) {
+	switch c := p.peek(); {
+	case c == '"':
+		return p.string()
+	case c == '[' && top:
+		p.pos++
+		list := []interface{}{}
+		for {
+			if p.peek() == ']' {
+				p.pos++
+				return list, nil
+			}
+			v, err := p.value(false)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			switch p.peek() {
+			case ',':
+				p.pos++
+			case ']':
+			default:
+				return nil, p.errorf("expected , or ] in list")
+			}
+		}
+	case c == '-' || c >= '0' && c <= '9':
+		w := p.word()
+		if i, err := strconv.ParseInt(w, 10, 64); err == nil {
+			return i, nil
+		}
+		// Floats only appear in values callers don't use
+		if _, err := strconv.ParseFloat(w, 64); err == nil {
+			return w, nil
+		}
+		return nil, p.errorf("invalid number %q", w)
+	default:
+		return nil, p.errorf("unexpected %q in value", c)
+	}
+}
+
+func (p *parser) string() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.pos < len(p.text) {
+				b.WriteByte(p.text[p.pos])
+				p.pos++
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
@@ -0,0 +1,209 @@
+package lvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// On-disk format of lib/format_text/layout.h of LVM2. All numbers are
+// little endian.
+const (
+	sectorSize    = 512
+	labelSectors  = 4
+	mdaHeaderSize = 512
+
+	// labelHeader: id[8], sector u64, crc u32, offset u32, type[8]
+	labelHeaderSize = 32
+	// pvHeader: uuid[32], device size u64, then disk areas
+	pvHeaderSize = 40
+	// rawLocn: offset u64, size u64, checksum u32, flags u32
+	rawLocnSize = 24
+
+	rawLocnIgnored = 1
+
+	initialCRC = 0xf597a6cf
+)
+
+var (
+	labelID   = []byte("LABELONE")
+	labelType = []byte("LVM2 001")
+	mdaMagic  = []byte(" LVM2 x[5A%r0N*>")
+)
+
+// area is a disk_locn, a range of the device in bytes
+type area struct {
+	offset, size uint64
+}
+
+// label is the PV label and the metadata text it points to
+type label struct {
+	uuid string
+	// size is the device size in bytes when the PV was created
+	size      uint64
+	dataAreas []area
+	// metadata is the text of the first metadata area that has one, empty
+	// for orphan PVs and PVs without metadata copies
+	metadata string
+}
+
+// readLabel reads the PV label of one of the first sectors of the device.
+// It returns nil for devices that are not PVs.
+func readLabel(r io.ReaderAt) (*label, error) {
+	buf := make([]byte, labelSectors*sectorSize)
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "failed to read PV label")
+	}
+	buf = buf[:n]
+
+	for sector := 0; (sector+1)*sectorSize <= len(buf); sector++ {
+		s := buf[sector*sectorSize : (sector+1)*sectorSize]
+		if !bytes.Equal(s[:8], labelID) || !bytes.Equal(s[24:32], labelType) {
+			continue
+		}
+		// The checksum covers the sector from the offset field
+		if binary.LittleEndian.Uint64(s[8:]) != uint64(sector) || binary.LittleEndian.Uint32(s[16:]) != crc(s[20:]) {
+			continue
+		}
+		return parsePVHeader(r, s, int(binary.LittleEndian.Uint32(s[20:])))
+	}
+	return nil, nil
+}
+
+// parsePVHeader parses the PV header at off of the label sector and reads
+// the metadata it points to
+func parsePVHeader(r io.ReaderAt, s []byte, off int) (*label, error) {
+	if off < labelHeaderSize || off+pvHeaderSize > len(s) {
+		return nil, errors.Errorf("bad PV header offset %d", off)
+	}
+	h := s[off:]
+	l := &label{
+		uuid: formatID(string(h[:32])),
+		size: binary.LittleEndian.Uint64(h[32:]),
+	}
+
+	// Two lists of areas each ending with a zero entry: data areas, then
+	// metadata areas
+	var lists [2][]area
+	pos := pvHeaderSize
+	for i := range lists {
+		for {
+			if pos+16 > len(h) {
+				return nil, errors.New("PV header area lists are not terminated")
+			}
+			a := area{binary.LittleEndian.Uint64(h[pos:]), binary.LittleEndian.Uint64(h[pos+8:])}
+			pos += 16
+			if a.offset == 0 {
+				break
+			}
+			lists[i] = append(lists[i], a)
+		}
+	}
+	l.dataAreas = lists[0]
+
+	for _, mda := range lists[1] {
+		text, err := readMetadata(r, mda)
+		if err != nil {
+			return nil, err
+		}
+		if text != "" {
+			l.metadata = text
+			break
+		}
+	}
+	return l, nil
+}
+
+// readMetadata reads the current metadata text of a metadata area. The
+// area is a circular buffer after its header and the text may wrap around
+// its end.
+func readMetadata(r io.ReaderAt, mda area) (string, error) {
+	h := make([]byte, mdaHeaderSize)
+	if _, err := r.ReadAt(h, int64(mda.offset)); err != nil {
+		return "", errors.Wrapf(err, "failed to read metadata area header at %d", mda.offset)
+	}
+	if !bytes.Equal(h[4:20], mdaMagic) || binary.LittleEndian.Uint32(h[:4]) != crc(h[4:]) ||
+		binary.LittleEndian.Uint64(h[32:]) != mda.size {
+		return "", errors.Errorf("bad metadata area header at %d", mda.offset)
+	}
+	// The size bounds the text, so the area must be on the device
+	size := mda.size
+	end := mda.offset + size
+	if size <= mdaHeaderSize || end < mda.offset || end > math.MaxInt64 {
+		return "", errors.Errorf("bad metadata area size %d at %d", size, mda.offset)
+	}
+	if _, err := r.ReadAt(make([]byte, 1), int64(end-1)); err != nil {
+		return "", errors.Wrapf(err, "metadata area at %d of %d bytes is not on the device", mda.offset, size)
+	}
+
+	// Only the first raw location is used
+	loc := h[40 : 40+rawLocnSize]
+	off := binary.LittleEndian.Uint64(loc)
+	length := binary.LittleEndian.Uint64(loc[8:])
+	checksum := binary.LittleEndian.Uint32(loc[16:])
+	flags := binary.LittleEndian.Uint32(loc[20:])
+	if off == 0 || length == 0 || flags&rawLocnIgnored != 0 {
+		return "", nil
+	}
+	if off < mdaHeaderSize || off >= size || length > size-mdaHeaderSize {
+		return "", errors.Errorf("metadata location at %d is outside of the area at %d", off, mda.offset)
+	}
+
+	text := make([]byte, length)
+	first := length
+	if off+length > size {
+		first = size - off
+	}
+	if _, err := r.ReadAt(text[:first], int64(mda.offset+off)); err != nil {
+		return "", errors.Wrapf(err, "failed to read metadata at %d", mda.offset+off)
+	}
+	if first < length {
+		if _, err := r.ReadAt(text[first:], int64(mda.offset+mdaHeaderSize)); err != nil {
+			return "", errors.Wrapf(err, "failed to read metadata at %d", mda.offset+mdaHeaderSize)
+		}
+	}
+	if crc(text) != checksum {
+		return "", errors.Errorf("bad metadata checksum in the area at %d", mda.offset)
+	}
+
+	return strings.TrimRight(string(text), "\x00"), nil
+}
+
+// crc is the checksum of LVM2, CRC-32 with its own initial value and
+// without the final inversion
+func crc(b []byte) uint32 {
+	return ^crc32.Update(^uint32(initialCRC), crc32.IEEETable, b)
+}
+
+// formatID groups the 32 chars of an LVM id like lvm prints them:
+// 6-4-4-4-4-4-6
+func formatID(id string) string {
+	if len(id) != 32 {
+		return id
+	}
+	var parts []string
+	for _, n := range []int{6, 4, 4, 4, 4, 4, 6} {
+		parts = append(parts, id[:n])
+		id = id[n:]
+	}
+	return strings.Join(parts, "-")
+}
+
+// readDeviceLabel reads the PV label of the device node
+func readDeviceLabel(node string) (*label, error) {
+	f, err := os.Open(node)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", node)
+	}
+	defer f.Close()
+
+	l, err := readLabel(f)
+	return l, errors.Wrapf(err, "failed to read %v", node)
+}
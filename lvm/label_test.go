@@ -0,0 +1,224 @@
+package lvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	pvUUID = "aB3dEfGhIjKlMnOpQrStUvWxYz012345"
+	vgText = "vg0 {\nid = \"xyz\"\nseqno = 3\n}\n"
+
+	imageSize = 1 << 20
+	mdaOffset = 4096
+	mdaSize   = 64 << 10
+)
+
+// pvImage describes a PV to build, the zero value is a PV with one
+// metadata area holding vgText
+type pvImage struct {
+	labelSector int
+	// textOffset is where the text starts in the metadata area, right
+	// after its header if zero
+	textOffset uint64
+	flags      uint32
+	// corrupt damages the image after it's built
+	corrupt func(img []byte)
+}
+
+func (p pvImage) build() []byte {
+	img := make([]byte, imageSize)
+
+	s := img[p.labelSector*sectorSize : (p.labelSector+1)*sectorSize]
+	copy(s, labelID)
+	binary.LittleEndian.PutUint64(s[8:], uint64(p.labelSector))
+	binary.LittleEndian.PutUint32(s[20:], labelHeaderSize)
+	copy(s[24:], labelType)
+
+	h := s[labelHeaderSize:]
+	copy(h, pvUUID)
+	binary.LittleEndian.PutUint64(h[32:], imageSize)
+	// One data area, then one metadata area, each list ends with zeros
+	binary.LittleEndian.PutUint64(h[40:], 128<<10)
+	binary.LittleEndian.PutUint64(h[72:], mdaOffset)
+	binary.LittleEndian.PutUint64(h[80:], mdaSize)
+	binary.LittleEndian.PutUint32(s[16:], crc(s[20:]))
+
+	mda := img[mdaOffset : mdaOffset+mdaSize]
+	copy(mda[4:], mdaMagic)
+	binary.LittleEndian.PutUint32(mda[20:], 1)
+	binary.LittleEndian.PutUint64(mda[24:], mdaOffset)
+	binary.LittleEndian.PutUint64(mda[32:], mdaSize)
+
+	off := p.textOffset
+	if off == 0 {
+		off = mdaHeaderSize
+	}
+	text := []byte(vgText)
+	for i, b := range text {
+		pos := off + uint64(i)
+		if pos >= mdaSize {
+			// The area is a circular buffer after its header
+			pos = pos - mdaSize + mdaHeaderSize
+		}
+		mda[pos] = b
+	}
+	loc := mda[40:]
+	binary.LittleEndian.PutUint64(loc, off)
+	binary.LittleEndian.PutUint64(loc[8:], uint64(len(text)))
+	binary.LittleEndian.PutUint32(loc[16:], crc(text))
+	binary.LittleEndian.PutUint32(loc[20:], p.flags)
+	binary.LittleEndian.PutUint32(mda, crc(mda[4:mdaHeaderSize]))
+
+	if p.corrupt != nil {
+		p.corrupt(img)
+	}
+	return img
+}
+
+// resign updates the checksum of the metadata area header after a change
+func resign(img []byte) {
+	mda := img[mdaOffset:]
+	binary.LittleEndian.PutUint32(mda, crc(mda[4:mdaHeaderSize]))
+}
+
+func TestReadLabel(t *testing.T) {
+	want := &label{
+		uuid:      "aB3dEf-GhIj-KlMn-OpQr-StUv-WxYz-012345",
+		size:      imageSize,
+		dataAreas: []area{{offset: 128 << 10}},
+		metadata:  vgText,
+	}
+	withoutText := *want
+	withoutText.metadata = ""
+
+	tests := []struct {
+		name    string
+		img     pvImage
+		want    *label
+		wantErr string
+	}{
+		{
+			name: "second sector",
+			img:  pvImage{labelSector: 1},
+			want: want,
+		},
+		{
+			name: "fourth sector",
+			img:  pvImage{labelSector: 3},
+			want: want,
+		},
+		{
+			name: "wrapped text",
+			img:  pvImage{labelSector: 1, textOffset: mdaSize - 10},
+			want: want,
+		},
+		{
+			name: "ignored location",
+			img:  pvImage{labelSector: 1, flags: rawLocnIgnored},
+			want: &withoutText,
+		},
+		{
+			name: "not a PV",
+			img:  pvImage{labelSector: 1, corrupt: func(img []byte) { copy(img[sectorSize:], "NOTALABL") }},
+		},
+		{
+			name: "bad label checksum",
+			img:  pvImage{labelSector: 1, corrupt: func(img []byte) { img[sectorSize+labelHeaderSize]++ }},
+		},
+		{
+			name: "label sector mismatch",
+			img: pvImage{labelSector: 1, corrupt: func(img []byte) {
+				s := img[sectorSize : 2*sectorSize]
+				binary.LittleEndian.PutUint64(s[8:], 2)
+				binary.LittleEndian.PutUint32(s[16:], crc(s[20:]))
+			}},
+		},
+		{
+			name: "bad PV header offset",
+			img: pvImage{labelSector: 1, corrupt: func(img []byte) {
+				s := img[sectorSize : 2*sectorSize]
+				binary.LittleEndian.PutUint32(s[20:], sectorSize-8)
+				binary.LittleEndian.PutUint32(s[16:], crc(s[20:]))
+			}},
+			wantErr: "bad PV header offset",
+		},
+		{
+			name: "unterminated area lists",
+			img: pvImage{labelSector: 1, corrupt: func(img []byte) {
+				s := img[sectorSize : 2*sectorSize]
+				for i := labelHeaderSize + pvHeaderSize; i < sectorSize; i += 8 {
+					binary.LittleEndian.PutUint64(s[i:], 1)
+				}
+				binary.LittleEndian.PutUint32(s[16:], crc(s[20:]))
+			}},
+			wantErr: "not terminated",
+		},
+		{
+			name:    "bad metadata area header checksum",
+			img:     pvImage{labelSector: 1, corrupt: func(img []byte) { img[mdaOffset+30]++ }},
+			wantErr: "bad metadata area header",
+		},
+		{
+			name: "metadata area size mismatch",
+			img: pvImage{labelSector: 1, corrupt: func(img []byte) {
+				binary.LittleEndian.PutUint64(img[mdaOffset+32:], mdaSize/2)
+				resign(img)
+			}},
+			wantErr: "bad metadata area header",
+		},
+		{
+			name: "metadata area past the device",
+			img: pvImage{labelSector: 1, corrupt: func(img []byte) {
+				s := img[sectorSize : 2*sectorSize]
+				binary.LittleEndian.PutUint64(s[labelHeaderSize+80:], 2*imageSize)
+				binary.LittleEndian.PutUint32(s[16:], crc(s[20:]))
+				binary.LittleEndian.PutUint64(img[mdaOffset+32:], 2*imageSize)
+				resign(img)
+			}},
+			wantErr: "not on the device",
+		},
+		{
+			name: "text outside of the area",
+			img: pvImage{labelSector: 1, corrupt: func(img []byte) {
+				binary.LittleEndian.PutUint64(img[mdaOffset+40:], mdaSize)
+				resign(img)
+			}},
+			wantErr: "outside of the area",
+		},
+		{
+			name: "text longer than the area",
+			img: pvImage{labelSector: 1, corrupt: func(img []byte) {
+				binary.LittleEndian.PutUint64(img[mdaOffset+48:], mdaSize)
+				resign(img)
+			}},
+			wantErr: "outside of the area",
+		},
+		{
+			name:    "bad metadata checksum",
+			img:     pvImage{labelSector: 1, corrupt: func(img []byte) { img[mdaOffset+mdaHeaderSize]++ }},
+			wantErr: "bad metadata checksum",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readLabel(bytes.NewReader(tt.img.build()))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("readLabel() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readLabel() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,436 @@
+// Package lvm discovers LVM2 physical volumes, volume groups and logical
+// volumes without the lvm tools. The topology comes from the metadata
+// stored on the PVs, active LVs are matched to their device-mapper devices
+// by dm UUID.
+package lvm
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/alexdzyoba/sys/block"
+	"github.com/pkg/errors"
+)
+
+// dmUUIDPrefix starts dm UUIDs of LVs, followed by the VG and LV ids
+// without dashes and an optional suffix of internal layers like "-tpool"
+const dmUUIDPrefix = "LVM-"
+
+// Topology is the LVM setup found on the block devices
+type Topology struct {
+	VGs []VG
+	// Orphans are PVs not in any volume group
+	Orphans []PV
+}
+
+// VG is a volume group
+type VG struct {
+	Name string
+	UUID string
+	// Seqno is incremented on every metadata change
+	Seqno uint64
+	// ExtentSize is the allocation unit in bytes
+	ExtentSize uint64
+	PVs        []PV
+	// LVs include hidden ones like mirror images and thin pool data,
+	// see LV.Visible
+	LVs []LV
+}
+
+// PV is a physical volume
+type PV struct {
+	// Device is the kernel name like "sdb1", empty for missing PVs
+	Device string
+	UUID   string
+	// DeviceHint is the path the PV had when metadata was written
+	DeviceHint string
+	// Size is in bytes
+	Size uint64
+	// PEStart is the offset of the first extent in bytes
+	PEStart     uint64
+	ExtentCount uint64
+	FreeExtents uint64
+	Allocatable bool
+	Missing     bool
+	extentSize  uint64
+}
+
+// Free returns the unallocated space of the PV in bytes
+func (pv PV) Free() uint64 {
+	return pv.FreeExtents * pv.extentSize
+}
+
+// LV is a logical volume
+type LV struct {
+	Name string
+	UUID string
+	// Size is in bytes
+	Size uint64
+	// Visible is false for LVs internal to others
+	Visible  bool
+	Status   []string
+	Segments []Segment
+	// Device is the kernel name of the dm device like "dm-0", empty when
+	// the LV is not active
+	Device string
+}
+
+// Segment is a range of extents of an LV with one layout
+type Segment struct {
+	// StartExtent and ExtentCount are in the extents of the LV
+	StartExtent uint64
+	ExtentCount uint64
+	// Type is the layout like "striped", "raid1", "thin-pool" or "thin"
+	Type string
+	// StripeSize is in bytes, zero for a single stripe
+	StripeSize uint64
+	// Areas are where linear and striped segments are stored
+	Areas []Area
+	// LVs are the LVs the segment is built on, like raid images or the
+	// pool of a thin LV
+	LVs []string
+}
+
+// Area is a range of extents of a PV holding a stripe of a segment
+type Area struct {
+	// PV is the UUID of the PV
+	PV          string
+	StartExtent uint64
+	ExtentCount uint64
+}
+
+// Size returns the size of the VG in bytes
+func (vg VG) Size() uint64 {
+	var extents uint64
+	for _, pv := range vg.PVs {
+		extents += pv.ExtentCount
+	}
+	return extents * vg.ExtentSize
+}
+
+// Free returns the unallocated space of the VG in bytes
+func (vg VG) Free() uint64 {
+	var extents uint64
+	for _, pv := range vg.PVs {
+		extents += pv.FreeExtents
+	}
+	return extents * vg.ExtentSize
+}
+
+// LV returns the LV of the name
+func (vg VG) LV(name string) (LV, bool) {
+	for _, lv := range vg.LVs {
+		if lv.Name == name {
+			return lv, true
+		}
+	}
+	return LV{}, false
+}
+
+// PVsOf returns the PVs the LV is stored on in VG order, following
+// the LVs it is built on
+func (vg VG) PVsOf(name string) []PV {
+	uuids := map[string]bool{}
+	seen := map[string]bool{}
+	var walk func(string)
+	walk = func(name string) {
+		lv, ok := vg.LV(name)
+		if !ok || seen[name] {
+			return
+		}
+		seen[name] = true
+		for _, s := range lv.Segments {
+			for _, a := range s.Areas {
+				uuids[a.PV] = true
+			}
+			for _, sub := range s.LVs {
+				walk(sub)
+			}
+		}
+	}
+	walk(name)
+
+	var pvs []PV
+	for _, pv := range vg.PVs {
+		if uuids[pv.UUID] {
+			pvs = append(pvs, pv)
+		}
+	}
+	return pvs
+}
+
+// Scan reads PV labels of all block devices and partitions and returns the
+// volume groups they make up. Reading devices needs root, unreadable ones
+// are skipped. Each VG is built from the newest metadata found on its PVs.
+func Scan() (*Topology, error) {
+	ds, err := block.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, d := range ds {
+		names = append(names, d.Name)
+		ps, err := d.Partitions()
+		if err != nil {
+			if errors.Is(err, block.ErrDeviceGone) {
+				continue
+			}
+			return nil, err
+		}
+		for _, p := range ps {
+			names = append(names, p.Name)
+		}
+	}
+
+	labels := map[string]*label{}
+	for _, name := range names {
+		l, err := readDeviceLabel(path.Join("/dev", name))
+		if err != nil || l == nil {
+			continue
+		}
+		labels[name] = l
+	}
+
+	t, err := build(labels)
+	if err != nil {
+		return nil, err
+	}
+	t.activate(ds)
+	return t, nil
+}
+
+// build makes the topology of labels keyed by device name
+func build(labels map[string]*label) (*Topology, error) {
+	// The newest metadata of each VG, PVs may have older copies
+	newest := map[string]*section{}
+	for _, l := range labels {
+		if l.metadata == "" {
+			continue
+		}
+		root, err := parseConfig(l.metadata)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse metadata of PV %s", l.uuid)
+		}
+		vg := vgSection(root)
+		if vg == nil {
+			continue
+		}
+		id := vg.str("id")
+		if cur, ok := newest[id]; !ok || vg.uint("seqno") > cur.uint("seqno") {
+			newest[id] = vg
+		}
+	}
+
+	var names []string
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Multipath PVs have their label on every path and on the dm device
+	// on top of them, which is the one LVM uses
+	devices := map[string]string{}
+	for _, name := range names {
+		uuid := labels[name].uuid
+		if cur, ok := devices[uuid]; !ok || isDM(name) && !isDM(cur) {
+			devices[uuid] = name
+		}
+	}
+
+	t := &Topology{}
+	members := map[string]bool{}
+	for _, s := range newest {
+		vg := parseVG(s, devices)
+		for _, pv := range vg.PVs {
+			members[pv.UUID] = true
+		}
+		t.VGs = append(t.VGs, vg)
+	}
+	sort.Slice(t.VGs, func(i, j int) bool { return t.VGs[i].Name < t.VGs[j].Name })
+
+	for _, name := range names {
+		l := labels[name]
+		if members[l.uuid] || devices[l.uuid] != name {
+			continue
+		}
+		pv := PV{Device: name, UUID: l.uuid, Size: l.size, Allocatable: true}
+		if len(l.dataAreas) > 0 {
+			pv.PEStart = l.dataAreas[0].offset
+		}
+		t.Orphans = append(t.Orphans, pv)
+	}
+	sort.Slice(t.Orphans, func(i, j int) bool { return t.Orphans[i].Device < t.Orphans[j].Device })
+
+	return t, nil
+}
+
+func isDM(name string) bool {
+	return strings.HasPrefix(name, "dm-")
+}
+
+// vgSection returns the section of the VG, the only one at the top level
+// next to values like contents and creation_time
+func vgSection(root *section) *section {
+	if len(root.children) != 1 || root.children[0].child("physical_volumes") == nil {
+		return nil
+	}
+	return root.children[0]
+}
+
+// parseVG converts the VG section, devices maps PV UUIDs to the device
+// names they were found on
+func parseVG(s *section, devices map[string]string) VG {
+	extentSize := s.uint("extent_size") * sectorSize
+	vg := VG{
+		Name:       s.name,
+		UUID:       s.str("id"),
+		Seqno:      s.uint("seqno"),
+		ExtentSize: extentSize,
+	}
+
+	// Segments refer to PVs by their section names like "pv0"
+	pvIndex := map[string]int{}
+	if pvs := s.child("physical_volumes"); pvs != nil {
+		for _, p := range pvs.children {
+			pv := PV{
+				UUID:        p.str("id"),
+				DeviceHint:  p.str("device"),
+				Size:        p.uint("dev_size") * sectorSize,
+				PEStart:     p.uint("pe_start") * sectorSize,
+				ExtentCount: p.uint("pe_count"),
+				Allocatable: p.flag("status", "ALLOCATABLE"),
+				extentSize:  extentSize,
+			}
+			pv.FreeExtents = pv.ExtentCount
+			pv.Device = devices[pv.UUID]
+			pv.Missing = pv.Device == "" || p.flag("flags", "MISSING")
+			pvIndex[p.name] = len(vg.PVs)
+			vg.PVs = append(vg.PVs, pv)
+		}
+	}
+
+	if lvs := s.child("logical_volumes"); lvs != nil {
+		for _, l := range lvs.children {
+			lv := parseLV(l, vg.PVs, pvIndex)
+			lv.Size *= extentSize
+			for _, seg := range lv.Segments {
+				for _, a := range seg.Areas {
+					for i := range vg.PVs {
+						if vg.PVs[i].UUID == a.PV && vg.PVs[i].FreeExtents >= a.ExtentCount {
+							vg.PVs[i].FreeExtents -= a.ExtentCount
+						}
+					}
+				}
+			}
+			vg.LVs = append(vg.LVs, lv)
+		}
+	}
+
+	return vg
+}
+
+// parseLV converts an LV section, its size is left in extents
+func parseLV(s *section, pvs []PV, pvIndex map[string]int) LV {
+	lv := LV{
+		Name:    s.name,
+		UUID:    s.str("id"),
+		Visible: s.flag("status", "VISIBLE"),
+	}
+	for _, v := range s.list("status") {
+		if str, ok := v.(string); ok {
+			lv.Status = append(lv.Status, str)
+		}
+	}
+
+	for _, c := range s.children {
+		if !strings.HasPrefix(c.name, "segment") {
+			continue
+		}
+		seg := Segment{
+			StartExtent: c.uint("start_extent"),
+			ExtentCount: c.uint("extent_count"),
+			Type:        c.str("type"),
+			StripeSize:  c.uint("stripe_size") * sectorSize,
+		}
+
+		// Stripes are pairs of PV name and first extent, each storing an
+		// equal part of the segment. Mirror legs of old metadata may be
+		// PVs too and store the whole segment each.
+		for _, key := range []string{"stripes", "mirrors"} {
+			list := c.list(key)
+			legs := uint64(len(list) / 2)
+			for i := 0; i+1 < len(list); i += 2 {
+				name, _ := list[i].(string)
+				start, _ := list[i+1].(int64)
+				idx, ok := pvIndex[name]
+				if !ok {
+					seg.LVs = append(seg.LVs, name)
+					continue
+				}
+				count := seg.ExtentCount
+				if key == "stripes" {
+					count /= legs
+				}
+				seg.Areas = append(seg.Areas, Area{PV: pvs[idx].UUID, StartExtent: uint64(start), ExtentCount: count})
+			}
+		}
+		// raid lists metadata and image LVs without extents
+		for _, v := range c.list("raids") {
+			if name, ok := v.(string); ok {
+				seg.LVs = append(seg.LVs, name)
+			}
+		}
+		for _, key := range []string{"thin_pool", "pool", "data", "metadata", "origin", "cow_store", "cache_pool", "writecache", "vdo_pool", "integrity_meta"} {
+			if name := c.str(key); name != "" {
+				seg.LVs = append(seg.LVs, name)
+			}
+		}
+
+		lv.Size += seg.ExtentCount
+		lv.Segments = append(lv.Segments, seg)
+	}
+
+	return lv
+}
+
+// activate sets LV devices from dm devices with LVM UUIDs
+func (t *Topology) activate(ds []block.Device) {
+	for _, d := range ds {
+		vgID, lvID, suffix, ok := parseDMUUID(d.DMUUID)
+		if !ok {
+			continue
+		}
+		for i := range t.VGs {
+			vg := &t.VGs[i]
+			if strings.ReplaceAll(vg.UUID, "-", "") != vgID {
+				continue
+			}
+			for j := range vg.LVs {
+				lv := &vg.LVs[j]
+				if strings.ReplaceAll(lv.UUID, "-", "") != lvID {
+					continue
+				}
+				// Layers like "-real" of an origin or "-tpool" of a thin
+				// pool share the id of the LV, the plain one wins
+				if suffix == "" || lv.Device == "" {
+					lv.Device = d.Name
+				}
+			}
+		}
+	}
+}
+
+// parseDMUUID splits a dm UUID of an LV into the VG and LV ids without
+// dashes and the layer suffix
+func parseDMUUID(uuid string) (string, string, string, bool) {
+	if !strings.HasPrefix(uuid, dmUUIDPrefix) {
+		return "", "", "", false
+	}
+	ids := strings.TrimPrefix(uuid, dmUUIDPrefix)
+	if len(ids) < 64 {
+		return "", "", "", false
+	}
+	return ids[:32], ids[32:64], strings.TrimPrefix(ids[64:], "-"), true
+}
@@ -0,0 +1,95 @@
+package mkfs
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/alexdzyoba/sys/probe"
+	"github.com/pkg/errors"
+)
+
+// Options configures filesystem creation
+type Options struct {
+	Label string
+	// Args are extra arguments passed to mkfs.<type> as is
+	Args []string
+}
+
+// Format creates a filesystem of the given type on the device by running
+// mkfs.<fstype>
+func Format(ctx context.Context, device, fstype string, opts Options) error {
+	args := mkfsArgs(device, fstype, opts)
+	return action.Run(ctx, formatAction(device, fstype, args), func() error {
+		return format(ctx, fstype, args)
+	})
+}
+
+// EnsureFilesystem formats the device unless it already holds a filesystem
+// of the given type. A device with any other signature is never touched
+// and an error is returned instead. EnsureFilesystem returns whether a
+// change was made.
+func EnsureFilesystem(ctx context.Context, device, fstype string, opts Options) (bool, error) {
+	current, err := detect(device)
+	if err != nil {
+		return false, err
+	}
+	if current != "" && current != fstype {
+		return false, errors.Errorf("%s already contains %s signature", device, current)
+	}
+
+	args := mkfsArgs(device, fstype, opts)
+	return action.Ensure(ctx, formatAction(device, fstype, args),
+		func() (bool, error) { return current == fstype, nil },
+		func() error { return format(ctx, fstype, args) })
+}
+
+func mkfsArgs(device, fstype string, opts Options) []string {
+	args := append([]string{}, opts.Args...)
+	if opts.Label != "" {
+		args = append(args, labelFlag(fstype), opts.Label)
+	}
+	return append(args, device)
+}
+
+// labelFlag returns the mkfs.<fstype> option setting the label, -n for the
+// FAT family and -L for ext*, xfs, btrfs, swap and the rest
+func labelFlag(fstype string) string {
+	switch fstype {
+	case "vfat", "fat", "msdos":
+		return "-n"
+	}
+	return "-L"
+}
+
+func formatAction(device, fstype string, args []string) action.Action {
+	return action.Action{
+		Op:     "mkfs",
+		Target: device,
+		Args:   map[string]string{"fstype": fstype, "args": strings.Join(args, " ")},
+	}
+}
+
+func format(ctx context.Context, fstype string, args []string) error {
+	out, err := exec.CommandContext(ctx, "mkfs."+fstype, args...).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "mkfs.%s failed: %s", fstype, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// detect returns the type of signature found on the device or empty
+// string if there is none. Partition tables count as signatures so
+// partitioned disks are never formatted.
+func detect(device string) (string, error) {
+	res, err := probe.Probe(device)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to probe %s", device)
+	}
+	if res == nil {
+		return "", nil
+	}
+	return res.Type, nil
+}
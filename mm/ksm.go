@@ -0,0 +1,94 @@
+package mm
+
+import (
+	"context"
+	"os"
+	"path"
+	"time"
+
+	"github.com/alexdzyoba/sys/sysfs"
+	"github.com/pkg/errors"
+)
+
+var ksmRoot = path.Join(mmRoot, "ksm")
+
+// KSMRun is the state of the samepage merging daemon
+type KSMRun int
+
+const (
+	// KSMStopped stops merging and keeps pages merged so far
+	KSMStopped KSMRun = iota
+	KSMRunning
+	// KSMUnmerge stops merging and unmerges all pages, which needs memory
+	// for every copy
+	KSMUnmerge
+)
+
+// KSM is the kernel samepage merging configuration and its counters
+type KSM struct {
+	Run KSMRun
+	// PagesToScan are scanned every Sleep
+	PagesToScan uint64
+	Sleep       time.Duration
+	// MergeAcrossNodes merges pages of different NUMA nodes
+	MergeAcrossNodes bool
+	// UseZeroPages merges empty pages with the zero page
+	UseZeroPages bool
+	// MaxPageSharing limits how many pages share one merged page
+	MaxPageSharing uint64
+
+	// PagesShared are merged pages in use and PagesSharing how many more
+	// pages they replace
+	PagesShared  uint64
+	PagesSharing uint64
+	// PagesUnshared are unique pages scanned repeatedly, PagesVolatile
+	// change too fast to merge
+	PagesUnshared uint64
+	PagesVolatile uint64
+	FullScans     uint64
+	// ZeroPages were merged with the zero page, zero before Linux 6.6
+	ZeroPages uint64
+	// GeneralProfit is the memory saved minus the metadata cost in bytes,
+	// zero before Linux 6.1
+	GeneralProfit int64
+}
+
+// Saved returns how much memory merging saves in bytes, not counting
+// its own metadata
+func (k KSM) Saved() uint64 {
+	return (k.PagesSharing + k.ZeroPages) * uint64(os.Getpagesize())
+}
+
+// ReadKSM reads the samepage merging state. Kernels built without KSM
+// don't have it and an error is returned.
+func ReadKSM() (*KSM, error) {
+	a := &attrs{dir: ksmRoot}
+	k := &KSM{
+		Run:              attrValue(a, "run", sysfs.Int[KSMRun]),
+		PagesToScan:      attrValue(a, "pages_to_scan", sysfs.Int[uint64]),
+		Sleep:            attrValue(a, "sleep_millisecs", millis),
+		MergeAcrossNodes: optional(a, "merge_across_nodes", sysfs.Bool),
+		UseZeroPages:     optional(a, "use_zero_pages", sysfs.Bool),
+		MaxPageSharing:   optional(a, "max_page_sharing", sysfs.Int[uint64]),
+		PagesShared:      attrValue(a, "pages_shared", sysfs.Int[uint64]),
+		PagesSharing:     attrValue(a, "pages_sharing", sysfs.Int[uint64]),
+		PagesUnshared:    attrValue(a, "pages_unshared", sysfs.Int[uint64]),
+		PagesVolatile:    attrValue(a, "pages_volatile", sysfs.Int[uint64]),
+		FullScans:        attrValue(a, "full_scans", sysfs.Int[uint64]),
+		ZeroPages:        optional(a, "ksm_zero_pages", sysfs.Int[uint64]),
+		GeneralProfit:    optional(a, "general_profit", sysfs.Int[int64]),
+	}
+	if a.err != nil {
+		return nil, errors.Wrap(a.err, "failed to read KSM settings")
+	}
+	return k, nil
+}
+
+// SetKSMRun starts or stops merging. Only memory of applications marked
+// with madvise(MADV_MERGEABLE) or prctl(PR_SET_MEMORY_MERGE) is merged.
+func SetKSMRun(ctx context.Context, run KSMRun) error {
+	if run < KSMStopped || run > KSMUnmerge {
+		return errors.Errorf("invalid KSM run state %d", run)
+	}
+	return sysfs.Write(ctx, path.Join(ksmRoot, "run"), run, sysfs.FormatInt[KSMRun])
+}
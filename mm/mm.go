@@ -0,0 +1,59 @@
+// Package mm reports and sets transparent hugepage, kernel samepage
+// merging and zswap settings from /sys/kernel/mm and the zswap module
+// parameters. Setting them requires root.
+package mm
+
+import (
+	"os"
+	"path"
+	"time"
+
+	"github.com/alexdzyoba/sys/sysfs"
+	"github.com/pkg/errors"
+)
+
+const mmRoot = "/sys/kernel/mm"
+
+// attrs reads attributes of a directory keeping the first error, so a
+// status struct can be filled without checking every field
+type attrs struct {
+	dir string
+	err error
+}
+
+func attrValue[T any](a *attrs, name string, parse sysfs.Parser[T]) T {
+	var zero T
+	if a.err != nil {
+		return zero
+	}
+	v, err := sysfs.Read(path.Join(a.dir, name), parse)
+	if err != nil {
+		a.err = err
+	}
+	return v
+}
+
+// optional reads an attribute newer kernels added, it's zero when missing
+func optional[T any](a *attrs, name string, parse sysfs.Parser[T]) T {
+	var zero T
+	if _, err := os.Stat(path.Join(a.dir, name)); os.IsNotExist(err) {
+		return zero
+	}
+	return attrValue(a, name, parse)
+}
+
+// checkMode validates a value for an attribute taking one of choices
+func checkMode[T ~string](attr string, v T, choices ...T) error {
+	for _, c := range choices {
+		if v == c {
+			return nil
+		}
+	}
+	return errors.Errorf("invalid %s mode %q", attr, v)
+}
+
+// millis parses a duration in milliseconds
+func millis(s string) (time.Duration, error) {
+	v, err := sysfs.Int[int64](s)
+	return time.Duration(v) * time.Millisecond, err
+}
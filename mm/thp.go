@@ -0,0 +1,221 @@
+package mm
+
+import (
+	"context"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alexdzyoba/sys/sysfs"
+	"github.com/pkg/errors"
+)
+
+var thpRoot = path.Join(mmRoot, "transparent_hugepage")
+
+// THPMode is when anonymous memory gets huge pages
+type THPMode string
+
+const (
+	THPAlways THPMode = "always"
+	// THPMadvise only uses huge pages for regions marked with
+	// madvise(MADV_HUGEPAGE)
+	THPMadvise THPMode = "madvise"
+	THPNever   THPMode = "never"
+	// THPInherit makes a huge page size follow the global mode, it's only
+	// valid per size
+	THPInherit THPMode = "inherit"
+)
+
+// DefragMode is how hard page faults try to get a huge page when none is
+// free
+type DefragMode string
+
+const (
+	// DefragAlways stalls faults for direct reclaim and compaction
+	DefragAlways DefragMode = "always"
+	// DefragDefer wakes kswapd and kcompactd and falls back to small pages
+	DefragDefer DefragMode = "defer"
+	// DefragDeferMadvise stalls madvised regions and defers others
+	DefragDeferMadvise DefragMode = "defer+madvise"
+	// DefragMadvise stalls madvised regions only
+	DefragMadvise DefragMode = "madvise"
+	DefragNever   DefragMode = "never"
+)
+
+// ShmemMode is when tmpfs and shared anonymous memory get huge pages
+type ShmemMode string
+
+const (
+	ShmemAlways ShmemMode = "always"
+	// ShmemWithinSize only uses huge pages fully inside the file size
+	ShmemWithinSize ShmemMode = "within_size"
+	ShmemAdvise     ShmemMode = "advise"
+	ShmemNever      ShmemMode = "never"
+	// ShmemDeny disables huge pages even for mounts asking for them
+	ShmemDeny ShmemMode = "deny"
+	// ShmemForce enables huge pages for all mounts, for testing
+	ShmemForce ShmemMode = "force"
+	// ShmemInherit makes a huge page size follow the global mode, it's
+	// only valid per size
+	ShmemInherit ShmemMode = "inherit"
+)
+
+var (
+	thpModes    = []THPMode{THPAlways, THPMadvise, THPNever, THPInherit}
+	defragModes = []DefragMode{DefragAlways, DefragDefer, DefragDeferMadvise, DefragMadvise, DefragNever}
+	shmemModes  = []ShmemMode{ShmemAlways, ShmemWithinSize, ShmemAdvise, ShmemNever, ShmemDeny, ShmemForce, ShmemInherit}
+)
+
+// THP is the transparent hugepage configuration
+type THP struct {
+	Enabled      THPMode
+	Defrag       DefragMode
+	ShmemEnabled ShmemMode
+	// PMDSize is the size of a PMD mapped huge page in bytes
+	PMDSize uint64
+	// UseZeroPage maps the huge zero page on read faults
+	UseZeroPage bool
+	Khugepaged  Khugepaged
+	// Sizes are the huge page sizes of multi-size THP ordered by size,
+	// empty before Linux 6.8
+	Sizes []THPSize
+}
+
+// Khugepaged is the daemon collapsing small pages into huge pages
+type Khugepaged struct {
+	// Defrag lets it reclaim and compact to get huge pages
+	Defrag bool
+	// PagesToScan are scanned every ScanSleep
+	PagesToScan uint64
+	ScanSleep   time.Duration
+	// AllocSleep is the wait after a failed huge page allocation
+	AllocSleep     time.Duration
+	FullScans      uint64
+	PagesCollapsed uint64
+}
+
+// THPSize is the configuration and counters of one huge page size
+type THPSize struct {
+	// Size is in bytes
+	Size uint64
+	// Enabled is empty for sizes only shared memory can use
+	Enabled      THPMode
+	ShmemEnabled ShmemMode
+	// Stats are the counters of stats/ like anon_fault_alloc and split
+	Stats map[string]uint64
+}
+
+// ReadTHP reads the transparent hugepage configuration
+func ReadTHP() (*THP, error) {
+	a := &attrs{dir: thpRoot}
+	t := &THP{
+		Enabled:      attrValue(a, "enabled", sysfs.Enum(thpModes...)),
+		Defrag:       attrValue(a, "defrag", sysfs.Enum(defragModes...)),
+		ShmemEnabled: optional(a, "shmem_enabled", sysfs.Enum(shmemModes...)),
+		PMDSize:      optional(a, "hpage_pmd_size", sysfs.Int[uint64]),
+		UseZeroPage:  optional(a, "use_zero_page", sysfs.Bool),
+	}
+
+	k := &attrs{dir: path.Join(thpRoot, "khugepaged")}
+	t.Khugepaged = Khugepaged{
+		Defrag:         attrValue(k, "defrag", sysfs.Bool),
+		PagesToScan:    attrValue(k, "pages_to_scan", sysfs.Int[uint64]),
+		ScanSleep:      attrValue(k, "scan_sleep_millisecs", millis),
+		AllocSleep:     attrValue(k, "alloc_sleep_millisecs", millis),
+		FullScans:      attrValue(k, "full_scans", sysfs.Int[uint64]),
+		PagesCollapsed: attrValue(k, "pages_collapsed", sysfs.Int[uint64]),
+	}
+	for _, err := range []error{a.err, k.err} {
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read transparent hugepage settings")
+		}
+	}
+
+	sizes, err := thpSizes()
+	if err != nil {
+		return nil, err
+	}
+	t.Sizes = sizes
+
+	return t, nil
+}
+
+func thpSizes() ([]THPSize, error) {
+	entries, err := ioutil.ReadDir(thpRoot)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", thpRoot)
+	}
+
+	var sizes []THPSize
+	for _, e := range entries {
+		kb := strings.TrimSuffix(strings.TrimPrefix(e.Name(), "hugepages-"), "kB")
+		if !e.IsDir() || kb == e.Name() {
+			continue
+		}
+		size, err := sysfs.KiB(kb)
+		if err != nil {
+			continue
+		}
+
+		a := &attrs{dir: path.Join(thpRoot, e.Name())}
+		s := THPSize{
+			Size:         size,
+			Enabled:      optional(a, "enabled", sysfs.Enum(thpModes...)),
+			ShmemEnabled: optional(a, "shmem_enabled", sysfs.Enum(shmemModes...)),
+			Stats:        map[string]uint64{},
+		}
+		if a.err != nil {
+			return nil, errors.Wrap(a.err, "failed to read transparent hugepage settings")
+		}
+
+		stats := &attrs{dir: path.Join(a.dir, "stats")}
+		names, _ := ioutil.ReadDir(stats.dir)
+		for _, n := range names {
+			s.Stats[n.Name()] = attrValue(stats, n.Name(), sysfs.Int[uint64])
+		}
+		if stats.err != nil {
+			return nil, errors.Wrap(stats.err, "failed to read transparent hugepage stats")
+		}
+
+		sizes = append(sizes, s)
+	}
+
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i].Size < sizes[j].Size })
+	return sizes, nil
+}
+
+// SetTHPEnabled sets when anonymous memory gets huge pages
+func SetTHPEnabled(ctx context.Context, m THPMode) error {
+	if err := checkMode("transparent hugepage", m, THPAlways, THPMadvise, THPNever); err != nil {
+		return err
+	}
+	return sysfs.Write(ctx, path.Join(thpRoot, "enabled"), m, sysfs.FormatString[THPMode])
+}
+
+// SetTHPDefrag sets how hard faults try to get huge pages
+func SetTHPDefrag(ctx context.Context, m DefragMode) error {
+	if err := checkMode("defrag", m, defragModes...); err != nil {
+		return err
+	}
+	return sysfs.Write(ctx, path.Join(thpRoot, "defrag"), m, sysfs.FormatString[DefragMode])
+}
+
+// SetTHPShmemEnabled sets when shared memory gets huge pages
+func SetTHPShmemEnabled(ctx context.Context, m ShmemMode) error {
+	if err := checkMode("shmem", m, ShmemAlways, ShmemWithinSize, ShmemAdvise, ShmemNever, ShmemDeny, ShmemForce); err != nil {
+		return err
+	}
+	return sysfs.Write(ctx, path.Join(thpRoot, "shmem_enabled"), m, sysfs.FormatString[ShmemMode])
+}
+
+// SetTHPSizeEnabled sets when anonymous memory gets huge pages of the size
+// in bytes, THPInherit follows SetTHPEnabled
+func SetTHPSizeEnabled(ctx context.Context, size uint64, m THPMode) error {
+	if err := checkMode("transparent hugepage", m, thpModes...); err != nil {
+		return err
+	}
+	p := path.Join(thpRoot, "hugepages-"+sysfs.FormatKiB(size)+"kB", "enabled")
+	return sysfs.Write(ctx, p, m, sysfs.FormatString[THPMode])
+}
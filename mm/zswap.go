@@ -0,0 +1,93 @@
+package mm
+
+import (
+	"context"
+	"path"
+
+	"github.com/alexdzyoba/sys/sysfs"
+	"github.com/pkg/errors"
+)
+
+// zswapRoot holds the module parameters, zswap has no directory in mm
+const zswapRoot = "/sys/module/zswap/parameters"
+
+// Zswap is the configuration of the compressed cache for swapped pages
+type Zswap struct {
+	Enabled bool
+	// Compressor is the crypto API algorithm like "lzo" or "zstd"
+	Compressor string
+	// Zpool is the allocator like "zsmalloc", empty since Linux 6.15
+	// where zsmalloc is the only one
+	Zpool string
+	// MaxPoolPercent caps the pool at a percentage of RAM
+	MaxPoolPercent int
+	// AcceptThresholdPercent is how far below the cap the pool has to
+	// shrink before storing pages again after it got full
+	AcceptThresholdPercent int
+	// ShrinkerEnabled writes cold pages back to swap under memory
+	// pressure
+	ShrinkerEnabled bool
+}
+
+// ReadZswap reads the zswap configuration. Kernels built without zswap
+// don't have it and an error is returned.
+func ReadZswap() (*Zswap, error) {
+	a := &attrs{dir: zswapRoot}
+	z := &Zswap{
+		Enabled:                attrValue(a, "enabled", sysfs.Bool),
+		Compressor:             attrValue(a, "compressor", sysfs.String),
+		Zpool:                  optional(a, "zpool", sysfs.String),
+		MaxPoolPercent:         attrValue(a, "max_pool_percent", sysfs.Int[int]),
+		AcceptThresholdPercent: optional(a, "accept_threshold_percent", sysfs.Int[int]),
+		ShrinkerEnabled:        optional(a, "shrinker_enabled", sysfs.Bool),
+	}
+	if a.err != nil {
+		return nil, errors.Wrap(a.err, "failed to read zswap settings")
+	}
+	return z, nil
+}
+
+// SetZswapEnabled turns zswap on or off. Pages already stored stay
+// compressed until they are faulted in or written back.
+func SetZswapEnabled(ctx context.Context, enabled bool) error {
+	return sysfs.Write(ctx, path.Join(zswapRoot, "enabled"), enabled, sysfs.FormatBool)
+}
+
+// SetZswapCompressor switches the algorithm for newly stored pages, the
+// kernel rejects ones it doesn't have
+func SetZswapCompressor(ctx context.Context, compressor string) error {
+	if compressor == "" {
+		return errors.New("empty zswap compressor")
+	}
+	return sysfs.Write(ctx, path.Join(zswapRoot, "compressor"), compressor, sysfs.FormatString[string])
+}
+
+// SetZswapMaxPoolPercent caps the pool at a percentage of RAM
+func SetZswapMaxPoolPercent(ctx context.Context, percent int) error {
+	if err := checkPercent("max pool", percent); err != nil {
+		return err
+	}
+	return sysfs.Write(ctx, path.Join(zswapRoot, "max_pool_percent"), percent, sysfs.FormatInt[int])
+}
+
+// SetZswapAcceptThresholdPercent sets how far below the cap the pool has to
+// shrink before accepting pages again
+func SetZswapAcceptThresholdPercent(ctx context.Context, percent int) error {
+	if err := checkPercent("accept threshold", percent); err != nil {
+		return err
+	}
+	return sysfs.Write(ctx, path.Join(zswapRoot, "accept_threshold_percent"), percent, sysfs.FormatInt[int])
+}
+
+// SetZswapShrinkerEnabled sets whether cold pages are written back to swap
+// under memory pressure
+func SetZswapShrinkerEnabled(ctx context.Context, enabled bool) error {
+	return sysfs.Write(ctx, path.Join(zswapRoot, "shrinker_enabled"), enabled, sysfs.FormatBool)
+}
+
+func checkPercent(attr string, v int) error {
+	if v < 0 || v > 100 {
+		return errors.Errorf("invalid zswap %s percentage %d", attr, v)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package mounts
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const systemdAutomountSource = "systemd-1"
+
+// AutofsMode is the kind of autofs map a trigger mount serves
+type AutofsMode string
+
+const (
+	AutofsUnknown  AutofsMode = ""
+	AutofsDirect   AutofsMode = "direct"
+	AutofsIndirect AutofsMode = "indirect"
+	AutofsOffset   AutofsMode = "offset"
+)
+
+// Trigger represents an autofs mount point that mounts a filesystem on
+// first access
+type Trigger struct {
+	Mount   Mount
+	Mode    AutofsMode
+	Systemd bool
+	// Active reports whether the real filesystem is already mounted on top
+	// of the trigger
+	Active bool
+}
+
+// IsAutofs returns whether the mount is an autofs trigger mount
+func (m Mount) IsAutofs() bool {
+	return m.FSType == "autofs"
+}
+
+// IsSystemdAutomount returns whether the mount is an autofs trigger set up
+// by a systemd .automount unit
+func (m Mount) IsSystemdAutomount() bool {
+	return m.IsAutofs() && m.Source == systemdAutomountSource
+}
+
+// ListTriggers returns autofs trigger mounts found in the mount table
+func ListTriggers() ([]Trigger, error) {
+	ms, err := ListMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	return triggers(ms), nil
+}
+
+func triggers(ms []Mount) []Trigger {
+	var ts []Trigger
+	for _, m := range ms {
+		if !m.IsAutofs() {
+			continue
+		}
+
+		t := Trigger{
+			Mount:   m,
+			Mode:    autofsMode(m),
+			Systemd: m.IsSystemdAutomount(),
+		}
+		for _, c := range ms {
+			if c.ParentID == m.ID && c.MountPoint == m.MountPoint {
+				t.Active = true
+				break
+			}
+		}
+		ts = append(ts, t)
+	}
+
+	return ts
+}
+
+func autofsMode(m Mount) AutofsMode {
+	for _, mode := range []AutofsMode{AutofsDirect, AutofsIndirect, AutofsOffset} {
+		if m.HasOption(string(mode)) {
+			return mode
+		}
+	}
+
+	return AutofsUnknown
+}
+
+// MountForPath returns the mount that a path belongs to.
+// The lookup is purely lexical and never touches the path itself, so it is
+// safe to use on autofs and hung network mounts.
+func MountForPath(p string) (*Mount, error) {
+	ms, err := ListMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	m := lookup(ms, p)
+	if m == nil {
+		return nil, errors.Errorf("no mount found for %s", p)
+	}
+
+	return m, nil
+}
+
+// lookup returns the deepest mount containing p. When several mounts share
+// a mount point the last one wins since it is stacked on top of the others.
+func lookup(ms []Mount, p string) *Mount {
+	p = path.Clean(p)
+
+	var found *Mount
+	for i := range ms {
+		mp := ms[i].MountPoint
+		if !contains(mp, p) {
+			continue
+		}
+		if found == nil || len(mp) >= len(found.MountPoint) {
+			found = &ms[i]
+		}
+	}
+
+	return found
+}
+
+// contains returns whether path p is located under mount point mp
+func contains(mp, p string) bool {
+	if mp == "/" || mp == p {
+		return true
+	}
+
+	return strings.HasPrefix(p, mp+"/")
+}
+
+// WouldTrigger returns whether accessing the path would make autofs mount
+// a filesystem. Inventory scans should skip such paths to avoid spinning up
+// network mounts or hanging on unreachable servers.
+func WouldTrigger(p string) (bool, error) {
+	ms, err := ListMounts()
+	if err != nil {
+		return false, err
+	}
+
+	return wouldTrigger(ms, p), nil
+}
+
+func wouldTrigger(ms []Mount, p string) bool {
+	m := lookup(ms, p)
+	if m == nil || !m.IsAutofs() {
+		return false
+	}
+
+	// The root of an indirect map is a plain directory, only lookups of
+	// the keys below it trigger mounts
+	if autofsMode(*m) == AutofsIndirect && m.MountPoint == path.Clean(p) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,184 @@
+package mounts
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const sysfsDevBlockRoot = "/sys/dev/block"
+
+// PropagationType is the mount propagation of shared subtrees, see
+// mount_namespaces(7)
+type PropagationType string
+
+const (
+	PropagationPrivate    PropagationType = "private"
+	PropagationShared     PropagationType = "shared"
+	PropagationSlave      PropagationType = "slave"
+	PropagationUnbindable PropagationType = "unbindable"
+)
+
+// Propagation describes the optional fields of a mountinfo entry
+type Propagation struct {
+	// Type is slave for mounts receiving events from a peer group even if
+	// they are also shared
+	Type PropagationType
+	// Shared is the peer group of a shared mount, zero otherwise
+	Shared int
+	// Master is the peer group a slave mount receives events from
+	Master int
+	// PropagateFrom is the closest dominant peer group of a slave visible
+	// in the mount namespace
+	PropagateFrom int
+}
+
+// Propagation returns the propagation flags of the mount
+func (m Mount) Propagation() Propagation {
+	p := Propagation{Type: PropagationPrivate}
+
+	for _, f := range m.Optional {
+		kv := strings.SplitN(f, ":", 2)
+		var n int
+		if len(kv) == 2 {
+			n, _ = strconv.Atoi(kv[1])
+		}
+
+		switch kv[0] {
+		case "shared":
+			p.Shared = n
+		case "master":
+			p.Master = n
+		case "propagate_from":
+			p.PropagateFrom = n
+		case "unbindable":
+			p.Type = PropagationUnbindable
+		}
+	}
+
+	switch {
+	case p.Master != 0:
+		p.Type = PropagationSlave
+	case p.Shared != 0:
+		p.Type = PropagationShared
+	}
+
+	return p
+}
+
+// MountsForDevice returns mounts of the block device like "/dev/sda" or
+// "sda", its partitions and devices stacked on top of it such as dm-crypt
+// or LVM volumes. An empty result means nothing on the device is mounted,
+// swap and other non-mount users are not considered.
+func MountsForDevice(dev string) ([]Mount, error) {
+	devs, err := deviceTree(dev)
+	if err != nil {
+		return nil, err
+	}
+
+	ms, err := ListMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Mount
+	for _, m := range ms {
+		if devs[devKey(m.Major, m.Minor)] || devs[sourceKey(m.Source)] {
+			found = append(found, m)
+		}
+	}
+
+	return found, nil
+}
+
+// IsMounted returns whether the block device or anything on it is mounted
+func IsMounted(dev string) (bool, error) {
+	ms, err := MountsForDevice(dev)
+	if err != nil {
+		return false, err
+	}
+	return len(ms) > 0, nil
+}
+
+// deviceTree returns device numbers as "major:minor" of the device, its
+// partitions and holders recursively
+func deviceTree(dev string) (map[string]bool, error) {
+	node := dev
+	if !strings.HasPrefix(node, "/") {
+		node = path.Join("/dev", dev)
+	}
+
+	var st syscall.Stat_t
+	if err := syscall.Stat(node, &st); err != nil {
+		return nil, errors.Wrapf(err, "failed to stat %s", node)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return nil, errors.Errorf("%s is not a block device", node)
+	}
+
+	major, minor := splitDev(uint64(st.Rdev))
+	sysfsPath, err := filepath.EvalSymlinks(path.Join(sysfsDevBlockRoot, devKey(major, minor)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve sysfs path of %s", node)
+	}
+
+	devs := map[string]bool{}
+	walkDevice(sysfsPath, devs)
+
+	return devs, nil
+}
+
+func walkDevice(sysfsPath string, devs map[string]bool) {
+	key := readAttr(path.Join(sysfsPath, "dev"))
+	if key == "" || devs[key] {
+		return
+	}
+	devs[key] = true
+
+	// Partitions are subdirectories with a partition attribute
+	partitions, _ := filepath.Glob(path.Join(sysfsPath, "*", "partition"))
+	for _, p := range partitions {
+		walkDevice(path.Dir(p), devs)
+	}
+
+	holders, _ := filepath.Glob(path.Join(sysfsPath, "holders", "*"))
+	for _, h := range holders {
+		if resolved, err := filepath.EvalSymlinks(h); err == nil {
+			walkDevice(resolved, devs)
+		}
+	}
+}
+
+// sourceKey returns the device number of a mount source device node. Some
+// filesystems like btrfs report an anonymous device number in mountinfo,
+// so the source is checked as well.
+func sourceKey(source string) string {
+	if !strings.HasPrefix(source, "/dev/") {
+		return ""
+	}
+
+	var st syscall.Stat_t
+	if err := syscall.Stat(source, &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return ""
+	}
+
+	return devKey(splitDev(uint64(st.Rdev)))
+}
+
+func devKey(major, minor uint32) string {
+	return fmt.Sprintf("%d:%d", major, minor)
+}
+
+func readAttr(p string) string {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
@@ -0,0 +1,302 @@
+package mounts
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/alexdzyoba/sys/poll"
+	"github.com/pkg/errors"
+)
+
+const (
+	sysfsExt4Root = "/sys/fs/ext4"
+	kmsgPath      = "/dev/kmsg"
+
+	// DefaultErrorInterval is how often mounts and error counters are
+	// checked unless set
+	DefaultErrorInterval = 5 * time.Second
+
+	// kmsgRecordSize fits any record, reads of /dev/kmsg return one
+	// record and fail with EINVAL if the buffer is smaller
+	kmsgRecordSize = 8192
+)
+
+// ErrorKind is what a filesystem error event reports
+type ErrorKind string
+
+const (
+	// ErrorCount is an increase of the ext4 errors_count counter
+	ErrorCount ErrorKind = "error-count"
+	// ErrorRemountReadOnly is a read-write mount turning read-only, which
+	// filesystems do on errors with errors=remount-ro
+	ErrorRemountReadOnly ErrorKind = "remount-ro"
+	// ErrorKernelMessage is a filesystem error logged by the kernel
+	ErrorKernelMessage ErrorKind = "kernel-message"
+)
+
+// ErrorEvent is a filesystem error
+type ErrorEvent struct {
+	Time time.Time
+	Kind ErrorKind
+	// Device is the kernel name of the block device like "sda1" or "dm-0"
+	Device string
+	// MountPoint and FSType are empty if the device isn't mounted anymore
+	MountPoint string
+	FSType     string
+	// Count is the errors_count for ErrorCount events
+	Count int
+	// Message holds the kernel message or the function of the last ext4
+	// error
+	Message string
+}
+
+// ErrorWatchOptions configure WatchErrors
+type ErrorWatchOptions struct {
+	// Interval defaults to DefaultErrorInterval
+	Interval time.Duration
+	// KernelLog also follows /dev/kmsg for ext4, xfs and btrfs errors,
+	// which requires root or CAP_SYSLOG
+	KernelLog bool
+}
+
+// kernelErrorPatterns match filesystem errors in the kernel log, the first
+// group is the device name
+var kernelErrorPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^EXT4-fs error \(device ([^)]+)\)`),
+	regexp.MustCompile(`^EXT4-fs \(([^)]+)\): Remounting filesystem read-only`),
+	regexp.MustCompile(`^XFS \(([^)]+)\): .*(shut down|[Cc]orrupt|metadata I/O error)`),
+	regexp.MustCompile(`^BTRFS(?: error|: error| critical) \(device ([^)]+)`),
+}
+
+// WatchErrors reports filesystem errors of mounted block devices until the
+// context is done, then the channel is closed. Counters and mount states
+// present at the start are the baseline, only changes are reported.
+func WatchErrors(ctx context.Context, opts ErrorWatchOptions) (<-chan ErrorEvent, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultErrorInterval
+	}
+
+	var kmsg *os.File
+	if opts.KernelLog {
+		var err error
+		if kmsg, err = openKmsg(); err != nil {
+			return nil, err
+		}
+	}
+
+	w := newErrorWatcher()
+	if _, err := w.scan(); err != nil {
+		if kmsg != nil {
+			kmsg.Close()
+		}
+		return nil, err
+	}
+
+	out := make(chan ErrorEvent)
+	var wg sync.WaitGroup
+	if kmsg != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			followKmsg(ctx, kmsg, w.mountOf, out)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		poll.Every(ctx, opts.Interval, func() bool {
+			// A failed scan keeps the previous state for the next one
+			evs, _ := w.scan()
+			for _, e := range evs {
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			return true
+		})
+	}()
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out, nil
+}
+
+type errorWatcher struct {
+	// listMounts, deviceName and ext4Root are where mounts and their error
+	// counters come from
+	listMounts func() ([]Mount, error)
+	deviceName func(major, minor uint32) string
+	ext4Root   string
+
+	// mu guards mounted, which kernel log events are matched against
+	mu sync.Mutex
+	// mounted maps kernel device names to their mount
+	mounted map[string]Mount
+	// counts are ext4 errors_count by device
+	counts map[string]int
+	// readOnly tracks mounts by ID
+	readOnly map[int]bool
+}
+
+func newErrorWatcher() *errorWatcher {
+	return &errorWatcher{
+		listMounts: ListMounts,
+		deviceName: deviceName,
+		ext4Root:   sysfsExt4Root,
+		counts:     map[string]int{},
+		readOnly:   map[int]bool{},
+	}
+}
+
+// scan reads mounts of block devices with their error counters and returns
+// the changes since the last scan
+func (w *errorWatcher) scan() ([]ErrorEvent, error) {
+	ms, err := w.listMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	var evs []ErrorEvent
+	now := time.Now()
+	mounted := map[string]Mount{}
+	readOnly := map[int]bool{}
+
+	for _, m := range ms {
+		name := w.deviceName(m.Major, m.Minor)
+		if name == "" {
+			continue
+		}
+		mounted[name] = m
+
+		ro := m.HasOption("ro")
+		readOnly[m.ID] = ro
+		if was, ok := w.readOnly[m.ID]; ok && !was && ro {
+			evs = append(evs, ErrorEvent{
+				Time: now, Kind: ErrorRemountReadOnly, Device: name, MountPoint: m.MountPoint, FSType: m.FSType,
+			})
+		}
+
+		if m.FSType != "ext4" {
+			continue
+		}
+		dir := path.Join(w.ext4Root, name)
+		count, err := strconv.Atoi(readAttr(path.Join(dir, "errors_count")))
+		if err != nil {
+			continue
+		}
+		if prev, ok := w.counts[name]; ok && count > prev {
+			evs = append(evs, ErrorEvent{
+				Time: now, Kind: ErrorCount, Device: name, MountPoint: m.MountPoint, FSType: m.FSType,
+				Count: count, Message: readAttr(path.Join(dir, "last_error_func")),
+			})
+		}
+		w.counts[name] = count
+	}
+	w.mu.Lock()
+	w.mounted = mounted
+	w.mu.Unlock()
+	w.readOnly = readOnly
+
+	return evs, nil
+}
+
+// mountOf returns the mount of the device as of the last scan
+func (w *errorWatcher) mountOf(device string) (Mount, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	m, ok := w.mounted[device]
+	return m, ok
+}
+
+// deviceName returns the kernel name of a block device number, empty for
+// anonymous devices of virtual filesystems
+func deviceName(major, minor uint32) string {
+	if major == 0 {
+		return ""
+	}
+	p, err := filepath.EvalSymlinks(path.Join(sysfsDevBlockRoot, devKey(major, minor)))
+	if err != nil {
+		return ""
+	}
+	return path.Base(p)
+}
+
+// openKmsg opens the kernel log positioned at its end so only new messages
+// are read
+func openKmsg() (*os.File, error) {
+	f, err := os.OpenFile(kmsgPath, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", kmsgPath)
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "failed to seek %v", kmsgPath)
+	}
+	return f, nil
+}
+
+// followKmsg sends filesystem errors found in kernel log records like
+// "3,1234,5678901,-;EXT4-fs error (device sda1): ..."
+func followKmsg(ctx context.Context, f *os.File, mountOf func(string) (Mount, bool), events chan<- ErrorEvent) {
+	// Closing the file unblocks the pending read
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	buf := make([]byte, kmsgRecordSize)
+	for {
+		n, err := f.Read(buf)
+		if errors.Is(err, syscall.EPIPE) {
+			// Records were overwritten before being read
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		e, ok := parseKmsg(string(buf[:n]))
+		if !ok {
+			continue
+		}
+		if m, ok := mountOf(e.Device); ok {
+			e.MountPoint, e.FSType = m.MountPoint, m.FSType
+		}
+		select {
+		case events <- e:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func parseKmsg(record string) (ErrorEvent, bool) {
+	i := strings.IndexByte(record, ';')
+	if i < 0 {
+		return ErrorEvent{}, false
+	}
+	// Continuation lines with key=value pairs follow the message
+	msg := strings.SplitN(record[i+1:], "\n", 2)[0]
+
+	for _, re := range kernelErrorPatterns {
+		if m := re.FindStringSubmatch(msg); m != nil {
+			return ErrorEvent{Time: time.Now(), Kind: ErrorKernelMessage, Device: m[1], Message: msg}, true
+		}
+	}
+	return ErrorEvent{}, false
+}
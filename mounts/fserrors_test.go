@@ -0,0 +1,198 @@
+package mounts
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestParseKmsg(t *testing.T) {
+	tests := []struct {
+		name       string
+		record     string
+		wantDevice string
+		wantMsg    string
+	}{
+		{
+			name:       "ext4 error",
+			record:     "3,1234,5678901,-;EXT4-fs error (device sda1): ext4_lookup:1855: inode #2: comm ls: deleted inode referenced: 12\n SUBSYSTEM=block\n DEVICE=b8:1\n",
+			wantDevice: "sda1",
+			wantMsg:    "EXT4-fs error (device sda1): ext4_lookup:1855: inode #2: comm ls: deleted inode referenced: 12",
+		},
+		{
+			name:       "ext4 remount",
+			record:     "2,1235,5678902,-;EXT4-fs (dm-0): Remounting filesystem read-only",
+			wantDevice: "dm-0",
+			wantMsg:    "EXT4-fs (dm-0): Remounting filesystem read-only",
+		},
+		{
+			name:       "xfs shutdown",
+			record:     "1,1236,5678903,-;XFS (nvme0n1p2): Filesystem has been shut down due to log error (0x2).",
+			wantDevice: "nvme0n1p2",
+			wantMsg:    "XFS (nvme0n1p2): Filesystem has been shut down due to log error (0x2).",
+		},
+		{
+			name:       "xfs corruption",
+			record:     "1,1237,5678904,-;XFS (sdb): Corruption detected. Unmount and run xfs_repair",
+			wantDevice: "sdb",
+			wantMsg:    "XFS (sdb): Corruption detected. Unmount and run xfs_repair",
+		},
+		{
+			name:       "xfs metadata I/O error",
+			record:     "3,1238,5678905,-;XFS (sdb): metadata I/O error in \"xfs_imap_to_bp+0x5c/0xa0\" at daddr 0x80",
+			wantDevice: "sdb",
+			wantMsg:    "XFS (sdb): metadata I/O error in \"xfs_imap_to_bp+0x5c/0xa0\" at daddr 0x80",
+		},
+		{
+			name:       "btrfs error",
+			record:     "3,1239,5678906,-;BTRFS error (device sdc1): bdev /dev/sdc1 errs: wr 0, rd 1, flush 0, corrupt 0, gen 0",
+			wantDevice: "sdc1",
+			wantMsg:    "BTRFS error (device sdc1): bdev /dev/sdc1 errs: wr 0, rd 1, flush 0, corrupt 0, gen 0",
+		},
+		{
+			name:       "btrfs critical",
+			record:     "2,1240,5678907,-;BTRFS critical (device sdc1): corrupt leaf: root=5 block=30408704 slot=0",
+			wantDevice: "sdc1",
+			wantMsg:    "BTRFS critical (device sdc1): corrupt leaf: root=5 block=30408704 slot=0",
+		},
+		{
+			name:   "xfs info",
+			record: "5,1241,5678908,-;XFS (sdb): Mounting V5 Filesystem",
+		},
+		{
+			name:   "ext4 mount",
+			record: "6,1242,5678909,-;EXT4-fs (sda1): mounted filesystem with ordered data mode",
+		},
+		{
+			name:   "pattern in continuation line",
+			record: "6,1243,5678910,-;usb 1-1: new device\n EXT4-fs error (device sda1): fake",
+		},
+		{
+			name:   "no prefix",
+			record: "EXT4-fs error (device sda1): ext4_lookup",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, ok := parseKmsg(tt.record)
+			if ok != (tt.wantDevice != "") {
+				t.Fatalf("parseKmsg() ok = %v, want %v", ok, tt.wantDevice != "")
+			}
+			if !ok {
+				return
+			}
+			if e.Kind != ErrorKernelMessage || e.Device != tt.wantDevice || e.Message != tt.wantMsg {
+				t.Errorf("parseKmsg() = %+v, want device %q message %q", e, tt.wantDevice, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestErrorWatcherScan(t *testing.T) {
+	root := t.TempDir()
+	setCount := func(device string, count int, fn string) {
+		dir := path.Join(root, device)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path.Join(dir, "errors_count"), []byte(strconv.Itoa(count)+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path.Join(dir, "last_error_func"), []byte(fn+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rootfs := Mount{ID: 21, Major: 8, Minor: 1, MountPoint: "/", FSType: "ext4", Options: []string{"rw"}}
+	data := Mount{ID: 22, Major: 8, Minor: 16, MountPoint: "/data", FSType: "xfs", Options: []string{"rw"}}
+	proc := Mount{ID: 23, Major: 0, Minor: 22, MountPoint: "/proc", FSType: "proc", Options: []string{"rw"}}
+	// remounted is data mounted again, with a new mount ID
+	remounted := data
+	remounted.ID = 24
+	ro := func(m Mount) Mount {
+		m.Options = []string{"ro"}
+		return m
+	}
+
+	var mounts []Mount
+	w := newErrorWatcher()
+	w.listMounts = func() ([]Mount, error) { return mounts, nil }
+	w.deviceName = func(major, minor uint32) string {
+		return map[[2]uint32]string{{8, 1}: "sda1", {8, 16}: "sdb"}[[2]uint32{major, minor}]
+	}
+	w.ext4Root = root
+
+	type want struct {
+		kind   ErrorKind
+		device string
+		count  int
+		msg    string
+	}
+	steps := []struct {
+		name   string
+		mounts []Mount
+		// count sets errors_count of sda1 before the scan
+		count int
+		want  []want
+	}{
+		{name: "baseline", mounts: []Mount{rootfs, data, proc}, count: 2},
+		{name: "unchanged", mounts: []Mount{rootfs, data, proc}, count: 2},
+		{
+			name:   "errors_count increase",
+			mounts: []Mount{rootfs, data, proc},
+			count:  3,
+			want:   []want{{kind: ErrorCount, device: "sda1", count: 3, msg: "ext4_lookup"}},
+		},
+		{
+			name:   "remount read-only",
+			mounts: []Mount{ro(rootfs), data, ro(proc)},
+			count:  3,
+			want:   []want{{kind: ErrorRemountReadOnly, device: "sda1"}},
+		},
+		{name: "stays read-only", mounts: []Mount{ro(rootfs), data}, count: 3},
+		{name: "counter reset", mounts: []Mount{ro(rootfs), data}, count: 0},
+		{
+			name:   "new mount is a baseline",
+			mounts: []Mount{ro(rootfs), ro(remounted)},
+			count:  0,
+		},
+		{name: "read-write again", mounts: []Mount{rootfs, remounted}, count: 0},
+		{
+			name:   "all at once",
+			mounts: []Mount{ro(rootfs), ro(remounted)},
+			count:  1,
+			want: []want{
+				{kind: ErrorRemountReadOnly, device: "sda1"},
+				{kind: ErrorCount, device: "sda1", count: 1, msg: "ext4_lookup"},
+				{kind: ErrorRemountReadOnly, device: "sdb"},
+			},
+		},
+	}
+
+	for _, s := range steps {
+		mounts = s.mounts
+		setCount("sda1", s.count, "ext4_lookup")
+
+		evs, err := w.scan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []want
+		for _, e := range evs {
+			got = append(got, want{kind: e.Kind, device: e.Device, count: e.Count, msg: e.Message})
+		}
+		if !reflect.DeepEqual(got, s.want) {
+			t.Errorf("%s: scan() = %+v, want %+v", s.name, got, s.want)
+		}
+	}
+
+	if m, ok := w.mountOf("sdb"); !ok || m.MountPoint != "/data" {
+		t.Errorf("mountOf(sdb) = %+v, %v", m, ok)
+	}
+	if _, ok := w.mountOf("sda2"); ok {
+		t.Error("mountOf(sda2) found a mount")
+	}
+}
@@ -0,0 +1,152 @@
+package mounts
+
+import (
+	"encoding/binary"
+	"os"
+	"path"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// ioctls from linux/fs.h and xfs_fs.h
+const (
+	figetbsz = 2
+	// XFS_IOC_FSGEOMETRY returns struct xfs_fsop_geom of kernels 5.1+,
+	// XFS_IOC_FSGEOMETRY_V1 the older prefix of it all kernels support
+	xfsIocFSGeometry   = 0x8100587e
+	xfsIocFSGeometryV1 = 0x80705864
+)
+
+// Geometry is the allocation geometry of a mounted filesystem
+type Geometry struct {
+	FSType    string
+	BlockSize uint64
+	// StripeUnit and StripeWidth are in bytes, zero if the filesystem
+	// wasn't made for a striped device. For ext4 they are the RAID stride
+	// and stripe width with the stripe mount option taking precedence.
+	StripeUnit  uint64
+	StripeWidth uint64
+	// AGCount and AGSize in bytes are the allocation groups of xfs
+	AGCount uint32
+	AGSize  uint64
+	// SectorSize is the sector size xfs was made with
+	SectorSize uint32
+}
+
+// FilesystemGeometry returns the geometry of the xfs or ext4 filesystem
+// mounted at the path. xfs is queried with XFS_IOC_FSGEOMETRY, ext4 stripe
+// settings are only in its superblock, which is read from the device.
+func FilesystemGeometry(mountPoint string) (*Geometry, error) {
+	ms, err := ListMounts()
+	if err != nil {
+		return nil, err
+	}
+	m := lookup(ms, mountPoint)
+	if m == nil || m.MountPoint != path.Clean(mountPoint) {
+		return nil, errors.Errorf("%s is not a mount point", mountPoint)
+	}
+
+	f, err := os.Open(m.MountPoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", m.MountPoint)
+	}
+	defer f.Close()
+
+	switch m.FSType {
+	case "xfs":
+		return xfsGeometry(f)
+	case "ext4", "ext3", "ext2":
+		return extGeometry(f, *m)
+	}
+	return nil, errors.Errorf("geometry of %s filesystems is not supported", m.FSType)
+}
+
+func xfsGeometry(f *os.File) (*Geometry, error) {
+	buf := make([]byte, 256)
+	if err := ioctl(f, xfsIocFSGeometry, buf); err != nil {
+		if err := ioctl(f, xfsIocFSGeometryV1, buf[:112]); err != nil {
+			return nil, errors.Wrapf(err, "failed to get xfs geometry of %v", f.Name())
+		}
+	}
+
+	return parseXFSGeometry(buf), nil
+}
+
+// parseXFSGeometry decodes struct xfs_fsop_geom, the fields used are in
+// the V1 prefix too
+func parseXFSGeometry(buf []byte) *Geometry {
+	bs := uint64(binary.LittleEndian.Uint32(buf[0:]))
+	return &Geometry{
+		FSType:    "xfs",
+		BlockSize: bs,
+		AGCount:   binary.LittleEndian.Uint32(buf[12:]),
+		AGSize:    uint64(binary.LittleEndian.Uint32(buf[8:])) * bs,
+		// sunit and swidth are in filesystem blocks
+		StripeUnit:  uint64(binary.LittleEndian.Uint32(buf[80:])) * bs,
+		StripeWidth: uint64(binary.LittleEndian.Uint32(buf[84:])) * bs,
+		SectorSize:  binary.LittleEndian.Uint32(buf[20:]),
+	}
+}
+
+func extGeometry(f *os.File, m Mount) (*Geometry, error) {
+	var bs int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), figetbsz, uintptr(unsafe.Pointer(&bs))); errno != 0 {
+		return nil, errors.Wrapf(errno, "failed to get block size of %v", f.Name())
+	}
+	g := &Geometry{FSType: m.FSType, BlockSize: uint64(bs)}
+
+	sb, err := readExtSuperblock(m)
+	if err != nil {
+		return nil, err
+	}
+	extStripe(g, sb, m)
+
+	return g, nil
+}
+
+// extStripe sets the stripe geometry from the RAID stride and stripe width
+// of the superblock and the stripe mount option
+func extStripe(g *Geometry, sb []byte, m Mount) {
+	g.StripeUnit = uint64(binary.LittleEndian.Uint16(sb[0x164:])) * g.BlockSize
+	g.StripeWidth = uint64(binary.LittleEndian.Uint32(sb[0x170:])) * g.BlockSize
+
+	if v, ok := m.Option("stripe"); ok {
+		if stripe, err := strconv.ParseUint(v, 10, 64); err == nil {
+			g.StripeWidth = stripe * g.BlockSize
+		}
+	}
+}
+
+// readExtSuperblock reads the primary superblock from the device node of
+// the mount, the source may be a name like /dev/root that doesn't exist
+func readExtSuperblock(m Mount) ([]byte, error) {
+	node := m.Source
+	if name := deviceName(m.Major, m.Minor); name != "" {
+		node = path.Join("/dev", name)
+	}
+
+	f, err := os.Open(node)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", node)
+	}
+	defer f.Close()
+
+	sb := make([]byte, 1024)
+	if _, err := f.ReadAt(sb, 1024); err != nil {
+		return nil, errors.Wrapf(err, "failed to read superblock of %v", node)
+	}
+	if binary.LittleEndian.Uint16(sb[0x38:]) != 0xef53 {
+		return nil, errors.Errorf("%s has no ext superblock", node)
+	}
+	return sb, nil
+}
+
+func ioctl(f *os.File, req uintptr, buf []byte) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(&buf[0]))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package mounts
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// xfsGeom builds struct xfs_fsop_geom with the fields Geometry uses
+func xfsGeom(blockSize, agBlocks, agCount, sectSize, sunit, swidth uint32) []byte {
+	buf := make([]byte, 256)
+	binary.LittleEndian.PutUint32(buf[0:], blockSize)
+	binary.LittleEndian.PutUint32(buf[8:], agBlocks)
+	binary.LittleEndian.PutUint32(buf[12:], agCount)
+	binary.LittleEndian.PutUint32(buf[20:], sectSize)
+	binary.LittleEndian.PutUint32(buf[80:], sunit)
+	binary.LittleEndian.PutUint32(buf[84:], swidth)
+	return buf
+}
+
+func TestParseXFSGeometry(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+		want *Geometry
+	}{
+		{
+			name: "plain",
+			buf:  xfsGeom(4096, 65536, 4, 512, 0, 0),
+			want: &Geometry{FSType: "xfs", BlockSize: 4096, AGCount: 4, AGSize: 256 << 20, SectorSize: 512},
+		},
+		{
+			name: "striped",
+			buf:  xfsGeom(4096, 262144, 16, 4096, 128, 512),
+			want: &Geometry{
+				FSType:      "xfs",
+				BlockSize:   4096,
+				AGCount:     16,
+				AGSize:      1 << 30,
+				SectorSize:  4096,
+				StripeUnit:  512 << 10,
+				StripeWidth: 2 << 20,
+			},
+		},
+		{
+			name: "V1 prefix",
+			buf:  xfsGeom(1024, 8192, 2, 512, 0, 0)[:112],
+			want: &Geometry{FSType: "xfs", BlockSize: 1024, AGCount: 2, AGSize: 8 << 20, SectorSize: 512},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseXFSGeometry(tt.buf); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseXFSGeometry() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtStripe(t *testing.T) {
+	sb := func(stride uint16, width uint32) []byte {
+		b := make([]byte, 1024)
+		binary.LittleEndian.PutUint16(b[0x164:], stride)
+		binary.LittleEndian.PutUint32(b[0x170:], width)
+		return b
+	}
+
+	tests := []struct {
+		name      string
+		sb        []byte
+		options   []string
+		wantUnit  uint64
+		wantWidth uint64
+	}{
+		{name: "not striped", sb: sb(0, 0)},
+		{name: "stride and stripe width", sb: sb(16, 64), wantUnit: 64 << 10, wantWidth: 256 << 10},
+		{
+			name:      "stripe option",
+			sb:        sb(16, 64),
+			options:   []string{"rw", "stripe=128"},
+			wantUnit:  64 << 10,
+			wantWidth: 512 << 10,
+		},
+		{
+			name:      "invalid stripe option",
+			sb:        sb(16, 64),
+			options:   []string{"stripe=many"},
+			wantUnit:  64 << 10,
+			wantWidth: 256 << 10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Geometry{FSType: "ext4", BlockSize: 4096}
+			extStripe(g, tt.sb, Mount{Options: tt.options})
+			if g.StripeUnit != tt.wantUnit || g.StripeWidth != tt.wantWidth {
+				t.Errorf("stripe unit %d width %d, want %d and %d", g.StripeUnit, g.StripeWidth, tt.wantUnit, tt.wantWidth)
+			}
+		})
+	}
+}
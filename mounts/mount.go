@@ -0,0 +1,180 @@
+package mounts
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const mountInfoPath = "/proc/self/mountinfo"
+
+// Mount represents a single entry of the mount table
+type Mount struct {
+	ID           int
+	ParentID     int
+	Major        uint32
+	Minor        uint32
+	Root         string
+	MountPoint   string
+	Options      []string
+	Optional     []string
+	FSType       string
+	Source       string
+	SuperOptions []string
+}
+
+// ListMounts returns mounts visible to the current process.
+// Mounts are discovered by parsing /proc/self/mountinfo.
+func ListMounts() ([]Mount, error) {
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", mountInfoPath)
+	}
+	defer f.Close()
+
+	return parseMountInfo(f)
+}
+
+func parseMountInfo(r io.Reader) ([]Mount, error) {
+	var ms []Mount
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		m, err := parseMountInfoLine(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse mountinfo line %q", line)
+		}
+		ms = append(ms, m)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read mountinfo")
+	}
+
+	return ms, nil
+}
+
+// parseMountInfoLine parses a line in the format described in proc(5):
+// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func parseMountInfoLine(line string) (Mount, error) {
+	fields := strings.Fields(line)
+
+	sep := -1
+	for i, f := range fields {
+		if f == "-" {
+			sep = i
+			break
+		}
+	}
+	if sep < 6 || len(fields) < sep+3 {
+		return Mount{}, errors.New("unexpected number of fields")
+	}
+
+	id, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return Mount{}, errors.Wrap(err, "failed to parse mount id")
+	}
+
+	parentID, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return Mount{}, errors.Wrap(err, "failed to parse parent id")
+	}
+
+	major, minor, err := parseDevNumber(fields[2])
+	if err != nil {
+		return Mount{}, err
+	}
+
+	m := Mount{
+		ID:         id,
+		ParentID:   parentID,
+		Major:      major,
+		Minor:      minor,
+		Root:       unescape(fields[3]),
+		MountPoint: unescape(fields[4]),
+		Options:    strings.Split(fields[5], ","),
+		Optional:   fields[6:sep],
+		FSType:     fields[sep+1],
+		Source:     unescape(fields[sep+2]),
+	}
+	if len(fields) > sep+3 {
+		m.SuperOptions = strings.Split(fields[sep+3], ",")
+	}
+
+	return m, nil
+}
+
+func parseDevNumber(s string) (uint32, uint32, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("invalid device number %q", s)
+	}
+
+	major, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to parse major number")
+	}
+
+	minor, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to parse minor number")
+	}
+
+	return uint32(major), uint32(minor), nil
+}
+
+// unescape decodes octal escapes (\040 for space etc.) used by the kernel
+// for whitespace and backslashes in mountinfo paths
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+// HasOption returns whether the mount has the given per-mount or superblock
+// option. Options with values are matched by their key, e.g. "errors".
+func (m Mount) HasOption(name string) bool {
+	_, ok := m.Option(name)
+	return ok
+}
+
+// Option returns the value of the given per-mount or superblock option
+func (m Mount) Option(name string) (string, bool) {
+	for _, opts := range [][]string{m.Options, m.SuperOptions} {
+		for _, o := range opts {
+			kv := strings.SplitN(o, "=", 2)
+			if kv[0] != name {
+				continue
+			}
+			if len(kv) == 2 {
+				return kv[1], true
+			}
+			return "", true
+		}
+	}
+
+	return "", false
+}
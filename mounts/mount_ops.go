@@ -0,0 +1,230 @@
+package mounts
+
+import (
+	"context"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/pkg/errors"
+)
+
+var mountFlags = map[string]uintptr{
+	"ro":          syscall.MS_RDONLY,
+	"nosuid":      syscall.MS_NOSUID,
+	"nodev":       syscall.MS_NODEV,
+	"noexec":      syscall.MS_NOEXEC,
+	"sync":        syscall.MS_SYNCHRONOUS,
+	"dirsync":     syscall.MS_DIRSYNC,
+	"mand":        syscall.MS_MANDLOCK,
+	"noatime":     syscall.MS_NOATIME,
+	"nodiratime":  syscall.MS_NODIRATIME,
+	"relatime":    syscall.MS_RELATIME,
+	"strictatime": syscall.MS_STRICTATIME,
+	"bind":        syscall.MS_BIND,
+	"rbind":       syscall.MS_BIND | syscall.MS_REC,
+	"remount":     syscall.MS_REMOUNT,
+}
+
+// parseOptions splits mount(8) style options into mount(2) flags and
+// filesystem specific data
+func parseOptions(options []string) (uintptr, string) {
+	var (
+		flags uintptr
+		data  []string
+	)
+	for _, o := range options {
+		switch o {
+		case "", "rw", "defaults":
+			continue
+		}
+		if f, ok := mountFlags[o]; ok {
+			flags |= f
+			continue
+		}
+		data = append(data, o)
+	}
+
+	return flags, strings.Join(data, ",")
+}
+
+// MountAt mounts source on target with mount(8) style options
+func MountAt(ctx context.Context, source, target, fstype string, options []string) error {
+	a := action.Action{
+		Op:     "mount",
+		Target: target,
+		Args: map[string]string{
+			"source":  source,
+			"fstype":  fstype,
+			"options": strings.Join(options, ","),
+		},
+	}
+
+	return action.Run(ctx, a, func() error {
+		flags, data := parseOptions(options)
+		if err := syscall.Mount(source, target, fstype, flags, data); err != nil {
+			return errors.Wrapf(err, "failed to mount %s on %s", source, target)
+		}
+		return nil
+	})
+}
+
+// EnsureMounted mounts source on target unless it's already mounted there.
+// A read-only/read-write mismatch is fixed with a remount. It's an error if
+// something else is mounted on target. EnsureMounted returns whether a
+// change was made.
+func EnsureMounted(ctx context.Context, source, target, fstype string, options []string) (bool, error) {
+	target = path.Clean(target)
+
+	ms, err := ListMounts()
+	if err != nil {
+		return false, err
+	}
+
+	var current *Mount
+	for i := range ms {
+		if ms[i].MountPoint == target {
+			current = &ms[i]
+		}
+	}
+
+	if current == nil {
+		if err := MountAt(ctx, source, target, fstype, options); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	same, err := sameSource(*current, source)
+	if err != nil {
+		return false, err
+	}
+	if !same {
+		return false, errors.Errorf("%s is already mounted on %s", current.Source, target)
+	}
+
+	wantRO, bind := false, false
+	for _, o := range options {
+		switch o {
+		case "ro":
+			wantRO = true
+		case "bind", "rbind":
+			bind = true
+		}
+	}
+
+	a := action.Action{
+		Op:     "mount.remount",
+		Target: target,
+		Args:   map[string]string{"ro": boolString(wantRO)},
+	}
+	return action.Ensure(ctx, a,
+		func() (bool, error) {
+			return current.HasOption("ro") == wantRO, nil
+		},
+		func() error {
+			flags, data := remountOptions(*current, options)
+			// Without MS_BIND the remount changes the filesystem under
+			// the bind mount and with it every other mount of it
+			if bind {
+				flags |= syscall.MS_BIND
+			}
+			if wantRO {
+				flags |= syscall.MS_RDONLY
+			}
+			if err := syscall.Mount("", target, "", flags, data); err != nil {
+				return errors.Wrapf(err, "failed to remount %s", target)
+			}
+			return nil
+		})
+}
+
+// remountOptions returns the flags and data to remount the mount with the
+// options. A remount resets the per mount flags like nosuid or noatime, so
+// the current ones are kept next to the requested ones. The read-only flag
+// is left to the caller.
+func remountOptions(m Mount, options []string) (uintptr, string) {
+	var kept []string
+	for _, o := range m.Options {
+		if _, ok := mountFlags[o]; ok {
+			kept = append(kept, o)
+		}
+	}
+
+	flags, data := parseOptions(append(kept, options...))
+	flags &^= syscall.MS_RDONLY | syscall.MS_BIND | syscall.MS_REC
+	return flags | syscall.MS_REMOUNT, data
+}
+
+// sameSource returns whether the mount comes from source. Block devices are
+// compared by device number so that /dev/disk/by-* symlinks match, and
+// directories by device and inode with the root of the mount to recognize
+// bind mounts.
+func sameSource(m Mount, source string) (bool, error) {
+	if m.Source == source {
+		return true, nil
+	}
+
+	var st syscall.Stat_t
+	if err := syscall.Stat(source, &st); err != nil {
+		if err == syscall.ENOENT {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "failed to stat %s", source)
+	}
+
+	switch st.Mode & syscall.S_IFMT {
+	case syscall.S_IFBLK:
+		major, minor := splitDev(uint64(st.Rdev))
+		return m.Major == major && m.Minor == minor, nil
+	case syscall.S_IFDIR:
+		// The mount point resolves to the root of the topmost mount on it,
+		// which is m
+		var root syscall.Stat_t
+		if err := syscall.Stat(m.MountPoint, &root); err != nil {
+			return false, errors.Wrapf(err, "failed to stat %s", m.MountPoint)
+		}
+		return st.Dev == root.Dev && st.Ino == root.Ino, nil
+	default:
+		return false, nil
+	}
+}
+
+// splitDev decodes a dev_t like the kernel's huge_decode_dev
+func splitDev(dev uint64) (uint32, uint32) {
+	major := uint32((dev>>8)&0xfff) | uint32((dev>>32)&^0xfff)
+	minor := uint32(dev&0xff) | uint32((dev>>12)&^0xff)
+	return major, minor
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+// EnsureUnmounted unmounts target if something is mounted there and returns
+// whether a change was made. A busy mount fails with a BusyError.
+func EnsureUnmounted(ctx context.Context, target string) (bool, error) {
+	target = path.Clean(target)
+
+	a := action.Action{Op: "umount", Target: target}
+	return action.Ensure(ctx, a,
+		func() (bool, error) {
+			ms, err := ListMounts()
+			if err != nil {
+				return false, err
+			}
+			for _, m := range ms {
+				if m.MountPoint == target {
+					return false, nil
+				}
+			}
+			return true, nil
+		},
+		func() error {
+			return unmount(ctx, target, UnmountOptions{})
+		})
+}
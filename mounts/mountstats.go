@@ -0,0 +1,321 @@
+package mounts
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const mountStatsPath = "/proc/self/mountstats"
+
+// MountStats represents a single device entry of /proc/self/mountstats
+type MountStats struct {
+	Device      string
+	MountPoint  string
+	FSType      string
+	StatVersion string
+	// NFS is populated for nfs and nfs4 mounts only
+	NFS *NFSStats
+}
+
+// NFSStats holds the per-mount statistics reported by the NFS client
+type NFSStats struct {
+	Options    []string
+	Age        time.Duration
+	Events     NFSEvents
+	Bytes      NFSBytes
+	Transport  NFSTransport
+	Operations []NFSOperation
+}
+
+// NFSEvents are the "events:" counters of an NFS mount
+type NFSEvents struct {
+	InodeRevalidate  uint64
+	DentryRevalidate uint64
+	DataInvalidate   uint64
+	AttrInvalidate   uint64
+	VFSOpen          uint64
+	VFSLookup        uint64
+	VFSAccess        uint64
+	VFSUpdatePage    uint64
+	VFSReadPage      uint64
+	VFSReadPages     uint64
+	VFSWritePage     uint64
+	VFSWritePages    uint64
+	VFSGetdents      uint64
+	VFSSetattr       uint64
+	VFSFlush         uint64
+	VFSFsync         uint64
+	VFSLock          uint64
+	VFSRelease       uint64
+	CongestionWait   uint64
+	SetattrTrunc     uint64
+	ExtendWrite      uint64
+	SillyRename      uint64
+	ShortRead        uint64
+	ShortWrite       uint64
+	JukeboxDelay     uint64
+	PNFSRead         uint64
+	PNFSWrite        uint64
+}
+
+// NFSBytes are the "bytes:" counters of an NFS mount
+type NFSBytes struct {
+	NormalRead  uint64
+	NormalWrite uint64
+	DirectRead  uint64
+	DirectWrite uint64
+	ServerRead  uint64
+	ServerWrite uint64
+	ReadPages   uint64
+	WritePages  uint64
+}
+
+// NFSTransport is the "xprt:" line of an NFS mount. The meaning of Counters
+// depends on Protocol, see the kernel's rpc_xprt_ops.print_stats.
+type NFSTransport struct {
+	Protocol string
+	Counters []uint64
+}
+
+// NFSOperation holds per-operation RPC statistics
+type NFSOperation struct {
+	Name          string
+	Requests      uint64
+	Transmissions uint64
+	MajorTimeouts uint64
+	BytesSent     uint64
+	BytesReceived uint64
+	QueueTime     time.Duration
+	RTT           time.Duration
+	ExecuteTime   time.Duration
+	// Errors is reported by kernels since 4.17 only
+	Errors uint64
+}
+
+// ListMountStats returns I/O statistics of all mounts.
+// Statistics are discovered by parsing /proc/self/mountstats.
+func ListMountStats() ([]MountStats, error) {
+	f, err := os.Open(mountStatsPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", mountStatsPath)
+	}
+	defer f.Close()
+
+	return parseMountStats(f)
+}
+
+func parseMountStats(r io.Reader) ([]MountStats, error) {
+	var (
+		stats   []MountStats
+		current *MountStats
+		inOps   bool
+	)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "device" {
+			ms, err := parseMountStatsHeader(fields)
+			if err != nil {
+				return nil, err
+			}
+			stats = append(stats, ms)
+			current = &stats[len(stats)-1]
+			inOps = false
+			continue
+		}
+
+		if current == nil || current.NFS == nil {
+			continue
+		}
+
+		if err := parseNFSLine(current.NFS, fields, &inOps); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse stats of %s", current.MountPoint)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read mountstats")
+	}
+
+	return stats, nil
+}
+
+// parseMountStatsHeader parses a line like
+// device server:/export mounted on /mnt with fstype nfs4 statvers=1.1
+func parseMountStatsHeader(fields []string) (MountStats, error) {
+	if len(fields) < 8 || fields[2] != "mounted" || fields[3] != "on" {
+		return MountStats{}, errors.Errorf("invalid mountstats header %q", strings.Join(fields, " "))
+	}
+
+	ms := MountStats{
+		Device:     fields[1],
+		MountPoint: unescape(fields[4]),
+		FSType:     fields[7],
+	}
+	if len(fields) > 8 {
+		ms.StatVersion = strings.TrimPrefix(fields[8], "statvers=")
+	}
+	if ms.FSType == "nfs" || ms.FSType == "nfs4" {
+		ms.NFS = &NFSStats{}
+	}
+
+	return ms, nil
+}
+
+func parseNFSLine(s *NFSStats, fields []string, inOps *bool) error {
+	key := fields[0]
+
+	if *inOps && strings.HasSuffix(key, ":") {
+		op, err := parseNFSOperation(fields)
+		if err != nil {
+			return err
+		}
+		s.Operations = append(s.Operations, op)
+		return nil
+	}
+
+	switch key {
+	case "opts:":
+		if len(fields) > 1 {
+			s.Options = strings.Split(fields[1], ",")
+		}
+	case "age:":
+		if len(fields) > 1 {
+			age, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return errors.Wrap(err, "failed to parse age")
+			}
+			s.Age = time.Duration(age) * time.Second
+		}
+	case "events:":
+		vs, err := parseUints(fields[1:])
+		if err != nil {
+			return errors.Wrap(err, "failed to parse events")
+		}
+		s.Events = nfsEvents(vs)
+	case "bytes:":
+		vs, err := parseUints(fields[1:])
+		if err != nil {
+			return errors.Wrap(err, "failed to parse bytes")
+		}
+		s.Bytes = nfsBytes(vs)
+	case "xprt:":
+		if len(fields) < 2 {
+			return errors.New("empty xprt line")
+		}
+		vs, err := parseUints(fields[2:])
+		if err != nil {
+			return errors.Wrap(err, "failed to parse xprt")
+		}
+		s.Transport = NFSTransport{Protocol: fields[1], Counters: vs}
+	case "per-op":
+		*inOps = true
+	}
+
+	return nil
+}
+
+func parseNFSOperation(fields []string) (NFSOperation, error) {
+	vs, err := parseUints(fields[1:])
+	if err != nil {
+		return NFSOperation{}, errors.Wrapf(err, "failed to parse %s statistics", fields[0])
+	}
+	if len(vs) < 8 {
+		return NFSOperation{}, errors.Errorf("not enough %s statistics", fields[0])
+	}
+
+	op := NFSOperation{
+		Name:          strings.TrimSuffix(fields[0], ":"),
+		Requests:      vs[0],
+		Transmissions: vs[1],
+		MajorTimeouts: vs[2],
+		BytesSent:     vs[3],
+		BytesReceived: vs[4],
+		QueueTime:     time.Duration(vs[5]) * time.Millisecond,
+		RTT:           time.Duration(vs[6]) * time.Millisecond,
+		ExecuteTime:   time.Duration(vs[7]) * time.Millisecond,
+	}
+	if len(vs) > 8 {
+		op.Errors = vs[8]
+	}
+
+	return op, nil
+}
+
+func parseUints(fields []string) ([]uint64, error) {
+	vs := make([]uint64, 0, len(fields))
+	for _, f := range fields {
+		v, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+	}
+
+	return vs, nil
+}
+
+// at returns the i-th value or zero for counters missing on older kernels
+func at(vs []uint64, i int) uint64 {
+	if i < len(vs) {
+		return vs[i]
+	}
+	return 0
+}
+
+func nfsEvents(vs []uint64) NFSEvents {
+	return NFSEvents{
+		InodeRevalidate:  at(vs, 0),
+		DentryRevalidate: at(vs, 1),
+		DataInvalidate:   at(vs, 2),
+		AttrInvalidate:   at(vs, 3),
+		VFSOpen:          at(vs, 4),
+		VFSLookup:        at(vs, 5),
+		VFSAccess:        at(vs, 6),
+		VFSUpdatePage:    at(vs, 7),
+		VFSReadPage:      at(vs, 8),
+		VFSReadPages:     at(vs, 9),
+		VFSWritePage:     at(vs, 10),
+		VFSWritePages:    at(vs, 11),
+		VFSGetdents:      at(vs, 12),
+		VFSSetattr:       at(vs, 13),
+		VFSFlush:         at(vs, 14),
+		VFSFsync:         at(vs, 15),
+		VFSLock:          at(vs, 16),
+		VFSRelease:       at(vs, 17),
+		CongestionWait:   at(vs, 18),
+		SetattrTrunc:     at(vs, 19),
+		ExtendWrite:      at(vs, 20),
+		SillyRename:      at(vs, 21),
+		ShortRead:        at(vs, 22),
+		ShortWrite:       at(vs, 23),
+		JukeboxDelay:     at(vs, 24),
+		PNFSRead:         at(vs, 25),
+		PNFSWrite:        at(vs, 26),
+	}
+}
+
+func nfsBytes(vs []uint64) NFSBytes {
+	return NFSBytes{
+		NormalRead:  at(vs, 0),
+		NormalWrite: at(vs, 1),
+		DirectRead:  at(vs, 2),
+		DirectWrite: at(vs, 3),
+		ServerRead:  at(vs, 4),
+		ServerWrite: at(vs, 5),
+		ReadPages:   at(vs, 6),
+		WritePages:  at(vs, 7),
+	}
+}
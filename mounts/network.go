@@ -0,0 +1,192 @@
+package mounts
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var networkFSTypes = map[string]bool{
+	"nfs":   true,
+	"nfs4":  true,
+	"cifs":  true,
+	"smb3":  true,
+	"smbfs": true,
+	"ceph":  true,
+}
+
+// IsNetwork returns whether the mount is a network filesystem
+func (m Mount) IsNetwork() bool {
+	return networkFSTypes[m.FSType]
+}
+
+// Remote is the server side of a network mount
+type Remote struct {
+	Server string
+	Export string
+}
+
+// Remote parses the mount source of a network filesystem.
+// NFS sources look like "server:/export" and CIFS sources like
+// "//server/share".
+func (m Mount) Remote() (Remote, error) {
+	if !m.IsNetwork() {
+		return Remote{}, errors.Errorf("%s is not a network mount", m.MountPoint)
+	}
+
+	src := m.Source
+	if strings.HasPrefix(src, "//") {
+		parts := strings.SplitN(strings.TrimPrefix(src, "//"), "/", 2)
+		r := Remote{Server: parts[0], Export: "/"}
+		if len(parts) == 2 {
+			r.Export += parts[1]
+		}
+		return r, nil
+	}
+
+	// IPv6 servers are enclosed in brackets: [fe80::1]:/export
+	if strings.HasPrefix(src, "[") {
+		end := strings.Index(src, "]:")
+		if end < 0 {
+			return Remote{}, errors.Errorf("invalid network mount source %q", src)
+		}
+		return Remote{Server: src[1:end], Export: src[end+2:]}, nil
+	}
+
+	i := strings.Index(src, ":")
+	if i < 0 {
+		return Remote{}, errors.Errorf("invalid network mount source %q", src)
+	}
+
+	return Remote{Server: src[:i], Export: src[i+1:]}, nil
+}
+
+// Health is the state of a mount as seen by a probe
+type Health int
+
+const (
+	HealthUnknown Health = iota
+	HealthOK
+	HealthStale
+	HealthTimeout
+	HealthError
+)
+
+func (h Health) String() string {
+	switch h {
+	case HealthOK:
+		return "ok"
+	case HealthStale:
+		return "stale"
+	case HealthTimeout:
+		return "timeout"
+	case HealthError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// ProbeResult is the outcome of a mount health probe
+type ProbeResult struct {
+	Mount   Mount
+	Health  Health
+	Latency time.Duration
+	Err     error
+}
+
+// statfsCall is a statfs(2) in flight. done is closed once err is set, so
+// every probe waiting on it sees the outcome.
+type statfsCall struct {
+	done chan struct{}
+	err  error
+}
+
+var (
+	pendingMu sync.Mutex
+	// pending tracks probes stuck in the kernel by mount point
+	pending = map[string]*statfsCall{}
+)
+
+// Probe checks that the mount responds to statfs(2) before the context
+// expires. The call is made in a separate goroutine so a hung NFS server
+// can't block the caller. A goroutine stuck on a dead server is shared by
+// concurrent and subsequent probes of the same mount point instead of
+// piling up new ones.
+func Probe(ctx context.Context, m Mount) ProbeResult {
+	start := time.Now()
+
+	pendingMu.Lock()
+	call, ok := pending[m.MountPoint]
+	if !ok {
+		call = &statfsCall{done: make(chan struct{})}
+		pending[m.MountPoint] = call
+		go func(mp string) {
+			var st syscall.Statfs_t
+			call.err = syscall.Statfs(mp, &st)
+
+			pendingMu.Lock()
+			delete(pending, mp)
+			pendingMu.Unlock()
+
+			close(call.done)
+		}(m.MountPoint)
+	}
+	pendingMu.Unlock()
+
+	res := ProbeResult{Mount: m}
+	select {
+	case <-call.done:
+		res.Latency = time.Since(start)
+		switch err := call.err; {
+		case err == nil:
+			res.Health = HealthOK
+		case err == syscall.ESTALE:
+			res.Health = HealthStale
+			res.Err = errors.Wrapf(err, "stale file handle on %s", m.MountPoint)
+		default:
+			res.Health = HealthError
+			res.Err = errors.Wrapf(err, "failed to statfs %s", m.MountPoint)
+		}
+	case <-ctx.Done():
+		res.Latency = time.Since(start)
+		res.Health = HealthTimeout
+		res.Err = errors.Wrapf(ctx.Err(), "statfs %s did not return", m.MountPoint)
+	}
+
+	return res
+}
+
+// ProbeNetworkMounts concurrently probes every network mount with the given
+// per-mount timeout
+func ProbeNetworkMounts(ctx context.Context, timeout time.Duration) ([]ProbeResult, error) {
+	ms, err := ListMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	var network []Mount
+	for _, m := range ms {
+		if m.IsNetwork() {
+			network = append(network, m)
+		}
+	}
+
+	results := make([]ProbeResult, len(network))
+	var wg sync.WaitGroup
+	for i, m := range network {
+		wg.Add(1)
+		go func(i int, m Mount) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			results[i] = Probe(pctx, m)
+		}(i, m)
+	}
+	wg.Wait()
+
+	return results, nil
+}
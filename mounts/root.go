@@ -0,0 +1,95 @@
+package mounts
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ostreeBooted is created by ostree-prepare-root on ostree based systems
+// like Fedora CoreOS and Silverblue
+const ostreeBooted = "/run/ostree-booted"
+
+// Root describes how the root filesystem persists writes
+type Root struct {
+	Mount Mount
+	// ReadOnly is set for read-only mounts and filesystems that can't be
+	// written at all like squashfs or an overlay without an upper layer
+	ReadOnly bool
+	// Overlay is set for overlayfs roots of live and container systems
+	Overlay   bool
+	LowerDirs []string
+	UpperDir  string
+	// OSTree is set on ostree deployments where /usr is read-only and
+	// only /etc and /var keep local changes
+	OSTree bool
+	// Transient is set when writes are lost on reboot: the root or the
+	// upper layer of its overlay is in memory
+	Transient bool
+}
+
+// Persistent returns whether writes to the root filesystem survive a
+// reboot
+func (r Root) Persistent() bool {
+	return !r.ReadOnly && !r.Transient
+}
+
+// readOnlyFS are filesystems without write support
+var readOnlyFS = map[string]bool{"squashfs": true, "erofs": true, "iso9660": true, "cramfs": true}
+
+// memoryFS are filesystems keeping data in memory
+var memoryFS = map[string]bool{"tmpfs": true, "ramfs": true, "rootfs": true}
+
+// RootFilesystem inspects the root filesystem of the current mount
+// namespace
+func RootFilesystem() (*Root, error) {
+	ms, err := ListMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = os.Stat(ostreeBooted)
+	return root(ms, err == nil)
+}
+
+func root(ms []Mount, ostree bool) (*Root, error) {
+	m := lookup(ms, "/")
+	if m == nil || m.MountPoint != "/" {
+		return nil, errors.New("no root mount found")
+	}
+
+	r := &Root{
+		Mount:    *m,
+		ReadOnly: m.HasOption("ro") || readOnlyFS[m.FSType],
+		OSTree:   ostree,
+	}
+
+	switch {
+	case m.FSType == "overlay":
+		r.Overlay = true
+		if lower, ok := m.Option("lowerdir"); ok {
+			r.LowerDirs = strings.Split(lower, ":")
+		}
+		r.UpperDir, _ = m.Option("upperdir")
+		if r.UpperDir == "" {
+			r.ReadOnly = true
+		} else if upper := lookup(ms, r.UpperDir); upper != nil && upper.MountPoint != "/" {
+			r.Transient = memoryFS[upper.FSType] || inMemoryDevice(upper.Source)
+		}
+	case memoryFS[m.FSType]:
+		r.Transient = true
+	default:
+		r.Transient = inMemoryDevice(m.Source)
+	}
+
+	return r, nil
+}
+
+// inMemoryDevice returns whether the source is a RAM backed block device
+func inMemoryDevice(source string) bool {
+	name := path.Base(source)
+	return strings.HasPrefix(source, "/dev/") &&
+		(strings.HasPrefix(name, "zram") || strings.HasPrefix(name, "ram"))
+}
@@ -0,0 +1,161 @@
+package mounts
+
+import (
+	"time"
+)
+
+// MountRates are per-second rates of a mount computed between two samples
+type MountRates struct {
+	Device     string
+	MountPoint string
+	Interval   time.Duration
+
+	ReadBytesPerSec  float64
+	WriteBytesPerSec float64
+	Operations       []OperationRates
+}
+
+// OperationRates are rates and average latencies of a single NFS operation
+type OperationRates struct {
+	Name             string
+	OpsPerSec        float64
+	ErrorsPerSec     float64
+	TimeoutsPerSec   float64
+	AvgRTT           time.Duration
+	AvgExecute       time.Duration
+	AvgQueue         time.Duration
+	KilobytesPerOp   float64
+	RetransmitsRatio float64
+}
+
+// Sampler computes rates and latencies of NFS mounts from consecutive
+// mountstats readings. Sampler is not safe for concurrent use.
+type Sampler struct {
+	prev     map[string]MountStats
+	prevTime time.Time
+}
+
+// NewSampler creates a Sampler type
+func NewSampler() *Sampler {
+	return &Sampler{}
+}
+
+// Sample reads mountstats and returns rates since the previous call.
+// The first call only records the baseline and returns no rates.
+func (s *Sampler) Sample() ([]MountRates, error) {
+	stats, err := ListMountStats()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.add(stats, time.Now()), nil
+}
+
+func (s *Sampler) add(stats []MountStats, now time.Time) []MountRates {
+	cur := make(map[string]MountStats, len(stats))
+	for _, ms := range stats {
+		if ms.NFS != nil {
+			cur[ms.Device+" "+ms.MountPoint] = ms
+		}
+	}
+
+	var rates []MountRates
+	if s.prev != nil {
+		interval := now.Sub(s.prevTime)
+		for key, ms := range cur {
+			prev, ok := s.prev[key]
+			// A reset mount starts a new baseline instead of a bogus rate
+			if !ok || interval <= 0 || reset(prev, ms) {
+				continue
+			}
+			rates = append(rates, mountRates(prev, ms, interval))
+		}
+	}
+
+	s.prev = cur
+	s.prevTime = now
+
+	return rates
+}
+
+func mountRates(prev, cur MountStats, interval time.Duration) MountRates {
+	secs := interval.Seconds()
+
+	r := MountRates{
+		Device:           cur.Device,
+		MountPoint:       cur.MountPoint,
+		Interval:         interval,
+		ReadBytesPerSec:  float64(delta(prev.NFS.Bytes.ServerRead, cur.NFS.Bytes.ServerRead)) / secs,
+		WriteBytesPerSec: float64(delta(prev.NFS.Bytes.ServerWrite, cur.NFS.Bytes.ServerWrite)) / secs,
+	}
+
+	prevOps := make(map[string]NFSOperation, len(prev.NFS.Operations))
+	for _, op := range prev.NFS.Operations {
+		prevOps[op.Name] = op
+	}
+
+	for _, op := range cur.NFS.Operations {
+		p := prevOps[op.Name]
+		reqs := delta(p.Requests, op.Requests)
+
+		or := OperationRates{
+			Name:           op.Name,
+			OpsPerSec:      float64(reqs) / secs,
+			ErrorsPerSec:   float64(delta(p.Errors, op.Errors)) / secs,
+			TimeoutsPerSec: float64(delta(p.MajorTimeouts, op.MajorTimeouts)) / secs,
+		}
+		if reqs > 0 {
+			n := time.Duration(reqs)
+			or.AvgRTT = (op.RTT - p.RTT) / n
+			or.AvgExecute = (op.ExecuteTime - p.ExecuteTime) / n
+			or.AvgQueue = (op.QueueTime - p.QueueTime) / n
+
+			bytes := delta(p.BytesSent, op.BytesSent) + delta(p.BytesReceived, op.BytesReceived)
+			or.KilobytesPerOp = float64(bytes) / 1024 / float64(reqs)
+
+			trans := delta(p.Transmissions, op.Transmissions)
+			if trans > reqs {
+				or.RetransmitsRatio = float64(trans-reqs) / float64(reqs)
+			}
+		}
+		r.Operations = append(r.Operations, or)
+	}
+
+	return r
+}
+
+// reset reports whether counters of the mount went back, as they do when
+// it's remounted under the same name or a counter wraps
+func reset(prev, cur MountStats) bool {
+	if cur.NFS.Age < prev.NFS.Age ||
+		cur.NFS.Bytes.ServerRead < prev.NFS.Bytes.ServerRead ||
+		cur.NFS.Bytes.ServerWrite < prev.NFS.Bytes.ServerWrite {
+		return true
+	}
+
+	prevOps := make(map[string]NFSOperation, len(prev.NFS.Operations))
+	for _, op := range prev.NFS.Operations {
+		prevOps[op.Name] = op
+	}
+	for _, op := range cur.NFS.Operations {
+		p, ok := prevOps[op.Name]
+		if !ok {
+			continue
+		}
+		if op.Requests < p.Requests || op.Transmissions < p.Transmissions ||
+			op.MajorTimeouts < p.MajorTimeouts || op.Errors < p.Errors ||
+			op.BytesSent < p.BytesSent || op.BytesReceived < p.BytesReceived ||
+			op.QueueTime < p.QueueTime || op.RTT < p.RTT || op.ExecuteTime < p.ExecuteTime {
+			return true
+		}
+	}
+	return false
+}
+
+// delta returns the counter increase, zero if it went back
+func delta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return 0
+	}
+	return cur - prev
+}
@@ -0,0 +1,216 @@
+package mounts
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/pkg/errors"
+)
+
+const (
+	mntForce  = 0x1
+	mntDetach = 0x2
+
+	procRoot = "/proc"
+)
+
+// Escalation is what Unmount does when the mount is busy
+type Escalation int
+
+const (
+	// EscalateNone returns a BusyError
+	EscalateNone Escalation = iota
+	// EscalateLazy detaches the mount from the tree right away and cleans
+	// it up once it's no longer in use (umount -l)
+	EscalateLazy
+	// EscalateForce aborts pending requests, which only network
+	// filesystems support, and falls back to a lazy unmount (umount -f -l)
+	EscalateForce
+)
+
+// UnmountOptions configures Unmount
+type UnmountOptions struct {
+	// Retries is the number of plain unmount attempts before escalating,
+	// useful when holders are expected to go away shortly
+	Retries       int
+	RetryInterval time.Duration
+	Escalation    Escalation
+	// OnBusy is called with the holders of the mount every time it was
+	// found busy, including when it's about to be escalated
+	OnBusy func(*BusyError)
+}
+
+// Holder is a process keeping a mount busy
+type Holder struct {
+	PID     int
+	Command string
+	// Uses describe what is held, e.g. "cwd", "root", "exe", "fd 3",
+	// "mmap /mnt/data/lib.so"
+	Uses []string
+}
+
+// BusyError is returned when a mount can't be unmounted because it's in use
+type BusyError struct {
+	MountPoint string
+	Holders    []Holder
+}
+
+func (e *BusyError) Error() string {
+	if len(e.Holders) == 0 {
+		return fmt.Sprintf("%s is busy", e.MountPoint)
+	}
+
+	var hs []string
+	for _, h := range e.Holders {
+		hs = append(hs, fmt.Sprintf("%s[%d] (%s)", h.Command, h.PID, strings.Join(h.Uses, ", ")))
+	}
+	return fmt.Sprintf("%s is busy: held by %s", e.MountPoint, strings.Join(hs, "; "))
+}
+
+// Unmount unmounts target. When the mount is busy the processes holding it
+// are reported in a BusyError, or the unmount is escalated according to
+// the options.
+func Unmount(ctx context.Context, target string, opts UnmountOptions) error {
+	target = path.Clean(target)
+
+	a := action.Action{
+		Op:     "umount",
+		Target: target,
+		Args:   map[string]string{"escalation": strconv.Itoa(int(opts.Escalation))},
+	}
+
+	return action.Run(ctx, a, func() error {
+		return unmount(ctx, target, opts)
+	})
+}
+
+func unmount(ctx context.Context, target string, opts UnmountOptions) error {
+	for attempt := 0; ; attempt++ {
+		err := syscall.Unmount(target, 0)
+		if err == nil {
+			return nil
+		}
+		if err != syscall.EBUSY {
+			return errors.Wrapf(err, "failed to unmount %s", target)
+		}
+
+		busy := &BusyError{MountPoint: target}
+		busy.Holders, _ = Holders(target)
+		if opts.OnBusy != nil {
+			opts.OnBusy(busy)
+		}
+
+		if attempt < opts.Retries {
+			select {
+			case <-time.After(opts.RetryInterval):
+				continue
+			case <-ctx.Done():
+				return busy
+			}
+		}
+
+		switch opts.Escalation {
+		case EscalateForce:
+			if err := syscall.Unmount(target, mntForce); err == nil {
+				return nil
+			}
+			fallthrough
+		case EscalateLazy:
+			if err := syscall.Unmount(target, mntDetach); err != nil {
+				return errors.Wrapf(err, "failed to lazily unmount %s", target)
+			}
+			return nil
+		}
+
+		return busy
+	}
+}
+
+// Holders returns processes that use files under the mount point as their
+// working directory, root, executable, open files or memory mappings
+func Holders(mountPoint string) ([]Holder, error) {
+	mountPoint = path.Clean(mountPoint)
+
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", procRoot)
+	}
+
+	var hs []Holder
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+
+		// Processes exit and deny access all the time during the scan,
+		// their entries are just skipped
+		uses := processUses(pid, mountPoint)
+		if len(uses) == 0 {
+			continue
+		}
+
+		comm, _ := ioutil.ReadFile(path.Join(procRoot, e.Name(), "comm"))
+		hs = append(hs, Holder{
+			PID:     pid,
+			Command: strings.TrimSpace(string(comm)),
+			Uses:    uses,
+		})
+	}
+
+	return hs, nil
+}
+
+func processUses(pid int, mountPoint string) []string {
+	dir := path.Join(procRoot, strconv.Itoa(pid))
+
+	var uses []string
+	for _, l := range []string{"cwd", "root", "exe"} {
+		if target, err := os.Readlink(path.Join(dir, l)); err == nil && under(mountPoint, target) {
+			uses = append(uses, l)
+		}
+	}
+
+	fds, _ := ioutil.ReadDir(path.Join(dir, "fd"))
+	for _, fd := range fds {
+		target, err := os.Readlink(path.Join(dir, "fd", fd.Name()))
+		if err == nil && under(mountPoint, target) {
+			uses = append(uses, "fd "+fd.Name()+" "+target)
+		}
+	}
+
+	maps, _ := ioutil.ReadFile(path.Join(dir, "maps"))
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(maps), "\n") {
+		// The path is the sixth field: address perms offset dev inode path
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+		p := fields[5]
+		if !seen[p] && under(mountPoint, p) {
+			seen[p] = true
+			uses = append(uses, "mmap "+p)
+		}
+	}
+
+	return uses
+}
+
+// under returns whether p resolved from /proc is located under the mount
+// point, ignoring the suffix the kernel appends to deleted files
+func under(mountPoint, p string) bool {
+	p = strings.TrimSuffix(p, " (deleted)")
+	if !strings.HasPrefix(p, "/") {
+		return false
+	}
+	return contains(mountPoint, p)
+}
@@ -0,0 +1,60 @@
+package mounts
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// Usage is the occupancy of a mounted filesystem from statfs(2)
+type Usage struct {
+	// Total, Free and Available are in bytes. Available excludes blocks
+	// reserved for root, so it's what unprivileged users can still write.
+	Total     uint64
+	Free      uint64
+	Available uint64
+	// Inodes and InodesFree are zero for filesystems without a fixed inode
+	// table like btrfs
+	Inodes     uint64
+	InodesFree uint64
+	// BlockSize is the fundamental block size of the filesystem
+	BlockSize uint64
+}
+
+// Used returns bytes in use
+func (u Usage) Used() uint64 {
+	return u.Total - u.Free
+}
+
+// UsedPercent returns the used share of space as df reports it, relative
+// to what is available to unprivileged users
+func (u Usage) UsedPercent() float64 {
+	used := u.Used()
+	if used+u.Available == 0 {
+		return 0
+	}
+	return float64(used) * 100 / float64(used+u.Available)
+}
+
+// FilesystemUsage returns usage of the filesystem mounted at the path
+func FilesystemUsage(mountPoint string) (Usage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(mountPoint, &st); err != nil {
+		return Usage{}, errors.Wrapf(err, "failed to statfs %s", mountPoint)
+	}
+
+	// Block counts are in f_frsize units, f_bsize is the preferred I/O size
+	bs := uint64(st.Frsize)
+	if bs == 0 {
+		bs = uint64(st.Bsize)
+	}
+
+	return Usage{
+		Total:      st.Blocks * bs,
+		Free:       st.Bfree * bs,
+		Available:  st.Bavail * bs,
+		Inodes:     st.Files,
+		InodesFree: st.Ffree,
+		BlockSize:  bs,
+	}, nil
+}
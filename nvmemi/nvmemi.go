@@ -0,0 +1,273 @@
+// Package nvmemi identifies NVMe drives and reads their health over SMBus
+// with the NVMe-MI Basic Management Command. It covers drives behind
+// backplanes where the host only sees the management bus.
+package nvmemi
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	sysfsI2CRoot = "/sys/bus/i2c/devices"
+
+	// DefaultAddress is the 7-bit SMBus address of the Basic Management
+	// Command responder
+	DefaultAddress = 0x6a
+
+	cmdStatus = 0
+	cmdVendor = 8
+
+	statusLength = 6
+	vendorLength = 22
+)
+
+// Status flags from the Basic Management Command
+const (
+	flagNotReady   = 1 << 6
+	flagFunctional = 1 << 5
+	flagNoReset    = 1 << 4
+	flagPort0Link  = 1 << 3
+	flagPort1Link  = 1 << 2
+)
+
+// SMART warnings bits. The drive reports them inverted, cleared bits are
+// warnings, and Health holds them the natural way.
+const (
+	WarnSpare       = 1 << 0
+	WarnTemperature = 1 << 1
+	WarnReliability = 1 << 2
+	WarnReadOnly    = 1 << 3
+	WarnBackup      = 1 << 4
+)
+
+// Health is the drive state reported by the Basic Management Command
+type Health struct {
+	Ready      bool
+	Functional bool
+	// ResetRequired is set when the drive needs a reset to recover
+	ResetRequired bool
+	Port0Link     bool
+	Port1Link     bool
+	// Warnings are SMART critical warnings, see Warn* constants
+	Warnings uint8
+	// Temperature is the composite temperature in Celsius, valid only if
+	// TemperatureValid is set
+	Temperature      int
+	TemperatureValid bool
+	// PercentUsed is an estimate of drive life used, may exceed 100
+	PercentUsed int
+}
+
+// Identity is the vendor and serial number of the drive
+type Identity struct {
+	VendorID uint16
+	Serial   string
+}
+
+// Drive is an NVMe drive reachable on an SMBus
+type Drive struct {
+	// Bus is the i2c adapter number, usually a backplane mux channel
+	Bus     int
+	Address uint8
+	Identity
+}
+
+// Health reads the drive health
+func (d Drive) Health() (*Health, error) {
+	buf, err := d.read(cmdStatus, statusLength)
+	if err != nil {
+		return nil, err
+	}
+
+	flags, warn, temp, used := buf[0], buf[1], buf[2], buf[3]
+	h := &Health{
+		Ready:         flags&flagNotReady == 0,
+		Functional:    flags&flagFunctional != 0,
+		ResetRequired: flags&flagNoReset == 0,
+		Port0Link:     flags&flagPort0Link != 0,
+		Port1Link:     flags&flagPort1Link != 0,
+		Warnings:      ^warn & 0x1f,
+		PercentUsed:   int(used),
+	}
+
+	// 0x80 means no data and 0x81 is a sensor failure, otherwise it's a
+	// two's complement value clamped to -60..127
+	if temp != 0x80 && temp != 0x81 {
+		h.Temperature = int(int8(temp))
+		h.TemperatureValid = true
+	}
+
+	return h, nil
+}
+
+// Identify reads the vendor and serial number of the drive at the address
+func Identify(bus int, addr uint8) (*Drive, error) {
+	d := Drive{Bus: bus, Address: addr}
+
+	buf, err := d.read(cmdVendor, vendorLength)
+	if err != nil {
+		return nil, err
+	}
+
+	d.VendorID = uint16(buf[0])<<8 | uint16(buf[1])
+	d.Serial = strings.TrimSpace(strings.TrimRight(string(buf[2:vendorLength]), "\x00"))
+
+	return &d, nil
+}
+
+// ListDrives probes the default address on the i2c adapters and returns
+// drives that answered with a valid Basic Management Command response.
+// Buses aren't probed by default since the probe writes to the address,
+// which other devices may not take well: callers name the backplane buses,
+// e.g. from MuxBuses. A bus that can't be opened is an error.
+func ListDrives(buses []int) ([]Drive, error) {
+	var ds []Drive
+	for _, bus := range buses {
+		d, err := Identify(bus, DefaultAddress)
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+			return nil, err
+		}
+		// Nothing at the address is not an error
+		if err != nil {
+			continue
+		}
+		ds = append(ds, *d)
+	}
+
+	return ds, nil
+}
+
+// MuxBuses returns the channels of i2c multiplexers, which is how
+// backplanes usually expose the management bus of each drive slot
+func MuxBuses() ([]int, error) {
+	entries, err := ioutil.ReadDir(sysfsI2CRoot)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", sysfsI2CRoot)
+	}
+
+	var buses []int
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), "i2c-") {
+			continue
+		}
+		bus, err := strconv.Atoi(strings.TrimPrefix(e.Name(), "i2c-"))
+		if err != nil {
+			continue
+		}
+		// The mux core names channels like "i2c-3-mux (chan_id 0)"
+		if strings.Contains(readAttr(path.Join(sysfsI2CRoot, e.Name(), "name")), "-mux (chan_id ") {
+			buses = append(buses, bus)
+		}
+	}
+	sort.Ints(buses)
+
+	return buses, nil
+}
+
+func readAttr(p string) string {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+const (
+	i2cSlave = 0x0703
+	i2cSMBus = 0x0720
+
+	smbusRead         = 1
+	smbusI2CBlockData = 8
+	smbusBlockMax     = 32
+)
+
+// smbusIoctlData mirrors struct i2c_smbus_ioctl_data
+type smbusIoctlData struct {
+	readWrite uint8
+	command   uint8
+	size      uint32
+	data      unsafe.Pointer
+}
+
+// read reads a length-prefixed block at the command offset and returns its
+// payload after checking the length and the packet error code
+func (d Drive) read(cmd uint8, length int) ([]byte, error) {
+	node := path.Join("/dev", "i2c-"+strconv.Itoa(d.Bus))
+	f, err := os.OpenFile(node, os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", node)
+	}
+	defer f.Close()
+
+	if err := ioctl(f, i2cSlave, uintptr(d.Address)); err != nil {
+		return nil, errors.Wrapf(err, "failed to select address %#x on %s", d.Address, node)
+	}
+
+	// Length byte, payload and PEC
+	n := length + 2
+	var block [smbusBlockMax + 2]byte
+	block[0] = byte(n)
+	args := smbusIoctlData{
+		readWrite: smbusRead,
+		command:   cmd,
+		size:      smbusI2CBlockData,
+		data:      unsafe.Pointer(&block),
+	}
+	if err := ioctl(f, i2cSMBus, uintptr(unsafe.Pointer(&args))); err != nil {
+		return nil, errors.Wrapf(err, "failed to read command %d from %#x on %s", cmd, d.Address, node)
+	}
+
+	resp := block[1 : 1+n]
+	if int(resp[0]) != length {
+		return nil, errors.Errorf("unexpected response length %d from %#x on %s", resp[0], d.Address, node)
+	}
+
+	// PEC covers the address with write bit, the command, the address with
+	// read bit and all the data bytes
+	pec := crc8([]byte{d.Address << 1, cmd, d.Address<<1 | 1})
+	pec = crc8Update(pec, resp[:n-1])
+	if pec != resp[n-1] {
+		return nil, errors.Errorf("bad PEC in response from %#x on %s", d.Address, node)
+	}
+
+	return resp[1 : n-1], nil
+}
+
+func crc8(b []byte) uint8 {
+	return crc8Update(0, b)
+}
+
+// crc8Update computes SMBus PEC, CRC-8 with x^8+x^2+x+1 polynomial
+func crc8Update(crc uint8, b []byte) uint8 {
+	for _, c := range b {
+		crc ^= c
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func ioctl(f *os.File, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
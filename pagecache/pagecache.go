@@ -0,0 +1,168 @@
+// Package pagecache reports how much of a file or block device is resident
+// in the page cache, like fincore does, for tools warming or evicting the
+// cache. It uses cachestat(2) of Linux 6.5 and falls back to mapping the
+// file and asking mincore(2), which only tells cached pages apart.
+package pagecache
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// sysCachestat is the same on all architectures
+const sysCachestat = 451
+
+// mincoreChunk bounds how much of the file is mapped at once
+const mincoreChunk = 1 << 30
+
+// Stat is the page cache state of a range of a file in pages
+type Stat struct {
+	// Pages is the size of the range
+	Pages     uint64
+	Cached    uint64
+	Dirty     uint64
+	Writeback uint64
+	// Evicted pages were cached and dropped, RecentlyEvicted ones would
+	// have been hit by a recent access
+	Evicted         uint64
+	RecentlyEvicted uint64
+	// Detailed is false when the kernel lacks cachestat and only Cached is
+	// known
+	Detailed bool
+}
+
+// Resident returns the cached part of the range from 0 to 1
+func (s Stat) Resident() float64 {
+	if s.Pages == 0 {
+		return 0
+	}
+	return float64(s.Cached) / float64(s.Pages)
+}
+
+// CachedBytes returns the size of cached pages in bytes
+func (s Stat) CachedBytes() uint64 {
+	return s.Cached * uint64(os.Getpagesize())
+}
+
+// Path returns the page cache state of the whole file or block device
+func Path(p string) (Stat, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return Stat{}, errors.Wrapf(err, "failed to open %s", p)
+	}
+	defer f.Close()
+
+	return File(f, 0, 0)
+}
+
+// File returns the page cache state of length bytes of the file at the
+// offset, length 0 means up to the end. Block devices opened as files are
+// supported.
+func File(f *os.File, off, length int64) (Stat, error) {
+	if off < 0 || length < 0 {
+		return Stat{}, errors.Errorf("invalid range %d+%d of %s", off, length, f.Name())
+	}
+	size, err := fileSize(f)
+	if err != nil {
+		return Stat{}, errors.Wrapf(err, "failed to get size of %s", f.Name())
+	}
+	if length == 0 || off+length > size {
+		length = size - off
+	}
+	if length <= 0 {
+		return Stat{}, nil
+	}
+
+	s, err := cachestat(f, off, length)
+	if err == syscall.ENOSYS || err == syscall.EOPNOTSUPP {
+		s, err = mincore(f, off, length)
+	}
+	return s, errors.Wrapf(err, "failed to get page cache state of %s", f.Name())
+}
+
+// fileSize seeks to the end, which works for block devices too, and
+// restores the offset of the caller
+func fileSize(f *os.File) (int64, error) {
+	cur, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = f.Seek(cur, io.SeekStart)
+	return size, err
+}
+
+// pages returns how many pages the range touches
+func pages(off, length int64) uint64 {
+	page := int64(os.Getpagesize())
+	return uint64((off+length+page-1)/page - off/page)
+}
+
+// cachestatRange and cachestatResult are struct cachestat_range and
+// struct cachestat
+type cachestatRange struct {
+	off, len uint64
+}
+
+type cachestatResult struct {
+	cache           uint64
+	dirty           uint64
+	writeback       uint64
+	evicted         uint64
+	recentlyEvicted uint64
+}
+
+func cachestat(f *os.File, off, length int64) (Stat, error) {
+	r := cachestatRange{off: uint64(off), len: uint64(length)}
+	var c cachestatResult
+	_, _, errno := syscall.Syscall6(sysCachestat, f.Fd(), uintptr(unsafe.Pointer(&r)), uintptr(unsafe.Pointer(&c)), 0, 0, 0)
+	if errno != 0 {
+		return Stat{}, errno
+	}
+	return Stat{
+		Pages:           pages(off, length),
+		Cached:          c.cache,
+		Dirty:           c.dirty,
+		Writeback:       c.writeback,
+		Evicted:         c.evicted,
+		RecentlyEvicted: c.recentlyEvicted,
+		Detailed:        true,
+	}, nil
+}
+
+// mincore maps the range chunk by chunk and counts resident pages. Mapping
+// doesn't read the file, so the cache is left as is.
+func mincore(f *os.File, off, length int64) (Stat, error) {
+	page := int64(os.Getpagesize())
+	s := Stat{Pages: pages(off, length)}
+
+	end := off + length
+	for pos := off &^ (page - 1); pos < end; pos += mincoreChunk {
+		n := end - pos
+		if n > mincoreChunk {
+			n = mincoreChunk
+		}
+		m, err := syscall.Mmap(int(f.Fd()), pos, int(n), syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			return Stat{}, err
+		}
+
+		vec := make([]byte, (n+page-1)/page)
+		_, _, errno := syscall.Syscall(syscall.SYS_MINCORE, uintptr(unsafe.Pointer(&m[0])), uintptr(n), uintptr(unsafe.Pointer(&vec[0])))
+		syscall.Munmap(m)
+		if errno != 0 {
+			return Stat{}, errno
+		}
+		for _, v := range vec {
+			s.Cached += uint64(v & 1)
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,194 @@
+package parttable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+var gptSignature = []byte("EFI PART")
+
+const (
+	gptHeaderSize = 92
+	gptEntrySize  = 128
+	// gptMaxEntries bounds allocations for corrupt headers, tools create
+	// 128 entries
+	gptMaxEntries = 1024
+	// gptMaxEntrySize and gptMaxTableSize bound the entry array of corrupt
+	// headers with a valid CRC before it's allocated
+	gptMaxEntrySize = 4096
+	gptMaxTableSize = 1 << 20
+)
+
+// gptHeader is the decoded GPT header
+type gptHeader struct {
+	currentLBA     uint64
+	backupLBA      uint64
+	firstUsableLBA uint64
+	lastUsableLBA  uint64
+	diskGUID       GUID
+	entriesLBA     uint64
+	numEntries     uint32
+	entrySize      uint32
+	entriesCRC     uint32
+}
+
+// readGPT reads the primary GPT at LBA 1 and the backup at the last LBA.
+// It returns nil if neither has a signature at this sector size.
+func readGPT(r io.ReaderAt, size, ssz int64) (*Table, error) {
+	if size < 3*ssz {
+		return nil, nil
+	}
+	lastLBA := uint64(size/ssz) - 1
+
+	primary, primaryEntries, primarySig, err := readGPTAt(r, size, ssz, 1)
+	if err != nil {
+		return nil, err
+	}
+	var backup *gptHeader
+	var backupEntries []byte
+	backupSig := false
+	if primary != nil && primary.backupLBA > 1 && primary.backupLBA <= lastLBA {
+		backup, backupEntries, backupSig, err = readGPTAt(r, size, ssz, primary.backupLBA)
+	} else {
+		backup, backupEntries, backupSig, err = readGPTAt(r, size, ssz, lastLBA)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !primarySig && !backupSig {
+		return nil, nil
+	}
+
+	h, entries := primary, primaryEntries
+	if h == nil {
+		h, entries = backup, backupEntries
+	}
+	if h == nil {
+		return nil, errors.New("GPT headers are corrupt")
+	}
+
+	t := &Table{
+		Type:           TypeGPT,
+		ID:             h.diskGUID.String(),
+		SectorSize:     ssz,
+		DiskSize:       size,
+		DiskGUID:       h.diskGUID,
+		FirstUsableLBA: h.firstUsableLBA,
+		LastUsableLBA:  h.lastUsableLBA,
+		PrimaryValid:   primary != nil,
+		BackupValid:    backup != nil,
+	}
+
+	for i := 0; i < int(h.numEntries); i++ {
+		e := entries[i*int(h.entrySize):]
+		p := Partition{
+			Number:     i + 1,
+			FirstLBA:   binary.LittleEndian.Uint64(e[32:]),
+			LastLBA:    binary.LittleEndian.Uint64(e[40:]),
+			Attributes: binary.LittleEndian.Uint64(e[48:]),
+			Name:       utf16String(e[56:128]),
+		}
+		copy(p.TypeGUID[:], e[0:16])
+		copy(p.GUID[:], e[16:32])
+		if p.TypeGUID.IsZero() {
+			continue
+		}
+		p.Bootable = p.Attributes&AttrLegacyBIOSBootable != 0
+		t.Partitions = append(t.Partitions, p)
+	}
+
+	return t, nil
+}
+
+// readGPTAt reads the header at the LBA and its entries. The header is nil
+// if it fails validation, sig reports whether the signature was there.
+func readGPTAt(r io.ReaderAt, size, ssz int64, lba uint64) (h *gptHeader, entries []byte, sig bool, err error) {
+	buf := make([]byte, ssz)
+	if _, err := r.ReadAt(buf, int64(lba)*ssz); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil, false, nil
+		}
+		return nil, nil, false, errors.Wrapf(err, "failed to read GPT header at LBA %d", lba)
+	}
+	if !bytes.Equal(buf[:8], gptSignature) {
+		return nil, nil, false, nil
+	}
+
+	h, ok := parseGPTHeader(buf, lba)
+	if !ok {
+		return nil, nil, true, nil
+	}
+
+	// The table has to fit the device, so a header can't make up a huge
+	// allocation
+	tableSize := int64(h.numEntries) * int64(h.entrySize)
+	if tableSize > gptMaxTableSize || h.entriesLBA > uint64(size/ssz) ||
+		int64(h.entriesLBA)*ssz+tableSize > size {
+		return nil, nil, true, nil
+	}
+
+	entries = make([]byte, tableSize)
+	if _, err := r.ReadAt(entries, int64(h.entriesLBA)*ssz); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil, true, nil
+		}
+		return nil, nil, true, errors.Wrapf(err, "failed to read GPT entries at LBA %d", h.entriesLBA)
+	}
+	if crc32.ChecksumIEEE(entries) != h.entriesCRC {
+		return nil, nil, true, nil
+	}
+
+	return h, entries, true, nil
+}
+
+// parseGPTHeader validates the header CRC and sanity of its fields
+func parseGPTHeader(buf []byte, lba uint64) (*gptHeader, bool) {
+	hdrSize := binary.LittleEndian.Uint32(buf[12:])
+	if hdrSize < gptHeaderSize || int(hdrSize) > len(buf) {
+		return nil, false
+	}
+
+	hdr := append([]byte(nil), buf[:hdrSize]...)
+	crc := binary.LittleEndian.Uint32(hdr[16:])
+	binary.LittleEndian.PutUint32(hdr[16:], 0)
+	if crc32.ChecksumIEEE(hdr) != crc {
+		return nil, false
+	}
+
+	h := &gptHeader{
+		currentLBA:     binary.LittleEndian.Uint64(buf[24:]),
+		backupLBA:      binary.LittleEndian.Uint64(buf[32:]),
+		firstUsableLBA: binary.LittleEndian.Uint64(buf[40:]),
+		lastUsableLBA:  binary.LittleEndian.Uint64(buf[48:]),
+		entriesLBA:     binary.LittleEndian.Uint64(buf[72:]),
+		numEntries:     binary.LittleEndian.Uint32(buf[80:]),
+		entrySize:      binary.LittleEndian.Uint32(buf[84:]),
+		entriesCRC:     binary.LittleEndian.Uint32(buf[88:]),
+	}
+	copy(h.diskGUID[:], buf[56:72])
+
+	if h.currentLBA != lba || h.entrySize < gptEntrySize || h.entrySize > gptMaxEntrySize ||
+		h.entrySize%8 != 0 || h.numEntries > gptMaxEntries {
+		return nil, false
+	}
+	return h, true
+}
+
+// utf16String decodes the NUL terminated UTF-16LE partition name
+func utf16String(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
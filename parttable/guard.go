@@ -0,0 +1,63 @@
+package parttable
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/alexdzyoba/sys/block"
+	"github.com/alexdzyoba/sys/mounts"
+	"github.com/alexdzyoba/sys/probe"
+	"github.com/pkg/errors"
+)
+
+// checkBlank refuses a disk without a partition table that still carries a
+// filesystem, LVM, LUKS, RAID or other signature
+func checkBlank(devicePath string) error {
+	sigs, err := probe.Scan(devicePath)
+	if err != nil {
+		return err
+	}
+	if len(sigs) > 0 {
+		return errors.Errorf("%s has a %s signature, set Replace to overwrite it", devicePath, sigs[0].Type)
+	}
+	return nil
+}
+
+// claim refuses a disk that is mounted or used by device mapper or md and
+// opens it exclusively, so nothing claims it while the table is written.
+// Image files are returned as is with a nil file.
+func claim(devicePath string) (*os.File, error) {
+	fi, err := os.Stat(devicePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to stat %v", devicePath)
+	}
+	if fi.Mode()&os.ModeDevice == 0 {
+		return nil, nil
+	}
+
+	ms, err := mounts.MountsForDevice(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(ms) > 0 {
+		return nil, errors.Errorf("%s is in use, %s is mounted at %s", devicePath, ms[0].Source, ms[0].MountPoint)
+	}
+
+	d, err := block.NewDevice(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	holders, err := d.Holders()
+	if err != nil {
+		return nil, err
+	}
+	if len(holders) > 0 {
+		return nil, errors.Errorf("%s is in use by %s", devicePath, holders[0].Name)
+	}
+
+	f, err := os.OpenFile(devicePath, os.O_RDONLY|syscall.O_EXCL, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s is in use, failed to open it exclusively", devicePath)
+	}
+	return f, nil
+}
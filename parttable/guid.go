@@ -0,0 +1,94 @@
+package parttable
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GUID as stored in GPT: the first three groups are little-endian
+type GUID [16]byte
+
+// Partition type GUIDs
+var (
+	TypeEFISystem          = MustParseGUID("c12a7328-f81f-11d2-ba4b-00a0c93ec93b")
+	TypeBIOSBoot           = MustParseGUID("21686148-6449-6e6f-744e-656564454649")
+	TypeMicrosoftBasicData = MustParseGUID("ebd0a0a2-b9e5-4433-87c0-68b6b72699c7")
+	TypeLinuxFilesystem    = MustParseGUID("0fc63daf-8483-4772-8e79-3d69d8477de4")
+	TypeLinuxSwap          = MustParseGUID("0657fd6d-a4ab-43c4-84e5-0933c84b4f4f")
+	TypeLinuxLVM           = MustParseGUID("e6d6d379-f507-44c2-a23c-238f2a3df928")
+	TypeLinuxRAID          = MustParseGUID("a19d880f-05fc-4d3b-a006-743f0f84911e")
+	TypeLinuxRootX86_64    = MustParseGUID("4f68bce3-e8cd-4db1-96e7-fbcaf984b709")
+	TypeLinuxRootARM64     = MustParseGUID("b921b045-1df0-41c3-af44-4c6f280d3fae")
+	TypeLinuxHome          = MustParseGUID("933ac7e1-2eb4-4f13-b844-0e14e2aef915")
+	TypeLinuxExtendedBoot  = MustParseGUID("bc13c2ff-59e6-4262-a352-b275fd6f7172")
+	TypeLinuxLUKS          = MustParseGUID("ca7d7ccb-63ed-4c53-861c-1742536059cc")
+	TypeMicrosoftReserved  = MustParseGUID("e3c9e316-0b5c-4db8-817d-f92df00215ae")
+	TypeWindowsRecovery    = MustParseGUID("de94bba4-06d1-4d40-a16a-bfd50179d6ac")
+)
+
+// ParseGUID parses the textual form like
+// "0fc63daf-8483-4772-8e79-3d69d8477de4", case insensitive
+func ParseGUID(s string) (GUID, error) {
+	var g GUID
+
+	parts := strings.Split(s, "-")
+	if len(parts) != 5 || len(parts[0]) != 8 || len(parts[1]) != 4 || len(parts[2]) != 4 ||
+		len(parts[3]) != 4 || len(parts[4]) != 12 {
+		return g, errors.Errorf("invalid GUID %q", s)
+	}
+	b, err := hex.DecodeString(strings.Join(parts, ""))
+	if err != nil {
+		return g, errors.Errorf("invalid GUID %q", s)
+	}
+
+	copy(g[:], b)
+	g.swap()
+	return g, nil
+}
+
+// MustParseGUID is ParseGUID panicking on errors, for constants
+func MustParseGUID(s string) GUID {
+	g, err := ParseGUID(s)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// IsZero returns whether the GUID is all zeroes, which marks unused GPT
+// entries
+func (g GUID) IsZero() bool {
+	return g == GUID{}
+}
+
+// String returns the lowercase textual form like udev and lsblk print it
+func (g GUID) String() string {
+	g.swap()
+	return fmt.Sprintf("%x-%x-%x-%x-%x", g[0:4], g[4:6], g[6:8], g[8:10], g[10:16])
+}
+
+// MarshalText encodes the GUID in the textual form
+func (g GUID) MarshalText() ([]byte, error) {
+	return []byte(g.String()), nil
+}
+
+// UnmarshalText decodes the textual form
+func (g *GUID) UnmarshalText(text []byte) error {
+	parsed, err := ParseGUID(string(text))
+	if err != nil {
+		return err
+	}
+	*g = parsed
+	return nil
+}
+
+// swap converts between the big-endian textual byte order and the mixed
+// endian on-disk one, it's its own inverse
+func (g *GUID) swap() {
+	g[0], g[1], g[2], g[3] = g[3], g[2], g[1], g[0]
+	g[4], g[5] = g[5], g[4]
+	g[6], g[7] = g[7], g[6]
+}
@@ -0,0 +1,320 @@
+package parttable
+
+import (
+	"context"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/pkg/errors"
+)
+
+// DefaultAlignment aligns partitions to 1MiB like parted and sgdisk do
+const DefaultAlignment = 1 << 20
+
+// Layout is the desired GPT of a disk
+type Layout struct {
+	// DiskGUID is kept from the current table or random if zero
+	DiskGUID GUID
+	// Alignment of partition starts in bytes, DefaultAlignment if zero
+	Alignment int64
+	// Partitions are laid out in order, numbered from 1
+	Partitions []PartitionSpec
+	// Replace allows overwriting a table whose partitions differ from the
+	// layout. Without it the layout can only add partitions after the
+	// existing ones.
+	Replace bool
+}
+
+// PartitionSpec is a partition of a layout
+type PartitionSpec struct {
+	Name string
+	Type GUID
+	// GUID is kept from the current table or random if zero
+	GUID GUID
+	// Size in bytes, rounded up to whole sectors. Zero takes the rest of
+	// the disk and is only allowed for the last partition.
+	Size       int64
+	Attributes uint64
+}
+
+// Table lays out the partitions on a disk of the given size. GUIDs left
+// zero in the layout are taken from the current table, which may be nil,
+// or generated.
+func (l Layout) Table(diskSize, sectorSize int64, current *Table) (*Table, error) {
+	if sectorSize <= 0 {
+		sectorSize = defaultSectorSize
+	}
+	if diskSize < 3*sectorSize+2*int64(gptEntries*gptEntrySize) {
+		return nil, errors.Errorf("disk of %d bytes is too small for GPT", diskSize)
+	}
+
+	align := uint64(1)
+	if l.Alignment == 0 {
+		l.Alignment = DefaultAlignment
+	}
+	if l.Alignment > sectorSize {
+		align = uint64(l.Alignment / sectorSize)
+	}
+
+	t := &Table{Type: TypeGPT, SectorSize: sectorSize, DiskSize: diskSize, DiskGUID: l.DiskGUID}
+	t.FirstUsableLBA, t.LastUsableLBA = usable(diskSize, sectorSize)
+	if current != nil && current.Type == TypeGPT && t.DiskGUID.IsZero() {
+		t.DiskGUID = current.DiskGUID
+	}
+	if t.DiskGUID.IsZero() {
+		t.DiskGUID = randomGUID()
+	}
+	t.ID = t.DiskGUID.String()
+
+	next := roundUp(t.FirstUsableLBA, align)
+	for i, s := range l.Partitions {
+		p := Partition{
+			Number:     i + 1,
+			FirstLBA:   next,
+			TypeGUID:   s.Type,
+			GUID:       s.GUID,
+			Name:       s.Name,
+			Attributes: s.Attributes,
+			Bootable:   s.Attributes&AttrLegacyBIOSBootable != 0,
+		}
+
+		switch {
+		case s.Size < 0:
+			return nil, errors.Errorf("partition %d has negative size", p.Number)
+		case s.Size == 0 && i != len(l.Partitions)-1:
+			return nil, errors.Errorf("partition %d takes the rest of the disk but isn't the last one", p.Number)
+		case s.Size == 0:
+			// The end stays aligned too so the disk can grow cleanly
+			p.LastLBA = (t.LastUsableLBA+1)/align*align - 1
+			if p.LastLBA < p.FirstLBA {
+				p.LastLBA = t.LastUsableLBA
+			}
+		default:
+			p.LastLBA = p.FirstLBA + uint64((s.Size+sectorSize-1)/sectorSize) - 1
+		}
+		if p.FirstLBA > t.LastUsableLBA || p.LastLBA > t.LastUsableLBA {
+			return nil, errors.Errorf("partition %d doesn't fit on the disk", p.Number)
+		}
+
+		if p.GUID.IsZero() && current != nil {
+			if c := current.Partition(p.Number); c != nil && sameExtent(*c, p) {
+				p.GUID = c.GUID
+			}
+		}
+		if p.GUID.IsZero() {
+			p.GUID = randomGUID()
+		}
+
+		t.Partitions = append(t.Partitions, p)
+		next = roundUp(p.LastLBA+1, align)
+	}
+
+	return t, nil
+}
+
+// Apply converges the partition table of the disk to the layout: it
+// creates a GPT, adds missing partitions or, with Replace, rewrites a
+// differing table, then has the kernel re-read it. A disk already matching
+// the layout is left alone. Apply returns whether a change was made.
+//
+// A disk without a partition table is only partitioned when it has no
+// filesystem or other signature, unless Replace is set. Tables destroying
+// data are not written while the disk or anything on it is mounted or in
+// use by device mapper or md.
+func Apply(ctx context.Context, devicePath string, l Layout) (bool, error) {
+	size, ssz, err := geometry(devicePath)
+	if err != nil {
+		return false, err
+	}
+	current, err := Read(devicePath)
+	if err != nil {
+		return false, err
+	}
+
+	desired, err := l.Table(size, ssz, current)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to lay out %v", devicePath)
+	}
+
+	if current != nil && !l.Replace {
+		if current.Type != TypeGPT && len(current.Partitions) > 0 {
+			return false, errors.Errorf("%s has a %s partition table", devicePath, current.Type)
+		}
+		for _, p := range current.Partitions {
+			d := desired.Partition(p.Number)
+			if d == nil || !matches(p, *d) {
+				return false, errors.Errorf("partition %d of %s doesn't match the layout", p.Number, devicePath)
+			}
+		}
+	}
+	if current == nil && !l.Replace {
+		if err := checkBlank(devicePath); err != nil {
+			return false, err
+		}
+	}
+	// Adding partitions leaves the existing ones alone, anything else
+	// destroys what is on the disk
+	clobber := current == nil || l.Replace && !keeps(current, desired)
+
+	a := action.Action{
+		Op:     "parttable.apply",
+		Target: devicePath,
+		Args:   map[string]string{"type": TypeGPT, "partitions": strconv.Itoa(len(desired.Partitions))},
+	}
+	return action.Ensure(ctx, a,
+		func() (bool, error) { return inSync(current, desired, l), nil },
+		func() error {
+			if clobber {
+				f, err := claim(devicePath)
+				if err != nil {
+					return err
+				}
+				err = writeGPT(devicePath, desired)
+				if f != nil {
+					f.Close()
+				}
+				if err != nil {
+					return err
+				}
+			} else if err := writeGPT(devicePath, desired); err != nil {
+				return err
+			}
+			return rereadWritten(devicePath, desired)
+		})
+}
+
+// EnsurePartition makes sure partition number exists as spec on the disk,
+// adding it after the current partitions and creating a GPT if needed. The
+// other partitions are kept as they are, so they must follow the layout
+// rules, and an existing partition differing from spec is an error.
+// EnsurePartition returns whether a change was made.
+func EnsurePartition(ctx context.Context, devicePath string, number int, spec PartitionSpec) (bool, error) {
+	current, err := Read(devicePath)
+	if err != nil {
+		return false, err
+	}
+
+	// Other tables are refused by Apply
+	var l Layout
+	if current != nil && current.Type == TypeGPT {
+		l.DiskGUID = current.DiskGUID
+		for _, p := range current.Partitions {
+			if p.Number != len(l.Partitions)+1 {
+				return false, errors.Errorf("partitions of %s aren't numbered in sequence", devicePath)
+			}
+			l.Partitions = append(l.Partitions, PartitionSpec{
+				Name:       p.Name,
+				Type:       p.TypeGUID,
+				GUID:       p.GUID,
+				Size:       current.Size(p),
+				Attributes: p.Attributes,
+			})
+		}
+	}
+
+	switch {
+	case number >= 1 && number <= len(l.Partitions):
+		if spec.GUID.IsZero() {
+			spec.GUID = l.Partitions[number-1].GUID
+		}
+		l.Partitions[number-1] = spec
+	case number == len(l.Partitions)+1:
+		l.Partitions = append(l.Partitions, spec)
+	default:
+		return false, errors.Errorf("partition %d of %s would leave a gap after partition %d", number, devicePath, len(l.Partitions))
+	}
+
+	return Apply(ctx, devicePath, l)
+}
+
+// keeps returns whether every current partition stays as is in the desired
+// table
+func keeps(current, desired *Table) bool {
+	if current.Type != TypeGPT && len(current.Partitions) > 0 {
+		return false
+	}
+	for _, p := range current.Partitions {
+		if d := desired.Partition(p.Number); d == nil || !matches(p, *d) {
+			return false
+		}
+	}
+	return true
+}
+
+// Write replaces the partition table of the disk with the GPT and has the
+// kernel re-read it. The usable range is recomputed for the disk, so a
+// table read with Read can be modified and written back.
+func Write(ctx context.Context, devicePath string, t *Table) error {
+	a := action.Action{
+		Op:     "parttable.write",
+		Target: devicePath,
+		Args:   map[string]string{"type": TypeGPT, "partitions": strconv.Itoa(len(t.Partitions))},
+	}
+	return action.Run(ctx, a, func() error {
+		if err := writeGPT(devicePath, t); err != nil {
+			return err
+		}
+		return rereadWritten(devicePath, t)
+	})
+}
+
+// rereadWritten tells callers the table is on disk even when the kernel
+// keeps using the old one, usually until a busy partition is released
+func rereadWritten(devicePath string, t *Table) error {
+	if err := reread(devicePath, t); err != nil {
+		return errors.Wrapf(err, "partition table of %v written but not in use", devicePath)
+	}
+	return nil
+}
+
+// inSync returns whether the current table already is the desired one
+func inSync(current, desired *Table, l Layout) bool {
+	if current == nil || current.Type != TypeGPT || !current.PrimaryValid || !current.BackupValid ||
+		len(current.Partitions) != len(desired.Partitions) {
+		return false
+	}
+	if !l.DiskGUID.IsZero() && current.DiskGUID != l.DiskGUID {
+		return false
+	}
+	for i, p := range current.Partitions {
+		d := desired.Partitions[i]
+		if !matches(p, d) || !l.Partitions[i].GUID.IsZero() && p.GUID != d.GUID {
+			return false
+		}
+	}
+	return true
+}
+
+// matches compares everything but the GUID a layout may leave unset
+func matches(p, d Partition) bool {
+	return sameExtent(p, d) && p.TypeGUID == d.TypeGUID && p.Name == d.Name && p.Attributes == d.Attributes
+}
+
+func sameExtent(p, d Partition) bool {
+	return p.Number == d.Number && p.FirstLBA == d.FirstLBA && p.LastLBA == d.LastLBA
+}
+
+// geometry returns the size and the logical block size of the disk
+func geometry(devicePath string) (int64, int64, error) {
+	f, err := os.Open(devicePath)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to open %v", devicePath)
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to get size of %v", devicePath)
+	}
+	ssz := sectorSize(f)
+	if ssz == 0 {
+		ssz = defaultSectorSize
+	}
+	return size, ssz, nil
+}
+
+func roundUp(v, align uint64) uint64 {
+	return (v + align - 1) / align * align
+}
@@ -0,0 +1,149 @@
+package parttable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	mbrEntriesOffset = 446
+	mbrEntrySize     = 16
+
+	mbrTypeProtective = 0xee
+
+	// mbrMaxLogical bounds the EBR chain walk against loops in corrupt
+	// tables
+	mbrMaxLogical = 256
+)
+
+// mbrEntry is a raw MBR or EBR partition record
+type mbrEntry struct {
+	status   byte
+	typ      byte
+	startLBA uint32
+	sectors  uint32
+}
+
+func parseMBREntries(sector []byte) [4]mbrEntry {
+	var es [4]mbrEntry
+	for i := range es {
+		e := sector[mbrEntriesOffset+i*mbrEntrySize:]
+		es[i] = mbrEntry{
+			status:   e[0],
+			typ:      e[4],
+			startLBA: binary.LittleEndian.Uint32(e[8:]),
+			sectors:  binary.LittleEndian.Uint32(e[12:]),
+		}
+	}
+	return es
+}
+
+func hasMBRSignature(sector []byte) bool {
+	return sector[510] == 0x55 && sector[511] == 0xaa
+}
+
+// isExtended returns whether the type is a container of logical partitions
+func isExtended(typ byte) bool {
+	return typ == 0x05 || typ == 0x0f || typ == 0x85
+}
+
+// readMBR parses primary partitions and walks the EBR chain of the
+// extended one. It returns nil without the boot signature or for a
+// protective MBR whose GPT is gone.
+func readMBR(r io.ReaderAt, size, ssz int64, sector []byte) (*Table, error) {
+	if !hasMBRSignature(sector) {
+		return nil, nil
+	}
+
+	es := parseMBREntries(sector)
+	for _, e := range es {
+		if e.typ == mbrTypeProtective {
+			return nil, nil
+		}
+	}
+
+	// Boot sectors of FAT and NTFS carry the signature too, their records
+	// have garbage in the status byte
+	for _, e := range es {
+		if e.status != 0 && e.status != 0x80 {
+			return nil, nil
+		}
+	}
+
+	t := &Table{
+		Type:       TypeDOS,
+		ID:         fmt.Sprintf("%08x", binary.LittleEndian.Uint32(sector[440:])),
+		SectorSize: ssz,
+		DiskSize:   size,
+	}
+
+	var extended *mbrEntry
+	for i, e := range es {
+		if e.typ == 0 || e.sectors == 0 {
+			continue
+		}
+		p := mbrPartition(i+1, 0, e)
+		if p.Extended && extended == nil {
+			extended = &es[i]
+		}
+		t.Partitions = append(t.Partitions, p)
+	}
+
+	if extended != nil {
+		logical, err := readLogical(r, ssz, uint64(extended.startLBA))
+		if err != nil {
+			return nil, err
+		}
+		t.Partitions = append(t.Partitions, logical...)
+	}
+
+	return t, nil
+}
+
+// readLogical follows the EBR chain. Each EBR describes one logical
+// partition relative to itself and links to the next EBR relative to the
+// start of the extended partition.
+func readLogical(r io.ReaderAt, ssz int64, extStart uint64) ([]Partition, error) {
+	var ps []Partition
+
+	ebr := extStart
+	seen := map[uint64]bool{}
+	sector := make([]byte, 512)
+	for len(ps) < mbrMaxLogical && !seen[ebr] {
+		seen[ebr] = true
+
+		if _, err := r.ReadAt(sector, int64(ebr)*ssz); err != nil {
+			return nil, errors.Wrapf(err, "failed to read EBR at LBA %d", ebr)
+		}
+		if !hasMBRSignature(sector) {
+			break
+		}
+
+		es := parseMBREntries(sector)
+		if es[0].typ != 0 && es[0].sectors != 0 {
+			ps = append(ps, mbrPartition(5+len(ps), ebr, es[0]))
+		}
+
+		if !isExtended(es[1].typ) || es[1].startLBA == 0 {
+			break
+		}
+		ebr = extStart + uint64(es[1].startLBA)
+	}
+
+	return ps, nil
+}
+
+func mbrPartition(number int, base uint64, e mbrEntry) Partition {
+	first := base + uint64(e.startLBA)
+	return Partition{
+		Number:   number,
+		FirstLBA: first,
+		LastLBA:  first + uint64(e.sectors) - 1,
+		MBRType:  e.typ,
+		Extended: isExtended(e.typ),
+		Bootable: e.status == 0x80,
+	}
+}
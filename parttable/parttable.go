@@ -0,0 +1,180 @@
+// Package parttable reads GPT and MBR partition tables directly from a
+// device, without sgdisk or parted, to verify disk layouts. It writes GPT
+// only: Apply converges a disk to a declarative Layout and Write stores a
+// modified Table, both then update the partitions the kernel sees.
+package parttable
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// ioctls from linux/fs.h
+const (
+	blkSSZGet = 0x1268
+)
+
+// defaultSectorSize is used for image files and devices not reporting their
+// logical block size
+const defaultSectorSize = 512
+
+// Table types named like blkid reports them in PTTYPE
+const (
+	TypeGPT = "gpt"
+	TypeDOS = "dos"
+)
+
+// Table is a partition table
+type Table struct {
+	// Type is TypeGPT or TypeDOS
+	Type string
+	// ID is the disk GUID for GPT and the disk signature like "2a3b4c5d"
+	// for MBR, the same as blkid PTUUID
+	ID         string
+	SectorSize int64
+	// DiskSize is the size of the device in bytes
+	DiskSize int64
+
+	// GPT only fields
+	DiskGUID       GUID
+	FirstUsableLBA uint64
+	LastUsableLBA  uint64
+	// PrimaryValid and BackupValid report which GPT header and entries
+	// passed their CRC checks, the table is read from the primary one if
+	// valid
+	PrimaryValid bool
+	BackupValid  bool
+
+	// Partitions are ordered by number: the GPT entry index plus one, or
+	// 1-4 for primary and 5 onwards for logical MBR partitions
+	Partitions []Partition
+}
+
+// Partition is an entry of the table
+type Partition struct {
+	Number   int
+	FirstLBA uint64
+	// LastLBA is inclusive
+	LastLBA uint64
+
+	// GPT only fields
+	TypeGUID   GUID
+	GUID       GUID
+	Name       string
+	Attributes uint64
+
+	// MBR only fields
+	MBRType byte
+	// Extended is set for the MBR container of logical partitions
+	Extended bool
+
+	// Bootable is the MBR active flag or the GPT legacy BIOS bootable
+	// attribute
+	Bootable bool
+}
+
+// GPT partition attributes
+const (
+	AttrRequired           = 1 << 0
+	AttrNoBlockIOProtocol  = 1 << 1
+	AttrLegacyBIOSBootable = 1 << 2
+	AttrReadOnly           = 1 << 60
+	AttrShadowCopy         = 1 << 61
+	AttrHidden             = 1 << 62
+	AttrNoAutomount        = 1 << 63
+)
+
+// Sectors returns the number of sectors of the partition
+func (p Partition) Sectors() uint64 {
+	return p.LastLBA - p.FirstLBA + 1
+}
+
+// Start returns the offset of the partition in bytes
+func (t Table) Start(p Partition) int64 {
+	return int64(p.FirstLBA) * t.SectorSize
+}
+
+// Size returns the size of the partition in bytes
+func (t Table) Size(p Partition) int64 {
+	return int64(p.Sectors()) * t.SectorSize
+}
+
+// Partition returns the partition by number or nil
+func (t Table) Partition(number int) *Partition {
+	for i := range t.Partitions {
+		if t.Partitions[i].Number == number {
+			return &t.Partitions[i]
+		}
+	}
+	return nil
+}
+
+// Read reads the partition table of a disk or an image file. GPT is
+// preferred over the protective or hybrid MBR in front of it. It returns nil
+// if the device has no partition table.
+func Read(devicePath string) (*Table, error) {
+	f, err := os.Open(devicePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", devicePath)
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get size of %v", devicePath)
+	}
+
+	sectorSizes := []int64{defaultSectorSize, 4096}
+	if ssz := sectorSize(f); ssz > 0 {
+		sectorSizes = []int64{ssz}
+	}
+
+	t, err := ReadFrom(f, size, sectorSizes...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read partition table of %v", devicePath)
+	}
+	return t, nil
+}
+
+// ReadFrom reads the partition table from r of the given size. GPT is
+// looked for at each sector size in turn, 512 if none given.
+func ReadFrom(r io.ReaderAt, size int64, sectorSizes ...int64) (*Table, error) {
+	if len(sectorSizes) == 0 {
+		sectorSizes = []int64{defaultSectorSize}
+	}
+
+	mbr := make([]byte, 512)
+	if _, err := r.ReadAt(mbr, 0); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to read MBR")
+	}
+
+	for _, ssz := range sectorSizes {
+		t, err := readGPT(r, size, ssz)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			return t, nil
+		}
+	}
+
+	return readMBR(r, size, sectorSizes[0], mbr)
+}
+
+// sectorSize returns the logical block size of a block device, 0 for
+// regular files
+func sectorSize(f *os.File) int64 {
+	var ssz int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blkSSZGet, uintptr(unsafe.Pointer(&ssz)))
+	if errno != 0 {
+		return 0
+	}
+	return int64(ssz)
+}
@@ -0,0 +1,278 @@
+package parttable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+const imageSize = 8 << 20
+
+var (
+	linuxFS  = MustParseGUID("0fc63daf-8483-4772-8e79-3d47d8581e4f")
+	espType  = MustParseGUID("c12a7328-f81f-11d2-ba4b-00a0c93ec93b")
+	diskGUID = MustParseGUID("5b3c1d2e-0a4f-4b6c-9d8e-7f6a5b4c3d2e")
+	partGUID = MustParseGUID("11111111-2222-3333-4444-555555555555")
+)
+
+// gptImage writes a GPT with two partitions to an image file and returns
+// its content
+func gptImage(t *testing.T, ssz int64) []byte {
+	t.Helper()
+
+	p := path.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(p, make([]byte, imageSize), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	first, last := usable(imageSize, ssz)
+	tbl := &Table{
+		SectorSize: ssz,
+		DiskGUID:   diskGUID,
+		Partitions: []Partition{
+			{Number: 1, FirstLBA: first, LastLBA: first + 99, TypeGUID: espType, GUID: partGUID, Name: "EFI system"},
+			{Number: 3, FirstLBA: first + 100, LastLBA: last, TypeGUID: linuxFS, GUID: partGUID, Name: "root",
+				Attributes: AttrLegacyBIOSBootable},
+		},
+	}
+	if err := writeGPT(p, tbl); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// mbrImage builds a disk with a primary partition and an extended one
+// holding two logical partitions
+func mbrImage() []byte {
+	img := make([]byte, imageSize)
+	entry := func(sector []byte, i int, status, typ byte, start, sectors uint32) {
+		e := sector[mbrEntriesOffset+i*mbrEntrySize:]
+		e[0], e[4] = status, typ
+		binary.LittleEndian.PutUint32(e[8:], start)
+		binary.LittleEndian.PutUint32(e[12:], sectors)
+		sector[510], sector[511] = 0x55, 0xaa
+	}
+
+	mbr := img[:512]
+	binary.LittleEndian.PutUint32(mbr[440:], 0x2a3b4c5d)
+	entry(mbr, 0, 0x80, 0x83, 2048, 4096)
+	entry(mbr, 1, 0, 0x05, 8192, 8192)
+
+	// Logical partitions are relative to their EBR, links to the next EBR
+	// are relative to the extended partition
+	entry(img[8192*512:], 0, 0, 0x83, 2048, 1024)
+	entry(img[8192*512:], 1, 0, 0x05, 4096, 4096)
+	entry(img[(8192+4096)*512:], 0, 0, 0x82, 2048, 2048)
+
+	return img
+}
+
+func TestReadFromGPT(t *testing.T) {
+	first, last := usable(imageSize, 512)
+	want512 := &Table{
+		Type:           TypeGPT,
+		ID:             diskGUID.String(),
+		SectorSize:     512,
+		DiskSize:       imageSize,
+		DiskGUID:       diskGUID,
+		FirstUsableLBA: first,
+		LastUsableLBA:  last,
+		PrimaryValid:   true,
+		BackupValid:    true,
+		Partitions: []Partition{
+			{Number: 1, FirstLBA: first, LastLBA: first + 99, TypeGUID: espType, GUID: partGUID, Name: "EFI system"},
+			{Number: 3, FirstLBA: first + 100, LastLBA: last, TypeGUID: linuxFS, GUID: partGUID, Name: "root",
+				Attributes: AttrLegacyBIOSBootable, Bootable: true},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		img         []byte
+		sectorSizes []int64
+		// corrupt damages the image before reading
+		corrupt func(img []byte)
+		want    func() *Table
+	}{
+		{
+			name: "intact",
+			img:  gptImage(t, 512),
+			want: func() *Table { return want512 },
+		},
+		{
+			name:    "corrupt primary header",
+			img:     gptImage(t, 512),
+			corrupt: func(img []byte) { img[512+60]++ },
+			want: func() *Table {
+				w := *want512
+				w.PrimaryValid = false
+				return &w
+			},
+		},
+		{
+			name:    "corrupt primary entries",
+			img:     gptImage(t, 512),
+			corrupt: func(img []byte) { img[2*512+56]++ },
+			want: func() *Table {
+				w := *want512
+				w.PrimaryValid = false
+				return &w
+			},
+		},
+		{
+			name:    "corrupt backup header",
+			img:     gptImage(t, 512),
+			corrupt: func(img []byte) { img[imageSize-512+60]++ },
+			want: func() *Table {
+				w := *want512
+				w.BackupValid = false
+				return &w
+			},
+		},
+		{
+			name: "no signatures",
+			img:  gptImage(t, 512),
+			corrupt: func(img []byte) {
+				copy(img[512:], "NOT GPT!")
+				copy(img[imageSize-512:], "NOT GPT!")
+			},
+			// The protective MBR alone isn't a table
+			want: func() *Table { return nil },
+		},
+		{
+			name:        "4096 byte sectors",
+			img:         gptImage(t, 4096),
+			sectorSizes: []int64{512, 4096},
+			want: func() *Table {
+				first, last := usable(imageSize, 4096)
+				w := *want512
+				w.SectorSize = 4096
+				w.FirstUsableLBA, w.LastUsableLBA = first, last
+				w.Partitions = []Partition{want512.Partitions[0], want512.Partitions[1]}
+				w.Partitions[0].FirstLBA, w.Partitions[0].LastLBA = first, first+99
+				w.Partitions[1].FirstLBA, w.Partitions[1].LastLBA = first+100, last
+				return &w
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.corrupt != nil {
+				tt.corrupt(tt.img)
+			}
+			got, err := ReadFrom(bytes.NewReader(tt.img), int64(len(tt.img)), tt.sectorSizes...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.want(); !reflect.DeepEqual(got, want) {
+				t.Errorf("ReadFrom() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// A header with a valid CRC must not make the reader allocate the entry
+// array it claims
+func TestReadFromGPTOversizedEntries(t *testing.T) {
+	img := gptImage(t, 512)
+	for _, lba := range []int64{1, imageSize/512 - 1} {
+		h := img[lba*512 : lba*512+gptHeaderSize]
+		binary.LittleEndian.PutUint32(h[80:], gptMaxEntries)
+		binary.LittleEndian.PutUint32(h[84:], gptMaxEntrySize)
+		binary.LittleEndian.PutUint32(h[16:], 0)
+		binary.LittleEndian.PutUint32(h[16:], crc32.ChecksumIEEE(h))
+	}
+
+	if _, err := ReadFrom(bytes.NewReader(img), int64(len(img))); err == nil {
+		t.Error("ReadFrom() accepted oversized entries")
+	}
+}
+
+func TestReadFromMBR(t *testing.T) {
+	fat := make([]byte, imageSize)
+	copy(fat, []byte{0xeb, 0x3c, 0x90})
+	copy(fat[0x36:], "FAT16   ")
+	fat[mbrEntriesOffset] = 0x29
+	fat[510], fat[511] = 0x55, 0xaa
+
+	loop := mbrImage()
+	// The second EBR links to itself
+	link := loop[(8192+4096)*512+mbrEntriesOffset+mbrEntrySize:]
+	link[4] = 0x05
+	binary.LittleEndian.PutUint32(link[8:], 4096)
+	binary.LittleEndian.PutUint32(link[12:], 4096)
+
+	primary := []Partition{
+		{Number: 1, FirstLBA: 2048, LastLBA: 6143, MBRType: 0x83, Bootable: true},
+		{Number: 2, FirstLBA: 8192, LastLBA: 16383, MBRType: 0x05, Extended: true},
+	}
+
+	tests := []struct {
+		name string
+		img  []byte
+		want *Table
+	}{
+		{
+			name: "primary and logical",
+			img:  mbrImage(),
+			want: &Table{
+				Type:       TypeDOS,
+				ID:         "2a3b4c5d",
+				SectorSize: 512,
+				DiskSize:   imageSize,
+				Partitions: append(primary[:2:2],
+					Partition{Number: 5, FirstLBA: 10240, LastLBA: 11263, MBRType: 0x83},
+					Partition{Number: 6, FirstLBA: 14336, LastLBA: 16383, MBRType: 0x82},
+				),
+			},
+		},
+		{
+			name: "EBR chain loop",
+			img:  loop,
+			want: &Table{
+				Type:       TypeDOS,
+				ID:         "2a3b4c5d",
+				SectorSize: 512,
+				DiskSize:   imageSize,
+				Partitions: append(primary[:2:2],
+					Partition{Number: 5, FirstLBA: 10240, LastLBA: 11263, MBRType: 0x83},
+					Partition{Number: 6, FirstLBA: 14336, LastLBA: 16383, MBRType: 0x82},
+				),
+			},
+		},
+		{
+			name: "FAT boot sector",
+			img:  fat,
+		},
+		{
+			name: "blank",
+			img:  make([]byte, imageSize),
+		},
+		{
+			name: "shorter than a sector",
+			img:  make([]byte, 100),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadFrom(bytes.NewReader(tt.img), int64(len(tt.img)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadFrom() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package parttable
+
+import (
+	"context"
+	"os"
+	"path"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/alexdzyoba/sys/block"
+	"github.com/pkg/errors"
+)
+
+// ioctls and operations from linux/fs.h and linux/blkpg.h
+const (
+	blkRRPart = 0x125f
+	blkPG     = 0x1269
+
+	blkpgAddPartition = 1
+	blkpgDelPartition = 2
+)
+
+type blkpgIoctlArg struct {
+	op      int32
+	flags   int32
+	datalen int32
+	data    uintptr
+}
+
+type blkpgPartition struct {
+	// start and length are in bytes
+	start   int64
+	length  int64
+	pno     int32
+	devname [64]byte
+	volname [64]byte
+}
+
+// Reread has the kernel re-read the partition table of the disk and
+// update its partition devices, like partprobe does
+func Reread(ctx context.Context, devicePath string) error {
+	return action.Run(ctx, action.Action{Op: "parttable.reread", Target: devicePath}, func() error {
+		t, err := Read(devicePath)
+		if err != nil {
+			return err
+		}
+		return reread(devicePath, t)
+	})
+}
+
+// reread issues BLKRRPART and then makes partition devices match the table
+// one by one with BLKPG like partx does: the kernel refuses BLKRRPART while
+// any partition is in use and may lack a parser for the table. Changed
+// partitions are removed, which fails only for those in use, and the new
+// ones are added. Image files are skipped.
+func reread(devicePath string, t *Table) error {
+	fi, err := os.Stat(devicePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat %v", devicePath)
+	}
+	if fi.Mode()&os.ModeDevice == 0 {
+		return nil
+	}
+
+	f, err := os.Open(devicePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %v", devicePath)
+	}
+	defer f.Close()
+
+	if err := ioctl(f, blkRRPart, 0); err != nil && err != syscall.EBUSY {
+		return errors.Wrapf(err, "failed to re-read partition table of %v", devicePath)
+	}
+
+	return updatePartitions(f, devicePath, t)
+}
+
+func updatePartitions(f *os.File, devicePath string, t *Table) error {
+	d, err := block.NewDevice(devicePath)
+	if err != nil {
+		return err
+	}
+	kernel, err := d.Partitions()
+	if err != nil {
+		return err
+	}
+
+	var want []Partition
+	if t != nil {
+		for _, p := range t.Partitions {
+			if !p.Extended {
+				want = append(want, p)
+			}
+		}
+	}
+	wanted := func(kp block.Partition) bool {
+		for _, p := range want {
+			if p.Number == kp.Number && t.Start(p) == int64(kp.Start)*512 && t.Size(p) == int64(kp.Size) {
+				return true
+			}
+		}
+		return false
+	}
+
+	present := map[int]bool{}
+	for _, kp := range kernel {
+		if wanted(kp) {
+			present[kp.Number] = true
+			continue
+		}
+		if err := blkpg(f, blkpgDelPartition, blkpgPartition{pno: int32(kp.Number)}); err != nil {
+			return errors.Wrapf(err, "failed to remove partition %s", path.Base(kp.Path()))
+		}
+	}
+
+	for _, p := range want {
+		if present[p.Number] {
+			continue
+		}
+		bp := blkpgPartition{start: t.Start(p), length: t.Size(p), pno: int32(p.Number)}
+		if err := blkpg(f, blkpgAddPartition, bp); err != nil {
+			return errors.Wrapf(err, "failed to add partition %d of %v", p.Number, devicePath)
+		}
+	}
+
+	return nil
+}
+
+func blkpg(f *os.File, op int32, p blkpgPartition) error {
+	arg := blkpgIoctlArg{op: op, datalen: int32(unsafe.Sizeof(p)), data: uintptr(unsafe.Pointer(&p))}
+	err := ioctl(f, blkPG, uintptr(unsafe.Pointer(&arg)))
+	runtime.KeepAlive(&p)
+	return err
+}
+
+func ioctl(f *os.File, req, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
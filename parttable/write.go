@@ -0,0 +1,194 @@
+package parttable
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// gptEntries is the number of entries written, which every tool uses and
+// fills 16KiB
+const gptEntries = 128
+
+// entrySectors returns the number of sectors the entries array takes
+func entrySectors(ssz int64) uint64 {
+	return uint64((gptEntries*gptEntrySize + ssz - 1) / ssz)
+}
+
+// usable returns the LBA range partitions can occupy on a disk with the
+// standard 128 entries array
+func usable(size, ssz int64) (first, last uint64) {
+	lastLBA := uint64(size/ssz) - 1
+	n := entrySectors(ssz)
+	return 2 + n, lastLBA - n - 1
+}
+
+// writeGPT writes a protective MBR, the primary and the backup GPT. The
+// usable range of the table is recomputed for the device size, the boot
+// code of an existing MBR is kept.
+func writeGPT(devicePath string, t *Table) error {
+	f, err := os.OpenFile(devicePath, os.O_RDWR, 0)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %v", devicePath)
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get size of %v", devicePath)
+	}
+	ssz := t.SectorSize
+	if ssz == 0 {
+		if ssz = sectorSize(f); ssz == 0 {
+			ssz = defaultSectorSize
+		}
+	}
+	if size < 3*ssz+2*int64(gptEntries*gptEntrySize) {
+		return errors.Errorf("%s is too small for GPT", devicePath)
+	}
+
+	t.Type = TypeGPT
+	t.SectorSize = ssz
+	t.DiskSize = size
+	t.FirstUsableLBA, t.LastUsableLBA = usable(size, ssz)
+	if t.DiskGUID.IsZero() {
+		t.DiskGUID = randomGUID()
+	}
+	t.ID = t.DiskGUID.String()
+	if err := t.validate(); err != nil {
+		return errors.Wrapf(err, "invalid partition table for %v", devicePath)
+	}
+
+	entries := make([]byte, gptEntries*gptEntrySize)
+	for _, p := range t.Partitions {
+		e := entries[(p.Number-1)*gptEntrySize:]
+		copy(e[0:16], p.TypeGUID[:])
+		copy(e[16:32], p.GUID[:])
+		binary.LittleEndian.PutUint64(e[32:], p.FirstLBA)
+		binary.LittleEndian.PutUint64(e[40:], p.LastLBA)
+		binary.LittleEndian.PutUint64(e[48:], p.Attributes)
+		for i, c := range utf16.Encode([]rune(p.Name)) {
+			binary.LittleEndian.PutUint16(e[56+2*i:], c)
+		}
+	}
+	entriesCRC := crc32.ChecksumIEEE(entries)
+
+	lastLBA := uint64(size/ssz) - 1
+	backupEntriesLBA := lastLBA - entrySectors(ssz)
+
+	mbr := make([]byte, 512)
+	if _, err := f.ReadAt(mbr, 0); err != nil {
+		return errors.Wrapf(err, "failed to read MBR of %v", devicePath)
+	}
+	protectiveMBR(mbr, lastLBA)
+
+	// The backup goes first so an interrupted write leaves a valid copy
+	writes := []struct {
+		lba  uint64
+		data []byte
+	}{
+		{backupEntriesLBA, entries},
+		{lastLBA, t.gptHeader(ssz, lastLBA, 1, backupEntriesLBA, entriesCRC)},
+		{2, entries},
+		{1, t.gptHeader(ssz, 1, lastLBA, 2, entriesCRC)},
+		{0, mbr},
+	}
+	for _, w := range writes {
+		if _, err := f.WriteAt(w.data, int64(w.lba)*ssz); err != nil {
+			return errors.Wrapf(err, "failed to write %v at LBA %d", devicePath, w.lba)
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		return errors.Wrapf(err, "failed to sync %v", devicePath)
+	}
+	return nil
+}
+
+// validate checks numbers, names and extents of the partitions
+func (t Table) validate() error {
+	ps := append([]Partition(nil), t.Partitions...)
+	sort.Slice(ps, func(i, j int) bool { return ps[i].FirstLBA < ps[j].FirstLBA })
+
+	numbers := map[int]bool{}
+	for i, p := range ps {
+		switch {
+		case p.Number < 1 || p.Number > gptEntries:
+			return errors.Errorf("partition number %d is out of range 1-%d", p.Number, gptEntries)
+		case numbers[p.Number]:
+			return errors.Errorf("partition number %d is used twice", p.Number)
+		case p.TypeGUID.IsZero():
+			return errors.Errorf("partition %d has no type", p.Number)
+		case len(utf16.Encode([]rune(p.Name))) > 36:
+			return errors.Errorf("name of partition %d is longer than 36 UTF-16 code units", p.Number)
+		case p.FirstLBA < t.FirstUsableLBA || p.LastLBA > t.LastUsableLBA || p.LastLBA < p.FirstLBA:
+			return errors.Errorf("partition %d at LBA %d-%d is outside of usable LBA %d-%d",
+				p.Number, p.FirstLBA, p.LastLBA, t.FirstUsableLBA, t.LastUsableLBA)
+		case i > 0 && p.FirstLBA <= ps[i-1].LastLBA:
+			return errors.Errorf("partition %d overlaps partition %d", p.Number, ps[i-1].Number)
+		}
+		numbers[p.Number] = true
+	}
+	return nil
+}
+
+// gptHeader encodes the header sector
+func (t Table) gptHeader(ssz int64, current, backup, entriesLBA uint64, entriesCRC uint32) []byte {
+	h := make([]byte, ssz)
+	copy(h, gptSignature)
+	binary.LittleEndian.PutUint32(h[8:], 0x00010000)
+	binary.LittleEndian.PutUint32(h[12:], gptHeaderSize)
+	binary.LittleEndian.PutUint64(h[24:], current)
+	binary.LittleEndian.PutUint64(h[32:], backup)
+	binary.LittleEndian.PutUint64(h[40:], t.FirstUsableLBA)
+	binary.LittleEndian.PutUint64(h[48:], t.LastUsableLBA)
+	copy(h[56:72], t.DiskGUID[:])
+	binary.LittleEndian.PutUint64(h[72:], entriesLBA)
+	binary.LittleEndian.PutUint32(h[80:], gptEntries)
+	binary.LittleEndian.PutUint32(h[84:], gptEntrySize)
+	binary.LittleEndian.PutUint32(h[88:], entriesCRC)
+	binary.LittleEndian.PutUint32(h[16:], crc32.ChecksumIEEE(h[:gptHeaderSize]))
+	return h
+}
+
+// protectiveMBR replaces the partition records with one covering the disk
+// so MBR-only tools see it as used
+func protectiveMBR(mbr []byte, lastLBA uint64) {
+	for i := mbrEntriesOffset; i < 510; i++ {
+		mbr[i] = 0
+	}
+
+	sectors := uint32(0xffffffff)
+	if lastLBA < uint64(sectors) {
+		sectors = uint32(lastLBA)
+	}
+	e := mbr[mbrEntriesOffset:]
+	// CHS of the start is 0/0/2, the end is past what CHS can address
+	copy(e[1:4], []byte{0x00, 0x02, 0x00})
+	e[4] = mbrTypeProtective
+	copy(e[5:8], []byte{0xff, 0xff, 0xff})
+	binary.LittleEndian.PutUint32(e[8:], 1)
+	binary.LittleEndian.PutUint32(e[12:], sectors)
+
+	mbr[510], mbr[511] = 0x55, 0xaa
+}
+
+// randomGUID returns a version 4 GUID
+func randomGUID() GUID {
+	var g GUID
+	if _, err := rand.Read(g[:]); err != nil {
+		panic("failed to read random bytes: " + err.Error())
+	}
+	// Version and variant bits are defined on the textual byte order
+	g.swap()
+	g[6] = g[6]&0x0f | 0x40
+	g[8] = g[8]&0x3f | 0x80
+	g.swap()
+	return g
+}
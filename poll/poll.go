@@ -0,0 +1,215 @@
+// Package poll coordinates periodic samplers like block statistics, network
+// counters and sensors reading sysfs. A single Scheduler per process caps
+// how many samplers read at once and spaces their reads, and jitter and
+// alignment keep a fleet of hosts from polling in lockstep. Until and Every
+// are the loops of the rest of the module waiting for a condition or
+// watching state at an interval.
+package poll
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Sampler is a periodic job
+type Sampler struct {
+	Name     string
+	Interval time.Duration
+	Sample   func(context.Context) error
+}
+
+// Options of a Scheduler. The zero value runs one sampler at a time without
+// jitter and alignment.
+type Options struct {
+	// MaxConcurrent caps samplers running at once, one if zero
+	MaxConcurrent int
+	// MinGap is the least time between starts of any two samplers
+	MinGap time.Duration
+	// Jitter delays every run by a random duration up to this
+	Jitter time.Duration
+	// Align starts runs at wall clock multiples of the interval, e.g. at
+	// :00, :10, :20 for 10s, so samples of different hosts line up.
+	// Combine with Jitter to still spread the actual reads.
+	Align bool
+	// OnError is called with failures of samplers, they are dropped if nil
+	OnError func(name string, err error)
+}
+
+// Scheduler runs samplers according to the options. Scheduler is safe for
+// concurrent use.
+type Scheduler struct {
+	opts Options
+	sem  chan struct{}
+
+	mu       sync.Mutex
+	samplers []Sampler
+	last     time.Time
+	rand     *rand.Rand
+	running  bool
+}
+
+// New creates a scheduler
+func New(opts Options) *Scheduler {
+	n := opts.MaxConcurrent
+	if n <= 0 {
+		n = 1
+	}
+
+	return &Scheduler{
+		opts: opts,
+		sem:  make(chan struct{}, n),
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Add registers the sampler, it must be called before Run
+func (s *Scheduler) Add(sm Sampler) error {
+	if sm.Interval <= 0 {
+		return errors.Errorf("sampler %s has no interval", sm.Name)
+	}
+	if sm.Sample == nil {
+		return errors.Errorf("sampler %s has no sample function", sm.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return errors.Errorf("failed to add sampler %s to a running scheduler", sm.Name)
+	}
+	s.samplers = append(s.samplers, sm)
+
+	return nil
+}
+
+// Run runs the samplers until the context is done. Runs missed because a
+// sampler was slower than its interval are skipped.
+func (s *Scheduler) Run(ctx context.Context) error {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return errors.New("scheduler is already running")
+	}
+	s.running = true
+	samplers := append([]Sampler(nil), s.samplers...)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+	}()
+
+	var wg sync.WaitGroup
+	for _, sm := range samplers {
+		wg.Add(1)
+		go func(sm Sampler) {
+			defer wg.Done()
+			s.loop(ctx, sm)
+		}(sm)
+	}
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// Once runs the sampler a single time honoring concurrency limits, for
+// callers sampling on demand alongside the scheduled ones
+func (s *Scheduler) Once(ctx context.Context, sm Sampler) error {
+	if err := s.acquire(ctx); err != nil {
+		return err
+	}
+	defer s.release()
+
+	return sm.Sample(ctx)
+}
+
+func (s *Scheduler) loop(ctx context.Context, sm Sampler) {
+	now := time.Now()
+	next := now
+	if s.opts.Align {
+		next = now.Truncate(sm.Interval).Add(sm.Interval)
+	}
+
+	for {
+		if !sleep(ctx, time.Until(next)+s.jitter()) {
+			return
+		}
+
+		if err := s.Once(ctx, sm); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if s.opts.OnError != nil {
+				s.opts.OnError(sm.Name, err)
+			}
+		}
+
+		// Skip the runs missed while sampling
+		next = next.Add(sm.Interval)
+		if now := time.Now(); next.Before(now) {
+			missed := now.Sub(next)/sm.Interval + 1
+			next = next.Add(missed * sm.Interval)
+		}
+	}
+}
+
+// acquire waits for a free slot and the minimum gap since the previous start
+func (s *Scheduler) acquire(ctx context.Context) error {
+	select {
+	case s.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for {
+		s.mu.Lock()
+		wait := s.last.Add(s.opts.MinGap).Sub(time.Now())
+		if wait <= 0 {
+			s.last = time.Now()
+			s.mu.Unlock()
+			return nil
+		}
+		s.mu.Unlock()
+
+		if !sleep(ctx, wait) {
+			s.release()
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Scheduler) release() {
+	<-s.sem
+}
+
+func (s *Scheduler) jitter() time.Duration {
+	if s.opts.Jitter <= 0 {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Duration(s.rand.Int63n(int64(s.opts.Jitter)))
+}
+
+// sleep waits for the duration returning false if the context is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,28 @@
+package poll
+
+import (
+	"context"
+	"time"
+)
+
+// Until calls check right away and then every interval until it reports
+// done or fails. It returns the context error if the context is done
+// first.
+func Until(ctx context.Context, interval time.Duration, check func() (bool, error)) error {
+	for {
+		done, err := check()
+		if err != nil || done {
+			return err
+		}
+		if !sleep(ctx, interval) {
+			return ctx.Err()
+		}
+	}
+}
+
+// Every calls fn right away and then every interval until fn returns false
+// or the context is done
+func Every(ctx context.Context, interval time.Duration, fn func() bool) {
+	for fn() && sleep(ctx, interval) {
+	}
+}
@@ -0,0 +1,115 @@
+// Package power reads and controls power supplies from
+// /sys/class/power_supply.
+package power
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/alexdzyoba/sys/sysfs"
+	"github.com/pkg/errors"
+)
+
+const powerSupplyRoot = "/sys/class/power_supply"
+
+const (
+	attrStartThreshold = "charge_control_start_threshold"
+	attrEndThreshold   = "charge_control_end_threshold"
+)
+
+// Battery is a power supply of type Battery like BAT0
+type Battery struct {
+	Name string
+}
+
+// Batteries lists batteries of the system
+func Batteries() ([]Battery, error) {
+	entries, err := ioutil.ReadDir(powerSupplyRoot)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", powerSupplyRoot)
+	}
+
+	var bs []Battery
+	for _, e := range entries {
+		typ, err := sysfs.Read(path.Join(powerSupplyRoot, e.Name(), "type"), sysfs.String)
+		if err != nil || typ != "Battery" {
+			continue
+		}
+		bs = append(bs, Battery{Name: e.Name()})
+	}
+
+	return bs, nil
+}
+
+func (b Battery) attr(name string) string {
+	return path.Join(powerSupplyRoot, b.Name, name)
+}
+
+// SupportsThresholds returns whether the driver can stop charging at an end
+// threshold. Not all drivers support a start threshold as well.
+func (b Battery) SupportsThresholds() bool {
+	_, err := os.Stat(b.attr(attrEndThreshold))
+	return err == nil
+}
+
+// StartThreshold returns the charge percentage below which charging starts
+func (b Battery) StartThreshold() (int, error) {
+	return sysfs.Read(b.attr(attrStartThreshold), sysfs.Int[int])
+}
+
+// EndThreshold returns the charge percentage at which charging stops
+func (b Battery) EndThreshold() (int, error) {
+	return sysfs.Read(b.attr(attrEndThreshold), sysfs.Int[int])
+}
+
+// SetStartThreshold sets the charge percentage below which charging starts
+func (b Battery) SetStartThreshold(ctx context.Context, percent int) error {
+	if err := checkPercent(percent); err != nil {
+		return err
+	}
+	return sysfs.Write(ctx, b.attr(attrStartThreshold), percent, sysfs.FormatInt[int])
+}
+
+// SetEndThreshold sets the charge percentage at which charging stops, e.g.
+// 80 to preserve battery life on laptops that are always plugged in
+func (b Battery) SetEndThreshold(ctx context.Context, percent int) error {
+	if err := checkPercent(percent); err != nil {
+		return err
+	}
+	return sysfs.Write(ctx, b.attr(attrEndThreshold), percent, sysfs.FormatInt[int])
+}
+
+// SetThresholds sets both thresholds. Drivers reject a start threshold
+// above the end one, so they are written in the order keeping them valid
+// all along.
+func (b Battery) SetThresholds(ctx context.Context, start, end int) error {
+	if start >= end {
+		return errors.Errorf("start threshold %d must be below end threshold %d", start, end)
+	}
+
+	current, err := b.EndThreshold()
+	if err != nil {
+		return err
+	}
+
+	if start >= current {
+		if err := b.SetEndThreshold(ctx, end); err != nil {
+			return err
+		}
+		return b.SetStartThreshold(ctx, start)
+	}
+
+	if err := b.SetStartThreshold(ctx, start); err != nil {
+		return err
+	}
+	return b.SetEndThreshold(ctx, end)
+}
+
+func checkPercent(percent int) error {
+	if percent < 0 || percent > 100 {
+		return errors.Errorf("threshold %d is not a percentage", percent)
+	}
+	return nil
+}
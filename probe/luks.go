@@ -0,0 +1,123 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// Crypto is the LUKS header of an encrypted device
+type Crypto struct {
+	// Version is 1 or 2
+	Version int
+	// Cipher is the data encryption like "aes-xts-plain64"
+	Cipher string
+	// KeySize is the size of the volume key in bits
+	KeySize int
+	// KeySlots are the numbers of the slots holding a key in order
+	KeySlots []int
+	// DataOffset is where the encrypted data starts in bytes
+	DataOffset uint64
+}
+
+const (
+	luks1HeaderSize = 592
+	luks1KeySlots   = 8
+	luks1SlotActive = 0x00ac71f3
+
+	luks2BinaryHeaderSize = 4096
+)
+
+// LUKS1 and LUKS2 share the magic, version and UUID location. Only LUKS2
+// has a label, its other metadata is JSON following the binary header.
+func parseLUKS(r region, magicOff int64, res *Result) {
+	hdr := r.bytes(magicOff, 208)
+	if hdr == nil {
+		return
+	}
+	res.UUID = cString(hdr[168:208])
+
+	switch binary.BigEndian.Uint16(hdr[6:]) {
+	case 1:
+		res.Crypto = parseLUKS1(r.bytes(magicOff, luks1HeaderSize))
+	case 2:
+		res.Label = cString(hdr[24:72])
+		res.Crypto = parseLUKS2(r, magicOff)
+	}
+}
+
+// parseLUKS1 reads the big endian LUKS1 header with the cipher at 8 and
+// the key slots at 208
+func parseLUKS1(hdr []byte) *Crypto {
+	if hdr == nil {
+		return nil
+	}
+	c := &Crypto{
+		Version:    1,
+		Cipher:     cString(hdr[8:40]) + "-" + cString(hdr[40:72]),
+		KeySize:    int(binary.BigEndian.Uint32(hdr[108:])) * 8,
+		DataOffset: uint64(binary.BigEndian.Uint32(hdr[104:])) * 512,
+	}
+	for i := 0; i < luks1KeySlots; i++ {
+		if binary.BigEndian.Uint32(hdr[208+i*48:]) == luks1SlotActive {
+			c.KeySlots = append(c.KeySlots, i)
+		}
+	}
+	return c
+}
+
+// luks2Metadata is the part of the LUKS2 JSON metadata Crypto needs
+type luks2Metadata struct {
+	Keyslots map[string]struct {
+		KeySize int `json:"key_size"`
+	} `json:"keyslots"`
+	Segments map[string]struct {
+		Type       string `json:"type"`
+		Offset     string `json:"offset"`
+		Encryption string `json:"encryption"`
+	} `json:"segments"`
+}
+
+// parseLUKS2 reads the JSON metadata area of hdr_size at 8 minus the
+// binary header
+func parseLUKS2(r region, magicOff int64) *Crypto {
+	hdr := r.bytes(magicOff, 16)
+	size := binary.BigEndian.Uint64(hdr[8:])
+	if size <= luks2BinaryHeaderSize || size > regionSize {
+		return nil
+	}
+	area := r.bytes(magicOff+luks2BinaryHeaderSize, int(size-luks2BinaryHeaderSize))
+	if area == nil {
+		return nil
+	}
+	if i := bytes.IndexByte(area, 0); i >= 0 {
+		area = area[:i]
+	}
+
+	var m luks2Metadata
+	if err := json.Unmarshal(area, &m); err != nil {
+		return nil
+	}
+
+	c := &Crypto{Version: 2}
+	for slot := range m.Keyslots {
+		n, err := strconv.Atoi(slot)
+		if err != nil {
+			continue
+		}
+		c.KeySlots = append(c.KeySlots, n)
+	}
+	sort.Ints(c.KeySlots)
+	if len(c.KeySlots) > 0 {
+		c.KeySize = m.Keyslots[strconv.Itoa(c.KeySlots[0])].KeySize * 8
+	}
+
+	// Segment 0 maps the data, more exist only during reencryption
+	if s, ok := m.Segments["0"]; ok && s.Type == "crypt" {
+		c.Cipher = s.Encryption
+		c.DataOffset, _ = strconv.ParseUint(s.Offset, 10, 64)
+	}
+	return c
+}
@@ -0,0 +1,215 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Result is what blkid reports for a device: the type of its primary
+// signature with the UUID and the label when the format has them
+type Result struct {
+	Type  string
+	Usage Usage
+	UUID  string
+	Label string
+	// Crypto is set for LUKS headers
+	Crypto *Crypto
+}
+
+// usagePriority orders signatures when a device has several: RAID and LVM
+// members and LUKS headers hide whatever is behind them, filesystems win
+// over partition tables like on hybrid ISO images
+var usagePriority = []Usage{UsageRAID, UsageCrypto, UsageFilesystem, UsageOther, UsagePartitionTable}
+
+// Probe identifies the device content by reading superblocks directly,
+// without blkid. The path can be a whole disk, a partition or a symlink to
+// either like /dev/disk/by-uuid/<uuid>. It returns nil if no known
+// signature is found.
+func Probe(devicePath string) (*Result, error) {
+	r, err := readDevice(devicePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return probe(r), nil
+}
+
+func probe(r region) *Result {
+	sigs := match(r)
+	for _, u := range usagePriority {
+		for _, sig := range sigs {
+			if sig.Usage != u {
+				continue
+			}
+
+			res := &Result{Type: sig.Type, Usage: sig.Usage}
+			if parse, ok := parsers[typeParser(sig.Type)]; ok {
+				parse(r, sig.Offset, res)
+			}
+			return res
+		}
+	}
+	return nil
+}
+
+func typeParser(typ string) string {
+	switch typ {
+	case "ext2", "ext3", "jbd":
+		return "ext4"
+	}
+	return typ
+}
+
+// parsers fill the result from the superblock found by its magic offset
+var parsers = map[string]func(r region, magicOff int64, res *Result){
+	"ext4":              parseExt,
+	"xfs":               parseXFS,
+	"btrfs":             parseBtrfs,
+	"vfat":              parseVFAT,
+	"ntfs":              parseNTFS,
+	"swap":              parseSwap,
+	"crypto_LUKS":       parseLUKS,
+	"linux_raid_member": parseMD,
+	"LVM2_member":       parseLVM,
+}
+
+// ext2/3/4 superblock at 1024 with the magic at 0x38
+func parseExt(r region, magicOff int64, res *Result) {
+	sb := r.bytes(magicOff-0x38, 0x88)
+	if sb == nil {
+		return
+	}
+
+	const (
+		compatHasJournal = 0x4
+		incompatJournal  = 0x8
+		// Features ext3 understands, anything else requires ext4
+		ext3Incompat = 0x2 | 0x4 | 0x10
+		ext3RoCompat = 0x1 | 0x2 | 0x4
+	)
+	compat := binary.LittleEndian.Uint32(sb[0x5c:])
+	incompat := binary.LittleEndian.Uint32(sb[0x60:])
+	roCompat := binary.LittleEndian.Uint32(sb[0x64:])
+
+	switch {
+	case incompat&incompatJournal != 0:
+		res.Type = "jbd"
+	case incompat&^ext3Incompat != 0 || roCompat&^ext3RoCompat != 0:
+		res.Type = "ext4"
+	case compat&compatHasJournal != 0:
+		res.Type = "ext3"
+	default:
+		res.Type = "ext2"
+	}
+
+	res.UUID = formatUUID(sb[0x68:0x78])
+	res.Label = cString(sb[0x78:0x88])
+}
+
+func parseXFS(r region, magicOff int64, res *Result) {
+	sb := r.bytes(magicOff, 120)
+	if sb == nil {
+		return
+	}
+	res.UUID = formatUUID(sb[32:48])
+	res.Label = cString(sb[108:120])
+}
+
+// btrfs superblock at 64KiB with the magic at 0x40
+func parseBtrfs(r region, magicOff int64, res *Result) {
+	sb := r.bytes(magicOff-0x40, 0x12b+256)
+	if sb == nil {
+		return
+	}
+	res.UUID = formatUUID(sb[0x20:0x30])
+	res.Label = cString(sb[0x12b:])
+}
+
+func parseVFAT(r region, magicOff int64, res *Result) {
+	bs := r.bytes(0, 512)
+	if bs == nil {
+		return
+	}
+
+	// FAT32 extended boot record is further than FAT12/16 one
+	serialOff, labelOff := 0x27, 0x2b
+	if magicOff == 0x52 {
+		serialOff, labelOff = 0x43, 0x47
+	}
+
+	serial := binary.LittleEndian.Uint32(bs[serialOff:])
+	res.UUID = fmt.Sprintf("%04X-%04X", serial>>16, serial&0xffff)
+	if label := cString(bs[labelOff : labelOff+11]); label != "NO NAME" {
+		res.Label = label
+	}
+}
+
+// parseNTFS only reads the serial, the label lives in the MFT
+func parseNTFS(r region, magicOff int64, res *Result) {
+	bs := r.bytes(0, 512)
+	if bs == nil {
+		return
+	}
+	res.UUID = fmt.Sprintf("%016X", binary.LittleEndian.Uint64(bs[0x48:]))
+}
+
+// Swap header is at the start of the first page, its signature at the end
+func parseSwap(r region, magicOff int64, res *Result) {
+	hdr := r.bytes(0, 1068)
+	if hdr == nil || bytes.Equal(r.bytes(magicOff, 10), []byte("SWAP-SPACE")) {
+		// Version 0 swap has no UUID and label
+		return
+	}
+	res.UUID = formatUUID(hdr[1036:1052])
+	res.Label = cString(hdr[1052:1068])
+}
+
+// parseMD reads the array UUID and name of 1.x superblocks, 0.90 ones are
+// identified by type only
+func parseMD(r region, magicOff int64, res *Result) {
+	sb := r.bytes(magicOff, 64)
+	if sb == nil || binary.LittleEndian.Uint32(sb[4:]) != 1 {
+		return
+	}
+	res.UUID = formatUUID(sb[16:32])
+	res.Label = cString(sb[32:64])
+}
+
+// parseLVM reads the PV UUID from the PV header the label points to
+func parseLVM(r region, magicOff int64, res *Result) {
+	// Label header starts 24 bytes before "LVM2 001"
+	label := r.bytes(magicOff-24, 32)
+	if label == nil || !bytes.Equal(label[:8], []byte("LABELONE")) {
+		return
+	}
+	pv := r.bytes(magicOff-24+int64(binary.LittleEndian.Uint32(label[20:])), 32)
+	if pv == nil {
+		return
+	}
+
+	// 32 chars grouped like lvm prints them: 6-4-4-4-4-4-6
+	var parts []string
+	id := string(pv)
+	for _, n := range []int{6, 4, 4, 4, 4, 4, 6} {
+		parts = append(parts, id[:n])
+		id = id[n:]
+	}
+	res.UUID = strings.Join(parts, "-")
+}
+
+func formatUUID(b []byte) string {
+	if bytes.Equal(b, make([]byte, len(b))) {
+		return ""
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// cString returns the NUL terminated string with padding spaces trimmed
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimRight(string(b), " ")
+}
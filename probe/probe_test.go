@@ -0,0 +1,338 @@
+package probe
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+const imageSize = 4 << 20
+
+var testUUID = []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0xfe, 0xdc, 0xba, 0x98, 0x76, 0x54, 0x32, 0x10}
+
+const testUUIDString = "01234567-89ab-cdef-fedc-ba9876543210"
+
+// put is a piece of an image, negative offsets are from the end
+type put struct {
+	off  int64
+	data []byte
+}
+
+func image(size int64, puts ...put) []byte {
+	img := make([]byte, size)
+	for _, p := range puts {
+		off := p.off
+		if off < 0 {
+			off += size
+		}
+		copy(img[off:], p.data)
+	}
+	return img
+}
+
+// imageRegion reads the image like readFile reads a device
+func imageRegion(img []byte) region {
+	n := len(img)
+	if n > regionSize {
+		n = regionSize
+	}
+	return region{img[:n], img[len(img)-n:], int64(len(img))}
+}
+
+func le32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func be16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func be32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func be64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// ext builds a superblock with the compat, incompat and ro_compat features
+func ext(compat, incompat, roCompat uint32) []byte {
+	return image(imageSize,
+		put{0x438, []byte{0x53, 0xef}},
+		put{0x45c, le32(compat)},
+		put{0x460, le32(incompat)},
+		put{0x464, le32(roCompat)},
+		put{0x468, testUUID},
+		put{0x478, []byte("root")},
+	)
+}
+
+func luks1() []byte {
+	puts := []put{
+		{0, []byte("LUKS\xba\xbe")},
+		{6, be16(1)},
+		{8, []byte("aes")},
+		{40, []byte("xts-plain64")},
+		{104, be32(4096)},
+		{108, be32(64)},
+		{168, []byte(testUUIDString)},
+	}
+	for _, slot := range []int{0, 3} {
+		puts = append(puts, put{int64(208 + slot*48), be32(luks1SlotActive)})
+	}
+	for _, slot := range []int{1, 2, 4, 5, 6, 7} {
+		puts = append(puts, put{int64(208 + slot*48), be32(0x0000dead)})
+	}
+	return image(imageSize, puts...)
+}
+
+func luks2(metadata string) []byte {
+	return image(imageSize,
+		put{0, []byte("LUKS\xba\xbe")},
+		put{6, be16(2)},
+		put{8, be64(16384)},
+		put{24, []byte("secret")},
+		put{168, []byte(testUUIDString)},
+		put{4096, []byte(metadata)},
+	)
+}
+
+const luks2JSON = `{
+	"keyslots": {"2": {"key_size": 64}, "0": {"key_size": 64}},
+	"segments": {"0": {"type": "crypt", "offset": "16777216", "encryption": "aes-xts-plain64"}}
+}`
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name string
+		img  []byte
+		want *Result
+	}{
+		{
+			name: "ext2",
+			img:  ext(0, 0, 0),
+			want: &Result{Type: "ext2", Usage: UsageFilesystem, UUID: testUUIDString, Label: "root"},
+		},
+		{
+			name: "ext3",
+			img:  ext(0x4, 0x2, 0x1),
+			want: &Result{Type: "ext3", Usage: UsageFilesystem, UUID: testUUIDString, Label: "root"},
+		},
+		{
+			name: "ext4",
+			img:  ext(0x4, 0x2|0x40, 0x1),
+			want: &Result{Type: "ext4", Usage: UsageFilesystem, UUID: testUUIDString, Label: "root"},
+		},
+		{
+			name: "ext journal device",
+			img:  ext(0, 0x8, 0),
+			want: &Result{Type: "jbd", Usage: UsageFilesystem, UUID: testUUIDString, Label: "root"},
+		},
+		{
+			name: "xfs",
+			img: image(imageSize,
+				put{0, []byte("XFSB")},
+				put{32, testUUID},
+				put{108, []byte("data")},
+			),
+			want: &Result{Type: "xfs", Usage: UsageFilesystem, UUID: testUUIDString, Label: "data"},
+		},
+		{
+			name: "btrfs",
+			img: image(imageSize,
+				put{0x10040, []byte("_BHRfS_M")},
+				put{0x10020, testUUID},
+				put{0x1012b, []byte("pool")},
+			),
+			want: &Result{Type: "btrfs", Usage: UsageFilesystem, UUID: testUUIDString, Label: "pool"},
+		},
+		{
+			name: "btrfs superblock past the end",
+			img:  image(0x10048, put{0x10040, []byte("_BHRfS_M")}),
+			want: &Result{Type: "btrfs", Usage: UsageFilesystem},
+		},
+		{
+			name: "vfat",
+			img: image(imageSize,
+				put{0x52, []byte("FAT32   ")},
+				put{0x43, le32(0x1234abcd)},
+				put{0x47, []byte("EFI        ")},
+				put{510, []byte{0x55, 0xaa}},
+			),
+			want: &Result{Type: "vfat", Usage: UsageFilesystem, UUID: "1234-ABCD", Label: "EFI"},
+		},
+		{
+			name: "vfat without label",
+			img: image(imageSize,
+				put{0x36, []byte("FAT16   ")},
+				put{0x27, le32(0x00ff00ff)},
+				put{0x2b, []byte("NO NAME    ")},
+			),
+			want: &Result{Type: "vfat", Usage: UsageFilesystem, UUID: "00FF-00FF"},
+		},
+		{
+			name: "ntfs",
+			img: image(imageSize,
+				put{3, []byte("NTFS    ")},
+				put{0x48, []byte{0x10, 0x32, 0x54, 0x76, 0x98, 0xba, 0xdc, 0xfe}},
+			),
+			want: &Result{Type: "ntfs", Usage: UsageFilesystem, UUID: "FEDCBA9876543210"},
+		},
+		{
+			name: "swap",
+			img: image(imageSize,
+				put{4086, []byte("SWAPSPACE2")},
+				put{1036, testUUID},
+				put{1052, []byte("swap0")},
+			),
+			want: &Result{Type: "swap", Usage: UsageOther, UUID: testUUIDString, Label: "swap0"},
+		},
+		{
+			name: "swap with 64KiB pages",
+			img: image(imageSize,
+				put{65526, []byte("SWAPSPACE2")},
+				put{1036, testUUID},
+			),
+			want: &Result{Type: "swap", Usage: UsageOther, UUID: testUUIDString},
+		},
+		{
+			name: "swap version 0",
+			img: image(imageSize,
+				put{4086, []byte("SWAP-SPACE")},
+				put{1036, testUUID},
+			),
+			want: &Result{Type: "swap", Usage: UsageOther},
+		},
+		{
+			name: "LUKS1",
+			img:  luks1(),
+			want: &Result{Type: "crypto_LUKS", Usage: UsageCrypto, UUID: testUUIDString, Crypto: &Crypto{
+				Version:    1,
+				Cipher:     "aes-xts-plain64",
+				KeySize:    512,
+				KeySlots:   []int{0, 3},
+				DataOffset: 4096 * 512,
+			}},
+		},
+		{
+			name: "LUKS2",
+			img:  luks2(luks2JSON),
+			want: &Result{Type: "crypto_LUKS", Usage: UsageCrypto, UUID: testUUIDString, Label: "secret", Crypto: &Crypto{
+				Version:    2,
+				Cipher:     "aes-xts-plain64",
+				KeySize:    512,
+				KeySlots:   []int{0, 2},
+				DataOffset: 16 << 20,
+			}},
+		},
+		{
+			name: "LUKS2 with corrupt metadata",
+			img:  luks2(`{"keyslots": `),
+			want: &Result{Type: "crypto_LUKS", Usage: UsageCrypto, UUID: testUUIDString, Label: "secret"},
+		},
+		{
+			name: "LUKS2 with oversized header",
+			img: image(imageSize,
+				put{0, []byte("LUKS\xba\xbe")},
+				put{6, be16(2)},
+				put{8, be64(regionSize + 1)},
+			),
+			want: &Result{Type: "crypto_LUKS", Usage: UsageCrypto},
+		},
+		{
+			name: "md 1.2",
+			img: image(imageSize,
+				put{4096, mdMagic},
+				put{4100, le32(1)},
+				put{4096 + 16, testUUID},
+				put{4096 + 32, []byte("host:0")},
+			),
+			want: &Result{Type: "linux_raid_member", Usage: UsageRAID, UUID: testUUIDString, Label: "host:0"},
+		},
+		{
+			name: "md 0.90",
+			img:  image(imageSize, put{imageSize - 0x10000, mdMagic}, put{imageSize - 0x10000 + 4, le32(0)}),
+			want: &Result{Type: "linux_raid_member", Usage: UsageRAID},
+		},
+		{
+			name: "LVM",
+			img: image(imageSize,
+				put{512, []byte("LABELONE")},
+				put{512 + 20, le32(32)},
+				put{512 + 24, []byte("LVM2 001")},
+				put{512 + 32, []byte("aB3dEfGhIjKlMnOpQrStUvWxYz012345")},
+			),
+			want: &Result{Type: "LVM2_member", Usage: UsageRAID, UUID: "aB3dEf-GhIj-KlMn-OpQr-StUv-WxYz-012345"},
+		},
+		{
+			name: "GPT",
+			img:  image(imageSize, put{510, []byte{0x55, 0xaa}}, put{512, []byte("EFI PART")}),
+			want: &Result{Type: "gpt", Usage: UsagePartitionTable},
+		},
+		{
+			name: "backup GPT",
+			img:  image(imageSize, put{-512, []byte("EFI PART")}),
+			want: &Result{Type: "gpt", Usage: UsagePartitionTable},
+		},
+		{
+			name: "MBR",
+			img: image(imageSize,
+				put{446, []byte{0x80, 0, 0, 0, 0x83}},
+				put{446 + 12, le32(2048)},
+				put{510, []byte{0x55, 0xaa}},
+			),
+			want: &Result{Type: "dos", Usage: UsagePartitionTable},
+		},
+		{
+			name: "boot sector signature without partitions",
+			img:  image(imageSize, put{446, []byte{0x29}}, put{510, []byte{0x55, 0xaa}}),
+		},
+		{
+			// Hybrid ISO images have an MBR too, the filesystem wins
+			name: "iso9660 with MBR",
+			img: image(imageSize,
+				put{446, []byte{0x80, 0, 0, 0, 0x17}},
+				put{446 + 12, le32(2048)},
+				put{510, []byte{0x55, 0xaa}},
+				put{0x8001, []byte("CD001")},
+			),
+			want: &Result{Type: "iso9660", Usage: UsageFilesystem},
+		},
+		{
+			// LUKS headers hide whatever was on the device before
+			name: "LUKS over old filesystem",
+			img: func() []byte {
+				img := luks1()
+				copy(img[0x10040:], "_BHRfS_M")
+				return img
+			}(),
+			want: &Result{Type: "crypto_LUKS", Usage: UsageCrypto, UUID: testUUIDString, Crypto: &Crypto{
+				Version:    1,
+				Cipher:     "aes-xts-plain64",
+				KeySize:    512,
+				KeySlots:   []int{0, 3},
+				DataOffset: 4096 * 512,
+			}},
+		},
+		{
+			name: "blank",
+			img:  image(imageSize),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := probe(imageRegion(tt.img)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("probe() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
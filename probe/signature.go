@@ -0,0 +1,244 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/alexdzyoba/sys/bulkio"
+	"github.com/pkg/errors"
+)
+
+// Usage is what a signature marks the device as
+type Usage string
+
+const (
+	UsageFilesystem     Usage = "filesystem"
+	UsagePartitionTable Usage = "partition_table"
+	UsageRAID           Usage = "raid"
+	UsageCrypto         Usage = "crypto"
+	UsageOther          Usage = "other"
+)
+
+// Signature is a magic value found on a device
+type Signature struct {
+	Type   string
+	Usage  Usage
+	Offset int64
+	Magic  []byte
+}
+
+// magic describes where a signature is looked for. offset returns the
+// location of the magic for a device of the given size, or -1 if the
+// device is too small.
+type magic struct {
+	typ    string
+	usage  Usage
+	offset func(size int64) int64
+	value  []byte
+}
+
+func at(off int64) func(int64) int64 {
+	return func(size int64) int64 {
+		// The magic table is shared by all scans, off must stay as is
+		o := off
+		if o < 0 {
+			o += size
+		}
+		return o
+	}
+}
+
+var mdMagic = []byte{0xfc, 0x4e, 0x2b, 0xa9}
+
+var magics = []magic{
+	{"gpt", UsagePartitionTable, at(512), []byte("EFI PART")},
+	{"gpt", UsagePartitionTable, at(4096), []byte("EFI PART")},
+	{"gpt", UsagePartitionTable, at(-512), []byte("EFI PART")},
+	{"gpt", UsagePartitionTable, at(-4096), []byte("EFI PART")},
+	{"dos", UsagePartitionTable, at(510), []byte{0x55, 0xaa}},
+	{"ext4", UsageFilesystem, at(0x438), []byte{0x53, 0xef}},
+	{"xfs", UsageFilesystem, at(0), []byte("XFSB")},
+	{"btrfs", UsageFilesystem, at(0x10040), []byte("_BHRfS_M")},
+	{"vfat", UsageFilesystem, at(0x36), []byte("FAT1")},
+	{"vfat", UsageFilesystem, at(0x52), []byte("FAT32   ")},
+	{"ntfs", UsageFilesystem, at(3), []byte("NTFS    ")},
+	{"iso9660", UsageFilesystem, at(0x8001), []byte("CD001")},
+	{"swap", UsageOther, at(4096 - 10), []byte("SWAPSPACE2")},
+	{"swap", UsageOther, at(4096 - 10), []byte("SWAP-SPACE")},
+	{"swap", UsageOther, at(65536 - 10), []byte("SWAPSPACE2")},
+	{"crypto_LUKS", UsageCrypto, at(0), []byte("LUKS\xba\xbe")},
+	{"crypto_LUKS", UsageCrypto, at(0x4000), []byte("SKUL\xba\xbe")},
+	{"LVM2_member", UsageRAID, at(512 + 24), []byte("LVM2 001")},
+	{"LVM2_member", UsageRAID, at(24), []byte("LVM2 001")},
+	{"LVM2_member", UsageRAID, at(1024 + 24), []byte("LVM2 001")},
+	{"LVM2_member", UsageRAID, at(1536 + 24), []byte("LVM2 001")},
+	{"linux_raid_member", UsageRAID, at(0), mdMagic},
+	{"linux_raid_member", UsageRAID, at(4096), mdMagic},
+	// md 0.90 superblock is in the last 64KiB aligned block
+	{"linux_raid_member", UsageRAID, func(size int64) int64 { return size&^0xffff - 0x10000 }, mdMagic},
+	// md 1.0 superblock is 8KiB before the end, 4KiB aligned
+	{"linux_raid_member", UsageRAID, func(size int64) int64 { return (size - 8192) &^ 4095 }, mdMagic},
+}
+
+const (
+	// regionSize is how much is read from the start and the end of the
+	// device; every known signature lives within it
+	regionSize = 1 << 20
+)
+
+// Scan looks for known partition table, filesystem, RAID, LVM and LUKS
+// signatures in the first and last MiB of the device
+func Scan(devicePath string) ([]Signature, error) {
+	r, err := readDevice(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	return match(r), nil
+}
+
+// ScanFile scans the open device like Scan, e.g. one opened exclusively
+// before its signatures are erased so the content can't change in between
+func ScanFile(f *os.File) ([]Signature, error) {
+	r, err := readFile(f)
+	if err != nil {
+		return nil, err
+	}
+	return match(r), nil
+}
+
+func readDevice(devicePath string) (region, error) {
+	f, err := os.Open(devicePath)
+	if err != nil {
+		return region{}, errors.Wrapf(err, "failed to open %s", devicePath)
+	}
+	defer f.Close()
+
+	return readFile(f)
+}
+
+func readFile(f *os.File) (region, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return region{}, errors.Wrapf(err, "failed to get size of %s", f.Name())
+	}
+
+	head, tail, err := readRegions(f, size)
+	if err != nil {
+		return region{}, errors.Wrapf(err, "failed to read %s", f.Name())
+	}
+
+	return region{head, tail, size}, nil
+}
+
+// reader is shared by all scans so an io_uring instance is set up once
+var (
+	readerOnce sync.Once
+	reader     bulkio.Reader
+)
+
+func sharedReader() bulkio.Reader {
+	readerOnce.Do(func() { reader = bulkio.New() })
+	return reader
+}
+
+// readRegions reads the first and the last MiB of the device in one batch
+func readRegions(f *os.File, size int64) ([]byte, []byte, error) {
+	n := int64(regionSize)
+	if size < n {
+		n = size
+	}
+
+	reqs := []*bulkio.Request{
+		{File: f, Offset: 0, Buf: make([]byte, n)},
+		{File: f, Offset: size - n, Buf: make([]byte, n)},
+	}
+
+	if err := sharedReader().ReadBatch(context.Background(), reqs); err != nil {
+		return nil, nil, err
+	}
+	for _, req := range reqs {
+		if req.Err != nil && req.Err != io.EOF {
+			return nil, nil, req.Err
+		}
+	}
+
+	return reqs[0].Buf[:reqs[0].N], reqs[1].Buf[:reqs[1].N], nil
+}
+
+// region holds the first and the last MiB of a device
+type region struct {
+	head, tail []byte
+	size       int64
+}
+
+// bytes returns n bytes at the device offset or nil if they were not read
+func (r region) bytes(off int64, n int) []byte {
+	end := off + int64(n)
+	tailStart := r.size - int64(len(r.tail))
+	switch {
+	case off < 0 || end > r.size:
+		return nil
+	case end <= int64(len(r.head)):
+		return r.head[off:end]
+	case off >= tailStart:
+		return r.tail[off-tailStart : end-tailStart]
+	}
+	return nil
+}
+
+func match(r region) []Signature {
+	var sigs []Signature
+	seen := map[int64]bool{}
+	for _, m := range magics {
+		off := m.offset(r.size)
+		if b := r.bytes(off, len(m.value)); b == nil || !bytes.Equal(b, m.value) || seen[off] {
+			continue
+		}
+		if m.typ == "dos" && !hasPartitionEntries(r.head) {
+			// Boot sectors of FAT and NTFS carry 0x55aa as well
+			continue
+		}
+
+		seen[off] = true
+		sig := Signature{
+			Type:   m.typ,
+			Usage:  m.usage,
+			Offset: off,
+			Magic:  m.value,
+		}
+		if parse, ok := parsers[m.typ]; ok {
+			// Some formats share the magic like ext2, ext3 and ext4
+			res := Result{Type: m.typ}
+			parse(r, off, &res)
+			sig.Type = res.Type
+		}
+		sigs = append(sigs, sig)
+	}
+
+	return sigs
+}
+
+// hasPartitionEntries returns whether the MBR partition table has at least
+// one entry with a non-zero type and valid boot indicators
+func hasPartitionEntries(head []byte) bool {
+	if len(head) < 512 {
+		return false
+	}
+
+	var used bool
+	for i := 0; i < 4; i++ {
+		e := head[446+i*16 : 446+(i+1)*16]
+		if e[0] != 0 && e[0] != 0x80 {
+			return false
+		}
+		if e[4] != 0 && binary.LittleEndian.Uint32(e[12:]) != 0 {
+			used = true
+		}
+	}
+
+	return used
+}
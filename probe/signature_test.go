@@ -0,0 +1,29 @@
+package probe
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// Scans of devices of different sizes share the magic table, offsets from
+// the end must be computed for each device
+func TestScanDifferentSizes(t *testing.T) {
+	dir := t.TempDir()
+	for _, size := range []int64{4 << 20, 8 << 20, 4 << 20} {
+		p := path.Join(dir, "disk.img")
+		if err := os.WriteFile(p, image(size, put{-512, []byte("EFI PART")}), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		sigs, err := Scan(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []Signature{{Type: "gpt", Usage: UsagePartitionTable, Offset: size - 512, Magic: []byte("EFI PART")}}
+		if !reflect.DeepEqual(sigs, want) {
+			t.Errorf("Scan() of %d bytes = %+v, want %+v", size, sigs, want)
+		}
+	}
+}
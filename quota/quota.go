@@ -0,0 +1,191 @@
+package quota
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/alexdzyoba/sys/mounts"
+	"github.com/pkg/errors"
+)
+
+// Type is the kind of id a quota is accounted for
+type Type int
+
+const (
+	TypeUser Type = iota
+	TypeGroup
+	TypeProject
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeUser:
+		return "user"
+	case TypeGroup:
+		return "group"
+	case TypeProject:
+		return "project"
+	}
+	return "unknown"
+}
+
+const (
+	qGetQuota     = 0x800007
+	qGetNextQuota = 0x800009
+
+	// Block limits are reported in 1KiB units
+	dqBlockSize = 1024
+)
+
+// ifDqblk mirrors struct if_dqblk from linux/quota.h
+type ifDqblk struct {
+	bhardlimit uint64
+	bsoftlimit uint64
+	curspace   uint64
+	ihardlimit uint64
+	isoftlimit uint64
+	curinodes  uint64
+	btime      uint64
+	itime      uint64
+	valid      uint32
+}
+
+// ifNextDqblk mirrors struct if_nextdqblk from linux/quota.h
+type ifNextDqblk struct {
+	bhardlimit uint64
+	bsoftlimit uint64
+	curspace   uint64
+	ihardlimit uint64
+	isoftlimit uint64
+	curinodes  uint64
+	btime      uint64
+	itime      uint64
+	valid      uint32
+	id         uint32
+}
+
+// Usage represents quota usage and limits of a single id
+type Usage struct {
+	ID   uint32
+	Type Type
+
+	SpaceUsed      uint64
+	SpaceSoftLimit uint64
+	SpaceHardLimit uint64
+	InodesUsed     uint64
+	InodeSoftLimit uint64
+	InodeHardLimit uint64
+
+	// SpaceGrace and InodeGrace are the times the soft limits turn into
+	// hard ones. They are zero unless the soft limit is exceeded.
+	SpaceGrace time.Time
+	InodeGrace time.Time
+}
+
+// Get returns quota usage of the given id on the filesystem mounted at
+// mountpoint. Both ext4 and xfs are supported through the generic VFS quota
+// interface.
+func Get(mountpoint string, typ Type, id uint32) (*Usage, error) {
+	special, err := specialDevice(mountpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var dq ifDqblk
+	if err := quotactl(qGetQuota, typ, special, id, unsafe.Pointer(&dq)); err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s quota %d on %s", typ, id, mountpoint)
+	}
+
+	u := newUsage(dq, typ, id)
+	return &u, nil
+}
+
+// List returns quota usage of every id that has quota accounted on the
+// filesystem mounted at mountpoint, like repquota(8) does
+func List(mountpoint string, typ Type) ([]Usage, error) {
+	special, err := specialDevice(mountpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var us []Usage
+	var id uint32
+	for {
+		var dq ifNextDqblk
+		err := quotactl(qGetNextQuota, typ, special, id, unsafe.Pointer(&dq))
+		if err == syscall.ENOENT {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list %s quotas on %s", typ, mountpoint)
+		}
+
+		next := dq.id
+		us = append(us, newUsage(ifDqblk{
+			bhardlimit: dq.bhardlimit,
+			bsoftlimit: dq.bsoftlimit,
+			curspace:   dq.curspace,
+			ihardlimit: dq.ihardlimit,
+			isoftlimit: dq.isoftlimit,
+			curinodes:  dq.curinodes,
+			btime:      dq.btime,
+			itime:      dq.itime,
+			valid:      dq.valid,
+		}, typ, next))
+
+		if next == ^uint32(0) {
+			break
+		}
+		id = next + 1
+	}
+
+	return us, nil
+}
+
+func newUsage(dq ifDqblk, typ Type, id uint32) Usage {
+	u := Usage{
+		ID:             id,
+		Type:           typ,
+		SpaceUsed:      dq.curspace,
+		SpaceSoftLimit: dq.bsoftlimit * dqBlockSize,
+		SpaceHardLimit: dq.bhardlimit * dqBlockSize,
+		InodesUsed:     dq.curinodes,
+		InodeSoftLimit: dq.isoftlimit,
+		InodeHardLimit: dq.ihardlimit,
+	}
+	if dq.btime != 0 {
+		u.SpaceGrace = time.Unix(int64(dq.btime), 0)
+	}
+	if dq.itime != 0 {
+		u.InodeGrace = time.Unix(int64(dq.itime), 0)
+	}
+
+	return u
+}
+
+// specialDevice returns the block device backing the mount
+func specialDevice(mountpoint string) (string, error) {
+	m, err := mounts.MountForPath(mountpoint)
+	if err != nil {
+		return "", err
+	}
+
+	return m.Source, nil
+}
+
+func quotactl(cmd int, typ Type, special string, id uint32, addr unsafe.Pointer) error {
+	p, err := syscall.BytePtrFromString(special)
+	if err != nil {
+		return err
+	}
+
+	qcmd := cmd<<8 | int(typ)&0xff
+	_, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL,
+		uintptr(qcmd), uintptr(unsafe.Pointer(p)), uintptr(id), uintptr(addr), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
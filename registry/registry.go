@@ -0,0 +1,272 @@
+package registry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexdzyoba/sys/block"
+	"github.com/alexdzyoba/sys/internal/fsutil"
+	"github.com/pkg/errors"
+)
+
+// EventKind is the kind of change recorded in a disk's history
+type EventKind string
+
+const (
+	EventFirstSeen  EventKind = "first-seen"
+	EventReappeared EventKind = "reappeared"
+	EventRenamed    EventKind = "renamed"
+	EventResized    EventKind = "resized"
+	EventMoved      EventKind = "moved"
+)
+
+// Event is a single entry of a disk's history
+type Event struct {
+	// StableID is the disk the event is about
+	StableID string    `json:"stable_id"`
+	Time     time.Time `json:"time"`
+	Kind     EventKind `json:"kind"`
+	From     string    `json:"from,omitempty"`
+	To       string    `json:"to,omitempty"`
+}
+
+// Entry is the lifecycle record of a physical disk
+type Entry struct {
+	StableID  string    `json:"stable_id"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Name      string    `json:"name"`
+	Size      uint64    `json:"size"`
+	Slot      string    `json:"slot,omitempty"`
+	History   []Event   `json:"history"`
+	// Annotations are user supplied facts like "purpose=etcd"
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Registry is a file-backed record of every disk ever observed on the host.
+// Registry is safe for concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]*Entry
+	// present tracks ids seen by the latest Observe to detect reappearance
+	present map[string]bool
+}
+
+// Open loads the registry from the file, which doesn't have to exist
+func Open(p string) (*Registry, error) {
+	r := &Registry{
+		path:    p,
+		entries: map[string]*Entry{},
+		present: map[string]bool{},
+	}
+
+	content, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", p)
+	}
+
+	var entries []*Entry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %v", p)
+	}
+	for _, e := range entries {
+		r.entries[e.StableID] = e
+	}
+
+	return r, nil
+}
+
+// Observe records the current state of the devices and returns the changes
+// found since the previous observation. Disks are tracked by their WWN or
+// serial number, devices without one are skipped: filesystem UUIDs and
+// physical paths of the weaker stable ids would mix up disks that were
+// cloned or swapped.
+func (r *Registry) Observe(ds []block.Device, now time.Time) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []Event
+	present := map[string]bool{}
+	for _, d := range ds {
+		id, ok := hardwareID(d)
+		if !ok {
+			continue
+		}
+		present[id] = true
+		slot, _ := d.PhysicalPath()
+
+		e, ok := r.entries[id]
+		if !ok {
+			e = &Entry{
+				StableID:  id,
+				FirstSeen: now,
+				Name:      d.Name,
+				Size:      d.Size,
+				Slot:      slot,
+			}
+			r.entries[id] = e
+			events = append(events, e.record(now, EventFirstSeen, "", d.Name))
+		} else {
+			if len(r.present) > 0 && !r.present[id] {
+				events = append(events, e.record(now, EventReappeared, "", d.Name))
+			}
+			if e.Name != d.Name {
+				events = append(events, e.record(now, EventRenamed, e.Name, d.Name))
+				e.Name = d.Name
+			}
+			if e.Size != d.Size {
+				events = append(events, e.record(now, EventResized,
+					strconv.FormatUint(e.Size, 10), strconv.FormatUint(d.Size, 10)))
+				e.Size = d.Size
+			}
+			if slot != "" && e.Slot != slot {
+				events = append(events, e.record(now, EventMoved, e.Slot, slot))
+				e.Slot = slot
+			}
+		}
+		e.LastSeen = now
+	}
+	r.present = present
+
+	return events
+}
+
+// hardwareID returns the stable id of the device if it comes from the
+// hardware
+func hardwareID(d block.Device) (string, bool) {
+	id, err := d.StableID()
+	if err != nil {
+		return "", false
+	}
+	for _, prefix := range []string{"wwn-", "wwid-", "serial-"} {
+		if strings.HasPrefix(id, prefix) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func (e *Entry) record(t time.Time, kind EventKind, from, to string) Event {
+	ev := Event{StableID: e.StableID, Time: t, Kind: kind, From: from, To: to}
+	e.History = append(e.History, ev)
+	return ev
+}
+
+// Get returns the record of the disk
+func (r *Registry) Get(stableID string) (Entry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[stableID]
+	if !ok {
+		return Entry{}, false
+	}
+	return e.copy(), true
+}
+
+// Entries returns all records sorted by first appearance
+func (r *Registry) Entries() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.sorted()
+}
+
+func (r *Registry) sorted() []Entry {
+	es := make([]Entry, 0, len(r.entries))
+	for _, e := range r.entries {
+		es = append(es, e.copy())
+	}
+	sort.Slice(es, func(i, j int) bool {
+		if es[i].FirstSeen.Equal(es[j].FirstSeen) {
+			return es[i].StableID < es[j].StableID
+		}
+		return es[i].FirstSeen.Before(es[j].FirstSeen)
+	})
+	return es
+}
+
+func (e *Entry) copy() Entry {
+	c := *e
+	c.History = append([]Event(nil), e.History...)
+	if e.Annotations != nil {
+		c.Annotations = make(map[string]string, len(e.Annotations))
+		for k, v := range e.Annotations {
+			c.Annotations[k] = v
+		}
+	}
+	return c
+}
+
+// Annotate sets the annotation of a known disk. An empty value removes the
+// annotation.
+func (r *Registry) Annotate(stableID, key, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[stableID]
+	if !ok {
+		return errors.Errorf("disk %s was never observed", stableID)
+	}
+
+	if value == "" {
+		delete(e.Annotations, key)
+		return nil
+	}
+	if e.Annotations == nil {
+		e.Annotations = map[string]string{}
+	}
+	e.Annotations[key] = value
+
+	return nil
+}
+
+// Annotated is a current device with its registry annotations
+type Annotated struct {
+	Device      block.Device
+	StableID    string
+	Annotations map[string]string
+}
+
+// Annotated merges annotations into the devices for inventory output.
+// Devices without a WWN or serial or never observed have no annotations.
+func (r *Registry) Annotated(ds []block.Device) []Annotated {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	as := make([]Annotated, 0, len(ds))
+	for _, d := range ds {
+		a := Annotated{Device: d}
+		if id, ok := hardwareID(d); ok {
+			a.StableID = id
+			if e, ok := r.entries[id]; ok {
+				a.Annotations = e.copy().Annotations
+			}
+		}
+		as = append(as, a)
+	}
+
+	return as
+}
+
+// Save writes the registry back to its file atomically
+func (r *Registry) Save() error {
+	r.mu.Lock()
+	content, err := json.MarshalIndent(r.sorted(), "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "failed to encode registry")
+	}
+
+	return fsutil.WriteFileAtomic(r.path, content)
+}
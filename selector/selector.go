@@ -0,0 +1,276 @@
+package selector
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alexdzyoba/sys/block"
+	"github.com/alexdzyoba/sys/topology"
+	"github.com/alexdzyoba/sys/udev"
+)
+
+// bootMounts are mount points whose disks are considered boot disks
+var bootMounts = []string{"/", "/boot", "/boot/efi", "/efi"}
+
+// Disk is a whole disk with the facts criteria are evaluated against
+type Disk struct {
+	Device    block.Device
+	Removable bool
+	// Boot is set for disks backing the root or boot filesystems
+	Boot bool
+	// Empty is set for disks without partitions and signatures
+	Empty bool
+	// Links are udev symlinks relative to /dev like "disk/by-path/..."
+	Links      []string
+	Properties map[string]string
+}
+
+// Filter accepts or rejects a disk, explaining why
+type Filter func(Disk) (ok bool, reason string)
+
+// Less orders accepted disks, the first one being the best candidate
+type Less func(a, b Disk) bool
+
+// Candidate is a disk evaluated by a Selector along with the reasoning
+type Candidate struct {
+	Disk    Disk
+	Reasons []string
+}
+
+// Selector picks disks matching all filters ranked by Less
+type Selector struct {
+	Filters []Filter
+	Less    Less
+	// Limit caps the number of selected disks, zero means no limit
+	Limit int
+}
+
+// Result of a selection. Rejected keeps disks that failed a filter with the
+// reason of the first failure.
+type Result struct {
+	Selected []Candidate
+	Rejected []Candidate
+}
+
+// Select evaluates every whole disk of the host
+func (s Selector) Select() (*Result, error) {
+	disks, err := Disks()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.SelectFrom(disks), nil
+}
+
+// SelectFrom evaluates the given disks
+func (s Selector) SelectFrom(disks []Disk) *Result {
+	r := &Result{}
+
+	for _, d := range disks {
+		c := Candidate{Disk: d}
+		accepted := true
+		for _, f := range s.Filters {
+			ok, reason := f(d)
+			c.Reasons = append(c.Reasons, reason)
+			if !ok {
+				accepted = false
+				break
+			}
+		}
+		if accepted {
+			r.Selected = append(r.Selected, c)
+		} else {
+			r.Rejected = append(r.Rejected, c)
+		}
+	}
+
+	if s.Less != nil {
+		sort.SliceStable(r.Selected, func(i, j int) bool {
+			return s.Less(r.Selected[i].Disk, r.Selected[j].Disk)
+		})
+	}
+	for i := range r.Selected {
+		r.Selected[i].Reasons = append(r.Selected[i].Reasons, fmt.Sprintf("ranked #%d", i+1))
+	}
+
+	if s.Limit > 0 && len(r.Selected) > s.Limit {
+		for _, c := range r.Selected[s.Limit:] {
+			c.Reasons = append(c.Reasons, fmt.Sprintf("beyond limit of %d", s.Limit))
+			r.Rejected = append(r.Rejected, c)
+		}
+		r.Selected = r.Selected[:s.Limit]
+	}
+
+	return r
+}
+
+// LargestInstallTarget selects the largest non-removable, non-hotpluggable
+// disk that doesn't host the running system
+func LargestInstallTarget() Selector {
+	return Selector{
+		Filters: []Filter{NonRemovable(), NonHotpluggable(), NonBoot()},
+		Less:    LargestFirst,
+		Limit:   1,
+	}
+}
+
+// EmptyDisksOver selects all empty disks of at least minSize bytes
+func EmptyDisksOver(minSize uint64) Selector {
+	return Selector{
+		Filters: []Filter{Empty(), MinSize(minSize)},
+		Less:    LargestFirst,
+	}
+}
+
+// ByPath selects disks with a /dev/disk/by-path name matching the glob,
+// e.g. "pci-0000:3b:00.0-sas-*"
+func ByPath(pattern string) Selector {
+	return Selector{
+		Filters: []Filter{PathMatches(pattern)},
+		Less:    NameOrder,
+	}
+}
+
+// NonRemovable rejects removable media
+func NonRemovable() Filter {
+	return func(d Disk) (bool, string) {
+		if d.Removable {
+			return false, "removable"
+		}
+		return true, "not removable"
+	}
+}
+
+// NonHotpluggable rejects disks attached via USB or FireWire like USB
+// sticks, which often aren't flagged removable
+func NonHotpluggable() Filter {
+	return func(d Disk) (bool, string) {
+		if d.Device.Hotpluggable {
+			return false, "hotpluggable"
+		}
+		return true, "not hotpluggable"
+	}
+}
+
+// NonBoot rejects disks hosting root or boot filesystems
+func NonBoot() Filter {
+	return func(d Disk) (bool, string) {
+		if d.Boot {
+			return false, "hosts the running system"
+		}
+		return true, "not a boot disk"
+	}
+}
+
+// Empty rejects disks with partitions or signatures
+func Empty() Filter {
+	return func(d Disk) (bool, string) {
+		if !d.Empty {
+			return false, "not empty"
+		}
+		return true, "empty"
+	}
+}
+
+// MinSize rejects disks smaller than size bytes
+func MinSize(size uint64) Filter {
+	return func(d Disk) (bool, string) {
+		if d.Device.Size < size {
+			return false, fmt.Sprintf("smaller than %d bytes", size)
+		}
+		return true, fmt.Sprintf("at least %d bytes", size)
+	}
+}
+
+// PathMatches accepts disks with a by-path link matching the glob
+func PathMatches(pattern string) Filter {
+	return func(d Disk) (bool, string) {
+		for _, l := range d.Links {
+			if !strings.HasPrefix(l, "disk/by-path/") {
+				continue
+			}
+			if ok, _ := filepath.Match(pattern, strings.TrimPrefix(l, "disk/by-path/")); ok {
+				return true, "by-path " + path.Base(l) + " matches " + pattern
+			}
+		}
+		return false, "no by-path name matches " + pattern
+	}
+}
+
+// LargestFirst ranks bigger disks first
+func LargestFirst(a, b Disk) bool {
+	if a.Device.Size == b.Device.Size {
+		return a.Device.Name < b.Device.Name
+	}
+	return a.Device.Size > b.Device.Size
+}
+
+// NameOrder ranks disks by kernel name
+func NameOrder(a, b Disk) bool {
+	return a.Device.Name < b.Device.Name
+}
+
+// Disks gathers facts about every whole disk of the host
+func Disks() ([]Disk, error) {
+	ds, err := block.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := topology.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	boot := map[string]bool{}
+	for _, mp := range bootMounts {
+		paths, err := g.PathToMount(mp)
+		if err != nil {
+			continue
+		}
+		for _, p := range paths {
+			boot[p[0].Name] = true
+		}
+	}
+
+	var disks []Disk
+	for _, d := range ds {
+		if d.Type != block.TypeDisk && d.Type != block.TypeNVMe {
+			continue
+		}
+
+		disk := Disk{
+			Device:     d,
+			Removable:  d.Removable,
+			Boot:       boot[d.Name],
+			Properties: map[string]string{},
+		}
+		if rec, err := udevRecord(d); err == nil {
+			disk.Links = rec.Links
+			disk.Properties = rec.Properties
+		}
+		disk.Empty, err = d.IsEmpty()
+		if err != nil {
+			// Reading the device needs privileges, fall back to what
+			// the kernel and udev know
+			disk.Empty = len(g.Children("block:"+d.Name)) == 0 &&
+				disk.Properties["ID_FS_TYPE"] == "" &&
+				disk.Properties["ID_PART_TABLE_TYPE"] == ""
+		}
+
+		disks = append(disks, disk)
+	}
+
+	return disks, nil
+}
+
+func udevRecord(d block.Device) (*udev.Record, error) {
+	major, minor, err := d.DevNumber()
+	if err != nil {
+		return nil, err
+	}
+	return udev.BlockDevice(major, minor)
+}
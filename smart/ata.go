@@ -0,0 +1,236 @@
+package smart
+
+import (
+	"encoding/binary"
+	"os"
+	"strings"
+
+	"github.com/alexdzyoba/sys/internal/sgio"
+	"github.com/pkg/errors"
+)
+
+const (
+	scsiATAPassThrough = 0x85
+
+	ataIdentifyDevice = 0xec
+	ataSMART          = 0xb0
+
+	// SMART subcommands in the features register
+	smartReadData      = 0xd0
+	smartReadThreshold = 0xd1
+	smartReturnStatus  = 0xda
+
+	// SMART commands require the key in LBA mid and high
+	smartLBAMid  = 0x4f
+	smartLBAHigh = 0xc2
+	// RETURN STATUS swaps them when a threshold is exceeded
+	smartFailedLBAMid  = 0xf4
+	smartFailedLBAHigh = 0x2c
+
+	ataSectorSize  = 512
+	attributeCount = 30
+	attributeSize  = 12
+)
+
+// ATA attribute IDs the report is made of
+const (
+	AttrReallocatedSectors = 5
+	AttrPowerOnHours       = 9
+	AttrWearLeveling       = 177
+	AttrAirflowTemperature = 190
+	AttrTemperature        = 194
+	AttrSSDLifeLeft        = 231
+	AttrMediaWearout       = 233
+)
+
+// Attribute is an ATA SMART attribute
+type Attribute struct {
+	ID uint8
+	// Value and Worst are normalized by the vendor, usually from 100 or
+	// 200 down, and compared to Threshold
+	Value     uint8
+	Worst     uint8
+	Threshold uint8
+	Raw       uint64
+	// Prefailure attributes falling to the threshold predict a failure,
+	// others only indicate age
+	Prefailure bool
+}
+
+// Failing returns whether the attribute is at or below its threshold
+func (a Attribute) Failing() bool {
+	return a.Threshold != 0 && a.Value <= a.Threshold
+}
+
+func readATA(f *os.File) (*Report, error) {
+	id := make([]byte, ataSectorSize)
+	if _, err := ataCommand(f, ataIdentifyDevice, 0, id); err != nil {
+		return nil, errors.Wrap(err, "IDENTIFY DEVICE failed")
+	}
+	le := binary.LittleEndian
+
+	r := &Report{
+		Protocol: ProtocolATA,
+		Serial:   ataString(id[20:40]),
+		Firmware: ataString(id[46:54]),
+		Model:    ataString(id[54:94]),
+	}
+	// Word 82 bit 0 is SMART support, word 85 bit 0 is SMART enabled
+	if le.Uint16(id[164:])&1 == 0 {
+		return nil, errors.New("drive doesn't support SMART")
+	}
+	if le.Uint16(id[170:])&1 == 0 {
+		return nil, errors.New("SMART is disabled")
+	}
+
+	data := make([]byte, ataSectorSize)
+	if _, err := ataCommand(f, ataSMART, smartReadData, data); err != nil {
+		return nil, errors.Wrap(err, "SMART READ DATA failed")
+	}
+	thresholds := make([]byte, ataSectorSize)
+	if _, err := ataCommand(f, ataSMART, smartReadThreshold, thresholds); err != nil {
+		return nil, errors.Wrap(err, "SMART READ THRESHOLDS failed")
+	}
+	r.Attributes = parseAttributes(data, thresholds)
+
+	passed := true
+	for _, a := range r.Attributes {
+		if a.Prefailure && a.Failing() {
+			passed = false
+		}
+		r.applyAttribute(a)
+	}
+
+	// The drive's own verdict wins when the registers come back
+	sense, err := ataCommand(f, ataSMART, smartReturnStatus, nil)
+	if err == nil {
+		if status, ok := returnStatus(sense); ok {
+			passed = status
+		}
+	}
+	r.Passed = passed
+
+	return r, nil
+}
+
+func (r *Report) applyAttribute(a Attribute) {
+	switch a.ID {
+	case AttrReallocatedSectors:
+		r.ReallocatedSectors = a.Raw
+	case AttrPowerOnHours:
+		// Some vendors keep minutes or milliseconds in the upper bytes
+		r.PowerOnHours = a.Raw & 0xffffffff
+	case AttrTemperature:
+		r.Temperature, r.TemperatureValid = int(a.Raw&0xff), true
+	case AttrAirflowTemperature:
+		if !r.TemperatureValid {
+			r.Temperature, r.TemperatureValid = int(a.Raw&0xff), true
+		}
+	case AttrWearLeveling, AttrSSDLifeLeft, AttrMediaWearout:
+		// Normalized values count down from 100 as endurance is used
+		if !r.WearValid && a.Value <= 100 {
+			r.PercentUsed, r.WearValid = 100-int(a.Value), true
+		}
+	}
+}
+
+// parseAttributes decodes the attribute table of SMART READ DATA and
+// matches thresholds by ID
+func parseAttributes(data, thresholds []byte) []Attribute {
+	limits := map[uint8]uint8{}
+	for i := 0; i < attributeCount; i++ {
+		e := thresholds[2+i*attributeSize:]
+		if e[0] != 0 {
+			limits[e[0]] = e[1]
+		}
+	}
+
+	var as []Attribute
+	for i := 0; i < attributeCount; i++ {
+		e := data[2+i*attributeSize:]
+		if e[0] == 0 {
+			continue
+		}
+
+		var raw uint64
+		for j := 5; j >= 0; j-- {
+			raw = raw<<8 | uint64(e[5+j])
+		}
+		as = append(as, Attribute{
+			ID:         e[0],
+			Prefailure: binary.LittleEndian.Uint16(e[1:])&1 != 0,
+			Value:      e[3],
+			Worst:      e[4],
+			Threshold:  limits[e[0]],
+			Raw:        raw,
+		})
+	}
+
+	return as
+}
+
+// ataCommand issues a 28-bit command through ATA PASS-THROUGH(16). With a
+// buffer it reads one sector with PIO, without it it's a non-data command
+// asking for the result registers in the sense data.
+func ataCommand(f *os.File, command, feature byte, buf []byte) ([]byte, error) {
+	protocol, flags, dir := byte(4), byte(0x0e), sgio.DirFromDev
+	if buf == nil {
+		// Non-data with CK_COND set to return the registers
+		protocol, flags, dir = 3, 0x20, sgio.DirNone
+	}
+
+	var mid, high byte
+	if command == ataSMART {
+		mid, high = smartLBAMid, smartLBAHigh
+	}
+
+	cdb := []byte{
+		scsiATAPassThrough,
+		protocol << 1,
+		flags,
+		0, feature,
+		0, 1,
+		0, 0,
+		0, mid,
+		0, high,
+		0,
+		command,
+		0,
+	}
+
+	sense, err := sgio.Exec(f, cdb, dir, buf, 0)
+	if err != nil && buf == nil {
+		// CK_COND reports the registers as a recovered error
+		if serr, ok := err.(*sgio.Error); ok && len(serr.Sense) > 0 {
+			return serr.Sense, nil
+		}
+	}
+	return sense, err
+}
+
+// returnStatus decodes LBA mid and high of the ATA Status Return
+// descriptor of descriptor format sense data
+func returnStatus(sense []byte) (passed bool, ok bool) {
+	if len(sense) < 8+14 || sense[0]&0x7f != 0x72 || sense[8] != 0x09 {
+		return false, false
+	}
+	desc := sense[8:]
+
+	switch {
+	case desc[9] == smartLBAMid && desc[11] == smartLBAHigh:
+		return true, true
+	case desc[9] == smartFailedLBAMid && desc[11] == smartFailedLBAHigh:
+		return false, true
+	}
+	return false, false
+}
+
+// ataString decodes IDENTIFY strings stored with the bytes of every word
+// swapped
+func ataString(b []byte) string {
+	s := make([]byte, len(b))
+	for i := 0; i+1 < len(b); i += 2 {
+		s[i], s[i+1] = b[i+1], b[i]
+	}
+	return strings.TrimSpace(string(s))
+}
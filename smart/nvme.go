@@ -0,0 +1,156 @@
+package smart
+
+import (
+	"encoding/binary"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// _IOWR('N', 0x41, struct nvme_admin_cmd)
+	nvmeIoctlAdminCmd = 0xc0484e41
+
+	nvmeAdminGetLogPage = 0x02
+	nvmeAdminIdentify   = 0x06
+
+	nvmeLogHealth      = 0x02
+	nvmeHealthLogSize  = 512
+	nvmeIdentifySize   = 4096
+	nvmeIdentifyCtrl   = 1
+	nvmeNamespaceAll   = 0xffffffff
+	kelvinCelsiusDelta = 273
+)
+
+// NVMe critical warning bits
+const (
+	WarnSpare       = 1 << 0
+	WarnTemperature = 1 << 1
+	WarnReliability = 1 << 2
+	WarnReadOnly    = 1 << 3
+	WarnBackup      = 1 << 4
+)
+
+// NVMeHealth is the SMART / Health Information log page
+type NVMeHealth struct {
+	// CriticalWarning holds Warn* bits
+	CriticalWarning uint8
+	// AvailableSpare and its threshold are percentages
+	AvailableSpare          int
+	AvailableSpareThreshold int
+	PercentUsed             int
+	// DataUnitsRead and DataUnitsWritten count thousands of 512 byte
+	// units
+	DataUnitsRead    uint64
+	DataUnitsWritten uint64
+	PowerCycles      uint64
+	PowerOnHours     uint64
+	UnsafeShutdowns  uint64
+	MediaErrors      uint64
+	ErrorLogEntries  uint64
+}
+
+// nvmeAdminCmd mirrors struct nvme_admin_cmd from linux/nvme_ioctl.h
+type nvmeAdminCmd struct {
+	opcode      uint8
+	flags       uint8
+	rsvd1       uint16
+	nsid        uint32
+	cdw2        uint32
+	cdw3        uint32
+	metadata    uint64
+	addr        uint64
+	metadataLen uint32
+	dataLen     uint32
+	cdw10       uint32
+	cdw11       uint32
+	cdw12       uint32
+	cdw13       uint32
+	cdw14       uint32
+	cdw15       uint32
+	timeoutMs   uint32
+	result      uint32
+}
+
+func readNVMe(f *os.File) (*Report, error) {
+	id := make([]byte, nvmeIdentifySize)
+	if err := nvmeAdmin(f, nvmeAdminIdentify, 0, nvmeIdentifyCtrl, id); err != nil {
+		return nil, errors.Wrap(err, "identify controller failed")
+	}
+
+	log := make([]byte, nvmeHealthLogSize)
+	// Number of dwords minus one in the upper half, log identifier in the
+	// lower
+	cdw10 := uint32(nvmeHealthLogSize/4-1)<<16 | nvmeLogHealth
+	if err := nvmeAdmin(f, nvmeAdminGetLogPage, nvmeNamespaceAll, cdw10, log); err != nil {
+		return nil, errors.Wrap(err, "get health log page failed")
+	}
+	h := parseNVMeHealth(log)
+
+	r := &Report{
+		Protocol:     ProtocolNVMe,
+		Serial:       strings.TrimSpace(string(id[4:24])),
+		Model:        strings.TrimSpace(string(id[24:64])),
+		Firmware:     strings.TrimSpace(string(id[64:72])),
+		Passed:       h.CriticalWarning == 0,
+		PowerOnHours: h.PowerOnHours,
+		MediaErrors:  h.MediaErrors,
+		PercentUsed:  h.PercentUsed,
+		WearValid:    true,
+		NVMe:         h,
+	}
+	if kelvin := int(binary.LittleEndian.Uint16(log[1:])); kelvin != 0 {
+		r.Temperature, r.TemperatureValid = kelvin-kelvinCelsiusDelta, true
+	}
+
+	return r, nil
+}
+
+func parseNVMeHealth(log []byte) *NVMeHealth {
+	return &NVMeHealth{
+		CriticalWarning:         log[0],
+		AvailableSpare:          int(log[3]),
+		AvailableSpareThreshold: int(log[4]),
+		PercentUsed:             int(log[5]),
+		DataUnitsRead:           uint128(log[32:48]),
+		DataUnitsWritten:        uint128(log[48:64]),
+		PowerCycles:             uint128(log[112:128]),
+		PowerOnHours:            uint128(log[128:144]),
+		UnsafeShutdowns:         uint128(log[144:160]),
+		MediaErrors:             uint128(log[160:176]),
+		ErrorLogEntries:         uint128(log[176:192]),
+	}
+}
+
+// uint128 decodes a little endian 128-bit counter saturating at
+// MaxUint64, no drive gets anywhere near it
+func uint128(b []byte) uint64 {
+	le := binary.LittleEndian
+	if le.Uint64(b[8:]) != 0 {
+		return ^uint64(0)
+	}
+	return le.Uint64(b)
+}
+
+func nvmeAdmin(f *os.File, opcode uint8, nsid, cdw10 uint32, buf []byte) error {
+	cmd := nvmeAdminCmd{
+		opcode:  opcode,
+		nsid:    nsid,
+		addr:    uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		dataLen: uint32(len(buf)),
+		cdw10:   cdw10,
+	}
+
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), nvmeIoctlAdminCmd, uintptr(unsafe.Pointer(&cmd)))
+	if errno != 0 {
+		return errno
+	}
+	// Positive results are NVMe status codes of failed commands
+	if r != 0 {
+		return errors.Errorf("command failed with status %#x", r)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Package smart reads drive health without smartctl: SMART data of ATA
+// drives through SCSI-ATA translation and the health log page of NVMe
+// drives. Both need root to open the device node.
+package smart
+
+import (
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// Protocol a drive was queried with
+type Protocol string
+
+const (
+	ProtocolATA  Protocol = "ata"
+	ProtocolNVMe Protocol = "nvme"
+)
+
+// Report is the health of a drive in terms common to ATA and NVMe
+type Report struct {
+	Protocol Protocol
+	Model    string
+	Serial   string
+	Firmware string
+	// Passed is the overall assessment: the ATA SMART status, or no NVMe
+	// critical warnings
+	Passed bool
+	// Temperature is in Celsius, valid only if TemperatureValid is set
+	Temperature      int
+	TemperatureValid bool
+	PowerOnHours     uint64
+	// ReallocatedSectors is ATA attribute 5, NVMe drives report
+	// MediaErrors instead
+	ReallocatedSectors uint64
+	MediaErrors        uint64
+	// PercentUsed estimates consumed endurance of SSDs, it may exceed 100.
+	// It's valid only if WearValid is set.
+	PercentUsed int
+	WearValid   bool
+	// Attributes are the raw ATA SMART attributes
+	Attributes []Attribute
+	// NVMe holds the health log of NVMe drives
+	NVMe *NVMeHealth
+}
+
+// Read queries the drive at the device node like /dev/sda or /dev/nvme0n1
+func Read(devicePath string) (*Report, error) {
+	f, err := os.OpenFile(devicePath, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", devicePath)
+	}
+	defer f.Close()
+
+	if strings.HasPrefix(path.Base(devicePath), "nvme") {
+		r, err := readNVMe(f)
+		return r, errors.Wrapf(err, "failed to read NVMe health of %s", devicePath)
+	}
+
+	r, err := readATA(f)
+	return r, errors.Wrapf(err, "failed to read SMART data of %s", devicePath)
+}
@@ -0,0 +1,157 @@
+package swap
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/pkg/errors"
+)
+
+// Layout of union swap_header from linux/swap.h, the signature is at the
+// end of the first page
+const (
+	headerOffset = 1024
+	uuidOffset   = headerOffset + 12
+	labelOffset  = uuidOffset + 16
+	labelSize    = 16
+	signature    = "SWAPSPACE2"
+
+	// minPages is what mkswap requires
+	minPages = 10
+)
+
+// FormatOptions configures the swap signature
+type FormatOptions struct {
+	// Label is up to 16 bytes
+	Label string
+	// UUID is random if empty
+	UUID string
+	// PageSize defaults to the page size of the system. Areas can only be
+	// activated on systems of the same page size.
+	PageSize int
+}
+
+// Format writes a version 1 swap signature on the block device or file like
+// mkswap, which makes it usable by On. The first page is overwritten, which
+// erases signatures of filesystems and partition tables there. Block
+// devices are opened exclusively, so mounted and active ones are refused.
+func Format(ctx context.Context, path string, opts FormatOptions) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve %v", path)
+	}
+
+	page := opts.PageSize
+	if page == 0 {
+		page = os.Getpagesize()
+	}
+	if page < 4096 || page&(page-1) != 0 {
+		return errors.Errorf("page size %d is invalid", page)
+	}
+	if len(opts.Label) > labelSize {
+		return errors.Errorf("swap label %q is longer than %d bytes", opts.Label, labelSize)
+	}
+	uuid, err := parseUUID(opts.UUID)
+	if err != nil {
+		return err
+	}
+
+	a := action.Action{
+		Op:     "swap.format",
+		Target: abs,
+		Args:   map[string]string{"label": opts.Label, "uuid": formatUUID(uuid), "pagesize": fmt.Sprint(page)},
+	}
+	return action.Run(ctx, a, func() error {
+		return format(abs, page, uuid, opts.Label)
+	})
+}
+
+func format(path string, page int, uuid [16]byte, label string) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat %v", path)
+	}
+	flag := os.O_RDWR
+	if st.Mode()&os.ModeDevice != 0 {
+		flag |= syscall.O_EXCL
+	}
+	f, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %v", path)
+	}
+	defer f.Close()
+
+	// Seeking to the end gives the size of block devices as well
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get size of %v", path)
+	}
+	pages := size / int64(page)
+	if pages < minPages {
+		return errors.Errorf("%v is too small for swap: %d bytes, at least %d needed", path, size, minPages*page)
+	}
+	if pages-1 > int64(^uint32(0)) {
+		pages = int64(^uint32(0)) + 1
+	}
+
+	hdr := make([]byte, page)
+	order := nativeOrder()
+	order.PutUint32(hdr[headerOffset:], 1)
+	order.PutUint32(hdr[headerOffset+4:], uint32(pages-1))
+	copy(hdr[uuidOffset:], uuid[:])
+	copy(hdr[labelOffset:labelOffset+labelSize], label)
+	copy(hdr[page-len(signature):], signature)
+
+	if _, err := f.WriteAt(hdr, 0); err != nil {
+		return errors.Wrapf(err, "failed to write swap header to %v", path)
+	}
+	if err := f.Sync(); err != nil {
+		return errors.Wrapf(err, "failed to sync %v", path)
+	}
+	return nil
+}
+
+// nativeOrder returns the byte order of the system, the kernel reads the
+// header in it
+func nativeOrder() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// parseUUID parses the textual form, an empty string gives a random
+// version 4 UUID
+func parseUUID(s string) ([16]byte, error) {
+	var u [16]byte
+	if s == "" {
+		if _, err := rand.Read(u[:]); err != nil {
+			return u, errors.Wrap(err, "failed to generate swap UUID")
+		}
+		u[6] = u[6]&0x0f | 0x40
+		u[8] = u[8]&0x3f | 0x80
+		return u, nil
+	}
+
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 || len(s) != 36 {
+		return u, errors.Errorf("invalid swap UUID %q", s)
+	}
+	copy(u[:], b)
+	return u, nil
+}
+
+func formatUUID(u [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
@@ -0,0 +1,110 @@
+package swap
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/alexdzyoba/sys/probe"
+)
+
+func TestFormat(t *testing.T) {
+	uuid, err := parseUUID("01234567-89ab-cdef-fedc-ba9876543210")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		size  int64
+		page  int
+		label string
+		// lastPage is the last usable page the header records
+		lastPage uint32
+		wantErr  string
+	}{
+		{name: "4KiB pages", size: 1 << 20, page: 4096, label: "swap0", lastPage: 255},
+		{name: "64KiB pages", size: 1 << 20, page: 65536, lastPage: 15},
+		{name: "partial last page", size: 1<<20 + 100, page: 4096, lastPage: 255},
+		{name: "full label", size: 1 << 20, page: 4096, label: "0123456789abcdef", lastPage: 255},
+		{name: "too small", size: 9 * 4096, page: 4096, wantErr: "too small"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := path.Join(t.TempDir(), "swapfile")
+			if err := os.WriteFile(p, make([]byte, tt.size), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			err := format(p, tt.page, uuid, tt.label)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("format() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hdr, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			order := nativeOrder()
+			if v := order.Uint32(hdr[headerOffset:]); v != 1 {
+				t.Errorf("version = %d, want 1", v)
+			}
+			if v := order.Uint32(hdr[headerOffset+4:]); v != tt.lastPage {
+				t.Errorf("last page = %d, want %d", v, tt.lastPage)
+			}
+			if sig := string(hdr[tt.page-len(signature) : tt.page]); sig != signature {
+				t.Errorf("signature = %q, want %q", sig, signature)
+			}
+
+			// blkid-like probing must find what mkswap would have written
+			res, err := probe.Probe(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := probe.Result{Type: "swap", Usage: probe.UsageOther, UUID: formatUUID(uuid), Label: tt.label}
+			if res == nil || *res != want {
+				t.Errorf("Probe() = %+v, want %+v", res, want)
+			}
+		})
+	}
+}
+
+func TestParseUUID(t *testing.T) {
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{"01234567-89ab-cdef-fedc-ba9876543210", true},
+		{"01234567-89AB-CDEF-FEDC-BA9876543210", true},
+		{"0123456789abcdeffedcba9876543210", false},
+		{"01234567-89ab-cdef-fedc-ba98765432", false},
+		{"01234567-89ab-cdef-fedc-ba987654321g", false},
+	}
+
+	for _, tt := range tests {
+		u, err := parseUUID(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseUUID(%q) error = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && formatUUID(u) != strings.ToLower(tt.in) {
+			t.Errorf("parseUUID(%q) = %s", tt.in, formatUUID(u))
+		}
+	}
+
+	u, err := parseUUID("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u[6]>>4 != 4 || u[8]>>6 != 2 {
+		t.Errorf("random UUID %s is not version 4", formatUUID(u))
+	}
+}
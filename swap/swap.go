@@ -0,0 +1,202 @@
+// Package swap lists active swap areas, writes swap signatures like mkswap
+// and activates and deactivates swap with the swapon and swapoff syscalls.
+package swap
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/pkg/errors"
+)
+
+const procSwaps = "/proc/swaps"
+
+// swapon flags from linux/swap.h
+const (
+	flagPrefer       = 0x8000
+	flagPriorityMask = 0x7fff
+	flagDiscard      = 0x10000
+	flagDiscardOnce  = 0x20000
+	flagDiscardPages = 0x40000
+)
+
+// Area is an active swap area
+type Area struct {
+	Filename string
+	// Type is "partition" for block devices and "file" for swap files
+	Type string
+	// Size and Used are in bytes
+	Size uint64
+	Used uint64
+	// Priority is negative when assigned by the kernel
+	Priority int
+}
+
+// List returns active swap areas from /proc/swaps in the order they were
+// activated
+func List() ([]Area, error) {
+	f, err := os.Open(procSwaps)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", procSwaps)
+	}
+	defer f.Close()
+
+	var areas []Area
+	s := bufio.NewScanner(f)
+	for header := true; s.Scan(); header = false {
+		fields := strings.Fields(s.Text())
+		if header || len(fields) < 5 {
+			continue
+		}
+
+		a := Area{Filename: unescape(fields[0]), Type: fields[1]}
+		size, err1 := strconv.ParseUint(fields[2], 10, 64)
+		used, err2 := strconv.ParseUint(fields[3], 10, 64)
+		prio, err3 := strconv.Atoi(fields[4])
+		if err1 != nil || err2 != nil || err3 != nil {
+			return nil, errors.Errorf("failed to parse %v line %q", procSwaps, s.Text())
+		}
+		a.Size, a.Used, a.Priority = size<<10, used<<10, prio
+		areas = append(areas, a)
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", procSwaps)
+	}
+
+	return areas, nil
+}
+
+// unescape decodes octal escapes (\040 for space etc.) used by the kernel
+// for whitespace and backslashes in file names
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+// Discard selects how swap on SSDs and thin volumes is discarded
+type Discard int
+
+const (
+	// DiscardNone doesn't discard
+	DiscardNone Discard = iota
+	// DiscardOnce discards the whole area when it's activated
+	DiscardOnce
+	// DiscardPages discards pages as they are freed
+	DiscardPages
+	// DiscardAll does both
+	DiscardAll
+)
+
+func (d Discard) String() string {
+	switch d {
+	case DiscardNone:
+		return "none"
+	case DiscardOnce:
+		return "once"
+	case DiscardPages:
+		return "pages"
+	case DiscardAll:
+		return "all"
+	}
+	return "Discard(" + strconv.Itoa(int(d)) + ")"
+}
+
+// Options configures activation
+type Options struct {
+	// Priority from 1 to 32767 sets the order areas are used in, higher
+	// first, and areas of the same priority are used round robin. Zero lets
+	// the kernel assign a priority below all others.
+	Priority int
+	Discard  Discard
+}
+
+func (o Options) flags() (uintptr, error) {
+	var flags uintptr
+	if o.Priority < 0 || o.Priority > flagPriorityMask {
+		return 0, errors.Errorf("swap priority %d is out of range", o.Priority)
+	}
+	if o.Priority > 0 {
+		flags |= flagPrefer | uintptr(o.Priority)
+	}
+
+	switch o.Discard {
+	case DiscardOnce:
+		flags |= flagDiscard | flagDiscardOnce
+	case DiscardPages:
+		flags |= flagDiscard | flagDiscardPages
+	case DiscardAll:
+		flags |= flagDiscard
+	}
+	return flags, nil
+}
+
+// On activates the swap area on the block device or file. Swap files must
+// not have holes, which rules out files created with truncate and copy on
+// write filesystems other than btrfs with nocow files.
+func On(ctx context.Context, path string, opts Options) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve %v", path)
+	}
+
+	flags, err := opts.flags()
+	if err != nil {
+		return err
+	}
+
+	a := action.Action{
+		Op:     "swap.on",
+		Target: abs,
+		Args:   map[string]string{"priority": strconv.Itoa(opts.Priority), "discard": opts.Discard.String()},
+	}
+	return action.Run(ctx, a, func() error {
+		return syscallPath(syscall.SYS_SWAPON, abs, flags, "failed to activate swap on %v")
+	})
+}
+
+// Off deactivates the swap area, the kernel moves its pages back to memory
+// first, which fails with ENOMEM when they don't fit
+func Off(ctx context.Context, path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve %v", path)
+	}
+
+	a := action.Action{Op: "swap.off", Target: abs}
+	return action.Run(ctx, a, func() error {
+		return syscallPath(syscall.SYS_SWAPOFF, abs, 0, "failed to deactivate swap on %v")
+	})
+}
+
+func syscallPath(trap uintptr, path string, flags uintptr, format string) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return errors.Wrapf(err, format, path)
+	}
+	if _, _, errno := syscall.Syscall(trap, uintptr(unsafe.Pointer(p)), flags, 0); errno != 0 {
+		return errors.Wrapf(errno, format, path)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package sysfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// attrDirs are subdirectories of block devices whose attributes are
+// recorded along with the top level ones
+var attrDirs = []string{"", "queue", "integrity"}
+
+// Matrix records which block device attributes exist on the running
+// kernel. Attributes are paths relative to /sys/block/<dev> like
+// "queue/wbt_lat_usec". An attribute counts as present when any device has
+// it, as some only exist for certain device types.
+type Matrix struct {
+	// Release is the kernel release like "5.15.0-91-generic"
+	Release string
+	attrs   map[string]bool
+}
+
+// Detect scans attributes of all block devices
+func Detect() (*Matrix, error) {
+	var u syscall.Utsname
+	if err := syscall.Uname(&u); err != nil {
+		return nil, errors.Wrap(err, "failed to get kernel release")
+	}
+	var release []byte
+	for _, c := range u.Release {
+		if c == 0 {
+			break
+		}
+		release = append(release, byte(c))
+	}
+
+	devs, err := ioutil.ReadDir(sysfsBlockRoot)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", sysfsBlockRoot)
+	}
+
+	m := &Matrix{Release: string(release), attrs: map[string]bool{}}
+	for _, dev := range devs {
+		for _, dir := range attrDirs {
+			entries, err := ioutil.ReadDir(path.Join(sysfsBlockRoot, dev.Name(), dir))
+			if err != nil {
+				// Devices come and go, integrity is optional
+				continue
+			}
+			for _, e := range entries {
+				if e.Mode().IsRegular() {
+					m.attrs[path.Join(dir, e.Name())] = true
+				}
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// HasAttr returns whether the kernel provides the attribute
+func (m *Matrix) HasAttr(name string) bool {
+	return m.attrs[name]
+}
+
+// Attrs returns all present attributes sorted
+func (m *Matrix) Attrs() []string {
+	attrs := make([]string, 0, len(m.attrs))
+	for a := range m.attrs {
+		attrs = append(attrs, a)
+	}
+	sort.Strings(attrs)
+	return attrs
+}
+
+var (
+	current     *Matrix
+	currentErr  error
+	currentOnce sync.Once
+)
+
+// Current returns the matrix of the running kernel detected on first use
+func Current() (*Matrix, error) {
+	currentOnce.Do(func() {
+		current, currentErr = Detect()
+	})
+	return current, currentErr
+}
+
+// HasAttr returns whether the running kernel provides the block device
+// attribute. It's false if detection fails.
+func HasAttr(name string) bool {
+	m, err := Current()
+	return err == nil && m.HasAttr(name)
+}
+
+// DeviceHasAttr returns whether the device has the attribute
+func DeviceHasAttr(dev, name string) bool {
+	_, err := os.Stat(path.Join(sysfsBlockRoot, dev, name))
+	return err == nil
+}
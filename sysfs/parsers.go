@@ -0,0 +1,129 @@
+package sysfs
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const sectorSizeBytes = 512
+
+// Integer is any integer type
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+// Int parses a decimal integer checking it fits the type
+func Int[T Integer](s string) (T, error) {
+	var zero T
+
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		// Values above MaxInt64 only fit unsigned types
+		u, uerr := strconv.ParseUint(s, 10, 64)
+		if uerr != nil || T(u) < 0 || uint64(T(u)) != u {
+			return zero, errors.Wrapf(err, "invalid integer %q", s)
+		}
+		return T(u), nil
+	}
+	if int64(T(v)) != v || (v < 0 && T(v) > 0) {
+		return zero, errors.Errorf("integer %q is out of range", s)
+	}
+
+	return T(v), nil
+}
+
+// FormatInt formats an integer in decimal
+func FormatInt[T Integer](v T) string {
+	if v < 0 {
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatUint(uint64(v), 10)
+}
+
+// Bool parses 0/1, Y/N, yes/no and on/off attributes
+func Bool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "1", "y", "yes", "on", "true":
+		return true, nil
+	case "0", "n", "no", "off", "false":
+		return false, nil
+	}
+	return false, errors.Errorf("invalid boolean %q", s)
+}
+
+// FormatBool formats a boolean as 1 or 0
+func FormatBool(v bool) string {
+	if v {
+		return "1"
+	}
+	return "0"
+}
+
+// Sectors parses a size in 512 bytes sectors, the unit of block device
+// sizes and offsets in sysfs regardless of the logical block size, and
+// returns it in bytes
+func Sectors(s string) (uint64, error) {
+	v, err := Int[uint64](s)
+	if err != nil {
+		return 0, err
+	}
+	return v * sectorSizeBytes, nil
+}
+
+// KiB parses a size in KiB like queue/max_sectors_kb and returns it in
+// bytes
+func KiB(s string) (uint64, error) {
+	v, err := Int[uint64](s)
+	if err != nil {
+		return 0, err
+	}
+	return v << 10, nil
+}
+
+// FormatKiB formats a size in bytes as KiB
+func FormatKiB(v uint64) string {
+	return strconv.FormatUint(v>>10, 10)
+}
+
+// String returns the value as is
+func String(s string) (string, error) {
+	return s, nil
+}
+
+// FormatString writes the value as is
+func FormatString[T ~string](v T) string {
+	return string(v)
+}
+
+// Enum returns a parser accepting only the given values. Attributes listing
+// all choices with the active one in brackets like queue/scheduler
+// "mq-deadline [kyber] none" are parsed to the active choice.
+func Enum[T ~string](values ...T) Parser[T] {
+	return func(s string) (T, error) {
+		if i := strings.Index(s, "["); i >= 0 {
+			if j := strings.Index(s[i:], "]"); j >= 0 {
+				s = s[i+1 : i+j]
+			}
+		}
+		for _, v := range values {
+			if string(v) == s {
+				return v, nil
+			}
+		}
+		return "", errors.Errorf("unexpected value %q", s)
+	}
+}
+
+// Selected parses the active choice of an attribute like queue/scheduler
+// without restricting the values
+func Selected(s string) (string, error) {
+	i := strings.Index(s, "[")
+	j := strings.Index(s, "]")
+	if i < 0 || j < i {
+		return "", errors.Errorf("no selected value in %q", s)
+	}
+	return s[i+1 : j], nil
+}
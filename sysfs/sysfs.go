@@ -0,0 +1,83 @@
+// Package sysfs reads and writes typed sysfs attributes. Attributes are
+// exposed by pairing a path with a parser or formatter:
+//
+//	rotational, err := sysfs.ReadAttr("sda", "queue/rotational", sysfs.Bool)
+package sysfs
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/pkg/errors"
+)
+
+const sysfsBlockRoot = "/sys/block"
+
+// Parser converts an attribute value with surrounding whitespace trimmed
+type Parser[T any] func(string) (T, error)
+
+// Formatter converts a value to be written to an attribute
+type Formatter[T any] func(T) string
+
+// ParseError is returned when an attribute has an unexpected format
+type ParseError struct {
+	Path  string
+	Value string
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("failed to parse %v: %v", e.Path, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Read reads the attribute at the path. A *ParseError is returned if the
+// value can't be parsed.
+func Read[T any](p string, parse Parser[T]) (T, error) {
+	var zero T
+
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return zero, errors.Wrapf(err, "failed to read %v", p)
+	}
+
+	value := strings.TrimSpace(string(content))
+	v, err := parse(value)
+	if err != nil {
+		return zero, &ParseError{Path: p, Value: value, Err: err}
+	}
+
+	return v, nil
+}
+
+// ReadAttr reads the attribute of a block device relative to
+// /sys/block/<dev>
+func ReadAttr[T any](dev, name string, parse Parser[T]) (T, error) {
+	return Read(path.Join(sysfsBlockRoot, dev, name), parse)
+}
+
+// Write writes the value to the attribute at the path
+func Write[T any](ctx context.Context, p string, v T, format Formatter[T]) error {
+	value := format(v)
+	a := action.Action{Op: "sysfs.write", Target: p, Args: map[string]string{"value": value}}
+
+	return action.Run(ctx, a, func() error {
+		if err := ioutil.WriteFile(p, []byte(value), 0644); err != nil {
+			return errors.Wrapf(err, "failed to write %v", p)
+		}
+		return nil
+	})
+}
+
+// WriteAttr writes the attribute of a block device relative to
+// /sys/block/<dev>
+func WriteAttr[T any](ctx context.Context, dev, name string, v T, format Formatter[T]) error {
+	return Write(ctx, path.Join(sysfsBlockRoot, dev, name), v, format)
+}
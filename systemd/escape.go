@@ -0,0 +1,44 @@
+package systemd
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DeviceUnitName returns the name of the device unit systemd creates for
+// the device node, e.g. "dev-sda1.device" for /dev/sda1. udev links like
+// /dev/disk/by-uuid/... have device units as well.
+func DeviceUnitName(node string) string {
+	return escapePath(node) + ".device"
+}
+
+// MountUnitName returns the name of the mount unit of the mount point,
+// e.g. "var-lib-docker.mount" for /var/lib/docker
+func MountUnitName(mountPoint string) string {
+	return escapePath(mountPoint) + ".mount"
+}
+
+// escapePath escapes the path like systemd-escape --path
+func escapePath(p string) string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return "-"
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case c == '/':
+			b.WriteByte('-')
+		case c == '.' && i == 0:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == ':', c == '_', c == '.':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,115 @@
+package systemd
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/alexdzyoba/sys/internal/dbus"
+	"github.com/alexdzyoba/sys/poll"
+	"github.com/pkg/errors"
+)
+
+// pollInterval is how often unit states are checked while waiting for jobs
+const pollInterval = 50 * time.Millisecond
+
+// TransientMount is a mount unit created at runtime like systemd-mount
+// does. It lives until it's stopped or the system reboots, and systemd
+// orders it against the device unit of What.
+type TransientMount struct {
+	What  string
+	Where string
+	// Type is detected by mount if empty
+	Type        string
+	Options     []string
+	Description string
+}
+
+// StartTransientMount creates the mount unit and waits until it's mounted.
+// The unit is named after Where, see MountUnitName.
+func (c *Conn) StartTransientMount(ctx context.Context, m TransientMount) error {
+	name := MountUnitName(m.Where)
+	a := action.Action{
+		Op:     "systemd.start-transient-mount",
+		Target: m.Where,
+		Args:   map[string]string{"what": m.What, "type": m.Type, "options": strings.Join(m.Options, ",")},
+	}
+
+	description := m.Description
+	if description == "" {
+		description = "Mount of " + m.What
+	}
+	props := []interface{}{
+		[]interface{}{"Description", dbus.Variant{Sig: "s", Value: description}},
+		[]interface{}{"What", dbus.Variant{Sig: "s", Value: m.What}},
+	}
+	if m.Type != "" {
+		props = append(props, []interface{}{"Type", dbus.Variant{Sig: "s", Value: m.Type}})
+	}
+	if len(m.Options) > 0 {
+		props = append(props, []interface{}{"Options", dbus.Variant{Sig: "s", Value: strings.Join(m.Options, ",")}})
+	}
+
+	return action.Run(ctx, a, func() error {
+		_, err := c.bus.Call(ctx, service, managerPath, managerInterface, "StartTransientUnit", "ssa(sv)a(sa(sv))",
+			name, "fail", props, []interface{}{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to start %s", name)
+		}
+
+		u, err := c.wait(ctx, name, func(u *Unit) bool { return u.Active() || u.ActiveState == "failed" })
+		if err != nil {
+			return errors.Wrapf(err, "failed to start %s", name)
+		}
+		if !u.Active() {
+			return errors.Errorf("failed to mount %s on %s: %s is %s", m.What, m.Where, name, u.ActiveState)
+		}
+		return nil
+	})
+}
+
+// StopUnit stops the unit and waits until it's inactive, stopping a mount
+// unit unmounts it. Transient units are gone afterwards.
+func (c *Conn) StopUnit(ctx context.Context, name string) error {
+	a := action.Action{Op: "systemd.stop-unit", Target: name}
+
+	return action.Run(ctx, a, func() error {
+		_, err := c.bus.Call(ctx, service, managerPath, managerInterface, "StopUnit", "ss", name, "fail")
+		if err != nil {
+			return unitErr(name, err)
+		}
+
+		u, err := c.wait(ctx, name, func(u *Unit) bool { return u.ActiveState == "inactive" || u.ActiveState == "failed" })
+		if errors.Is(err, ErrNoUnit) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to stop %s", name)
+		}
+		if u.ActiveState == "failed" {
+			return errors.Errorf("failed to stop %s: unit failed", name)
+		}
+		return nil
+	})
+}
+
+// wait polls the unit until done returns true or its job is finished
+// without getting there
+func (c *Conn) wait(ctx context.Context, name string, done func(u *Unit) bool) (*Unit, error) {
+	var u *Unit
+	err := poll.Until(ctx, pollInterval, func() (bool, error) {
+		var err error
+		if u, err = c.Unit(ctx, name); err != nil {
+			return false, err
+		}
+		return done(u) || u.job == 0, nil
+	})
+	if err != nil && err == ctx.Err() && u != nil {
+		return nil, errors.Wrapf(ctx.Err(), "%s is %s", name, u.ActiveState)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
@@ -0,0 +1,260 @@
+// Package systemd queries device and mount units of the systemd service
+// manager over D-Bus and starts transient mount units, so mounts systemd
+// manages can be matched with devices and mounts of the library. It talks
+// to systemd directly through its private socket when running as root and
+// through the system bus otherwise.
+package systemd
+
+import (
+	"context"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/alexdzyoba/sys/block"
+	"github.com/alexdzyoba/sys/internal/dbus"
+	"github.com/pkg/errors"
+)
+
+const (
+	privateSocket   = "/run/systemd/private"
+	systemBusSocket = "/run/dbus/system_bus_socket"
+
+	service          = "org.freedesktop.systemd1"
+	managerPath      = dbus.ObjectPath("/org/freedesktop/systemd1")
+	managerInterface = "org.freedesktop.systemd1.Manager"
+	unitInterface    = "org.freedesktop.systemd1.Unit"
+	mountInterface   = "org.freedesktop.systemd1.Mount"
+	propsInterface   = "org.freedesktop.DBus.Properties"
+
+	errNoSuchUnit = "org.freedesktop.systemd1.NoSuchUnit"
+)
+
+// ErrNoUnit is returned for units systemd doesn't have loaded, e.g. for
+// devices udev didn't tag for systemd. Check for it with errors.Is.
+var ErrNoUnit = errors.New("unit is not loaded")
+
+// Conn is a connection to systemd, it's safe for concurrent use
+type Conn struct {
+	bus *dbus.Conn
+}
+
+// Dial connects to systemd
+func Dial() (*Conn, error) {
+	if os.Geteuid() == 0 {
+		if bus, err := dbus.Dial(privateSocket, false); err == nil {
+			return &Conn{bus: bus}, nil
+		}
+	}
+
+	bus, err := dbus.Dial(systemBusSocket, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to systemd")
+	}
+	return &Conn{bus: bus}, nil
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	return c.bus.Close()
+}
+
+// Unit is the state of a unit
+type Unit struct {
+	Name        string
+	Description string
+	// LoadState is "loaded", "not-found", "masked" and so on
+	LoadState string
+	// ActiveState is "active", "inactive", "activating", "deactivating"
+	// or "failed", SubState is the unit type specific state like "plugged"
+	// of devices and "mounted" of mounts
+	ActiveState string
+	SubState    string
+
+	// job is the id of the pending job, zero if there is none
+	job uint32
+}
+
+// Active returns whether the unit is active, i.e. the device is plugged or
+// the mount is mounted
+func (u Unit) Active() bool {
+	return u.ActiveState == "active"
+}
+
+// MountUnit is the state of a mount unit and what it mounts
+type MountUnit struct {
+	Unit
+	What  string
+	Where string
+	Type  string
+	// Options are the options systemd mounted with, they aren't the same
+	// as in mountinfo
+	Options []string
+	// Result is "success" or why the unit failed, e.g. "exit-code"
+	Result string
+}
+
+// Unit returns the state of the unit
+func (c *Conn) Unit(ctx context.Context, name string) (*Unit, error) {
+	p, err := c.unitPath(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return c.unit(ctx, name, p)
+}
+
+// DeviceUnit returns the state of the device unit of the device
+func (c *Conn) DeviceUnit(ctx context.Context, d block.Device) (*Unit, error) {
+	return c.Unit(ctx, DeviceUnitName(path.Join("/dev", d.Name)))
+}
+
+// MountUnit returns the mount unit of the mount point. Pass MountPoint of
+// mounts.Mount to find the unit of a mount.
+func (c *Conn) MountUnit(ctx context.Context, mountPoint string) (*MountUnit, error) {
+	name := MountUnitName(mountPoint)
+	p, err := c.unitPath(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return c.mountUnit(ctx, name, p)
+}
+
+// MountUnits returns mount units systemd has loaded ordered by mount point
+func (c *Conn) MountUnits(ctx context.Context) ([]MountUnit, error) {
+	reply, err := c.bus.Call(ctx, service, managerPath, managerInterface, "ListUnitsByPatterns", "asas", []string{}, []string{"*.mount"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list mount units")
+	}
+
+	units, err := first[[]interface{}](reply)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list mount units")
+	}
+
+	var ms []MountUnit
+	for _, u := range units {
+		fields, _ := u.([]interface{})
+		if len(fields) < 7 {
+			return nil, errors.Errorf("failed to list mount units: unit has %d fields", len(fields))
+		}
+		name, _ := fields[0].(string)
+		p, _ := fields[6].(dbus.ObjectPath)
+		m, err := c.mountUnit(ctx, name, p)
+		if errors.Is(err, ErrNoUnit) {
+			// Unloaded between the calls
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, *m)
+	}
+
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Where < ms[j].Where })
+	return ms, nil
+}
+
+// unitPath returns the object of a loaded unit
+func (c *Conn) unitPath(ctx context.Context, name string) (dbus.ObjectPath, error) {
+	reply, err := c.bus.Call(ctx, service, managerPath, managerInterface, "GetUnit", "s", name)
+	if err != nil {
+		return "", unitErr(name, err)
+	}
+	p, err := first[dbus.ObjectPath](reply)
+	return p, errors.Wrapf(err, "failed to get unit %s", name)
+}
+
+func (c *Conn) unit(ctx context.Context, name string, p dbus.ObjectPath) (*Unit, error) {
+	props, err := c.properties(ctx, name, p, unitInterface)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &Unit{
+		Name:        name,
+		Description: props.string("Description"),
+		LoadState:   props.string("LoadState"),
+		ActiveState: props.string("ActiveState"),
+		SubState:    props.string("SubState"),
+	}
+	if job, ok := props["Job"].([]interface{}); ok && len(job) == 2 {
+		u.job, _ = job[0].(uint32)
+	}
+	return u, nil
+}
+
+func (c *Conn) mountUnit(ctx context.Context, name string, p dbus.ObjectPath) (*MountUnit, error) {
+	u, err := c.unit(ctx, name, p)
+	if err != nil {
+		return nil, err
+	}
+	props, err := c.properties(ctx, name, p, mountInterface)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MountUnit{
+		Unit:   *u,
+		What:   props.string("What"),
+		Where:  props.string("Where"),
+		Type:   props.string("Type"),
+		Result: props.string("Result"),
+	}
+	if opts := props.string("Options"); opts != "" {
+		m.Options = strings.Split(opts, ",")
+	}
+	return m, nil
+}
+
+// properties are values of a GetAll reply
+type properties map[string]interface{}
+
+func (p properties) string(name string) string {
+	s, _ := p[name].(string)
+	return s
+}
+
+func (c *Conn) properties(ctx context.Context, name string, p dbus.ObjectPath, iface string) (properties, error) {
+	reply, err := c.bus.Call(ctx, service, p, propsInterface, "GetAll", "s", iface)
+	if err != nil {
+		return nil, unitErr(name, err)
+	}
+
+	entries, err := first[[]interface{}](reply)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get properties of %s", name)
+	}
+	props := properties{}
+	for _, entry := range entries {
+		if kv, ok := entry.([]interface{}); ok && len(kv) == 2 {
+			k, _ := kv[0].(string)
+			v, _ := kv[1].(dbus.Variant)
+			props[k] = v.Value
+		}
+	}
+	return props, nil
+}
+
+// first returns the first value of a reply checking its type
+func first[T any](reply []interface{}) (T, error) {
+	var zero T
+	if len(reply) == 0 {
+		return zero, errors.New("reply is empty")
+	}
+	v, ok := reply[0].(T)
+	if !ok {
+		return zero, errors.Errorf("reply has unexpected %T", reply[0])
+	}
+	return v, nil
+}
+
+// unitErr turns replies about missing units into ErrNoUnit. Objects of
+// units unloaded after GetUnit don't exist anymore.
+func unitErr(name string, err error) error {
+	var e *dbus.Error
+	if errors.As(err, &e) && (e.Name == errNoSuchUnit || e.Name == "org.freedesktop.DBus.Error.UnknownObject") {
+		return errors.Wrapf(ErrNoUnit, "%s", name)
+	}
+	return errors.Wrapf(err, "failed to get unit %s", name)
+}
@@ -0,0 +1,206 @@
+package tape
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/pkg/errors"
+)
+
+const sysfsScsiTapeRoot = "/sys/class/scsi_tape"
+
+// Drive represents a SCSI tape drive
+type Drive struct {
+	// Name is the kernel name like "st0"
+	Name string
+	// Node rewinds the tape on close, NonRewindNode doesn't
+	Node          string
+	NonRewindNode string
+	Vendor        string
+	Model         string
+}
+
+// ListDrives returns tape drives found in the system
+func ListDrives() ([]Drive, error) {
+	entries, err := ioutil.ReadDir(sysfsScsiTapeRoot)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", sysfsScsiTapeRoot)
+	}
+
+	var ds []Drive
+	for _, e := range entries {
+		name := e.Name()
+		// Every drive is registered as st<N> plus mode and no-rewind
+		// variants (st0l, st0m, st0a, nst0, ...), only the base one counts
+		if !strings.HasPrefix(name, "st") {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimPrefix(name, "st")); err != nil {
+			continue
+		}
+
+		devicePath := path.Join(sysfsScsiTapeRoot, name, "device")
+		ds = append(ds, Drive{
+			Name:          name,
+			Node:          path.Join("/dev", name),
+			NonRewindNode: path.Join("/dev", "n"+name),
+			Vendor:        readAttr(path.Join(devicePath, "vendor")),
+			Model:         readAttr(path.Join(devicePath, "model")),
+		})
+	}
+
+	return ds, nil
+}
+
+func readAttr(p string) string {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// Op is a magnetic tape operation from linux/mtio.h
+type Op int16
+
+const (
+	OpForwardFile  Op = 1
+	OpBackwardFile Op = 2
+	OpWriteEOF     Op = 5
+	OpRewind       Op = 6
+	OpOffline      Op = 7
+	OpEndOfMedia   Op = 12
+	OpErase        Op = 13
+)
+
+var opNames = map[Op]string{
+	OpForwardFile:  "fsf",
+	OpBackwardFile: "bsf",
+	OpWriteEOF:     "weof",
+	OpRewind:       "rewind",
+	OpOffline:      "offline",
+	OpEndOfMedia:   "eom",
+	OpErase:        "erase",
+}
+
+func (o Op) String() string {
+	if n, ok := opNames[o]; ok {
+		return n
+	}
+	return "op" + strconv.Itoa(int(o))
+}
+
+// mtop mirrors struct mtop
+type mtop struct {
+	op    int16
+	count int32
+}
+
+// mtget mirrors struct mtget, whose fields are C longs
+type mtget struct {
+	typ    int
+	resid  int
+	dsreg  int
+	gstat  int
+	erreg  int
+	fileno int32
+	blkno  int32
+}
+
+const (
+	mtiocTop = 0x40086d01
+
+	gmtEOF    = 0x80000000
+	gmtBOT    = 0x40000000
+	gmtEOT    = 0x20000000
+	gmtWrProt = 0x04000000
+	gmtOnline = 0x01000000
+	gmtDrOpen = 0x00040000
+)
+
+// mtiocGet is _IOR('m', 2, struct mtget) whose size depends on the word size
+var mtiocGet = uintptr(0x80000000 | unsafe.Sizeof(mtget{})<<16 | 'm'<<8 | 2)
+
+// Status is the position and state of a tape drive
+type Status struct {
+	Online          bool
+	DoorOpen        bool
+	WriteProtected  bool
+	BeginningOfTape bool
+	EndOfTape       bool
+	EndOfFile       bool
+	// FileNumber and BlockNumber are -1 when the position is unknown
+	FileNumber  int
+	BlockNumber int
+}
+
+// Status returns whether a medium is loaded and the tape position
+func (d Drive) Status() (*Status, error) {
+	f, err := d.open(os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var g mtget
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), mtiocGet, uintptr(unsafe.Pointer(&g)))
+	if errno != 0 {
+		return nil, errors.Wrapf(errno, "failed to get status of %s", d.Name)
+	}
+
+	gs := uint32(g.gstat)
+	return &Status{
+		Online:          gs&gmtOnline != 0,
+		DoorOpen:        gs&gmtDrOpen != 0,
+		WriteProtected:  gs&gmtWrProt != 0,
+		BeginningOfTape: gs&gmtBOT != 0,
+		EndOfTape:       gs&gmtEOT != 0,
+		EndOfFile:       gs&gmtEOF != 0,
+		FileNumber:      int(g.fileno),
+		BlockNumber:     int(g.blkno),
+	}, nil
+}
+
+// Do performs a tape operation count times, e.g. Do(ctx, OpForwardFile, 2)
+// skips two files
+func (d Drive) Do(ctx context.Context, op Op, count int) error {
+	a := action.Action{
+		Op:     "tape." + op.String(),
+		Target: d.NonRewindNode,
+		Args:   map[string]string{"count": strconv.Itoa(count)},
+	}
+
+	return action.Run(ctx, a, func() error {
+		f, err := d.open(os.O_RDWR)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		m := mtop{op: int16(op), count: int32(count)}
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), mtiocTop, uintptr(unsafe.Pointer(&m)))
+		if errno != 0 {
+			return errors.Wrapf(errno, "failed to %s %s", op, d.Name)
+		}
+		return nil
+	})
+}
+
+// open opens the non-rewinding node so operations don't lose the position
+func (d Drive) open(flag int) (*os.File, error) {
+	f, err := os.OpenFile(d.NonRewindNode, flag|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", d.NonRewindNode)
+	}
+	return f, nil
+}
@@ -0,0 +1,152 @@
+package topology
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Capacity summarizes the space of the host's disks after all layers
+type Capacity struct {
+	// Raw is the total size of physical disks
+	Raw uint64
+	// Usable is the space available to filesystems and other consumers
+	// on the top of the stack plus the free space of volume groups. Thin
+	// volumes count with the size of their pool.
+	Usable uint64
+	// RedundancyOverhead is the space consumed by RAID mirrors and parity
+	RedundancyOverhead uint64
+	// Unallocated is disk space not covered by partitions on partitioned
+	// disks
+	Unallocated uint64
+	// ThinPoolSize is the backing size of thin pools and ThinProvisioned
+	// the sum of virtual sizes of thin volumes carved out of them
+	ThinPoolSize    uint64
+	ThinProvisioned uint64
+}
+
+// OverProvisioning returns the ratio of provisioned thin volume size to
+// the backing pool size, zero without thin pools
+func (c Capacity) OverProvisioning() float64 {
+	if c.ThinPoolSize == 0 {
+		return 0
+	}
+	return float64(c.ThinProvisioned) / float64(c.ThinPoolSize)
+}
+
+// Capacity rolls up sizes from physical disks through partition, RAID and
+// device-mapper layers. Loop, zram and RAM disks are ignored along with
+// everything on them, also loop devices backed by files on the disks.
+func (g *Graph) Capacity() Capacity {
+	var c Capacity
+
+	onDisk := map[string]bool{}
+	for _, n := range g.Nodes() {
+		if n.Kind != KindDisk {
+			continue
+		}
+		c.Raw += n.Size
+		onDisk[n.ID] = true
+		for _, d := range g.Descendants(n.ID) {
+			if !g.isVirtual(d) {
+				onDisk[d.ID] = true
+			}
+		}
+
+		var partitioned uint64
+		var hasPartitions bool
+		for _, child := range g.Children(n.ID) {
+			if child.Kind == KindPartition {
+				partitioned += child.Size
+				hasPartitions = true
+			}
+		}
+		if hasPartitions && partitioned < n.Size {
+			c.Unallocated += n.Size - partitioned
+		}
+	}
+
+	for _, n := range g.Nodes() {
+		if !onDisk[n.ID] || !isBlock(n) {
+			continue
+		}
+
+		if n.Kind == KindRAID {
+			var members uint64
+			for _, p := range g.Parents(n.ID) {
+				members += p.Size
+			}
+			if members > n.Size {
+				c.RedundancyOverhead += members - n.Size
+			}
+		}
+
+		if isThinPool(n) {
+			c.ThinPoolSize += n.Size
+			c.Usable += n.Size
+			continue
+		}
+
+		if g.isThinVolume(n) {
+			// The user visible pool device is stacked on the pool as
+			// well, it's neither a volume nor extra space
+			if !strings.HasSuffix(n.Attrs["dm_uuid"], "-pool") {
+				c.ThinProvisioned += n.Size
+			}
+			continue
+		}
+
+		// The used part of a PV is counted with its LVs
+		if free, ok := n.Attrs["pv_free"]; ok {
+			v, _ := strconv.ParseUint(free, 10, 64)
+			c.Usable += v
+			continue
+		}
+
+		if g.isTop(n) {
+			c.Usable += n.Size
+		}
+	}
+
+	return c
+}
+
+func isBlock(n *Node) bool {
+	return n.Kind != KindFilesystem && n.Kind != KindMount
+}
+
+// isVirtual returns whether the node is or is built on a device not backed
+// by a disk
+func (g *Graph) isVirtual(n *Node) bool {
+	for _, a := range append(g.Ancestors(n.ID), n) {
+		switch a.Kind {
+		case KindLoop, KindZram, KindRAMDisk:
+			return true
+		}
+	}
+	return false
+}
+
+// isTop returns whether no other block device is built on top of the node
+func (g *Graph) isTop(n *Node) bool {
+	for _, c := range g.Children(n.ID) {
+		if isBlock(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// isThinPool recognizes LVM thin pools by the dm uuid suffix LVM gives to
+// the pool device
+func isThinPool(n *Node) bool {
+	return strings.HasSuffix(n.Attrs["dm_uuid"], "-tpool")
+}
+
+func (g *Graph) isThinVolume(n *Node) bool {
+	for _, p := range g.Parents(n.ID) {
+		if isThinPool(p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package topology
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/alexdzyoba/sys/units"
+)
+
+var dotShapes = map[Kind]string{
+	KindDisk:       "cylinder",
+	KindPartition:  "box",
+	KindFilesystem: "folder",
+	KindMount:      "note",
+}
+
+// WriteDOT renders the graph in Graphviz DOT format
+func WriteDOT(w io.Writer, g *Graph) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "digraph storage {")
+	fmt.Fprintln(bw, "\trankdir=BT;")
+	for _, n := range g.Nodes() {
+		shape := dotShapes[n.Kind]
+		if shape == "" {
+			shape = "box3d"
+		}
+		fmt.Fprintf(bw, "\t%s [label=%s, shape=%s];\n", quote(n.ID), quote(label(n, `\n`)), shape)
+	}
+	for _, n := range g.Nodes() {
+		for _, c := range g.Children(n.ID) {
+			fmt.Fprintf(bw, "\t%s -> %s;\n", quote(n.ID), quote(c.ID))
+		}
+	}
+	fmt.Fprintln(bw, "}")
+
+	return bw.Flush()
+}
+
+// WriteD2 renders the graph in the D2 diagram language
+func WriteD2(w io.Writer, g *Graph) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "direction: up")
+	for _, n := range g.Nodes() {
+		fmt.Fprintf(bw, "%s: %s\n", quote(n.ID), quote(label(n, `\n`)))
+		if n.Kind == KindDisk {
+			fmt.Fprintf(bw, "%s.shape: cylinder\n", quote(n.ID))
+		}
+	}
+	for _, n := range g.Nodes() {
+		for _, c := range g.Children(n.ID) {
+			fmt.Fprintf(bw, "%s -> %s\n", quote(n.ID), quote(c.ID))
+		}
+	}
+
+	return bw.Flush()
+}
+
+func label(n *Node, newline string) string {
+	parts := []string{n.Name, string(n.Kind)}
+	if t := n.Attrs["type"]; t != "" {
+		parts[1] += " " + t
+	}
+	if n.Size > 0 {
+		parts = append(parts, units.Size(n.Size))
+	}
+	return strings.Join(parts, newline)
+}
+
+// quote escapes identifiers and labels, both formats accept C-like quoted
+// strings
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`"`, `\"`).Replace(s) + `"`
+}
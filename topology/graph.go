@@ -0,0 +1,191 @@
+package topology
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Kind is the layer of the storage stack a node belongs to
+type Kind string
+
+const (
+	KindDisk         Kind = "disk"
+	KindPartition    Kind = "partition"
+	KindRAID         Kind = "raid"
+	KindLVM          Kind = "lvm"
+	KindCrypt        Kind = "crypt"
+	KindDeviceMapper Kind = "dm"
+	KindLoop         Kind = "loop"
+	KindZram         Kind = "zram"
+	KindRAMDisk      Kind = "ramdisk"
+	KindBlock        Kind = "block"
+	KindFilesystem   Kind = "filesystem"
+	KindMount        Kind = "mount"
+)
+
+// Node is a single element of the storage stack
+type Node struct {
+	// ID is unique within the graph, e.g. "block:sda", "fs:sda1" or
+	// "mount:/var/lib"
+	ID   string
+	Kind Kind
+	Name string
+	// Size is in bytes, zero for mounts
+	Size  uint64
+	Attrs map[string]string
+}
+
+// Graph is an immutable snapshot of the storage stack. Edges point from
+// lower layers to the ones built on top of them: disk → partition → md/dm
+// → filesystem → mount.
+type Graph struct {
+	nodes    map[string]*Node
+	children map[string][]string
+	parents  map[string][]string
+}
+
+func newGraph() *Graph {
+	return &Graph{
+		nodes:    map[string]*Node{},
+		children: map[string][]string{},
+		parents:  map[string][]string{},
+	}
+}
+
+func (g *Graph) add(n *Node) {
+	if _, ok := g.nodes[n.ID]; !ok {
+		g.nodes[n.ID] = n
+	}
+}
+
+func (g *Graph) link(parent, child string) {
+	if parent == child {
+		return
+	}
+	for _, c := range g.children[parent] {
+		if c == child {
+			return
+		}
+	}
+	g.children[parent] = append(g.children[parent], child)
+	g.parents[child] = append(g.parents[child], parent)
+}
+
+// Node returns the node by id
+func (g *Graph) Node(id string) (*Node, bool) {
+	n, ok := g.nodes[id]
+	return n, ok
+}
+
+// Nodes returns all nodes sorted by id
+func (g *Graph) Nodes() []*Node {
+	ns := make([]*Node, 0, len(g.nodes))
+	for _, n := range g.nodes {
+		ns = append(ns, n)
+	}
+	sortNodes(ns)
+	return ns
+}
+
+// Roots returns nodes without parents, normally whole disks
+func (g *Graph) Roots() []*Node {
+	var ns []*Node
+	for id, n := range g.nodes {
+		if len(g.parents[id]) == 0 {
+			ns = append(ns, n)
+		}
+	}
+	sortNodes(ns)
+	return ns
+}
+
+// Children returns nodes directly built on top of the node
+func (g *Graph) Children(id string) []*Node {
+	return g.lookup(g.children[id])
+}
+
+// Parents returns nodes the node is directly built on
+func (g *Graph) Parents(id string) []*Node {
+	return g.lookup(g.parents[id])
+}
+
+// Ancestors returns every node the node depends on, nearest first
+func (g *Graph) Ancestors(id string) []*Node {
+	return g.walk(id, g.parents)
+}
+
+// Descendants returns every node depending on the node, nearest first
+func (g *Graph) Descendants(id string) []*Node {
+	return g.walk(id, g.children)
+}
+
+// PathToMount returns the stacks of nodes from a root disk up to the mount
+// at mountpoint. Several paths are returned when the filesystem spans
+// multiple disks, e.g. on md RAID or LVM.
+func (g *Graph) PathToMount(mountpoint string) ([][]*Node, error) {
+	id := mountID(mountpoint)
+	if _, ok := g.nodes[id]; !ok {
+		return nil, errors.Errorf("no block device backed mount at %s", mountpoint)
+	}
+
+	// onPath guards against cycles, a stack ends where it would repeat
+	// a node
+	var paths [][]*Node
+	onPath := map[string]bool{}
+	var visit func(id string, tail []*Node)
+	visit = func(id string, tail []*Node) {
+		p := append([]*Node{g.nodes[id]}, tail...)
+		onPath[id] = true
+		defer delete(onPath, id)
+
+		var parents []string
+		for _, parent := range g.parents[id] {
+			if !onPath[parent] {
+				parents = append(parents, parent)
+			}
+		}
+		if len(parents) == 0 {
+			paths = append(paths, p)
+			return
+		}
+		for _, parent := range parents {
+			visit(parent, p)
+		}
+	}
+	visit(id, nil)
+
+	return paths, nil
+}
+
+func (g *Graph) walk(id string, edges map[string][]string) []*Node {
+	seen := map[string]bool{id: true}
+	queue := append([]string(nil), edges[id]...)
+
+	var ns []*Node
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if seen[cur] {
+			continue
+		}
+		seen[cur] = true
+		ns = append(ns, g.nodes[cur])
+		queue = append(queue, edges[cur]...)
+	}
+
+	return ns
+}
+
+func (g *Graph) lookup(ids []string) []*Node {
+	ns := make([]*Node, 0, len(ids))
+	for _, id := range ids {
+		ns = append(ns, g.nodes[id])
+	}
+	sortNodes(ns)
+	return ns
+}
+
+func sortNodes(ns []*Node) {
+	sort.Slice(ns, func(i, j int) bool { return ns[i].ID < ns[j].ID })
+}
@@ -0,0 +1,170 @@
+package topology
+
+import (
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/alexdzyoba/sys/mounts"
+	"github.com/pkg/errors"
+)
+
+// Effect is what happens to a node when a device it depends on fails
+type Effect string
+
+const (
+	// EffectLost means the node becomes unavailable
+	EffectLost Effect = "lost"
+	// EffectDegraded means the node keeps working without redundancy
+	EffectDegraded Effect = "degraded"
+)
+
+// Affected is a node hit by a device failure
+type Affected struct {
+	Node   *Node
+	Effect Effect
+}
+
+// VMDisk is a virtual machine disk image opened from an affected mount
+type VMDisk struct {
+	Path    string
+	Mount   string
+	PID     int
+	Command string
+}
+
+// Report is the outcome of an impact analysis
+type Report struct {
+	Device   string
+	Affected []Affected
+	VMDisks  []VMDisk
+}
+
+var vmDiskExtensions = []string{".qcow2", ".qcow", ".img", ".raw", ".vmdk", ".vdi", ".vhd", ".vhdx"}
+
+// Impact lists every volume, filesystem and mount that would be lost or
+// degraded if the device failed or was removed. Redundant md arrays
+// absorb failures up to their level's tolerance and are reported as
+// degraded. Running processes are scanned for VM disk images opened from
+// lost mounts.
+func (g *Graph) Impact(device string) (*Report, error) {
+	id := device
+	if _, ok := g.nodes[id]; !ok {
+		id = blockID(path.Base(device))
+	}
+	if _, ok := g.nodes[id]; !ok {
+		return nil, errors.Errorf("device %s not found", device)
+	}
+
+	failed := map[string]bool{id: true}
+	degraded := map[string]bool{}
+	var order []string
+
+	// Propagate until fixed point since an array is only lost once enough
+	// of its members are
+	for changed := true; changed; {
+		changed = false
+		for _, n := range g.Nodes() {
+			if failed[n.ID] {
+				continue
+			}
+
+			var lostParents int
+			for _, p := range g.parents[n.ID] {
+				if failed[p] {
+					lostParents++
+				}
+			}
+			if lostParents == 0 {
+				continue
+			}
+
+			if n.Kind == KindRAID && lostParents <= raidTolerance(n) {
+				if !degraded[n.ID] {
+					degraded[n.ID] = true
+					changed = true
+				}
+				continue
+			}
+
+			failed[n.ID] = true
+			delete(degraded, n.ID)
+			order = append(order, n.ID)
+			changed = true
+		}
+	}
+
+	r := &Report{Device: device}
+	for _, nid := range order {
+		r.Affected = append(r.Affected, Affected{Node: g.nodes[nid], Effect: EffectLost})
+	}
+	for _, n := range g.Nodes() {
+		if degraded[n.ID] {
+			r.Affected = append(r.Affected, Affected{Node: n, Effect: EffectDegraded})
+		}
+	}
+
+	for _, a := range r.Affected {
+		if a.Node.Kind != KindMount || a.Effect != EffectLost {
+			continue
+		}
+		r.VMDisks = append(r.VMDisks, vmDisks(a.Node.Name)...)
+	}
+
+	return r, nil
+}
+
+// raidTolerance returns how many members the array can lose
+func raidTolerance(n *Node) int {
+	disks, _ := strconv.Atoi(n.Attrs["raid_disks"])
+
+	switch n.Attrs["level"] {
+	case "raid1":
+		return disks - 1
+	case "raid4", "raid5", "raid10":
+		// raid10 survives more in lucky cases, one is guaranteed
+		return 1
+	case "raid6":
+		return 2
+	}
+	return 0
+}
+
+func vmDisks(mountpoint string) []VMDisk {
+	holders, err := mounts.Holders(mountpoint)
+	if err != nil {
+		return nil
+	}
+
+	var ds []VMDisk
+	for _, h := range holders {
+		for _, use := range h.Uses {
+			if !strings.HasPrefix(use, "fd ") {
+				continue
+			}
+			// Uses look like "fd 12 /var/lib/libvirt/images/vm.qcow2"
+			fields := strings.SplitN(use, " ", 3)
+			if len(fields) != 3 || !isVMDisk(fields[2]) {
+				continue
+			}
+			ds = append(ds, VMDisk{
+				Path:    fields[2],
+				Mount:   mountpoint,
+				PID:     h.PID,
+				Command: h.Command,
+			})
+		}
+	}
+
+	return ds
+}
+
+func isVMDisk(p string) bool {
+	ext := path.Ext(p)
+	for _, e := range vmDiskExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
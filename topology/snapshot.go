@@ -0,0 +1,286 @@
+package topology
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/alexdzyoba/sys/block"
+	"github.com/alexdzyoba/sys/lvm"
+	"github.com/alexdzyoba/sys/mounts"
+	"github.com/alexdzyoba/sys/udev"
+	"github.com/pkg/errors"
+)
+
+const (
+	sectorSizeBytes = 512
+	// ramDiskMajor is the major of brd devices
+	ramDiskMajor = "1"
+)
+
+func blockID(name string) string      { return "block:" + name }
+func filesystemID(name string) string { return "fs:" + name }
+func mountID(mountpoint string) string {
+	return "mount:" + path.Clean(mountpoint)
+}
+
+// Signatures reported by udev that mark members of other layers rather
+// than filesystems
+var memberFSTypes = map[string]bool{
+	"linux_raid_member": true,
+	"LVM2_member":       true,
+	"crypto_LUKS":       true,
+	"ddf_raid_member":   true,
+	"isw_raid_member":   true,
+}
+
+// Snapshot reads sysfs, the udev database and the mount table and returns
+// the storage stack of the host. Sysfs is read from the tree of
+// block.DefaultSysfs.
+func Snapshot() (*Graph, error) {
+	g := newGraph()
+
+	classRoot := block.DefaultSysfs.Path("class", "block")
+	entries, err := ioutil.ReadDir(classRoot)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", classRoot)
+	}
+
+	byDevNumber := map[string]string{}
+	for _, e := range entries {
+		name := e.Name()
+		sysfsPath := path.Join(classRoot, name)
+
+		dev := readAttr(sysfsPath, "dev")
+		if dev == "" {
+			// The device went away while scanning
+			continue
+		}
+		byDevNumber[dev] = name
+
+		n := &Node{
+			ID:    blockID(name),
+			Kind:  blockKind(sysfsPath),
+			Name:  name,
+			Size:  readSize(sysfsPath),
+			Attrs: map[string]string{"dev": dev},
+		}
+		if n.Kind == KindRAID {
+			n.Attrs["level"] = readAttr(sysfsPath, "md/level")
+			n.Attrs["raid_disks"] = readAttr(sysfsPath, "md/raid_disks")
+		}
+		if n.Kind == KindLoop {
+			if f := readAttr(sysfsPath, "loop/backing_file"); f != "" {
+				n.Attrs["backing_file"] = f
+			}
+		}
+		if uuid := readAttr(sysfsPath, "dm/uuid"); uuid != "" {
+			n.Attrs["dm_uuid"] = uuid
+			n.Attrs["dm_name"] = readAttr(sysfsPath, "dm/name")
+		}
+		g.add(n)
+
+		if n.Kind == KindPartition {
+			// Partitions are subdirectories of their disk in
+			// /sys/devices, the class link resolves to it
+			parent := path.Base(path.Dir(resolve(sysfsPath)))
+			g.link(blockID(parent), n.ID)
+		}
+
+		slaves, _ := ioutil.ReadDir(path.Join(sysfsPath, "slaves"))
+		for _, s := range slaves {
+			g.link(blockID(s.Name()), n.ID)
+		}
+
+		if fs := filesystemNode(name, dev); fs != nil {
+			g.add(fs)
+			g.link(n.ID, fs.ID)
+		}
+	}
+
+	ms, err := mounts.ListMounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range ms {
+		name, ok := byDevNumber[fmt.Sprintf("%d:%d", m.Major, m.Minor)]
+		if !ok {
+			continue
+		}
+
+		fsID := filesystemID(name)
+		if _, ok := g.nodes[fsID]; !ok {
+			g.add(&Node{
+				ID:    fsID,
+				Kind:  KindFilesystem,
+				Name:  name,
+				Attrs: map[string]string{"type": m.FSType},
+			})
+			g.link(blockID(name), fsID)
+		}
+
+		mn := &Node{
+			ID:   mountID(m.MountPoint),
+			Kind: KindMount,
+			Name: m.MountPoint,
+			Attrs: map[string]string{
+				"fstype":  m.FSType,
+				"root":    m.Root,
+				"options": strings.Join(m.Options, ","),
+			},
+		}
+		g.add(mn)
+		g.link(fsID, mn.ID)
+	}
+
+	// Free extents of PVs are space for new LVs. Reading labels needs root,
+	// without it the PVs have no free space attribute.
+	if t, err := lvm.Scan(); err == nil {
+		for _, vg := range t.VGs {
+			for _, pv := range vg.PVs {
+				if n, ok := g.nodes[blockID(pv.Device)]; ok && pv.Device != "" {
+					n.Attrs["pv_free"] = strconv.FormatUint(pv.Free(), 10)
+				}
+			}
+		}
+	}
+
+	// Loop devices depend on the mount their backing file lives on. The
+	// path is as seen by whoever set the device up, from another mount
+	// namespace it may resolve to a mount on the loop device itself, such
+	// a link would make a cycle.
+	for _, n := range g.nodes {
+		if f := n.Attrs["backing_file"]; f != "" && n.Kind == KindLoop {
+			if m := g.mountFor(f); m != nil && !g.dependsOn(m.ID, n.ID) {
+				g.link(m.ID, n.ID)
+			}
+		}
+	}
+
+	return g, nil
+}
+
+// dependsOn returns whether the node is built on the other one
+func (g *Graph) dependsOn(id, other string) bool {
+	for _, a := range g.Ancestors(id) {
+		if a.ID == other {
+			return true
+		}
+	}
+	return false
+}
+
+// mountFor returns the deepest mount node containing the path
+func (g *Graph) mountFor(p string) *Node {
+	var found *Node
+	for _, n := range g.nodes {
+		if n.Kind != KindMount {
+			continue
+		}
+		mp := n.Name
+		if mp != "/" && mp != p && !strings.HasPrefix(p, mp+"/") {
+			continue
+		}
+		if found == nil || len(mp) > len(found.Name) {
+			found = n
+		}
+	}
+	return found
+}
+
+func blockKind(sysfsPath string) Kind {
+	switch {
+	case exists(sysfsPath, "partition"):
+		return KindPartition
+	case exists(sysfsPath, "md"):
+		return KindRAID
+	case exists(sysfsPath, "dm"):
+		uuid := readAttr(sysfsPath, "dm/uuid")
+		switch {
+		case strings.HasPrefix(uuid, "LVM-"):
+			return KindLVM
+		case strings.HasPrefix(uuid, "CRYPT-"):
+			return KindCrypt
+		}
+		return KindDeviceMapper
+	case exists(sysfsPath, "loop") || strings.HasPrefix(path.Base(sysfsPath), "loop"):
+		// The loop directory only exists for attached devices
+		return KindLoop
+	case exists(sysfsPath, "mm_stat"):
+		return KindZram
+	case strings.HasPrefix(readAttr(sysfsPath, "dev"), ramDiskMajor+":"):
+		return KindRAMDisk
+	case exists(sysfsPath, "device"):
+		return KindDisk
+	}
+	return KindBlock
+}
+
+// filesystemNode returns the filesystem udev found on the device, if any
+func filesystemNode(name, dev string) *Node {
+	parts := strings.SplitN(dev, ":", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	major, err1 := strconv.ParseUint(parts[0], 10, 32)
+	minor, err2 := strconv.ParseUint(parts[1], 10, 32)
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+
+	rec, err := udev.BlockDevice(uint32(major), uint32(minor))
+	if err != nil {
+		return nil
+	}
+
+	typ := rec.Properties["ID_FS_TYPE"]
+	if typ == "" || memberFSTypes[typ] {
+		return nil
+	}
+
+	return &Node{
+		ID:   filesystemID(name),
+		Kind: KindFilesystem,
+		Name: name,
+		Attrs: map[string]string{
+			"type":  typ,
+			"uuid":  rec.Properties["ID_FS_UUID"],
+			"label": rec.Properties["ID_FS_LABEL"],
+		},
+	}
+}
+
+func readAttr(sysfsPath, attr string) string {
+	content, err := ioutil.ReadFile(path.Join(sysfsPath, attr))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+func readSize(sysfsPath string) uint64 {
+	size, err := strconv.ParseUint(readAttr(sysfsPath, "size"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return size * sectorSizeBytes
+}
+
+func exists(sysfsPath, name string) bool {
+	_, err := os.Stat(path.Join(sysfsPath, name))
+	return err == nil
+}
+
+func resolve(p string) string {
+	real, err := os.Readlink(p)
+	if err != nil {
+		return p
+	}
+	if !path.IsAbs(real) {
+		real = path.Join(path.Dir(p), real)
+	}
+	return real
+}
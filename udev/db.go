@@ -0,0 +1,64 @@
+package udev
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const dataRoot = "/run/udev/data"
+
+// Record is the udev database entry of a device
+type Record struct {
+	// Links are device node symlinks relative to /dev, e.g.
+	// "disk/by-id/wwn-0x5000c500a1b2c3d4"
+	Links      []string
+	Properties map[string]string
+	Tags       []string
+}
+
+// BlockDevice returns the udev database record of the block device with
+// the given device number
+func BlockDevice(major, minor uint32) (*Record, error) {
+	return read(path.Join(dataRoot, fmt.Sprintf("b%d:%d", major, minor)))
+}
+
+func read(p string) (*Record, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", p)
+	}
+	defer f.Close()
+
+	r := &Record{Properties: map[string]string{}}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < 2 || line[1] != ':' {
+			continue
+		}
+
+		value := line[2:]
+		switch line[0] {
+		case 'S':
+			r.Links = append(r.Links, value)
+		case 'E':
+			kv := strings.SplitN(value, "=", 2)
+			if len(kv) == 2 {
+				r.Properties[kv[0]] = kv[1]
+			}
+		case 'G':
+			r.Tags = append(r.Tags, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", p)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,61 @@
+package udev
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alexdzyoba/sys/poll"
+	"github.com/pkg/errors"
+)
+
+const (
+	// queueFlag exists while systemd-udevd has events queued or running
+	queueFlag = "/run/udev/queue"
+	// controlSocket exists while systemd-udevd runs
+	controlSocket = "/run/udev/control"
+	seqnumPath    = "/sys/kernel/uevent_seqnum"
+
+	settleInterval = 50 * time.Millisecond
+)
+
+// ErrNotRunning is returned by Settle when there is no udev daemon to wait
+// for, so links in /dev/disk and database records will never appear
+var ErrNotRunning = errors.New("systemd-udevd is not running")
+
+// Settle waits until udev has processed all queued events like udevadm
+// settle, so /dev/disk links and database records of devices added so far
+// exist. The queue has to be empty and the kernel event sequence number
+// unchanged for one check interval, which covers events the kernel sent
+// but udevd didn't pick up yet. Bound the wait with the context.
+func Settle(ctx context.Context) error {
+	if _, err := os.Stat(controlSocket); os.IsNotExist(err) {
+		return ErrNotRunning
+	}
+
+	last, first := seqnum(), true
+	err := poll.Until(ctx, settleInterval, func() (bool, error) {
+		if first {
+			first = false
+			return false, nil
+		}
+		current := seqnum()
+		_, err := os.Stat(queueFlag)
+		settled := os.IsNotExist(err) && current == last
+		last = current
+		return settled, nil
+	})
+	return errors.Wrap(err, "udev queue didn't settle")
+}
+
+// seqnum returns the number of the last uevent the kernel sent, empty if
+// it can't be read
+func seqnum() string {
+	content, err := ioutil.ReadFile(seqnumPath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
@@ -0,0 +1,167 @@
+package uevent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// Source is the netlink multicast group to listen on
+type Source int
+
+const (
+	// SourceKernel delivers events as soon as the kernel emits them
+	SourceKernel Source = 1
+	// SourceUdev delivers events after udev processed them, so device
+	// nodes, symlinks and the udev database are already in place
+	SourceUdev Source = 2
+)
+
+const (
+	udevPrefix = "libudev\x00"
+	udevMagic  = 0xfeedcafe
+	// udevHeaderSize is the size of struct udev_monitor_netlink_header
+	udevHeaderSize = 40
+
+	recvBufferSize = 1 << 16
+	socketBuffer   = 1 << 20
+)
+
+// Event represents a kernel or udev uevent
+type Event struct {
+	Action     string
+	DevPath    string
+	Subsystem  string
+	DevType    string
+	DevName    string
+	Seqnum     uint64
+	Properties map[string]string
+	// Synthetic marks events generated by Replay rather than the kernel
+	Synthetic bool
+}
+
+// Conn is a netlink connection receiving uevents
+type Conn struct {
+	f   *os.File
+	buf []byte
+}
+
+// Dial opens a uevent netlink socket subscribed to the source
+func Dial(src Source) (*Conn, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open uevent socket")
+	}
+
+	// Bursts of events on boot or coldplug easily overflow the default
+	// buffer
+	_ = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, socketBuffer)
+
+	sa := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: uint32(src)}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, errors.Wrap(err, "failed to bind uevent socket")
+	}
+
+	// A non-blocking descriptor is managed by the runtime poller, which
+	// makes deadlines and Close work
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, errors.Wrap(err, "failed to set uevent socket non-blocking")
+	}
+
+	return &Conn{
+		f:   os.NewFile(uintptr(fd), "uevent"),
+		buf: make([]byte, recvBufferSize),
+	}, nil
+}
+
+// Read blocks until the next event is received
+func (c *Conn) Read() (Event, error) {
+	for {
+		n, err := c.f.Read(c.buf)
+		if err != nil {
+			return Event{}, errors.Wrap(err, "failed to receive uevent")
+		}
+
+		ev, ok := Parse(c.buf[:n])
+		if ok {
+			return ev, nil
+		}
+	}
+}
+
+// SetReadDeadline sets the deadline for pending and future Read calls
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.f.SetReadDeadline(t)
+}
+
+// Close closes the connection, unblocking pending reads
+func (c *Conn) Close() error {
+	return c.f.Close()
+}
+
+// hostOrder is the byte order of the machine
+var hostOrder binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// Parse decodes a uevent message in either the kernel format
+// ("action@devpath\0KEY=VALUE\0...") or the libudev monitor format. It
+// returns false for messages that are neither.
+func Parse(msg []byte) (Event, bool) {
+	var props []byte
+
+	if bytes.HasPrefix(msg, []byte(udevPrefix)) {
+		// struct udev_monitor_netlink_header has the magic in network
+		// order and the other fields in host order
+		if len(msg) < udevHeaderSize || binary.BigEndian.Uint32(msg[8:]) != udevMagic {
+			return Event{}, false
+		}
+		off := uint64(hostOrder.Uint32(msg[16:]))
+		length := uint64(hostOrder.Uint32(msg[20:]))
+		if off < udevHeaderSize || off+length > uint64(len(msg)) {
+			return Event{}, false
+		}
+		props = msg[off : off+length]
+	} else {
+		i := bytes.IndexByte(msg, 0)
+		if i < 0 || !bytes.Contains(msg[:i], []byte("@")) {
+			return Event{}, false
+		}
+		props = msg[i+1:]
+	}
+
+	ev := Event{Properties: map[string]string{}}
+	for _, kv := range bytes.Split(props, []byte{0}) {
+		parts := strings.SplitN(string(kv), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		ev.Properties[parts[0]] = parts[1]
+	}
+
+	ev.Action = ev.Properties["ACTION"]
+	ev.DevPath = ev.Properties["DEVPATH"]
+	ev.Subsystem = ev.Properties["SUBSYSTEM"]
+	ev.DevType = ev.Properties["DEVTYPE"]
+	ev.DevName = ev.Properties["DEVNAME"]
+	ev.Seqnum, _ = strconv.ParseUint(ev.Properties["SEQNUM"], 10, 64)
+
+	if ev.Action == "" || ev.DevPath == "" {
+		return Event{}, false
+	}
+
+	return ev, true
+}
@@ -0,0 +1,130 @@
+package uevent
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+const addProps = "ACTION=add\x00DEVPATH=/devices/virtual/block/loop0\x00SUBSYSTEM=block\x00" +
+	"DEVTYPE=disk\x00DEVNAME=loop0\x00SEQNUM=1234\x00"
+
+// udevMessage builds a libudev monitor message with the properties at off
+func udevMessage(off, length uint32, props string) []byte {
+	h := make([]byte, udevHeaderSize)
+	copy(h, udevPrefix)
+	binary.BigEndian.PutUint32(h[8:], udevMagic)
+	hostOrder.PutUint32(h[12:], udevHeaderSize)
+	hostOrder.PutUint32(h[16:], off)
+	hostOrder.PutUint32(h[20:], length)
+	return append(h, props...)
+}
+
+func TestParse(t *testing.T) {
+	loop0 := Event{
+		Action:    "add",
+		DevPath:   "/devices/virtual/block/loop0",
+		Subsystem: "block",
+		DevType:   "disk",
+		DevName:   "loop0",
+		Seqnum:    1234,
+		Properties: map[string]string{
+			"ACTION":    "add",
+			"DEVPATH":   "/devices/virtual/block/loop0",
+			"SUBSYSTEM": "block",
+			"DEVTYPE":   "disk",
+			"DEVNAME":   "loop0",
+			"SEQNUM":    "1234",
+		},
+	}
+
+	badMagic := udevMessage(udevHeaderSize, uint32(len(addProps)), addProps)
+	binary.BigEndian.PutUint32(badMagic[8:], 0xdeadbeef)
+
+	tests := []struct {
+		name string
+		msg  []byte
+		want Event
+		ok   bool
+	}{
+		{
+			name: "kernel",
+			msg:  []byte("add@/devices/virtual/block/loop0\x00" + addProps),
+			want: loop0,
+			ok:   true,
+		},
+		{
+			name: "kernel without separator",
+			msg:  []byte("add@/devices/virtual/block/loop0"),
+		},
+		{
+			name: "kernel without action",
+			msg:  []byte("/devices/virtual/block/loop0\x00" + addProps),
+		},
+		{
+			name: "kernel with missing properties",
+			msg:  []byte("add@/devices/virtual/block/loop0\x00SUBSYSTEM=block\x00"),
+		},
+		{
+			name: "udev",
+			msg:  udevMessage(udevHeaderSize, uint32(len(addProps)), addProps),
+			want: loop0,
+			ok:   true,
+		},
+		{
+			name: "udev with padding before properties",
+			msg:  udevMessage(udevHeaderSize+8, uint32(len(addProps)), "\x00\x00\x00\x00\x00\x00\x00\x00"+addProps),
+			want: loop0,
+			ok:   true,
+		},
+		{
+			name: "udev with truncated header",
+			msg:  udevMessage(udevHeaderSize, uint32(len(addProps)), addProps)[:udevHeaderSize-1],
+		},
+		{
+			name: "udev with bad magic",
+			msg:  badMagic,
+		},
+		{
+			name: "udev with properties inside header",
+			msg:  udevMessage(8, uint32(len(addProps)), addProps),
+		},
+		{
+			name: "udev with properties past the end",
+			msg:  udevMessage(udevHeaderSize, uint32(len(addProps))+1, addProps),
+		},
+		{
+			name: "udev with overflowing offset",
+			msg:  udevMessage(0xffffffff, 0xffffffff, addProps),
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Parse(tt.msg)
+			if ok != tt.ok {
+				t.Fatalf("Parse() ok = %v, want %v", ok, tt.ok)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIgnoresMalformedProperties(t *testing.T) {
+	msg := "change@/block/sda\x00ACTION=change\x00DEVPATH=/block/sda\x00garbage\x00ID_FS_LABEL=a=b\x00"
+	ev, ok := Parse([]byte(msg))
+	if !ok {
+		t.Fatal("Parse() failed")
+	}
+	if _, ok := ev.Properties["garbage"]; ok {
+		t.Error("property without value was kept")
+	}
+	if got := ev.Properties["ID_FS_LABEL"]; got != "a=b" {
+		t.Errorf("ID_FS_LABEL = %q, want %q", got, "a=b")
+	}
+}
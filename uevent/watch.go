@@ -0,0 +1,239 @@
+package uevent
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	sysfsRoot      = "/sys"
+	sysfsClassRoot = "/sys/class"
+
+	defaultBuffer = 256
+)
+
+// ActionResync is delivered in-band after events were lost when no Resync
+// callback is configured. Consumers should rescan the current state.
+const ActionResync = "resync"
+
+// WatchOptions configures Watch
+type WatchOptions struct {
+	// Buffer is the number of events queued for a slow consumer before
+	// new events are dropped. Defaults to 256.
+	Buffer int
+	// Subsystems limits events to the given subsystems, e.g. "block"
+	Subsystems []string
+	// Replay emits synthetic "add" events for devices that already exist
+	// before any live event, so consumers see the full state
+	Replay bool
+	// Resync is called when events were lost, either dropped because the
+	// buffer was full or because the kernel overflowed the socket. lost is
+	// the number of dropped events or -1 if unknown. The callback runs on
+	// the watcher goroutine and should return quickly.
+	Resync func(lost int)
+}
+
+// Watch subscribes to uevents and delivers them on the returned channel
+// until the context is done. The channel is closed when watching stops.
+func Watch(ctx context.Context, src Source, opts WatchOptions) (<-chan Event, error) {
+	if opts.Buffer <= 0 {
+		opts.Buffer = defaultBuffer
+	}
+
+	conn, err := Dial(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var replay []Event
+	if opts.Replay {
+		replay, err = Replay(opts.Subsystems)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	w := &watcher{
+		opts:   opts,
+		conn:   conn,
+		out:    make(chan Event, opts.Buffer),
+		filter: map[string]bool{},
+	}
+	for _, s := range opts.Subsystems {
+		w.filter[s] = true
+	}
+
+	// The socket is closed when the context is done, which unblocks the
+	// reader, or when the reader stopped on its own
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+	go func() {
+		defer close(done)
+		w.run(ctx, replay)
+	}()
+
+	return w.out, nil
+}
+
+type watcher struct {
+	opts   WatchOptions
+	conn   *Conn
+	out    chan Event
+	filter map[string]bool
+
+	lost    int
+	pending bool
+}
+
+func (w *watcher) run(ctx context.Context, replay []Event) {
+	defer close(w.out)
+
+	// Replayed state is delivered in full, blocking if needed, since
+	// dropping it would defeat its purpose
+	for _, ev := range replay {
+		select {
+		case w.out <- ev:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	for {
+		ev, err := w.conn.Read()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			var errno syscall.Errno
+			if errors.As(err, &errno) && errno == syscall.ENOBUFS {
+				w.overflow(-1)
+				continue
+			}
+			return
+		}
+
+		if len(w.filter) > 0 && !w.filter[ev.Subsystem] {
+			continue
+		}
+
+		w.send(ev)
+	}
+}
+
+// send queues the event without blocking the socket reader
+func (w *watcher) send(ev Event) {
+	if w.pending && w.opts.Resync == nil {
+		select {
+		case w.out <- Event{Action: ActionResync, Properties: map[string]string{}}:
+			w.pending = false
+		default:
+			w.lost++
+			return
+		}
+	}
+
+	select {
+	case w.out <- ev:
+		if w.pending {
+			w.pending = false
+			w.opts.Resync(w.lost)
+		}
+		w.lost = 0
+	default:
+		w.overflow(1)
+	}
+}
+
+func (w *watcher) overflow(n int) {
+	w.pending = true
+	if n < 0 || w.lost < 0 {
+		w.lost = -1
+		return
+	}
+	w.lost += n
+}
+
+// Replay returns synthetic "add" events for every device currently
+// registered in the given subsystems, or in all classes if none are given.
+// Events are built from the uevent attribute of each device in sysfs.
+func Replay(subsystems []string) ([]Event, error) {
+	if len(subsystems) == 0 {
+		entries, err := ioutil.ReadDir(sysfsClassRoot)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %v", sysfsClassRoot)
+		}
+		for _, e := range entries {
+			subsystems = append(subsystems, e.Name())
+		}
+	}
+
+	var evs []Event
+	for _, s := range subsystems {
+		dir := path.Join(sysfsClassRoot, s)
+		entries, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %v", dir)
+		}
+
+		for _, e := range entries {
+			ev, err := syntheticAdd(path.Join(dir, e.Name()), s)
+			if err != nil {
+				// The device went away while replaying
+				continue
+			}
+			evs = append(evs, ev)
+		}
+	}
+
+	return evs, nil
+}
+
+func syntheticAdd(classPath, subsystem string) (Event, error) {
+	real, err := filepath.EvalSymlinks(classPath)
+	if err != nil {
+		return Event{}, err
+	}
+
+	content, err := ioutil.ReadFile(path.Join(real, "uevent"))
+	if err != nil {
+		return Event{}, err
+	}
+
+	ev := Event{
+		Action:     "add",
+		DevPath:    strings.TrimPrefix(real, sysfsRoot),
+		Subsystem:  subsystem,
+		Properties: map[string]string{},
+		Synthetic:  true,
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) == 2 {
+			ev.Properties[kv[0]] = kv[1]
+		}
+	}
+	ev.DevType = ev.Properties["DEVTYPE"]
+	ev.DevName = ev.Properties["DEVNAME"]
+	ev.Properties["ACTION"] = ev.Action
+	ev.Properties["DEVPATH"] = ev.DevPath
+	ev.Properties["SUBSYSTEM"] = subsystem
+
+	return ev, nil
+}
@@ -0,0 +1,141 @@
+// Package units renders sizes, rates and temperatures for humans. All
+// human-readable output of the module goes through it so a UI can switch
+// units and number formatting in one place with SetDefault.
+package units
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// System of size prefixes
+type System int
+
+const (
+	// IEC uses powers of 1024 like "931.5 GiB"
+	IEC System = iota
+	// SI uses powers of 1000 like "1.0 TB"
+	SI
+)
+
+// Temperature scale
+type Scale int
+
+const (
+	Celsius Scale = iota
+	Fahrenheit
+)
+
+// Format describes how values are rendered. The zero value renders IEC
+// sizes, Celsius and a dot as the decimal separator with one decimal.
+type Format struct {
+	System System
+	Scale  Scale
+	// DecimalSeparator is "." if empty, e.g. "," for many European locales
+	DecimalSeparator string
+	// Precision is the number of decimals, zero means one. Use a negative
+	// value for none.
+	Precision int
+}
+
+var (
+	mu     sync.RWMutex
+	format Format
+)
+
+// SetDefault installs the format used by the package level functions
+func SetDefault(f Format) {
+	mu.Lock()
+	defer mu.Unlock()
+	format = f
+}
+
+// Default returns the format used by the package level functions
+func Default() Format {
+	mu.RLock()
+	defer mu.RUnlock()
+	return format
+}
+
+// Size renders bytes with the default format
+func Size(b uint64) string {
+	return Default().Size(b)
+}
+
+// Rate renders bytes per second with the default format
+func Rate(bytesPerSecond float64) string {
+	return Default().Rate(bytesPerSecond)
+}
+
+// Temperature renders degrees Celsius with the default format
+func Temperature(celsius float64) string {
+	return Default().Temperature(celsius)
+}
+
+// Size renders bytes like "931.5 GiB" or "1.0 TB"
+func (f Format) Size(b uint64) string {
+	return f.scaled(float64(b), "B")
+}
+
+// Rate renders bytes per second like "120.0 MiB/s"
+func (f Format) Rate(bytesPerSecond float64) string {
+	return f.scaled(bytesPerSecond, "B/s")
+}
+
+// Temperature converts degrees Celsius to the scale of the format and
+// renders them like "41.0 °C"
+func (f Format) Temperature(celsius float64) string {
+	if f.Scale == Fahrenheit {
+		return f.number(celsius*9/5+32) + " °F"
+	}
+	return f.number(celsius) + " °C"
+}
+
+func (f Format) scaled(v float64, suffix string) string {
+	unit, infix := 1024.0, "i"
+	if f.System == SI {
+		unit, infix = 1000.0, ""
+	}
+
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+
+	if v < unit {
+		return fmt.Sprintf("%s%.0f %s", sign, v, suffix)
+	}
+
+	exp := 0
+	for v >= unit && exp < len(prefixes) {
+		v /= unit
+		exp++
+	}
+
+	prefix := string(prefixes[exp-1])
+	if f.System == SI && prefix == "K" {
+		// SI kilo is lowercase
+		prefix = "k"
+	}
+
+	return sign + f.number(v) + " " + prefix + infix + suffix
+}
+
+const prefixes = "KMGTPE"
+
+func (f Format) number(v float64) string {
+	precision := f.Precision
+	switch {
+	case precision == 0:
+		precision = 1
+	case precision < 0:
+		precision = 0
+	}
+
+	s := fmt.Sprintf("%.*f", precision, v)
+	if f.DecimalSeparator != "" && f.DecimalSeparator != "." {
+		s = strings.Replace(s, ".", f.DecimalSeparator, 1)
+	}
+	return s
+}
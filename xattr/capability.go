@@ -0,0 +1,185 @@
+package xattr
+
+import (
+	"context"
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CapabilityName is the extended attribute holding file capabilities
+const CapabilityName = "security.capability"
+
+const (
+	vfsCapRevisionMask   = 0xff000000
+	vfsCapRevision1      = 0x01000000
+	vfsCapRevision2      = 0x02000000
+	vfsCapRevision3      = 0x03000000
+	vfsCapFlagsEffective = 0x000001
+
+	vfsCapSize1 = 12
+	vfsCapSize2 = 20
+	vfsCapSize3 = 24
+)
+
+// Cap is a Linux capability number as defined in linux/capability.h
+type Cap uint
+
+var capNames = []string{
+	"chown", "dac_override", "dac_read_search", "fowner", "fsetid",
+	"kill", "setgid", "setuid", "setpcap", "linux_immutable",
+	"net_bind_service", "net_broadcast", "net_admin", "net_raw", "ipc_lock",
+	"ipc_owner", "sys_module", "sys_rawio", "sys_chroot", "sys_ptrace",
+	"sys_pacct", "sys_admin", "sys_boot", "sys_nice", "sys_resource",
+	"sys_time", "sys_tty_config", "mknod", "lease", "audit_write",
+	"audit_control", "setfcap", "mac_override", "mac_admin", "syslog",
+	"wake_alarm", "block_suspend", "audit_read", "perfmon", "bpf",
+	"checkpoint_restore",
+}
+
+func (c Cap) String() string {
+	if int(c) < len(capNames) {
+		return "cap_" + capNames[c]
+	}
+	return "cap_" + strconv.Itoa(int(c))
+}
+
+// ParseCap returns the capability by its name like "cap_net_admin"
+func ParseCap(name string) (Cap, error) {
+	n := strings.TrimPrefix(strings.ToLower(name), "cap_")
+	for i, cn := range capNames {
+		if cn == n {
+			return Cap(i), nil
+		}
+	}
+
+	return 0, errors.Errorf("unknown capability %q", name)
+}
+
+// CapSet is a bit set of capabilities
+type CapSet uint64
+
+// Has returns whether the capability is in the set
+func (s CapSet) Has(c Cap) bool {
+	return s&(1<<c) != 0
+}
+
+// Add returns the set with the capability added
+func (s CapSet) Add(c Cap) CapSet {
+	return s | 1<<c
+}
+
+// Caps returns capabilities in the set in ascending order
+func (s CapSet) Caps() []Cap {
+	var cs []Cap
+	for c := Cap(0); c < 64; c++ {
+		if s.Has(c) {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+func (s CapSet) String() string {
+	var names []string
+	for _, c := range s.Caps() {
+		names = append(names, c.String())
+	}
+	return strings.Join(names, ",")
+}
+
+// Capabilities represents decoded file capabilities
+type Capabilities struct {
+	Permitted   CapSet
+	Inheritable CapSet
+	Effective   bool
+	// RootID is the owner uid of the user namespace the capabilities are
+	// valid in. It's only set for revision 3 (namespaced) capabilities.
+	RootID    uint32
+	HasRootID bool
+}
+
+// ParseCapabilities decodes a security.capability value (struct
+// vfs_ns_cap_data)
+func ParseCapabilities(b []byte) (*Capabilities, error) {
+	if len(b) < 4 {
+		return nil, errors.New("capability data is too short")
+	}
+
+	magic := binary.LittleEndian.Uint32(b)
+	c := &Capabilities{Effective: magic&vfsCapFlagsEffective != 0}
+
+	switch magic & vfsCapRevisionMask {
+	case vfsCapRevision1:
+		if len(b) < vfsCapSize1 {
+			return nil, errors.New("capability data is too short for revision 1")
+		}
+		c.Permitted = CapSet(binary.LittleEndian.Uint32(b[4:]))
+		c.Inheritable = CapSet(binary.LittleEndian.Uint32(b[8:]))
+	case vfsCapRevision2, vfsCapRevision3:
+		if len(b) < vfsCapSize2 {
+			return nil, errors.New("capability data is too short for revision 2")
+		}
+		c.Permitted = CapSet(binary.LittleEndian.Uint32(b[4:])) |
+			CapSet(binary.LittleEndian.Uint32(b[12:]))<<32
+		c.Inheritable = CapSet(binary.LittleEndian.Uint32(b[8:])) |
+			CapSet(binary.LittleEndian.Uint32(b[16:]))<<32
+		if magic&vfsCapRevisionMask == vfsCapRevision3 {
+			if len(b) < vfsCapSize3 {
+				return nil, errors.New("capability data is too short for revision 3")
+			}
+			c.RootID = binary.LittleEndian.Uint32(b[20:])
+			c.HasRootID = true
+		}
+	default:
+		return nil, errors.Errorf("unknown capability revision %#x", magic&vfsCapRevisionMask)
+	}
+
+	return c, nil
+}
+
+// Marshal encodes capabilities to a security.capability value. Revision 3
+// is used when RootID is set and revision 2 otherwise.
+func (c Capabilities) Marshal() []byte {
+	size, magic := vfsCapSize2, uint32(vfsCapRevision2)
+	if c.HasRootID {
+		size, magic = vfsCapSize3, vfsCapRevision3
+	}
+	if c.Effective {
+		magic |= vfsCapFlagsEffective
+	}
+
+	b := make([]byte, size)
+	binary.LittleEndian.PutUint32(b, magic)
+	binary.LittleEndian.PutUint32(b[4:], uint32(c.Permitted))
+	binary.LittleEndian.PutUint32(b[8:], uint32(c.Inheritable))
+	binary.LittleEndian.PutUint32(b[12:], uint32(c.Permitted>>32))
+	binary.LittleEndian.PutUint32(b[16:], uint32(c.Inheritable>>32))
+	if c.HasRootID {
+		binary.LittleEndian.PutUint32(b[20:], c.RootID)
+	}
+
+	return b
+}
+
+// GetCapabilities returns file capabilities of the path
+func GetCapabilities(path string) (*Capabilities, error) {
+	b, err := Get(path, CapabilityName)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := ParseCapabilities(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse capabilities of %s", path)
+	}
+
+	return c, nil
+}
+
+// SetCapabilities sets file capabilities of the path
+func SetCapabilities(ctx context.Context, path string, c Capabilities) error {
+	return Set(ctx, path, CapabilityName, c.Marshal())
+}
@@ -0,0 +1,194 @@
+package xattr
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/pkg/errors"
+)
+
+// List returns names of extended attributes set on the path
+func List(path string) ([]string, error) {
+	buf, err := read(func(buf []byte) (int, error) { return syscall.Listxattr(path, buf) })
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list xattrs of %s", path)
+	}
+	return splitNames(buf), nil
+}
+
+// Get returns the value of an extended attribute
+func Get(path, name string) ([]byte, error) {
+	buf, err := read(func(buf []byte) (int, error) { return syscall.Getxattr(path, name, buf) })
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get xattr %s of %s", name, path)
+	}
+	return buf, nil
+}
+
+// read sizes the buffer with a call without one first. The list or the
+// value can grow before the second call, which then fails with ERANGE and
+// is sized again.
+func read(call func([]byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := call(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, size)
+		if size == 0 {
+			return buf, nil
+		}
+		size, err = call(buf)
+		if err == syscall.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:size], nil
+	}
+}
+
+// splitNames splits the sequence of NUL-terminated names of a list
+func splitNames(buf []byte) []string {
+	var names []string
+	for _, n := range strings.Split(string(buf), "\x00") {
+		if n != "" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// Set sets the value of an extended attribute, creating it if needed
+func Set(ctx context.Context, path, name string, value []byte) error {
+	a := action.Action{
+		Op:     "xattr.set",
+		Target: path,
+		Args:   map[string]string{"name": name, "value": strconv.Quote(string(value))},
+	}
+
+	return action.Run(ctx, a, func() error {
+		if err := syscall.Setxattr(path, name, value, 0); err != nil {
+			return errors.Wrapf(err, "failed to set xattr %s of %s", name, path)
+		}
+		return nil
+	})
+}
+
+// Remove removes an extended attribute
+func Remove(ctx context.Context, path, name string) error {
+	a := action.Action{
+		Op:     "xattr.remove",
+		Target: path,
+		Args:   map[string]string{"name": name},
+	}
+
+	return action.Run(ctx, a, func() error {
+		if err := syscall.Removexattr(path, name); err != nil {
+			return errors.Wrapf(err, "failed to remove xattr %s of %s", name, path)
+		}
+		return nil
+	})
+}
+
+// CopyAll copies every extended attribute of src to dst. Symlinks aren't
+// followed, so a link gets the attributes of the link and not of its target.
+// Attributes the destination filesystem doesn't support are skipped.
+func CopyAll(ctx context.Context, src, dst string) error {
+	a := action.Action{
+		Op:     "xattr.copy",
+		Target: dst,
+		Args:   map[string]string{"source": src},
+	}
+
+	return action.Run(ctx, a, func() error {
+		return copyAll(src, dst)
+	})
+}
+
+func copyAll(src, dst string) error {
+	buf, err := read(func(buf []byte) (int, error) { return llistxattr(src, buf) })
+	if err != nil {
+		return errors.Wrapf(err, "failed to list xattrs of %s", src)
+	}
+
+	for _, n := range splitNames(buf) {
+		v, err := read(func(buf []byte) (int, error) { return lgetxattr(src, n, buf) })
+		if err == syscall.ENODATA {
+			// Removed since it was listed
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to get xattr %s of %s", n, src)
+		}
+
+		err = lsetxattr(dst, n, v, 0)
+		if err == syscall.ENOTSUP {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to set xattr %s of %s", n, dst)
+		}
+	}
+
+	return nil
+}
+
+// The syscall package lacks the variants not following symlinks
+
+func llistxattr(path string, dest []byte) (int, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	r, _, errno := syscall.Syscall(syscall.SYS_LLISTXATTR, uintptr(unsafe.Pointer(p)), uintptr(bufPtr(dest)), uintptr(len(dest)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
+
+func lgetxattr(path, name string, dest []byte) (int, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	r, _, errno := syscall.Syscall6(syscall.SYS_LGETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(n)), uintptr(bufPtr(dest)), uintptr(len(dest)), 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
+
+func lsetxattr(path, name string, value []byte, flags int) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	n, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_LSETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(n)), uintptr(bufPtr(value)), uintptr(len(value)), uintptr(flags), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func bufPtr(b []byte) unsafe.Pointer {
+	if len(b) == 0 {
+		return nil
+	}
+	return unsafe.Pointer(&b[0])
+}
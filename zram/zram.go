@@ -0,0 +1,240 @@
+// Package zram lists, creates and configures zram devices, block devices
+// compressing their data in RAM, like zramctl does. A device is set up
+// with a size and a compression algorithm and keeps them until it's reset.
+// Devices are read with block.Device.Zram from the tree of
+// block.DefaultSysfs.
+package zram
+
+import (
+	"context"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alexdzyoba/sys/action"
+	"github.com/alexdzyoba/sys/block"
+	"github.com/alexdzyoba/sys/sysfs"
+	"github.com/pkg/errors"
+)
+
+// Device is a zram device
+type Device struct {
+	// Name is the kernel name like "zram0"
+	Name string
+	Node string
+	// Initialized is set once the device got its size and until it's
+	// reset
+	Initialized bool
+	// DiskSize is the uncompressed capacity in bytes, zero until the
+	// device is set up
+	DiskSize uint64
+	// Algorithm is the compressor like "lzo-rle" or "zstd"
+	Algorithm string
+	// Algorithms are the compressors the kernel offers
+	Algorithms []string
+	// MemLimit caps the memory for compressed data in bytes, zero means no
+	// limit
+	MemLimit uint64
+}
+
+// Config is the setup of a device
+type Config struct {
+	// Size is the uncompressed capacity in bytes
+	Size uint64
+	// Algorithm is empty to keep the current one
+	Algorithm string
+	MemLimit  uint64
+}
+
+func (c Config) args() map[string]string {
+	args := map[string]string{"size": strconv.FormatUint(c.Size, 10)}
+	if c.Algorithm != "" {
+		args["algorithm"] = c.Algorithm
+	}
+	if c.MemLimit != 0 {
+		args["mem_limit"] = strconv.FormatUint(c.MemLimit, 10)
+	}
+	return args
+}
+
+// List returns all zram devices sorted by number. It's empty when the zram
+// module isn't loaded.
+func List() ([]Device, error) {
+	bds, err := block.DefaultSysfs.ListDevices()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list zram devices")
+	}
+
+	var ds []Device
+	for _, bd := range bds {
+		if bd.Type != block.TypeZram {
+			continue
+		}
+		d, err := fromBlock(bd)
+		if err != nil {
+			if errors.Is(err, block.ErrDeviceGone) {
+				continue
+			}
+			return nil, err
+		}
+		ds = append(ds, d)
+	}
+	sort.Slice(ds, func(i, j int) bool {
+		return number(ds[i].Name) < number(ds[j].Name)
+	})
+
+	return ds, nil
+}
+
+// Get returns the zram device by its kernel name
+func Get(name string) (Device, error) {
+	if number(name) < 0 {
+		return Device{}, errors.Errorf("%s is not a zram device", name)
+	}
+	bd, err := block.DefaultSysfs.NewDevice(name)
+	if err != nil {
+		return Device{}, err
+	}
+	return fromBlock(*bd)
+}
+
+func fromBlock(bd block.Device) (Device, error) {
+	s, err := bd.Zram()
+	if err != nil {
+		return Device{}, err
+	}
+	return Device{
+		Name:        bd.Name,
+		Node:        path.Join("/dev", bd.Name),
+		Initialized: s.Initialized,
+		DiskSize:    s.DiskSize,
+		Algorithm:   s.Algorithm,
+		Algorithms:  s.Algorithms,
+		// mem_limit is write only, mm_stat reports it
+		MemLimit: s.MemLimit,
+	}, nil
+}
+
+// Create adds a new zram device. In dry-run mode the returned device is
+// empty.
+func Create(ctx context.Context) (Device, error) {
+	hotAdd := controlPath("hot_add")
+	a := action.Action{Op: "zram.create", Target: hotAdd}
+
+	var name string
+	err := action.Run(ctx, a, func() error {
+		// Reading hot_add allocates the device and returns its number
+		n, err := sysfs.Read(hotAdd, sysfs.Int[int])
+		if err != nil {
+			return errors.Wrap(err, "failed to add zram device")
+		}
+		name = "zram" + strconv.Itoa(n)
+		return nil
+	})
+	if err != nil || name == "" {
+		return Device{}, err
+	}
+
+	return Get(name)
+}
+
+// Remove deletes a device, it must not be in use
+func Remove(ctx context.Context, name string) error {
+	n := number(name)
+	if n < 0 {
+		return errors.Errorf("%s is not a zram device", name)
+	}
+
+	a := action.Action{Op: "zram.remove", Target: path.Join("/dev", name)}
+	return action.Run(ctx, a, func() error {
+		return errors.Wrapf(writeAttr(controlPath("hot_remove"), strconv.Itoa(n)), "failed to remove %s", name)
+	})
+}
+
+// Setup sets the size, algorithm and memory limit of a device. The device
+// must not be initialized, see Reset.
+func Setup(ctx context.Context, name string, cfg Config) error {
+	if number(name) < 0 {
+		return errors.Errorf("%s is not a zram device", name)
+	}
+	if cfg.Size == 0 {
+		return errors.Errorf("zram device %s needs a size", name)
+	}
+	a := action.Action{Op: "zram.setup", Target: path.Join("/dev", name), Args: cfg.args()}
+	return action.Run(ctx, a, func() error {
+		initialized, err := sysfs.Read(attrPath(name, "initstate"), sysfs.Bool)
+		if err != nil {
+			return err
+		}
+		if initialized {
+			return errors.Errorf("zram device %s is already set up", name)
+		}
+
+		// The algorithm can only change before the size is set
+		if cfg.Algorithm != "" {
+			if err := writeAttr(attrPath(name, "comp_algorithm"), cfg.Algorithm); err != nil {
+				return errors.Wrapf(err, "failed to set algorithm %s of %s", cfg.Algorithm, name)
+			}
+		}
+		if cfg.MemLimit != 0 {
+			if err := writeAttr(attrPath(name, "mem_limit"), strconv.FormatUint(cfg.MemLimit, 10)); err != nil {
+				return errors.Wrapf(err, "failed to set memory limit of %s", name)
+			}
+		}
+		return errors.Wrapf(writeAttr(attrPath(name, "disksize"), strconv.FormatUint(cfg.Size, 10)), "failed to set size of %s", name)
+	})
+}
+
+// SetMemLimit caps the memory for compressed data of a device in bytes,
+// zero removes the limit. Unlike the size it can change any time.
+func SetMemLimit(ctx context.Context, name string, limit uint64) error {
+	if number(name) < 0 {
+		return errors.Errorf("%s is not a zram device", name)
+	}
+	return sysfs.Write(ctx, attrPath(name, "mem_limit"), limit, sysfs.FormatInt[uint64])
+}
+
+// Reset frees the memory of a device and drops its data and setup. It
+// fails while the device is open, e.g. used as swap.
+func Reset(ctx context.Context, name string) error {
+	if number(name) < 0 {
+		return errors.Errorf("%s is not a zram device", name)
+	}
+
+	a := action.Action{Op: "zram.reset", Target: path.Join("/dev", name)}
+	return action.Run(ctx, a, func() error {
+		return errors.Wrapf(writeAttr(attrPath(name, "reset"), "1"), "failed to reset %s", name)
+	})
+}
+
+// writeAttr writes the attribute returning the errno of the write, which
+// tells why the kernel refused the value
+func writeAttr(p, value string) error {
+	f, err := os.OpenFile(p, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(value)
+	return err
+}
+
+// attrPath returns the path of the attribute of the device in the tree
+func attrPath(name, attr string) string {
+	return block.DefaultSysfs.Path("block", name, attr)
+}
+
+func controlPath(attr string) string {
+	return block.DefaultSysfs.Path("class", "zram-control", attr)
+}
+
+func number(name string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(name, "zram"))
+	if err != nil || !strings.HasPrefix(name, "zram") {
+		return -1
+	}
+	return n
+}